@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+var (
+	// ErrTooManyUnacked is returned by SendReliable when FailWhenUnacked is set and MaxUnacked messages are awaiting acks
+	ErrTooManyUnacked = errors.New("too many messages awaiting acknowledgement")
+	// ErrAckTimeout is returned by SendAndWait when some recipients haven't acknowledged the message in time
+	ErrAckTimeout = errors.New("timed out waiting for acknowledgement")
+	// ErrDeliveryFailed is wrapped by the error SendAndWait returns when the hub gives up on the message for a recipient
+	ErrDeliveryFailed = errors.New("hub failed to deliver message")
+
+	// ReceivedIDsSize is how many of the latest messages received with a MessageID can be acked with AckProcessed
+	ReceivedIDsSize = 1024
+
+	// SendTimedTimeout is how long SendTimed waits for the recipient's ack before failing with ErrAckTimeout
+	SendTimedTimeout = 10 * time.Second
+)
+
+// SendReliable queues msg on the Sending channel with a MessageID, so the hub acknowledges it once each recipient accepts it.
+// If MaxUnacked messages are already awaiting acks it waits for a slot to free up, or fails with ErrTooManyUnacked if FailWhenUnacked is set.
+// A recipient the hub gives up on acks with types.AckFailed, which frees the slot like any other ack.
+// Returns the MessageID, which is generated if msg doesn't have one.
+func (c *Client) SendReliable(msg types.SendingMessage) (string, error) {
+	return c.SendReliableContext(context.Background(), msg)
+}
+
+// SendReliableContext is SendReliable, giving up waiting for a slot with ctx's error once ctx is done
+func (c *Client) SendReliableContext(ctx context.Context, msg types.SendingMessage) (string, error) {
+	if err := VerifyRecipients(msg.Recipients); err != nil {
+		return "", err
+	}
+
+	if msg.MessageID == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return "", err
+		}
+		msg.MessageID = id
+	}
+
+	pending := recipientSet(msg.Recipients)
+
+	// Wake the wait below once ctx is done, if a free slot doesn't first
+	waited := make(chan struct{})
+	defer close(waited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.ackedCond.Broadcast()
+			c.mu.Unlock()
+		case <-waited:
+		}
+	}()
+
+	c.mu.Lock()
+	for c.MaxUnacked > 0 && len(c.unacked) >= c.MaxUnacked {
+		if c.FailWhenUnacked {
+			c.mu.Unlock()
+			return "", ErrTooManyUnacked
+		}
+		if err := ctx.Err(); err != nil {
+			c.mu.Unlock()
+			return "", err
+		}
+		c.ackedCond.Wait()
+	}
+	c.unacked[msg.MessageID] = pending
+	c.mu.Unlock()
+
+	if err := c.Send(msg); err != nil {
+		// Never sent, so it won't be acked
+		c.mu.Lock()
+		delete(c.unacked, msg.MessageID)
+		c.ackedCond.Broadcast()
+		c.mu.Unlock()
+		return "", err
+	}
+	return msg.MessageID, nil
+}
+
+// SendAndWait sends msg like SendReliable, then blocks until every recipient has acknowledged it, or fails with ErrAckTimeout
+// once timeout has passed, including any time spent waiting for a slot. A message that times out stops counting towards
+// MaxUnacked, and later acks of it are ignored.
+func (c *Client) SendAndWait(msg types.SendingMessage, timeout time.Duration) error {
+	return c.sendAndWait(msg, timeout, false)
+}
+
+// SendAndWaitProcessed is SendAndWait, only returning once every recipient has also acknowledged processing the message,
+// which it does by calling AckProcessed
+func (c *Client) SendAndWaitProcessed(msg types.SendingMessage, timeout time.Duration) error {
+	return c.sendAndWait(msg, timeout, true)
+}
+
+// sendAndWait is SendAndWait, also waiting for recipients to ack processing the message if processed is set
+func (c *Client) sendAndWait(msg types.SendingMessage, timeout time.Duration, processed bool) error {
+	deadline := time.Now().Add(timeout)
+
+	// Waited on before sending, as a quick recipient could ack processing, or the hub fail the message, before
+	// SendReliable returns
+	if msg.MessageID == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return err
+		}
+		msg.MessageID = id
+	}
+	c.mu.Lock()
+	c.failures[msg.MessageID] = nil
+	if processed {
+		c.unprocessed[msg.MessageID] = recipientSet(msg.Recipients)
+	}
+	c.mu.Unlock()
+
+	// Wake the wait below at the deadline, if no ack does first
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		c.mu.Lock()
+		c.ackedCond.Broadcast()
+		c.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	// Waiting for a slot counts towards the timeout too
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	id, err := c.SendReliableContext(ctx, msg)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.unprocessed, msg.MessageID)
+		delete(c.failures, msg.MessageID)
+		c.mu.Unlock()
+		if err == context.DeadlineExceeded {
+			return ErrAckTimeout
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer delete(c.failures, id)
+	for {
+		if err := c.failures[id]; err != nil {
+			return err
+		}
+		_, undelivered := c.unacked[id]
+		_, unprocessed := c.unprocessed[id]
+		if !undelivered && !unprocessed {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			delete(c.unacked, id)
+			delete(c.unprocessed, id)
+			c.ackedCond.Broadcast()
+			return ErrAckTimeout
+		}
+		c.ackedCond.Wait()
+	}
+}
+
+// SendTimed sends data to recipient like SendAndWait, returning how long it took from sending to the recipient's ack, e.g.
+// for measuring latency against an SLO. That's end to end when the hub has an AckTimeout and the recipient AckMessages,
+// otherwise the hub acks the message itself once it's handed over.
+func (c *Client) SendTimed(recipient uint64, data []byte) (time.Duration, error) {
+	start := time.Now()
+	msg := types.SendingMessage{Recipients: strconv.FormatUint(recipient, 10), Data: data}
+	if err := c.SendAndWait(msg, SendTimedTimeout); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Unacked returns how many messages sent with SendReliable are still awaiting acks
+func (c *Client) Unacked() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.unacked)
+}
+
+// AckProcessed acknowledges to its sender that the application has processed the message received with messageID, for
+// senders waiting on that with SendAndWaitProcessed. Delivery is acked separately, by the hub or with AckMessages.
+// Only the latest ReceivedIDsSize messages received can be acked.
+func (c *Client) AckProcessed(messageID string) error {
+	c.mu.Lock()
+	sender, exists := c.receivedSenders[messageID]
+	c.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("no message received with ID %s", messageID)
+	}
+
+	return c.Send(types.SendingMessage{
+		Recipients: strconv.FormatUint(sender, 10),
+		MessageID:  messageID,
+		Ack:        true,
+		AckStatus:  types.AckProcessed,
+	})
+}
+
+// received records who sent msg, so the application can AckProcessed it. The oldest are forgotten past ReceivedIDsSize.
+func (c *Client) received(msg types.SendingMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.receivedSenders == nil {
+		c.receivedSenders = make(map[string]uint64)
+	}
+	if _, exists := c.receivedSenders[msg.MessageID]; !exists {
+		c.receivedOrder = append(c.receivedOrder, msg.MessageID)
+	}
+	c.receivedSenders[msg.MessageID] = msg.Sender
+	for len(c.receivedOrder) > ReceivedIDsSize {
+		delete(c.receivedSenders, c.receivedOrder[0])
+		c.receivedOrder = c.receivedOrder[1:]
+	}
+}
+
+// acked records the ack of a message by one of its recipients, freeing its slot once every recipient has acked it.
+// A failed ack frees the slot straight away, the message won't be delivered to every recipient whatever the rest do.
+// Observers implementing AckObserver are told of every ack, whether or not the client is waiting on it.
+func (c *Client) acked(ack types.SendingMessage) {
+	status := ack.AckStatus
+	if status == "" {
+		status = types.AckDelivered
+	}
+
+	c.mu.Lock()
+	switch status {
+	case types.AckFailed:
+		if _, waiting := c.failures[ack.MessageID]; waiting {
+			c.failures[ack.MessageID] = fmt.Errorf("%w to %d: %s", ErrDeliveryFailed, ack.Sender, ack.Data)
+		}
+		delete(c.unacked, ack.MessageID)
+		delete(c.unprocessed, ack.MessageID)
+		c.ackedCond.Broadcast()
+	default:
+		waiting := c.unacked
+		if status == types.AckProcessed {
+			waiting = c.unprocessed
+		}
+		if pending, exists := waiting[ack.MessageID]; exists {
+			delete(pending, ack.Sender)
+			if len(pending) == 0 {
+				delete(waiting, ack.MessageID)
+				c.ackedCond.Broadcast()
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	c.notify(func(o Observer) {
+		if a, ok := o.(AckObserver); ok {
+			a.OnAck(ack.MessageID, ack.Sender, status)
+		}
+	})
+}
+
+// recipientSet parses the IDs of recipients, which should have been checked with VerifyRecipients
+func recipientSet(recipients string) map[uint64]struct{} {
+	ids := make(map[uint64]struct{})
+	for _, recipient := range strings.Split(recipients, ",") {
+		id, _ := strconv.ParseUint(recipient, 10, 64)
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// newMessageID generates a random (version 4) UUID
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate message ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,417 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_MaxUnacked(t *testing.T) {
+	tests := []struct {
+		name            string
+		failWhenUnacked bool
+	}{
+		{
+			name: "Blocks until acked",
+		},
+		{
+			name:            "Fails when full",
+			failWhenUnacked: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			// Unbuffered, so messages aren't handed over (and acked) until the recipient connects
+			h.ClientBufferSize = 0
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			sender, err := New(address)
+			require.NoError(t, err)
+			sender.MaxUnacked = 2
+			sender.FailWhenUnacked = tt.failWhenUnacked
+
+			conn, err := sender.InitWebsocket()
+			require.NoError(t, err)
+			defer conn.Close()
+			go sender.WriteMessages(conn)
+			go sender.ReadMessages(conn)
+
+			// The recipient is registered but not connected yet, so the hub can't hand it messages or ack them
+			recipient, err := New(address)
+			require.NoError(t, err)
+			msg := types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("reliable")}
+
+			for i := 0; i < sender.MaxUnacked; i++ {
+				_, err := sender.SendReliable(msg)
+				require.NoError(t, err)
+			}
+			require.Equal(t, 2, sender.Unacked())
+
+			sent := make(chan error, 1)
+			go func() {
+				_, err := sender.SendReliable(msg)
+				sent <- err
+			}()
+
+			if tt.failWhenUnacked {
+				select {
+				case err := <-sent:
+					require.Equal(t, ErrTooManyUnacked, err)
+				case <-time.After(5 * time.Second):
+					t.Fatal("SendReliable didn't fail with a full window")
+				}
+				return
+			}
+
+			select {
+			case err := <-sent:
+				t.Fatalf("SendReliable returned with a full window: %v", err)
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			// Once the recipient connects the hub hands over the messages and acks them, freeing the window
+			recipientConn, err := recipient.InitWebsocket()
+			require.NoError(t, err)
+			defer recipientConn.Close()
+			go recipient.ReadMessages(recipientConn)
+
+			select {
+			case err := <-sent:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("SendReliable didn't return after acks freed the window")
+			}
+			require.Eventually(t, func() bool { return sender.Unacked() == 0 }, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestClient_AckMessages(t *testing.T) {
+	h := hub.New()
+	h.AckTimeout = time.Second
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+	go sender.ReadMessages(senderConn)
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipient.AckMessages = true
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.WriteMessages(recipientConn)
+	go recipient.ReadMessages(recipientConn)
+
+	_, err = sender.SendReliable(types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("reliable")})
+	require.NoError(t, err)
+
+	// The hub only acks the sender once the recipient has acked it
+	require.Eventually(t, func() bool { return sender.Unacked() == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, h.DeadLetters(), 0)
+}
+
+func TestClient_AckMessagesNotWriting(t *testing.T) {
+	c := newClient("localhost")
+	c.AckMessages = true
+	observer := &recordingObserver{events: make(chan string, 10)}
+	c.RegisterObserver(observer)
+
+	// Without WriteMessages running the ack is given up on rather than left waiting on Sending
+	c.handle(types.SendingMessage{Sender: 1, MessageID: "m1", Data: []byte("data")})
+	expectEvent(t, observer, "error")
+	require.Empty(t, c.Sending)
+}
+
+func TestClient_SendAndWait(t *testing.T) {
+	tests := []struct {
+		name        string
+		ackMessages bool
+		expectedErr error
+	}{
+		{
+			name:        "Recipient acks",
+			ackMessages: true,
+		},
+		{
+			name:        "Recipient never acks",
+			expectedErr: ErrAckTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			h.AckTimeout = 5 * time.Second
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			recipient, err := New(address)
+			require.NoError(t, err)
+			recipient.AckMessages = tt.ackMessages
+			recipientConn, err := recipient.InitWebsocket()
+			require.NoError(t, err)
+			defer recipientConn.Close()
+			go recipient.WriteMessages(recipientConn)
+			go recipient.ReadMessages(recipientConn)
+
+			sender, err := New(address)
+			require.NoError(t, err)
+			senderConn, err := sender.InitWebsocket()
+			require.NoError(t, err)
+			defer senderConn.Close()
+			go sender.WriteMessages(senderConn)
+			go sender.ReadMessages(senderConn)
+
+			msg := types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("Hi")}
+			require.Eventually(t, func() bool {
+				err = sender.SendAndWait(msg, 500*time.Millisecond)
+				return err != ErrNotConnected
+			}, time.Second, 10*time.Millisecond)
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, 0, sender.Unacked())
+
+			received := <-recipient.Incoming()
+			require.NotEmpty(t, received.MessageID)
+		})
+	}
+}
+
+func TestClient_SendAndWaitFailed(t *testing.T) {
+	tests := []struct {
+		name           string
+		paused         bool
+		unknown        bool
+		expectedReason string
+	}{
+		{
+			name:           "Recipient not registered",
+			unknown:        true,
+			expectedReason: "Recipient not registered",
+		},
+		{
+			name:           "Hub paused",
+			paused:         true,
+			expectedReason: "Message dropped, hub is paused",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			recipient, err := New(address)
+			require.NoError(t, err)
+			recipientID := recipient.ID
+			if tt.unknown {
+				recipientID++
+			}
+
+			sender, err := New(address)
+			require.NoError(t, err)
+			// Without the hub failing them, the first would hold the only slot and the second never get sent
+			sender.MaxUnacked = 1
+			senderConn, err := sender.InitWebsocket()
+			require.NoError(t, err)
+			defer senderConn.Close()
+			go sender.WriteMessages(senderConn)
+			go sender.ReadMessages(senderConn)
+
+			if tt.paused {
+				h.Pause()
+			}
+
+			msg := types.SendingMessage{Recipients: fmt.Sprint(recipientID), Data: []byte("Hi")}
+			require.Eventually(t, func() bool {
+				err = sender.SendAndWait(msg, 5*time.Second)
+				return err != ErrNotConnected
+			}, time.Second, 10*time.Millisecond)
+			require.True(t, errors.Is(err, ErrDeliveryFailed), "unexpected error %v", err)
+			require.Contains(t, err.Error(), tt.expectedReason)
+
+			err = sender.SendAndWait(msg, 5*time.Second)
+			require.True(t, errors.Is(err, ErrDeliveryFailed), "unexpected error %v", err)
+			require.Equal(t, 0, sender.Unacked())
+		})
+	}
+}
+
+func TestClient_SendReliableContext(t *testing.T) {
+	c := newClient("localhost")
+	c.MaxUnacked = 1
+	c.unacked["waiting"] = map[uint64]struct{}{1: {}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.SendReliableContext(ctx, types.SendingMessage{Recipients: "1", Data: []byte("Hi")})
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 1, c.Unacked())
+}
+
+func TestClient_SendAndWaitFullWindow(t *testing.T) {
+	c := newClient("localhost")
+	c.MaxUnacked = 1
+	c.unacked["waiting"] = map[uint64]struct{}{1: {}}
+
+	// The timeout covers waiting for a slot, not only for the ack once it's sent
+	done := make(chan error, 1)
+	go func() { done <- c.SendAndWait(types.SendingMessage{Recipients: "1", Data: []byte("Hi")}, 200*time.Millisecond) }()
+	select {
+	case err := <-done:
+		require.Equal(t, ErrAckTimeout, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("SendAndWait still waiting for a slot past its timeout")
+	}
+	require.Equal(t, 1, c.Unacked())
+}
+
+func TestClient_SendTimed(t *testing.T) {
+	h := hub.New()
+	h.AckTimeout = 5 * time.Second
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipient.AckMessages = true
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.WriteMessages(recipientConn)
+	go recipient.ReadMessages(recipientConn)
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+	go sender.ReadMessages(senderConn)
+
+	var latency time.Duration
+	require.Eventually(t, func() bool {
+		latency, err = sender.SendTimed(recipient.ID, []byte("Ping"))
+		return err != ErrNotConnected
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, latency > 0, "latency wasn't positive: %v", latency)
+	require.True(t, latency < time.Second, "latency too large for a local hub: %v", latency)
+
+	received := <-recipient.Incoming()
+	require.Equal(t, "Ping", string(received.Data))
+}
+
+// ackRecorder is a recordingObserver also recording the acks it's told of
+type ackRecorder struct {
+	recordingObserver
+}
+
+func (r *ackRecorder) OnAck(messageID string, recipient uint64, status string) {
+	r.events <- fmt.Sprintf("ack %s %s %d", messageID, status, recipient)
+}
+
+func TestClient_AckProcessed(t *testing.T) {
+	h := hub.New()
+	h.AckTimeout = 5 * time.Second
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipient.AckMessages = true
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.WriteMessages(recipientConn)
+	go recipient.ReadMessages(recipientConn)
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+	go sender.ReadMessages(senderConn)
+
+	observer := &ackRecorder{recordingObserver{events: make(chan string, 10)}}
+	sender.RegisterObserver(observer)
+
+	msg := types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("Process me"), MessageID: "m1"}
+	processed := make(chan error, 1)
+	go func() {
+		err := ErrNotConnected
+		for err == ErrNotConnected {
+			err = sender.SendAndWaitProcessed(msg, 5*time.Second)
+		}
+		processed <- err
+	}()
+
+	// Delivery is acked by the transport, while the application takes its time
+	expectEvent(t, &observer.recordingObserver, fmt.Sprintf("ack m1 delivered %d", recipient.ID))
+	received := <-recipient.Incoming()
+	require.Equal(t, "Process me", string(received.Data))
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-processed:
+		t.Fatalf("SendAndWaitProcessed returned before the message was processed: %v", err)
+	default:
+	}
+
+	require.NoError(t, recipient.AckProcessed(received.MessageID))
+	expectEvent(t, &observer.recordingObserver, fmt.Sprintf("ack m1 processed %d", recipient.ID))
+	select {
+	case err := <-processed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendAndWaitProcessed didn't return once the message was processed")
+	}
+
+	require.EqualError(t, recipient.AckProcessed("m2"), "no message received with ID m2")
+}
+
+func TestClient_WriteMessagesMessageID(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+	go c.WriteMessages(conn)
+	go c.ReadMessages(conn)
+
+	// Plain sends are given an ID, which the hub acks
+	c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("Hi")}
+
+	select {
+	case msg := <-c.Incoming():
+		require.Len(t, msg.MessageID, 36)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+}
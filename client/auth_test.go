@@ -0,0 +1,41 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Token(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	c, err := New(address)
+	require.NoError(t, err)
+	require.NotEmpty(t, c.token)
+
+	id, err := c.Identify()
+	require.NoError(t, err)
+	assert.Equal(t, c.ID, id)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Knowing the ID isn't enough to act as the client without its token
+	impostor := newClient(address)
+	impostor.ID = c.ID
+	_, err = impostor.Identify()
+	assert.Error(t, err)
+	_, err = impostor.InitWebsocket()
+	assert.Error(t, err)
+	assert.Error(t, impostor.Deregister())
+
+	require.NoError(t, c.Deregister())
+}
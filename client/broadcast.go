@@ -0,0 +1,23 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// Broadcast relays data to every other client registered with the hub, without needing a websocket.
+// It fails if any of them couldn't be handed the message in time, though the rest will still have received it.
+func (c *Client) Broadcast(data []byte) error {
+	var resp types.BroadcastResponse
+	if err := c.postData("/broadcast", url.Values{"exclude": {strconv.FormatUint(c.ID, 10)}}, data, &resp); err != nil {
+		return err
+	}
+
+	if len(resp.Failed) > 0 {
+		return fmt.Errorf("broadcast delivered to %d clients, but not %v", resp.Delivered, resp.Failed)
+	}
+	return nil
+}
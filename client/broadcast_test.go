@@ -0,0 +1,47 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Broadcast(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	clients := make([]*Client, 3)
+	for i := range clients {
+		c, err := New(address)
+		require.NoError(t, err)
+		conn, err := c.InitWebsocket()
+		require.NoError(t, err)
+		defer conn.Close()
+		go c.ReadMessages(conn)
+		clients[i] = c
+	}
+
+	require.NoError(t, clients[0].Broadcast([]byte("Hi everyone")))
+
+	for _, c := range clients[1:] {
+		select {
+		case msg := <-c.Incoming():
+			require.Equal(t, "Hi everyone", string(msg.Data))
+		case <-time.After(time.Second):
+			t.Fatalf("%d didn't receive the broadcast", c.ID)
+		}
+	}
+
+	// The broadcaster is excluded
+	select {
+	case msg := <-clients[0].Incoming():
+		t.Fatalf("broadcaster received its own message: %s", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// ChunkTimeout is how long a chunked message has to arrive in full after its first chunk, before the chunks received
+// are dropped and observers told of the incomplete transfer
+var ChunkTimeout = time.Minute
+
+// chunkKey identifies a chunked message by who sent it, as FileIDs are only unique to their sender
+type chunkKey struct {
+	sender uint64
+	fileID string
+}
+
+// partial is a chunked message part way through being reassembled
+type partial struct {
+	chunks   [][]byte // Data of each chunk by ChunkIndex, nil until it arrives
+	received int
+	expiry   *time.Timer // Drops the partial after ChunkTimeout, see expireChunks
+}
+
+// maxChunks is how many chunks of MaxDataSize a message of MaxMessageSize is split into, the most a client reassembles
+func maxChunks() int {
+	return int((MaxMessageSize + MaxDataSize - 1) / MaxDataSize)
+}
+
+// sendChunks queues msg as chunks of at most MaxDataSize, in order, sharing a FileID for the recipients to reassemble them by.
+// Each chunk gets its own MessageID when written, so they're acknowledged separately.
+func (c *Client) sendChunks(msg types.SendingMessage) error {
+	if msg.Encrypted {
+		// Recipients decrypt each chunk on its own, which a slice of ciphertext can't be
+		return errors.New("encrypted data can't be split, it must be under MaxDataSize")
+	}
+	if int64(len(msg.Data)) > MaxMessageSize {
+		return fmt.Errorf("data exceeded max message size(%d) was: %d", MaxMessageSize, len(msg.Data))
+	}
+
+	fileID, err := newMessageID()
+	if err != nil {
+		return err
+	}
+
+	count := int((int64(len(msg.Data)) + MaxDataSize - 1) / MaxDataSize)
+	for i := 0; i < count; i++ {
+		start, end := int64(i)*MaxDataSize, int64(i+1)*MaxDataSize
+		if end > int64(len(msg.Data)) {
+			end = int64(len(msg.Data))
+		}
+
+		chunk := msg
+		chunk.Data = msg.Data[start:end]
+		chunk.MessageID = ""
+		chunk.FileID, chunk.ChunkIndex, chunk.ChunkCount = fileID, i, count
+		if err := c.Send(chunk); err != nil {
+			return fmt.Errorf("failed to send chunk %d of %d: %v", i+1, count, err)
+		}
+	}
+	return nil
+}
+
+// reassemble holds onto a chunk of a split message, returning the whole message once it has every chunk.
+// Chunks can arrive in any order, e.g. with priorities reordering a recipient's queue, and are put back in ChunkIndex order.
+// Repeats of a chunk are ignored, and chunks that don't fit the message they claim to be from are dropped, as are those
+// claiming to be from a message of more than maxChunks.
+func (c *Client) reassemble(chunk types.SendingMessage) (types.SendingMessage, bool) {
+	key := chunkKey{chunk.Sender, chunk.FileID}
+
+	// Checked before anything is allocated for the message, as the sender picks how many chunks it has
+	if chunk.ChunkCount > maxChunks() || chunk.ChunkIndex < 0 || chunk.ChunkIndex >= chunk.ChunkCount {
+		err := fmt.Errorf("dropped chunk %d of %d from %d, messages can be split into at most %d chunks",
+			chunk.ChunkIndex, chunk.ChunkCount, chunk.Sender, maxChunks())
+		c.notify(func(o Observer) { o.OnError(err) })
+		return chunk, false
+	}
+
+	c.mu.Lock()
+	if c.partials == nil {
+		c.partials = make(map[chunkKey]*partial)
+	}
+	p, exists := c.partials[key]
+	if !exists {
+		p = &partial{chunks: make([][]byte, chunk.ChunkCount)}
+		p.expiry = time.AfterFunc(ChunkTimeout, func() { c.expireChunks(key, p) })
+		c.partials[key] = p
+	}
+
+	if chunk.ChunkCount != len(p.chunks) {
+		c.mu.Unlock()
+		err := fmt.Errorf("dropped chunk %d of %d from %d, which doesn't fit message %s of %d chunks",
+			chunk.ChunkIndex, chunk.ChunkCount, chunk.Sender, chunk.FileID, len(p.chunks))
+		c.notify(func(o Observer) { o.OnError(err) })
+		return chunk, false
+	}
+	if p.chunks[chunk.ChunkIndex] == nil {
+		p.chunks[chunk.ChunkIndex] = chunk.Data
+		p.received++
+	}
+	complete := p.received == len(p.chunks)
+	if complete {
+		p.expiry.Stop()
+		delete(c.partials, key)
+	}
+	c.mu.Unlock()
+
+	if !complete {
+		return chunk, false
+	}
+
+	var data []byte
+	for _, d := range p.chunks {
+		data = append(data, d...)
+	}
+
+	msg := chunk
+	msg.Data = data
+	msg.FileID, msg.ChunkIndex, msg.ChunkCount = "", 0, 0
+	return msg, true
+}
+
+// expireChunks drops p, the partial of the message identified by key, if it's still incomplete after ChunkTimeout
+func (c *Client) expireChunks(key chunkKey, p *partial) {
+	c.mu.Lock()
+	expired, received := c.partials[key] == p, p.received
+	if expired {
+		delete(c.partials, key)
+	}
+	c.mu.Unlock()
+
+	if expired {
+		err := fmt.Errorf("dropped message %s from %d, only %d of %d chunks arrived within %v",
+			key.fileID, key.sender, received, len(p.chunks), ChunkTimeout)
+		c.notify(func(o Observer) { o.OnError(err) })
+	}
+}
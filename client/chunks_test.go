@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SplitOversize(t *testing.T) {
+	defer func(size int64) { MaxDataSize = size }(MaxDataSize)
+	MaxDataSize = 16
+
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.ReadMessages(recipientConn)
+
+	data := bytes.Repeat([]byte("0123456789"), 5)
+	recipients := fmt.Sprint(recipient.ID)
+
+	// Too large to send without splitting
+	assert.Error(t, sender.SendWithMetadata(recipients, data, nil))
+
+	sender.SplitOversize = true
+	require.Eventually(t, func() bool {
+		return sender.Send(types.SendingMessage{Recipients: recipients, Data: data}) != ErrNotConnected
+	}, time.Second, 10*time.Millisecond)
+
+	select {
+	case msg := <-recipient.Incoming():
+		assert.Equal(t, string(data), string(msg.Data))
+		assert.Equal(t, sender.ID, msg.Sender)
+		assert.Zero(t, msg.ChunkCount)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reassembled message wasn't delivered")
+	}
+
+	// Only the whole message is passed on, not its chunks
+	select {
+	case msg := <-recipient.Incoming():
+		t.Fatalf("received more than the reassembled message: %s", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClient_ReassembleOutOfOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		indexes  []int
+		expected string
+		timedOut bool
+	}{
+		{
+			name:     "Reversed",
+			indexes:  []int{2, 1, 0},
+			expected: "Hello, world!",
+		},
+		{
+			name:     "Shuffled with a repeat",
+			indexes:  []int{1, 2, 1, 0},
+			expected: "Hello, world!",
+		},
+		{
+			name:     "Never completed",
+			indexes:  []int{2, 0},
+			timedOut: true,
+		},
+	}
+	chunks := []string{"Hello", ", wor", "ld!"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(timeout time.Duration) { ChunkTimeout = timeout }(ChunkTimeout)
+			ChunkTimeout = 200 * time.Millisecond
+
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			sender, err := New(address)
+			require.NoError(t, err)
+			senderConn, err := sender.InitWebsocket()
+			require.NoError(t, err)
+			defer senderConn.Close()
+			go sender.WriteMessages(senderConn)
+
+			recipient, err := New(address)
+			require.NoError(t, err)
+			observer := &recordingObserver{events: make(chan string, 10)}
+			recipient.RegisterObserver(observer)
+			recipientConn, err := recipient.InitWebsocket()
+			require.NoError(t, err)
+			defer recipientConn.Close()
+			expectEvent(t, observer, "connected")
+			go recipient.ReadMessages(recipientConn)
+
+			// Chunks are relayed in the order they're sent, so send them out of order
+			for _, i := range tt.indexes {
+				chunk := types.SendingMessage{
+					Recipients: fmt.Sprint(recipient.ID),
+					Data:       []byte(chunks[i]),
+					FileID:     "greeting",
+					ChunkIndex: i,
+					ChunkCount: len(chunks),
+				}
+				require.Eventually(t, func() bool { return sender.Send(chunk) != ErrNotConnected }, time.Second, 10*time.Millisecond)
+				expectEvent(t, observer, "message "+chunks[i])
+			}
+
+			if tt.timedOut {
+				expectEvent(t, observer, "error")
+				recipient.mu.Lock()
+				assert.Empty(t, recipient.partials)
+				recipient.mu.Unlock()
+
+				select {
+				case msg := <-recipient.Incoming():
+					t.Fatalf("received an incomplete message: %s", msg.Data)
+				default:
+				}
+				return
+			}
+
+			select {
+			case msg := <-recipient.Incoming():
+				assert.Equal(t, tt.expected, string(msg.Data))
+				assert.Equal(t, sender.ID, msg.Sender)
+			case <-time.After(5 * time.Second):
+				t.Fatal("reassembled message wasn't delivered")
+			}
+
+			// The completed transfer doesn't time out later
+			select {
+			case event := <-observer.events:
+				t.Fatalf("unexpected %s after reassembly", event)
+			case <-time.After(2 * ChunkTimeout):
+			}
+		})
+	}
+}
+
+func TestClient_ReassembleBounds(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunkIndex int
+		chunkCount int
+	}{
+		{
+			name:       "More chunks than a message can have",
+			chunkCount: 1 << 62,
+		},
+		{
+			name:       "Just over the maximum",
+			chunkCount: 101,
+		},
+		{
+			name:       "Index past the count",
+			chunkIndex: 2,
+			chunkCount: 2,
+		},
+		{
+			name:       "Negative index",
+			chunkIndex: -1,
+			chunkCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient("localhost")
+			observer := &recordingObserver{events: make(chan string, 10)}
+			c.RegisterObserver(observer)
+
+			_, complete := c.reassemble(types.SendingMessage{Sender: 1, FileID: "file", ChunkIndex: tt.chunkIndex, ChunkCount: tt.chunkCount})
+			assert.False(t, complete)
+			expectEvent(t, observer, "error")
+
+			// Nothing is held for the message
+			c.mu.Lock()
+			assert.Empty(t, c.partials)
+			c.mu.Unlock()
+		})
+	}
+}
@@ -1,14 +1,25 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/StephenBirch/message-delivery-system/ratelimit"
 	"github.com/StephenBirch/message-delivery-system/types"
 	"github.com/gorilla/websocket"
 )
@@ -18,6 +29,31 @@ var (
 	MaxRecipients = 255
 	// MaxDataSize refers to the max number of bytes for a single data section
 	MaxDataSize = int64(1024000) // 1024 kilobyes
+	// MaxMessageSize is the largest message SplitOversize sends in chunks, and so the largest a client reassembles from them
+	MaxMessageSize = 100 * MaxDataSize
+	// SystemBufferSize is how many undelivered system messages are held before newer ones are dropped
+	SystemBufferSize = 16
+	// IncomingBufferSize is how many received messages are held for Incoming before the oldest are dropped
+	IncomingBufferSize = 64
+
+	// DefaultHTTPTimeout is the HTTPTimeout of new clients
+	DefaultHTTPTimeout = 10 * time.Second
+
+	// ErrRateLimited is reported to observers for each message dropped by WriteMessages for exceeding the Limiter
+	ErrRateLimited = errors.New("message dropped, sending faster than the rate limit")
+
+	// ErrNotConnected is returned by Send and the other Send helpers when WriteMessages isn't running to write the message
+	ErrNotConnected = errors.New("not connected, call InitWebsocket and run WriteMessages before sending")
+
+	// ErrFileNotFound is wrapped by the error VerifyFile returns for a file that doesn't exist
+	ErrFileNotFound = errors.New("file not found")
+	// ErrFileTooBig is wrapped by the error VerifyFile returns for a file larger than MaxDataSize
+	ErrFileTooBig = errors.New("file exceeded max size")
+
+	// ErrIDInUse is returned by NewWithID when another client is registered with the ID, so a different one should be tried
+	ErrIDInUse = errors.New("ID already in use")
+
+	errReplaced = errors.New("connection replaced by Reconnect or closed by Deregister")
 )
 
 // Client holds the ID, Address, and Channel for sending messages down the websocket
@@ -25,15 +61,125 @@ type Client struct {
 	ID      uint64
 	Address string
 	Sending chan types.SendingMessage
+
+	// Limiter, when set, paces the messages written by WriteMessages so the client stays under the hub's limits
+	Limiter *ratelimit.Limiter
+	// DropWhenLimited makes WriteMessages drop messages over the Limiter's rate rather than waiting to send them
+	DropWhenLimited bool
+	// MaxUnacked caps how many messages sent with SendReliable can await acks at once, 0 means unlimited
+	MaxUnacked int
+	// FailWhenUnacked makes SendReliable fail rather than wait while MaxUnacked messages await acks
+	FailWhenUnacked bool
+	// HTTPTimeout bounds how long requests to the hub like Register, Identify and ListUsers take, 0 means no limit.
+	// New clients have DefaultHTTPTimeout.
+	HTTPTimeout time.Duration
+	// MaxReconnectAttempts is how many times in a row RunWithReconnect tries to reconnect before giving up, 0 means it never does
+	MaxReconnectAttempts int
+	// CoalesceWindow is how long SendCoalesced holds a message for newer ones with the same key to replace it
+	CoalesceWindow time.Duration
+	// AckMessages makes ReadMessages acknowledge each message with a MessageID back to the hub, which a hub with an AckTimeout waits for
+	AckMessages bool
+	// ResendGaps makes ReadMessages ask the hub to resend messages it notices were skipped in the Sequence, e.g. those lost
+	// with a dropped connection, which a hub with a ResendBufferSize keeps
+	ResendGaps bool
+	// ExactlyOnce makes ReadMessages pass on each message with a MessageID only once, even if it's delivered again, e.g.
+	// redelivered by the hub after its ack was lost across a reconnect. Repeats are still acknowledged with AckMessages,
+	// so the hub stops redelivering them, but are otherwise dropped. See DeliveredMarkersSize.
+	ExactlyOnce bool
+	// Secure makes the client connect to the hub over TLS, with https:// and wss://, see NewSecure
+	Secure bool
+	// TLSConfig configures the TLS connections made while Secure is set, e.g. to trust a self-signed certificate.
+	// nil uses the system's defaults.
+	TLSConfig *tls.Config
+	// ProtocolToken is presented to the hub when connecting the websocket, for hubs that only accept clients holding it
+	ProtocolToken string
+	// SplitOversize makes Send split a message with Data over MaxDataSize into chunks that fit, rather than the hub
+	// having to relay it whole. Clients reassemble the chunks they receive, passing on the whole message once the last arrives.
+	SplitOversize bool
+	// ReconnectOnWriteError makes WriteMessages reconnect and retry a message that failed to write, rather than returning
+	ReconnectOnWriteError bool
+	// ReceiveTransport forces Receive to get messages over a single transport, e.g. LongPollTransport for debugging or
+	// where others are known not to work, rather than negotiating one. Empty negotiates.
+	ReceiveTransport string
+
+	mu           sync.Mutex
+	system       chan types.SendingMessage
+	incoming     chan types.SendingMessage
+	observers    []Observer
+	privateKey   *rsa.PrivateKey
+	publicKeys   map[uint64]*rsa.PublicKey
+	sessionToken string                         // Issued by the hub for the latest websocket connection, see WhoAmI
+	token        string                         // Issued by the hub at registration, presented whenever acting as the client
+	unacked      map[string]map[uint64]struct{} // Recipients yet to ack each message, by MessageID
+	unprocessed  map[string]map[uint64]struct{} // Recipients yet to ack processing each message, see SendAndWaitProcessed
+	failures     map[string]error               // Why the hub failed each message SendAndWait is waiting on, nil until it does
+	ackedCond    *sync.Cond                     // Signalled on mu when an unacked or unprocessed message is fully acked
+
+	conn     *websocket.Conn        // Latest websocket connection, swapped out by Reconnect
+	replaced chan struct{}          // Closed when conn is swapped out, see swapConn
+	unsent   []types.SendingMessage // Taken from Sending but failed to write, retried on the next connection
+
+	writers     int           // How many WriteMessages loops are running
+	writersDone chan struct{} // Closed once the last running WriteMessages returns
+
+	transport string // Transport of the latest connection made by Receive
+
+	lastSequence uint64 // Highest Sequence received, for ResendGaps
+
+	deliveredMarkers map[deliveryKey]struct{} // Messages received with ExactlyOnce, see delivered
+	deliveredOrder   []deliveryKey            // Keys of deliveredMarkers, oldest first
+
+	receivedSenders map[string]uint64 // Sender of each message received with a MessageID, for AckProcessed
+	receivedOrder   []string          // Keys of receivedSenders, oldest first
+
+	transportTLS *tls.Config  // TLSConfig that httpc was made for
+	httpc        *http.Client // Made for a TLSConfig, see httpClient
+	timedc       *http.Client // httpClient with the HTTPTimeout, see timedClient
+
+	coalescing map[coalesceKey]types.SendingMessage // Latest message of each SendCoalesced key within its window
+
+	partials map[chunkKey]*partial // Chunked messages being reassembled, see reassemble
 }
 
 // New is used to create a new client object
 func New(address string) (*Client, error) {
-	client := &Client{
-		Address: address,
-		Sending: make(chan types.SendingMessage),
+	return registered(newClient(address))
+}
+
+// NewWithID is New for a client that chooses its own ID, returning ErrIDInUse if another client already has it
+func NewWithID(address string, id uint64) (*Client, error) {
+	client := newClient(address)
+	if _, err := client.register(context.Background(), url.Values{"id": {strconv.FormatUint(id, 10)}}); err != nil {
+		return nil, fmt.Errorf("failed to register client: %w", err)
 	}
 
+	client.ID = id
+
+	return client, nil
+}
+
+// newClient creates a client object that's yet to be registered
+func newClient(address string) *Client {
+	c := &Client{
+		Address:        address,
+		Sending:        make(chan types.SendingMessage),
+		CoalesceWindow: DefaultCoalesceWindow,
+		HTTPTimeout:    DefaultHTTPTimeout,
+		system:         make(chan types.SendingMessage, SystemBufferSize),
+		incoming:       make(chan types.SendingMessage, IncomingBufferSize),
+		publicKeys:     make(map[uint64]*rsa.PublicKey),
+		unacked:        make(map[string]map[uint64]struct{}),
+		unprocessed:    make(map[string]map[uint64]struct{}),
+		failures:       make(map[string]error),
+		coalescing:     make(map[coalesceKey]types.SendingMessage),
+	}
+	c.ackedCond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// registered registers the client with the hub, filling in its ID
+func registered(client *Client) (*Client, error) {
 	id, err := client.Register()
 	if err != nil {
 		return nil, fmt.Errorf("failed to register client: %v", err)
@@ -45,10 +191,44 @@ func New(address string) (*Client, error) {
 }
 
 // do wraps http calls, taking in an interface and ensuring that the interface can be unmarshalled into. This interface should be a pointer reference as its not returned
-func (c *Client) do(address string, object interface{}) error {
-	resp, err := http.Get(address)
+// The client's registration token is presented as a bearer token, for the hub to check it's acting as itself.
+// The call is abandoned once ctx is done, or after the HTTPTimeout.
+func (c *Client) do(ctx context.Context, address string, object interface{}) error {
+	return c.doBody(ctx, "GET", address, nil, object)
+}
+
+// doBody is do with any HTTP method, sending body as the request's data if it isn't nil
+func (c *Client) doBody(ctx context.Context, method, address string, body io.Reader, object interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, address, body)
 	if err != nil {
-		return fmt.Errorf("failed to reach hub %s: %s", c.Address, err)
+		return fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	c.authorize(req.Header)
+	return c.doRequest(req, object)
+}
+
+// authorize adds the client's registration token to header as a bearer token, if it has one
+func (c *Client) authorize(header http.Header) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// doRequest is do for requests that need more than a plain GET, e.g. extra headers.
+// Responses with a failing status code are returned as a *HubError, otherwise the response is unmarshalled into object,
+// unless it's nil for endpoints that don't respond with anything.
+func (c *Client) doRequest(req *http.Request, object interface{}) error {
+	// The default transport advertises Accept-Encoding: gzip and transparently decompresses gzipped responses
+	resp, err := c.timedClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach hub %s: %w", c.Address, err)
 	}
 	defer resp.Body.Close()
 
@@ -57,6 +237,12 @@ func (c *Client) do(address string, object interface{}) error {
 		return fmt.Errorf("failed to read response from %s: %s", c.Address, err)
 	}
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return hubError(resp.StatusCode, b)
+	}
+	if object == nil {
+		return nil
+	}
 	if err := json.Unmarshal(b, &object); err != nil {
 		return fmt.Errorf("failed to unmarshal response from %s: %s", c.Address, err)
 	}
@@ -64,22 +250,129 @@ func (c *Client) do(address string, object interface{}) error {
 
 }
 
-// Register is used to get an ID, and is automatically called by New()
+// Register is used to get an ID, and is automatically called by New(). Encrypted clients also register their public key.
 func (c *Client) Register() (uint64, error) {
+	return c.RegisterContext(context.Background())
+}
+
+// RegisterContext is Register, abandoned once ctx is done
+func (c *Client) RegisterContext(ctx context.Context) (uint64, error) {
+	return c.register(ctx, url.Values{})
+}
+
+// idInUse are the messages the hub refuses to register an ID with because another client has it
+var idInUse = map[string]bool{"ID already in use": true, "ID in use by an active connection": true}
+
+// register calls /register with query, adding the clients public key if it has one.
+// The client can always decompress messages, so it asks the hub to compress those that benefit from it, and it reads
+// envelopes up to types.EnvelopeVersion, see SendEnveloped.
+// It registers with POST, so the hub issues it a token that stops others acting as it, replacing any it held before.
+func (c *Client) register(ctx context.Context, query url.Values) (uint64, error) {
+	query.Set("compress", types.GzipEncoding)
+	query.Set("envelope", strconv.Itoa(types.EnvelopeVersion))
+	if c.privateKey != nil {
+		key, err := c.encodedPublicKey()
+		if err != nil {
+			return 0, err
+		}
+		query.Set("pubkey", key)
+	}
+
+	address := c.url("http", "/register")
+	if len(query) > 0 {
+		address += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", address, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	// Registering its own ID again, the token lets the client reclaim it if the hub still has it registered
+	c.authorize(req.Header)
+
+	// Hubs respond with a types.RegisterResponse, though older ones only gave the bare ID
+	var resp json.RawMessage
+	if err := c.doRequest(req, &resp); err != nil {
+		var hubErr *HubError
+		if errors.As(err, &hubErr) && idInUse[hubErr.Message] {
+			return 0, ErrIDInUse
+		}
+		return 0, err
+	}
+
 	var id uint64
-	return id, c.do(fmt.Sprintf("http://%s/register", c.Address), &id)
+	err = json.Unmarshal(resp, &id)
+	if err == nil {
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		return id, nil
+	}
+
+	// Anything else won't have a ProtocolVersion
+	var detailed types.RegisterResponse
+	if json.Unmarshal(resp, &detailed) != nil || detailed.ProtocolVersion == "" {
+		return 0, fmt.Errorf("failed to unmarshal response from %s: %s", c.Address, err)
+	}
+
+	c.mu.Lock()
+	c.token = detailed.Token
+	c.mu.Unlock()
+	return detailed.ID, nil
 }
 
 // ListUsers is used to wrap the /users endpoint from the hub
 func (c *Client) ListUsers() (types.ListResponse, error) {
+	return c.ListUsersContext(context.Background())
+}
+
+// ListUsersContext is ListUsers, abandoned once ctx is done
+func (c *Client) ListUsersContext(ctx context.Context) (types.ListResponse, error) {
 	var resp types.ListResponse
-	return resp, c.do(fmt.Sprintf("http://%s/users?id=%d", c.Address, c.ID), &resp)
+	return resp, c.do(ctx, c.url("http", fmt.Sprintf("/users?id=%d", c.ID)), &resp)
 }
 
 // Identify is used to wrap the /identify endpoint, using the client.ID to obtain it back after checking with the hub
 func (c *Client) Identify() (uint64, error) {
+	return c.IdentifyContext(context.Background())
+}
+
+// IdentifyContext is Identify, abandoned once ctx is done
+func (c *Client) IdentifyContext(ctx context.Context) (uint64, error) {
+	var id uint64
+	return id, c.do(ctx, c.url("http", fmt.Sprintf("/identify?id=%d", c.ID)), &id)
+}
+
+// Deregister removes the client from the hub, ending the read/write loops without them reconnecting.
+func (c *Client) Deregister() error {
+	var id uint64
+	if err := c.do(context.Background(), c.url("http", fmt.Sprintf("/deregister?id=%d", c.ID)), &id); err != nil {
+		return err
+	}
+
+	if old := c.swapConn(nil, ""); old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// WhoAmI asks the hub which client it associates with the clients websocket connection, so InitWebsocket must be called first
+func (c *Client) WhoAmI() (uint64, error) {
+	c.mu.Lock()
+	token := c.sessionToken
+	c.mu.Unlock()
+	if token == "" {
+		return 0, fmt.Errorf("not connected, call InitWebsocket first")
+	}
+
+	req, err := http.NewRequest("GET", c.url("http", "/whoami"), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
 	var id uint64
-	return id, c.do(fmt.Sprintf("http://%s/identify?id=%d", c.Address, c.ID), &id)
+	return id, c.doRequest(req, &id)
 }
 
 // VerifyRecipients checks that there's not more than MaxRecipient entries, and that they can all be parsed as uint64
@@ -98,18 +391,18 @@ func VerifyRecipients(recipients string) error {
 	return nil
 }
 
-// VerifyFile checks that the file exists, and that it is smaller than MaxDataSize
+// VerifyFile checks that the file exists, and that it is smaller than MaxDataSize.
+// The errors wrap ErrFileNotFound or ErrFileTooBig for those failures, check them with errors.Is.
 func VerifyFile(filepath string) error {
-	f, err := os.Open(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %s", err)
+	stats, err := os.Stat(filepath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, filepath)
 	}
-	stats, err := f.Stat()
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %s", err)
 	}
 	if stats.Size() > MaxDataSize {
-		return fmt.Errorf("file exceeded max size(%d) was: %d", MaxDataSize, stats.Size())
+		return fmt.Errorf("%w, max size(%d) was: %d", ErrFileTooBig, MaxDataSize, stats.Size())
 	}
 
 	return nil
@@ -117,39 +410,251 @@ func VerifyFile(filepath string) error {
 
 // InitWebsocket is a one time call to upgrade the connection to a websocket for sending/receiving messages
 func (c *Client) InitWebsocket() (*websocket.Conn, error) {
-	conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%d", c.Address, c.ID), nil)
+	conn, resp, err := c.dialWebsocket()
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial websocket: %s", err)
+		return nil, fmt.Errorf("failed to dial websocket: %w", err)
 	}
 	// 101 = Switching Protocols, expected for Upgrade requests
 	if resp.StatusCode != 101 {
 		return nil, fmt.Errorf("Non-101 return code: %d", resp.StatusCode)
 	}
+
+	// Loops still running on a connection this replaces return, rather than sharing the channels with the new one
+	if old := c.swapConn(conn, resp.Header.Get(types.SessionTokenHeader)); old != nil {
+		old.Close()
+	}
+
+	c.notify(func(o Observer) { o.OnConnected() })
 	return conn, nil
 }
 
-// WriteMessages is a blocking call constantly writing messages from the clients channel
+// swapConn makes conn the clients latest connection, closing replaced for the one it takes over from in the same
+// critical section so concurrent swaps can't close it twice. The connection taken over from is returned to be closed.
+func (c *Client) swapConn(conn *websocket.Conn, sessionToken string) *websocket.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.conn
+	if old != nil {
+		close(c.replaced)
+	}
+	c.conn, c.sessionToken = conn, sessionToken
+	if conn != nil {
+		c.replaced = make(chan struct{})
+	}
+	return old
+}
+
+// dialWebsocket opens a websocket to the hub for the client, presenting the ProtocolToken if it has one
+func (c *Client) dialWebsocket() (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	if c.ProtocolToken != "" {
+		header.Set(types.ProtocolTokenHeader, c.ProtocolToken)
+	}
+	c.authorize(header)
+
+	return c.dialer().Dial(c.url("ws", fmt.Sprintf("/ws?id=%d", c.ID)), header)
+}
+
+// Reconnect dials a new websocket for the client after its connection dropped.
+// The hub forgets a client once its last connection closes, so the client's ID is registered again first.
+// The old connection is closed, so read/write loops still running on it return and can be restarted on the new one,
+// while Sending, Incoming and System carry on as they were. Messages the old connection failed to write are sent first.
+func (c *Client) Reconnect() (*websocket.Conn, error) {
+	// Fails if the hub hasn't noticed the old connection close yet, which is fine
+	c.register(context.Background(), url.Values{"id": {strconv.FormatUint(c.ID, 10)}})
+
+	// InitWebsocket closes the connection it replaces
+	return c.InitWebsocket()
+}
+
+// Conn returns the clients latest websocket connection, which changes whenever it reconnects
+func (c *Client) Conn() *websocket.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// replacedBy returns a channel closed once conn is no longer the clients latest connection
+func (c *Client) replacedBy(conn *websocket.Conn) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn == c.conn {
+		return c.replaced
+	}
+	replaced := make(chan struct{})
+	close(replaced)
+	return replaced
+}
+
+// WriteMessages is a blocking call constantly writing messages from the clients channel, paced by the Limiter if set.
+// Messages without a MessageID are given one, so the hub acknowledges them.
+// It returns once Reconnect replaces conn, leaving the rest of Sending to the new connection.
+// A message that fails to write is kept to be sent first on the next connection, and with ReconnectOnWriteError
+// WriteMessages makes that connection itself, retrying like RunWithReconnect, so a transient failure loses nothing.
 func (c *Client) WriteMessages(conn *websocket.Conn) error {
 	if conn == nil {
 		return fmt.Errorf("conn can't be nil")
 	}
+	defer c.startWriting()()
+
+	for {
+		err := c.writeMessages(conn)
+		if err == errReplaced || !c.ReconnectOnWriteError {
+			return err
+		}
+
+		if conn, err = c.reconnectWithRetries(); err != nil {
+			return err
+		}
+	}
+}
+
+// startWriting marks a WriteMessages loop as running for Send, returning the func to call once it returns
+func (c *Client) startWriting() func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.writers == 0 {
+		c.writersDone = make(chan struct{})
+	}
+	c.writers++
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.writers--
+		if c.writers == 0 {
+			close(c.writersDone)
+		}
+	}
+}
+
+// Send queues msg on the Sending channel for WriteMessages to write, failing with ErrNotConnected rather than
+// blocking if it isn't running, or stops before taking msg. With SplitOversize, msg is queued as chunks if it's too large.
+func (c *Client) Send(msg types.SendingMessage) error {
+	if c.SplitOversize && int64(len(msg.Data)) > MaxDataSize {
+		return c.sendChunks(msg)
+	}
+
+	c.mu.Lock()
+	writing, done := c.writers > 0, c.writersDone
+	c.mu.Unlock()
+	if !writing {
+		return ErrNotConnected
+	}
+
+	select {
+	case c.Sending <- msg:
+		return nil
+	case <-done:
+		return ErrNotConnected
+	}
+}
+
+// writeMessages writes held and Sending messages down conn until a write fails or conn is replaced
+func (c *Client) writeMessages(conn *websocket.Conn) error {
+	replaced := c.replacedBy(conn)
+
+	c.mu.Lock()
+	unsent := c.unsent
+	c.unsent = nil
+	c.mu.Unlock()
+
+	for i, msg := range unsent {
+		if err := c.writeMessage(conn, msg); err != nil {
+			c.holdUnsent(unsent[i:]...)
+			return err
+		}
+	}
+
 	for {
 		select {
+		case <-replaced:
+			return errReplaced
 		case msg := <-c.Sending:
-			b, err := json.Marshal(msg)
-			if err != nil {
-				return fmt.Errorf("failed to Marshal message: %s", err)
+			if msg.MessageID == "" && !msg.Ack {
+				if id, err := newMessageID(); err == nil {
+					msg.MessageID = id
+				}
 			}
 
-			err = conn.WriteMessage(websocket.TextMessage, b)
-			if err != nil {
-				return fmt.Errorf("failed to write message: %s", err)
+			if c.Limiter != nil {
+				if c.DropWhenLimited {
+					if !c.Limiter.Allow() {
+						c.notify(func(o Observer) { o.OnError(ErrRateLimited) })
+						continue
+					}
+				} else {
+					c.Limiter.Wait()
+				}
+			}
+
+			if err := c.writeMessage(conn, msg); err != nil {
+				c.holdUnsent(msg)
+				return err
 			}
 		}
 	}
 }
 
-// ReadMessages is a blocking call constantly checking for messages from the websocket connection and writing them out to stdout
+// holdUnsent keeps msgs to be written first by the next WriteMessages
+func (c *Client) holdUnsent(msgs ...types.SendingMessage) {
+	c.mu.Lock()
+	c.unsent = append(msgs, c.unsent...)
+	c.mu.Unlock()
+}
+
+// writeMessage writes msg down conn
+func (c *Client) writeMessage(conn *websocket.Conn, msg types.SendingMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		err = fmt.Errorf("failed to Marshal message: %s", err)
+		c.notify(func(o Observer) { o.OnError(err) })
+		return err
+	}
+
+	err = conn.WriteMessage(websocket.TextMessage, b)
+	if err != nil {
+		err = fmt.Errorf("failed to write message: %s", err)
+		c.notify(func(o Observer) { o.OnError(err) })
+		return err
+	}
+	return nil
+}
+
+// SendScheduled queues data on the Sending channel for the hub to hold, then deliver to recipients at the given time
+func (c *Client) SendScheduled(recipients string, data []byte, at time.Time) error {
+	if err := VerifyRecipients(recipients); err != nil {
+		return err
+	}
+
+	return c.Send(types.SendingMessage{Recipients: recipients, Data: data, DeliverAt: at})
+}
+
+// SendHTTP relays data to recipients through the hub's /send endpoint, a one-shot send that doesn't need a websocket.
+// The client is named as the sender, so it isn't sent data itself if it's one of the recipients.
+func (c *Client) SendHTTP(recipients string, data []byte) error {
+	if err := VerifyRecipients(recipients); err != nil {
+		return err
+	}
+	if int64(len(data)) > MaxDataSize {
+		return fmt.Errorf("data exceeded max size(%d) was: %d", MaxDataSize, len(data))
+	}
+
+	query := url.Values{"ids": {recipients}, "from": {strconv.FormatUint(c.ID, 10)}}
+	return c.doBody(context.Background(), "POST", c.url("http", "/send?"+query.Encode()), bytes.NewReader(data), nil)
+}
+
+// System returns the channel that hub-originated messages (e.g. shutdown notices) are delivered on by ReadMessages
+func (c *Client) System() <-chan types.SendingMessage {
+	return c.system
+}
+
+// ReadMessages is a blocking call constantly checking for messages from the websocket connection and writing them out to stdout.
+// Encrypted messages are decrypted first, peer messages are also buffered for Incoming, and System messages are instead passed to the System channel.
 func (c *Client) ReadMessages(conn *websocket.Conn) error {
 	if conn == nil {
 		return fmt.Errorf("conn can't be nil")
@@ -157,8 +662,160 @@ func (c *Client) ReadMessages(conn *websocket.Conn) error {
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			return fmt.Errorf("failed to read message: %v", err)
+			err = fmt.Errorf("failed to read message: %v", err)
+			c.notify(func(o Observer) { o.OnDisconnected(err) })
+			return err
 		}
+		c.dispatch(message)
+	}
+}
+
+// dispatch passes a received message on to System or Incoming, whichever transport it arrived over
+func (c *Client) dispatch(message []byte) {
+	msg, framed, ok := c.receive(message)
+	if !framed {
+		// Not a framed message, e.g. from an older hub, so show it as it is
 		fmt.Printf("Incoming data: %s\n", message)
+		return
+	}
+	c.trackSequence(msg.Sequence)
+	if !ok {
+		return
 	}
+	c.handle(msg)
+}
+
+// handle passes a received message on to where it's read from
+func (c *Client) handle(msg types.SendingMessage) {
+	msg, ok := c.accept(msg)
+	if !ok {
+		return
+	}
+	c.bufferIncoming(msg)
+	if msg.Sender != 0 {
+		fmt.Printf("From %d: %s\n", msg.Sender, msg.Data)
+		return
+	}
+	fmt.Printf("Incoming data: %s\n", msg.Data)
+}
+
+// accept puts a received message through everything before it's read: system messages go to System, the message is
+// acked if AckMessages is set, and duplicates are dropped under ExactlyOnce, then chunks are reassembled and envelopes
+// unwrapped. ok is false if there's nothing to pass on yet.
+func (c *Client) accept(msg types.SendingMessage) (_ types.SendingMessage, ok bool) {
+	if msg.System {
+		select {
+		case c.system <- msg:
+		default:
+			// Nobody is draining system messages, don't hold up peer messages for them
+		}
+		return msg, false
+	}
+	if c.AckMessages && msg.MessageID != "" {
+		// Written by WriteMessages, so don't hold up reading on it. Send gives up if it isn't running, rather than
+		// leaving the ack waiting on Sending forever.
+		go func() {
+			if err := c.Send(types.SendingMessage{MessageID: msg.MessageID, Ack: true}); err != nil {
+				err = fmt.Errorf("failed to ack message %s: %v", msg.MessageID, err)
+				c.notify(func(o Observer) { o.OnError(err) })
+			}
+		}()
+	}
+	if c.ExactlyOnce && msg.MessageID != "" && c.delivered(msg) {
+		return msg, false
+	}
+	if msg.MessageID != "" {
+		c.received(msg)
+	}
+	if msg.ChunkCount > 0 {
+		var complete bool
+		if msg, complete = c.reassemble(msg); !complete {
+			return msg, false
+		}
+	}
+	if msg.Enveloped {
+		msg = unwrap(msg)
+	}
+	return msg, true
+}
+
+// receive decodes a message read from the websocket, decompressing or decrypting it if needed and notifying observers.
+// framed is false if the message isn't a SendingMessage, and ok is false if it's an ack or couldn't be decrypted.
+func (c *Client) receive(message []byte) (msg types.SendingMessage, framed, ok bool) {
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return msg, false, false
+	}
+
+	if msg.Ack {
+		c.acked(msg)
+		return msg, true, false
+	}
+
+	if msg.Encoding == types.GzipEncoding {
+		data, err := gunzip(msg.Data)
+		if err != nil {
+			err = fmt.Errorf("failed to decompress message: %v", err)
+			c.notify(func(o Observer) { o.OnError(err) })
+			return msg, true, false
+		}
+		msg.Data, msg.Encoding = data, ""
+	}
+
+	if msg.Encrypted {
+		plaintext, err := c.decrypt(msg.Data)
+		if err != nil {
+			c.notify(func(o Observer) { o.OnError(err) })
+			return msg, true, false
+		}
+		msg.Data, msg.Encrypted = plaintext, false
+	}
+
+	c.notify(func(o Observer) { o.OnMessage(msg) })
+	return msg, true, true
+}
+
+// Incoming returns the buffer of peer messages received by ReadMessages
+func (c *Client) Incoming() <-chan types.SendingMessage {
+	return c.incoming
+}
+
+// bufferIncoming adds msg to the incoming buffer, making room by dropping the oldest message if it's full
+func (c *Client) bufferIncoming(msg types.SendingMessage) {
+	for {
+		select {
+		case c.incoming <- msg:
+			return
+		default:
+		}
+
+		select {
+		case <-c.incoming:
+		default:
+		}
+	}
+}
+
+// DrainIncoming empties the incoming buffer without blocking, returning the messages it held oldest first.
+// Useful to discard a backlog, e.g. when reconnecting or switching how messages are consumed.
+func (c *Client) DrainIncoming() []types.SendingMessage {
+	var drained []types.SendingMessage
+	for {
+		select {
+		case msg := <-c.incoming:
+			drained = append(drained, msg)
+		default:
+			return drained
+		}
+	}
+}
+
+// gunzip decompresses data the hub compressed with GzipEncoding
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
 }
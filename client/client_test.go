@@ -2,16 +2,29 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/ratelimit"
 	"github.com/StephenBirch/message-delivery-system/types"
 	"github.com/stretchr/testify/require"
 )
 
+// serveHub starts h on an httptest server, returning it and the address clients dial it on. httptest listens before
+// returning, so the hub is reachable as soon as it's dialed.
+func serveHub(h *hub.Hub) (*httptest.Server, string) {
+	serv := httptest.NewServer(h.Router)
+	return serv, strings.TrimPrefix(serv.URL, "http://")
+}
+
 func TestHub_NewClient(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -31,19 +44,13 @@ func TestHub_NewClient(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			h := hub.New()
 
-			// wrap in a http.Server so we can force shutdown later
-			serv := &http.Server{
-				Addr:    ":8080",
-				Handler: h.Router,
-			}
+			serv, address := serveHub(h)
 
-			if tt.hubRunning {
-				go func() {
-					serv.ListenAndServe()
-				}()
+			if !tt.hubRunning {
+				serv.Close()
 			}
 
-			c, err := New("localhost:8080")
+			c, err := New(address)
 			require.Equal(t, tt.expectedError, err != nil)
 
 			if !tt.expectedError {
@@ -54,11 +61,66 @@ func TestHub_NewClient(t *testing.T) {
 				require.Error(t, err)
 			}
 
-			serv.Shutdown(context.Background())
+			serv.Close()
 		})
 	}
 }
 
+func TestClient_NewWithID(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	c, err := NewWithID(address, 500)
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), c.ID)
+
+	id, err := c.Identify()
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), id)
+
+	// Another client choosing the same ID can tell to pick a different one
+	_, err = NewWithID(address, 500)
+	require.True(t, errors.Is(err, ErrIDInUse), "expected ErrIDInUse, got %v", err)
+
+	_, err = NewWithID(address, 501)
+	require.NoError(t, err)
+}
+
+func TestClient_HTTPTimeout(t *testing.T) {
+	// A hub that never answers
+	release := make(chan struct{})
+	serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer serv.Close()
+	defer close(release)
+
+	c := newClient(strings.TrimPrefix(serv.URL, "http://"))
+	require.Equal(t, DefaultHTTPTimeout, c.HTTPTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.IdentifyContext(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "expected the deadline to fire, got %v", err)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	_, err = c.RegisterContext(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "expected the deadline to fire, got %v", err)
+
+	// Without a context the HTTPTimeout still stops the call hanging
+	c.HTTPTimeout = 50 * time.Millisecond
+	start = time.Now()
+	_, err = c.ListUsers()
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
 func TestHub_Identify(t *testing.T) {
 	tests := []struct {
 		name string
@@ -71,24 +133,16 @@ func TestHub_Identify(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			h := hub.New()
 
-			// wrap in a http.Server so we can force shutdown later
-			serv := &http.Server{
-				Addr:    ":8080",
-				Handler: h.Router,
-			}
-
-			go func() {
-				serv.ListenAndServe()
-			}()
+			serv, address := serveHub(h)
 
-			c, err := New("localhost:8080")
+			c, err := New(address)
 			require.NoError(t, err)
 
 			id, err := c.Identify()
 			require.NoError(t, err)
 			require.Equal(t, id, c.ID)
 
-			serv.Shutdown(context.Background())
+			serv.Close()
 		})
 	}
 }
@@ -96,55 +150,31 @@ func TestHub_Identify(t *testing.T) {
 func TestHub_ListUsers(t *testing.T) {
 	tests := []struct {
 		name    string
-		clients map[uint64]chan []byte
+		clients []uint64
 	}{
 		{
-			name: "Two",
-			clients: map[uint64]chan []byte{
-				100: make(chan []byte),
-				200: make(chan []byte),
-			},
+			name:    "Two",
+			clients: []uint64{100, 200},
 		},
 		{
-			name: "Many",
-			clients: map[uint64]chan []byte{
-				100:  make(chan []byte),
-				200:  make(chan []byte),
-				300:  make(chan []byte),
-				400:  make(chan []byte),
-				500:  make(chan []byte),
-				600:  make(chan []byte),
-				700:  make(chan []byte),
-				800:  make(chan []byte),
-				900:  make(chan []byte),
-				2900: make(chan []byte),
-				1800: make(chan []byte),
-				2700: make(chan []byte),
-			},
+			name:    "Many",
+			clients: []uint64{100, 200, 300, 400, 500, 600, 700, 800, 900, 2900, 1800, 2700},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := hub.New()
-			h.Clients = tt.clients
-			// wrap in a http.Server so we can force shutdown later
-			serv := &http.Server{
-				Addr:    ":8080",
-				Handler: h.Router,
-			}
+			h.SeedClients(tt.clients...)
+			serv, address := serveHub(h)
 
-			go func() {
-				serv.ListenAndServe()
-			}()
-
-			c, err := New("localhost:8080")
+			c, err := New(address)
 			require.NoError(t, err)
 
 			users, err := c.ListUsers()
 			require.NoError(t, err)
-			require.Equal(t, len(users.IDs), len(tt.clients)-1)
+			require.Equal(t, len(users.IDs), len(tt.clients))
 
-			serv.Shutdown(context.Background())
+			serv.Close()
 		})
 	}
 }
@@ -194,7 +224,7 @@ func TestVerifyFile(t *testing.T) {
 	tests := []struct {
 		name     string
 		filepath string
-		wantErr  bool
+		wantErr  error
 	}{
 		{
 			name:     "Golden Path",
@@ -203,23 +233,41 @@ func TestVerifyFile(t *testing.T) {
 		{
 			name:     "doesn't exist",
 			filepath: "../exampleData/medium.txt",
-			wantErr:  true,
+			wantErr:  ErrFileNotFound,
 		},
 		{
 			name:     "Too big",
 			filepath: "../exampleData/big.txt",
-			wantErr:  true,
+			wantErr:  ErrFileTooBig,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := VerifyFile(tt.filepath); (err != nil) != tt.wantErr {
+			err := VerifyFile(tt.filepath)
+			if (err != nil) != (tt.wantErr != nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
 				t.Errorf("VerifyFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
+func TestVerifyFile_NoDescriptorLeak(t *testing.T) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("can't count open descriptors without /proc:", err)
+	}
+	before := len(fds)
+
+	for i := 0; i < 5000; i++ {
+		require.NoError(t, VerifyFile("../exampleData/small.txt"))
+	}
+
+	fds, err = ioutil.ReadDir("/proc/self/fd")
+	require.NoError(t, err)
+	// Allow for the runtime opening a few of its own meanwhile
+	require.True(t, len(fds) <= before+5, "descriptors grew from %d to %d", before, len(fds))
+}
+
 func TestHub_InitWebsocket(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -239,17 +287,9 @@ func TestHub_InitWebsocket(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			h := hub.New()
 
-			// wrap in a http.Server so we can force shutdown later
-			serv := &http.Server{
-				Addr:    ":8080",
-				Handler: h.Router,
-			}
+			serv, address := serveHub(h)
 
-			go func() {
-				serv.ListenAndServe()
-			}()
-
-			c, err := New("localhost:8080")
+			c, err := New(address)
 			require.NoError(t, err)
 			require.NotNil(t, c)
 
@@ -264,7 +304,7 @@ func TestHub_InitWebsocket(t *testing.T) {
 				conn.Close()
 			}
 
-			serv.Shutdown(context.Background())
+			serv.Close()
 		})
 	}
 }
@@ -285,17 +325,9 @@ func TestHub_WriteMessages(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			h := hub.New()
 
-			// wrap in a http.Server so we can force shutdown later
-			serv := &http.Server{
-				Addr:    ":8080",
-				Handler: h.Router,
-			}
-
-			go func() {
-				serv.ListenAndServe()
-			}()
+			serv, address := serveHub(h)
 
-			c, err := New("localhost:8080")
+			c, err := New(address)
 			require.NoError(t, err)
 			require.NotNil(t, c)
 
@@ -305,7 +337,7 @@ func TestHub_WriteMessages(t *testing.T) {
 
 			go func() {
 				if err := c.WriteMessages(conn); err != nil {
-					t.Fatalf("Unexpected Error")
+					t.Errorf("Unexpected Error")
 				}
 			}()
 
@@ -313,7 +345,566 @@ func TestHub_WriteMessages(t *testing.T) {
 
 			time.Sleep(time.Second)
 
-			serv.Shutdown(context.Background())
+			serv.Close()
+		})
+	}
+}
+
+func TestClient_System(t *testing.T) {
+	tests := []struct {
+		name     string
+		trigger  func(h *hub.Hub)
+		expected []byte
+	}{
+		{
+			name:     "Announce",
+			trigger:  func(h *hub.Hub) { h.Announce([]byte("maintenance at noon")) },
+			expected: []byte("maintenance at noon"),
+		},
+		{
+			name:     "Shutdown",
+			trigger:  func(h *hub.Hub) { require.NoError(t, h.Shutdown(context.Background())) },
+			expected: []byte("Hub is shutting down"),
+		},
+		{
+			name:     "Pause",
+			trigger:  func(h *hub.Hub) { h.Pause() },
+			expected: []byte("Hub is paused, sends are refused until it resumes"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+			require.NoError(t, err)
+
+			conn, err := c.InitWebsocket()
+			require.NoError(t, err)
+			defer conn.Close()
+
+			go c.ReadMessages(conn)
+
+			tt.trigger(h)
+
+			select {
+			case msg := <-c.System():
+				require.True(t, msg.System)
+				require.Equal(t, tt.expected, msg.Data)
+			case <-time.After(5 * time.Second):
+				t.Fatal("system message not received")
+			}
+		})
+	}
+}
+
+func TestClient_Limiter(t *testing.T) {
+	tests := []struct {
+		name             string
+		limiter          *ratelimit.Limiter
+		drop             bool
+		send             int
+		expectedMessages int
+		expectedErrors   int
+		minDuration      time.Duration
+	}{
+		{
+			name:             "Block",
+			limiter:          ratelimit.New(20, 1),
+			send:             5,
+			expectedMessages: 5,
+			// First message uses the burst, the rest are paced 50ms apart
+			minDuration: 190 * time.Millisecond,
+		},
+		{
+			name:             "Drop",
+			limiter:          ratelimit.New(0.001, 2),
+			drop:             true,
+			send:             5,
+			expectedMessages: 2,
+			expectedErrors:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+			require.NoError(t, err)
+			c.Limiter = tt.limiter
+			c.DropWhenLimited = tt.drop
+
+			observer := &recordingObserver{events: make(chan string, 100)}
+			c.RegisterObserver(observer)
+
+			conn, err := c.InitWebsocket()
+			require.NoError(t, err)
+			defer conn.Close()
+			expectEvent(t, observer, "connected")
+
+			go c.WriteMessages(conn)
+			go c.ReadMessages(conn)
+
+			start := time.Now()
+			for i := 0; i < tt.send; i++ {
+				c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("tick")}
+			}
+
+			messages, errors := 0, 0
+			for messages+errors < tt.send {
+				select {
+				case event := <-observer.events:
+					switch event {
+					case "message tick":
+						messages++
+					case "error":
+						errors++
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("only saw %d messages and %d errors", messages, errors)
+				}
+			}
+
+			require.Equal(t, tt.expectedMessages, messages)
+			require.Equal(t, tt.expectedErrors, errors)
+			require.GreaterOrEqual(t, int64(time.Since(start)), int64(tt.minDuration))
 		})
 	}
 }
+
+func TestClient_SendScheduled(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+
+	observer := &recordingObserver{events: make(chan string, 10)}
+	recipient.RegisterObserver(observer)
+
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	expectEvent(t, observer, "connected")
+	go recipient.ReadMessages(recipientConn)
+
+	at := time.Now().Add(500 * time.Millisecond)
+	require.NoError(t, sender.SendScheduled(fmt.Sprint(recipient.ID), []byte("later"), at))
+
+	expectEvent(t, observer, "message later")
+	require.False(t, time.Now().Before(at), "delivered before it was scheduled")
+	require.WithinDuration(t, at, time.Now(), time.Second)
+}
+
+func TestClient_SendHTTP(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go recipient.Receive(ctx)
+	require.Eventually(t, func() bool { return recipient.Transport() != "" }, time.Second, 10*time.Millisecond)
+
+	// The sender never opens a websocket
+	sender, err := New(address)
+	require.NoError(t, err)
+	require.NoError(t, sender.SendHTTP(fmt.Sprint(recipient.ID), []byte("One-shot")))
+
+	select {
+	case msg := <-recipient.Incoming():
+		require.Equal(t, "One-shot", string(msg.Data))
+		require.Equal(t, sender.ID, msg.Sender)
+	case <-time.After(5 * time.Second):
+		t.Fatal("recipient didn't receive the message")
+	}
+
+	err = sender.SendHTTP("500", []byte("Nobody"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ID not registered")
+}
+
+func TestClient_DrainIncoming(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	go c.WriteMessages(conn)
+	go c.ReadMessages(conn)
+
+	// Nothing is buffered yet
+	require.Empty(t, c.DrainIncoming())
+
+	sent := []string{"one", "two", "three"}
+	for _, data := range sent {
+		c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte(data)}
+	}
+	require.Eventually(t, func() bool { return len(c.incoming) == len(sent) }, 5*time.Second, 10*time.Millisecond)
+
+	drained := c.DrainIncoming()
+	require.Len(t, drained, len(sent))
+	for i, msg := range drained {
+		require.Equal(t, []byte(sent[i]), msg.Data)
+	}
+
+	require.Empty(t, c.DrainIncoming())
+	require.Len(t, c.incoming, 0)
+}
+
+func TestClient_ListUsersGzip(t *testing.T) {
+	h := hub.New()
+	for id := uint64(1); id <= 1000; id++ {
+		h.SeedClients(id)
+	}
+
+	// Record what the client asked for, and what the hub answered with
+	var acceptEncoding, contentEncoding string
+	serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.Router.ServeHTTP(w, r)
+		if r.URL.Path == "/users" {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			contentEncoding = w.Header().Get("Content-Encoding")
+		}
+	}))
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	users, err := c.ListUsers()
+	require.NoError(t, err)
+	require.Len(t, users.IDs, 1000)
+
+	require.Equal(t, "gzip", acceptEncoding)
+	require.Equal(t, "gzip", contentEncoding)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+	id := c.ID
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	conn.Close()
+
+	// Once the hub notices the drop it forgets the client
+	require.Eventually(t, func() bool {
+		_, err := c.Identify()
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	conn, err = c.Reconnect()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Equal(t, id, c.ID)
+	identified, err := c.Identify()
+	require.NoError(t, err)
+	require.Equal(t, id, identified)
+}
+
+func TestClient_ReconnectKeepsChannels(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+	incoming := c.Incoming()
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	writeErrs := make(chan error, 1)
+	go func() { writeErrs <- c.WriteMessages(conn) }()
+	go c.ReadMessages(conn)
+
+	c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("before")}
+	select {
+	case msg := <-incoming:
+		require.Equal(t, "before", string(msg.Data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received before reconnecting")
+	}
+
+	// Drop the connection, and wait for the hub to forget the client
+	conn.Close()
+	require.Eventually(t, func() bool {
+		_, err := c.Identify()
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	newConn, err := c.Reconnect()
+	require.NoError(t, err)
+	defer newConn.Close()
+
+	// The writer on the old connection stops rather than taking messages meant for the new one
+	select {
+	case err := <-writeErrs:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteMessages didn't return once the connection was replaced")
+	}
+
+	go c.WriteMessages(newConn)
+	go c.ReadMessages(newConn)
+
+	// The same channels carry on working over the new connection
+	c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("after")}
+	select {
+	case msg := <-incoming:
+		require.Equal(t, "after", string(msg.Data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received after reconnecting")
+	}
+}
+
+func TestClient_WhoAmI(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	// Without a connection the hub has nothing to identify the client by
+	_, err = c.WhoAmI()
+	require.Error(t, err)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	id, err := c.WhoAmI()
+	require.NoError(t, err)
+	require.Equal(t, c.ID, id)
+}
+
+func TestClient_Send(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	msg := types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("Hi")}
+
+	// Nothing would ever take the message, so it fails rather than blocking
+	require.Equal(t, ErrNotConnected, c.Send(msg))
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	require.Equal(t, ErrNotConnected, c.Send(msg))
+
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- c.WriteMessages(conn) }()
+	go c.ReadMessages(conn)
+
+	require.Eventually(t, func() bool { return c.Send(msg) == nil }, time.Second, 10*time.Millisecond)
+
+	select {
+	case got := <-c.Incoming():
+		require.Equal(t, msg.Data, got.Data)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	// Once the write loop has returned, sends fail again
+	conn.Close()
+	require.NoError(t, c.Send(msg))
+	<-writeErr
+	require.Equal(t, ErrNotConnected, c.Send(msg))
+}
+
+func TestClient_Deregister(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- c.WriteMessages(conn) }()
+
+	require.NoError(t, c.Deregister())
+
+	select {
+	case err := <-writeErr:
+		require.Equal(t, errReplaced, err)
+	case <-time.After(time.Second):
+		t.Fatal("WriteMessages still running after Deregister")
+	}
+
+	_, err = c.Identify()
+	require.Error(t, err)
+
+	// Already gone, so the hub refuses a second time
+	require.Error(t, c.Deregister())
+}
+
+func TestClient_RegisterDetailed(t *testing.T) {
+	h := hub.New()
+	h.DetailedRegistration = true
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	id, err := c.Identify()
+	require.NoError(t, err)
+	require.Equal(t, c.ID, id)
+}
+
+func TestClient_ReceiveCompressed(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	go c.WriteMessages(conn)
+	go c.ReadMessages(conn)
+
+	// Large enough that the hub compresses it for the client, which decompresses it transparently
+	data := strings.Repeat("compress me ", 100)
+	c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte(data)}
+
+	select {
+	case msg := <-c.Incoming():
+		require.Equal(t, data, string(msg.Data))
+		require.Empty(t, msg.Encoding)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestClient_ReconnectOnWriteError(t *testing.T) {
+	interval := ReconnectInterval
+	ReconnectInterval = 10 * time.Millisecond
+	defer func() { ReconnectInterval = interval }()
+
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.ReadMessages(recipientConn)
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	sender.ReconnectOnWriteError = true
+	observer := &recordingObserver{events: make(chan string, 10)}
+	sender.RegisterObserver(observer)
+
+	conn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	expectEvent(t, observer, "connected")
+
+	// Closing the connection underneath WriteMessages makes the first write fail
+	conn.Close()
+	writeErrs := make(chan error, 1)
+	go func() { writeErrs <- sender.WriteMessages(conn) }()
+	defer func() { sender.Conn().Close() }()
+
+	sender.Sending <- types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("retried")}
+	expectEvent(t, observer, "error")
+
+	select {
+	case msg := <-recipient.Incoming():
+		require.Equal(t, "retried", string(msg.Data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered after reconnecting")
+	}
+	require.True(t, conn != sender.Conn(), "connection wasn't replaced")
+
+	select {
+	case err := <-writeErrs:
+		t.Fatalf("WriteMessages returned rather than reconnecting: %v", err)
+	default:
+	}
+}
+
+func TestClient_ConcurrentReconnect(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- c.WriteMessages(conn) }()
+
+	// Each replaces whatever connection is latest when it's done dialing, so none can close the same one twice
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Reconnect()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Deregister()
+	}()
+	wg.Wait()
+
+	select {
+	case err := <-writeErr:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteMessages still running on a replaced connection")
+	}
+	if latest := c.Conn(); latest != nil {
+		latest.Close()
+	}
+}
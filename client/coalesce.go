@@ -0,0 +1,48 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// DefaultCoalesceWindow is the CoalesceWindow new clients start with
+var DefaultCoalesceWindow = 100 * time.Millisecond
+
+// coalesceKey identifies the sends SendCoalesced collapses together
+type coalesceKey struct {
+	recipient uint64
+	key       string
+}
+
+// SendCoalesced queues data for recipient like any other send, except that further sends with the same key to the same
+// recipient within the CoalesceWindow replace it, so only the latest is written. Suits updates where only the most
+// recent matters, e.g. presence ticks. It doesn't block, the message is queued on the Sending channel once the window ends.
+func (c *Client) SendCoalesced(recipient uint64, data []byte, key string) error {
+	if int64(len(data)) > MaxDataSize {
+		return fmt.Errorf("data exceeded max size(%d) was: %d", MaxDataSize, len(data))
+	}
+
+	k := coalesceKey{recipient: recipient, key: key}
+	msg := types.SendingMessage{Recipients: fmt.Sprint(recipient), Data: data}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, waiting := c.coalescing[k]; waiting {
+		c.coalescing[k] = msg
+		return nil
+	}
+	c.coalescing[k] = msg
+
+	time.AfterFunc(c.CoalesceWindow, func() {
+		c.mu.Lock()
+		latest := c.coalescing[k]
+		delete(c.coalescing, k)
+		c.mu.Unlock()
+
+		c.Sending <- latest
+	})
+	return nil
+}
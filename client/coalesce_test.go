@@ -0,0 +1,67 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendCoalesced(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+	c.CoalesceWindow = 200 * time.Millisecond
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	go c.WriteMessages(conn)
+	go c.ReadMessages(conn)
+
+	// Rapid updates under two keys, interleaved
+	for _, update := range []struct{ key, data string }{
+		{"position", "1"},
+		{"status", "away"},
+		{"position", "2"},
+		{"position", "3"},
+		{"status", "busy"},
+	} {
+		require.NoError(t, c.SendCoalesced(c.ID, []byte(update.data), update.key))
+	}
+
+	// Only the latest of each key is written
+	var received []string
+	timeout := time.After(time.Second)
+	for len(received) < 2 {
+		select {
+		case msg := <-c.Incoming():
+			received = append(received, string(msg.Data))
+		case <-timeout:
+			t.Fatalf("only received %v", received)
+		}
+	}
+	require.ElementsMatch(t, []string{"3", "busy"}, received)
+
+	select {
+	case msg := <-c.Incoming():
+		t.Fatalf("received superseded update %q", msg.Data)
+	case <-time.After(c.CoalesceWindow + 100*time.Millisecond):
+	}
+
+	// Once the window has passed a new one starts
+	require.NoError(t, c.SendCoalesced(c.ID, []byte("4"), "position"))
+	select {
+	case msg := <-c.Incoming():
+		require.Equal(t, "4", string(msg.Data))
+	case <-time.After(time.Second):
+		t.Fatal("update after the window not received")
+	}
+}
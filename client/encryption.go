@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// EncryptionKeyBits is the size of the RSA key pair generated by NewEncrypted
+var EncryptionKeyBits = 2048
+
+// NewEncrypted creates a client like New, but with a fresh key pair whose public half is registered with the hub.
+// Peers can then SendEncrypted to it, and ReadMessages transparently decrypts what they send.
+func NewEncrypted(address string) (*Client, error) {
+	key, err := rsa.GenerateKey(rand.Reader, EncryptionKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	client := newClient(address)
+	client.privateKey = key
+
+	return registered(client)
+}
+
+// encodedPublicKey returns the clients public key in the form the hub expects on /register
+func (c *Client) encodedPublicKey() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&c.privateKey.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(der), nil
+}
+
+// PublicKey fetches the public key the given client registered with the hub, caching it for subsequent sends
+func (c *Client) PublicKey(id uint64) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, cached := c.publicKeys[id]
+	c.mu.Unlock()
+	if cached {
+		return key, nil
+	}
+
+	var encoded string
+	if err := c.do(context.Background(), c.url("http", fmt.Sprintf("/pubkey?id=%d", id)), &encoded); err != nil {
+		return nil, err
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key of %d: %v", id, err)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key of %d: %v", id, err)
+	}
+
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key of %d is not an RSA key", id)
+	}
+
+	c.mu.Lock()
+	c.publicKeys[id] = key
+	c.mu.Unlock()
+
+	return key, nil
+}
+
+// SendEncrypted encrypts plaintext to the recipient's public key and queues it on the Sending channel.
+// Only the recipient can decrypt it, the hub relays the ciphertext without being able to read it.
+func (c *Client) SendEncrypted(recipient uint64, plaintext []byte) error {
+	key, err := c.PublicKey(recipient)
+	if err != nil {
+		return fmt.Errorf("failed to get public key of %d: %v", recipient, err)
+	}
+
+	data, err := encrypt(key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	return c.Send(types.SendingMessage{Recipients: fmt.Sprint(recipient), Data: data, Encrypted: true})
+}
+
+// encrypt seals plaintext with a random AES-256-GCM key, which is itself encrypted to key with RSA-OAEP.
+// The result is the encrypted AES key, followed by the GCM nonce, followed by the sealed plaintext.
+func encrypt(key *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, fmt.Errorf("failed to generate session key: %v", err)
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt session key: %v", err)
+	}
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	data := append(wrappedKey, nonce...)
+	return gcm.Seal(data, nonce, plaintext, nil), nil
+}
+
+// decrypt reverses encrypt using the clients private key
+func (c *Client) decrypt(data []byte) ([]byte, error) {
+	if c.privateKey == nil {
+		return nil, fmt.Errorf("received an encrypted message but the client has no private key")
+	}
+
+	keySize := c.privateKey.Size()
+	if len(data) < keySize {
+		return nil, fmt.Errorf("encrypted message too short")
+	}
+
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, c.privateKey, data[:keySize], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt session key: %v", err)
+	}
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := data[keySize:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted message too short")
+	}
+
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %v", err)
+	}
+	return plaintext, nil
+}
+
+// newGCM creates an AES-GCM AEAD from key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %v", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendEncrypted(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := NewEncrypted(address)
+	require.NoError(t, err)
+
+	recipient, err := NewEncrypted(address)
+	require.NoError(t, err)
+
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	plaintext := []byte("for your eyes only")
+
+	// With the recipient not yet connected, its message waits in the hub where we can inspect it as the hub sees it
+	errs := make(chan error, 1)
+	go func() { errs <- sender.SendEncrypted(recipient.ID, plaintext) }()
+
+	var frame []byte
+	select {
+	case frame = <-h.Clients[recipient.ID]:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message never reached the hub")
+	}
+	require.NoError(t, <-errs)
+	require.False(t, bytes.Contains(frame, plaintext), "hub could read the plaintext")
+
+	var relayed types.SendingMessage
+	require.NoError(t, json.Unmarshal(frame, &relayed))
+	require.True(t, relayed.Encrypted)
+
+	// Only the recipient's private key can open it
+	_, err = sender.decrypt(relayed.Data)
+	require.Error(t, err)
+
+	// End to end, the recipient transparently gets the plaintext back
+	observer := &recordingObserver{events: make(chan string, 10)}
+	recipient.RegisterObserver(observer)
+
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	expectEvent(t, observer, "connected")
+	go recipient.ReadMessages(recipientConn)
+
+	require.NoError(t, sender.SendEncrypted(recipient.ID, plaintext))
+	expectEvent(t, observer, "message "+string(plaintext))
+}
+
+func TestClient_PublicKey(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	encrypted, err := NewEncrypted(address)
+	require.NoError(t, err)
+
+	plain, err := New(address)
+	require.NoError(t, err)
+
+	key, err := plain.PublicKey(encrypted.ID)
+	require.NoError(t, err)
+	require.Equal(t, &encrypted.privateKey.PublicKey, key)
+
+	// Clients registered without a key have nothing to encrypt to
+	_, err = encrypted.PublicKey(plain.ID)
+	require.Error(t, err)
+	require.Error(t, encrypted.SendEncrypted(plain.ID, []byte("secret")))
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// EnvelopeVersionFor returns the newest types.Envelope version client id reads, as it registered with the hub.
+// 0 means it only reads plain messages.
+func (c *Client) EnvelopeVersionFor(id uint64) (int, error) {
+	var version int
+	return version, c.do(context.Background(), c.url("http", fmt.Sprintf("/envelope?id=%d", id)), &version)
+}
+
+// SendEnveloped queues msg to each of its recipients in an Envelope of the newest version both they and the client read,
+// so the message schema can change without every client changing at once. Recipients that don't read envelopes are
+// sent msg as it is.
+func (c *Client) SendEnveloped(msg types.SendingMessage) error {
+	if err := VerifyRecipients(msg.Recipients); err != nil {
+		return err
+	}
+
+	// Recipients reading the same version share a message
+	byVersion := make(map[int][]string)
+	for _, recipient := range strings.Split(msg.Recipients, ",") {
+		id, _ := strconv.ParseUint(recipient, 10, 64)
+		version, err := c.EnvelopeVersionFor(id)
+		if err != nil {
+			return fmt.Errorf("failed to negotiate envelope version with %d: %v", id, err)
+		}
+		if version > types.EnvelopeVersion {
+			version = types.EnvelopeVersion
+		}
+		byVersion[version] = append(byVersion[version], recipient)
+	}
+
+	for version, recipients := range byVersion {
+		out := msg
+		out.Recipients = strings.Join(recipients, ",")
+		if version > 0 {
+			inner, err := json.Marshal(out)
+			if err != nil {
+				return fmt.Errorf("failed to Marshal message: %s", err)
+			}
+			envelope, err := json.Marshal(types.Envelope{Version: version, Message: inner})
+			if err != nil {
+				return fmt.Errorf("failed to Marshal envelope: %s", err)
+			}
+			// The hub still reads the fields it delivers by from outside the envelope
+			out = types.SendingMessage{
+				Recipients:    out.Recipients,
+				Data:          envelope,
+				Enveloped:     true,
+				DeliverAt:     msg.DeliverAt,
+				Priority:      msg.Priority,
+				MessageID:     msg.MessageID,
+				CorrelationID: msg.CorrelationID,
+			}
+		}
+
+		if err := c.Send(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unwrap returns the message inside an Enveloped msg, with the Sender, Sequence and MessageID the hub delivered it with.
+// Envelopes of a version the client doesn't read are returned as they are, for the caller to make sense of.
+func unwrap(msg types.SendingMessage) types.SendingMessage {
+	var envelope types.Envelope
+	if err := json.Unmarshal(msg.Data, &envelope); err != nil || envelope.Version < 1 || envelope.Version > types.EnvelopeVersion {
+		return msg
+	}
+
+	var inner types.SendingMessage
+	if err := json.Unmarshal(envelope.Message, &inner); err != nil {
+		return msg
+	}
+	inner.Sender, inner.Sequence, inner.MessageID = msg.Sender, msg.Sequence, msg.MessageID
+	return inner
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendEnveloped(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.ReadMessages(recipientConn)
+
+	// Registered by something that doesn't read envelopes
+	resp, err := http.Get(serv.URL + "/register?id=500")
+	require.NoError(t, err)
+	resp.Body.Close()
+	plain := newClient(address)
+	plain.ID = 500
+	plainConn, err := plain.InitWebsocket()
+	require.NoError(t, err)
+	defer plainConn.Close()
+	go plain.ReadMessages(plainConn)
+
+	version, err := sender.EnvelopeVersionFor(recipient.ID)
+	require.NoError(t, err)
+	assert.Equal(t, types.EnvelopeVersion, version)
+	version, err = sender.EnvelopeVersionFor(plain.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	// receive returns the next message c receives
+	receive := func(c *Client) types.SendingMessage {
+		select {
+		case msg := <-c.Incoming():
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d didn't receive a message", c.ID)
+		}
+		return types.SendingMessage{}
+	}
+
+	// A v1 envelope for the recipient, and a plain message for the client that doesn't read them
+	msg := types.SendingMessage{
+		Recipients: fmt.Sprintf("%d,%d", recipient.ID, plain.ID),
+		Data:       []byte("Hello v1"),
+		Metadata:   map[string]string{"trace-id": "abc"},
+	}
+	require.Eventually(t, func() bool { return sender.SendEnveloped(msg) != ErrNotConnected }, time.Second, 10*time.Millisecond)
+
+	for _, c := range []*Client{recipient, plain} {
+		received := receive(c)
+		assert.False(t, received.Enveloped)
+		assert.Equal(t, "Hello v1", string(received.Data))
+		assert.Equal(t, msg.Metadata, received.Metadata)
+		assert.Equal(t, sender.ID, received.Sender)
+	}
+
+	// A newer envelope than the recipient reads is still routed, and passed on as it is
+	stub, err := json.Marshal(types.Envelope{Version: 2, Message: json.RawMessage(`{"Body":"Hello v2"}`)})
+	require.NoError(t, err)
+	require.NoError(t, sender.Send(types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: stub, Enveloped: true}))
+
+	received := receive(recipient)
+	require.True(t, received.Enveloped)
+	var envelope types.Envelope
+	require.NoError(t, json.Unmarshal(received.Data, &envelope))
+	assert.Equal(t, 2, envelope.Version)
+	assert.Equal(t, `{"Body":"Hello v2"}`, string(envelope.Message))
+}
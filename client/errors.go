@@ -0,0 +1,30 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HubError is an error response from the hub, which responds to failed requests with a JSON status and message
+type HubError struct {
+	// Code is the HTTP status code of the response
+	Code    int `json:"-"`
+	Status  string
+	Message string
+}
+
+func (e *HubError) Error() string {
+	return fmt.Sprintf("hub responded %d %s: %s", e.Code, e.Status, e.Message)
+}
+
+// hubError decodes the body of a response with a failing status code into a HubError.
+// Bodies that aren't the hub's usual JSON, e.g. from a proxy in front of it, become the Message as they are.
+func hubError(code int, body []byte) *HubError {
+	e := &HubError{Code: code}
+	if json.Unmarshal(body, e) != nil || e.Message == "" {
+		e.Status, e.Message = http.StatusText(code), strings.TrimSpace(string(body))
+	}
+	return e
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_HubError(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c := newClient(strings.TrimPrefix(serv.URL, "http://"))
+	c.ID = 500
+
+	id, err := c.Identify()
+	require.Equal(t, uint64(0), id)
+	var hubErr *HubError
+	require.True(t, errors.As(err, &hubErr), "expected a *HubError, got %v", err)
+	require.Equal(t, &HubError{Code: 400, Status: "Bad Request", Message: "ID not registered"}, hubErr)
+	require.EqualError(t, err, "hub responded 400 Bad Request: ID not registered")
+
+	// Error responses that aren't from the hub itself are passed on as they are
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	c.Address = strings.TrimPrefix(proxy.URL, "http://")
+
+	_, err = c.ListUsers()
+	require.True(t, errors.As(err, &hubErr), "expected a *HubError, got %v", err)
+	require.Equal(t, &HubError{Code: 502, Status: "Bad Gateway", Message: "upstream unavailable"}, hubErr)
+}
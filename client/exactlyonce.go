@@ -0,0 +1,36 @@
+package client
+
+import "github.com/StephenBirch/message-delivery-system/types"
+
+// DeliveredMarkersSize is how many of the latest messages received with ExactlyOnce are remembered to suppress repeats of
+var DeliveredMarkersSize = 1024
+
+// deliveryKey identifies a message by who sent it, as MessageIDs are only unique to their sender
+type deliveryKey struct {
+	sender    uint64
+	messageID string
+}
+
+// delivered records a delivery marker for msg, reporting whether it had one already, i.e. it's a repeat of a message the
+// client has received before. Markers outlive reconnects, the oldest being forgotten past DeliveredMarkersSize.
+func (c *Client) delivered(msg types.SendingMessage) bool {
+	key := deliveryKey{msg.Sender, msg.MessageID}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.deliveredMarkers == nil {
+		c.deliveredMarkers = make(map[deliveryKey]struct{})
+	}
+	if _, exists := c.deliveredMarkers[key]; exists {
+		return true
+	}
+
+	c.deliveredMarkers[key] = struct{}{}
+	c.deliveredOrder = append(c.deliveredOrder, key)
+	for len(c.deliveredOrder) > DeliveredMarkersSize {
+		delete(c.deliveredMarkers, c.deliveredOrder[0])
+		c.deliveredOrder = c.deliveredOrder[1:]
+	}
+	return false
+}
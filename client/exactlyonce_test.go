@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ExactlyOnce(t *testing.T) {
+	tests := []struct {
+		name               string
+		exactlyOnce        bool
+		expectedDeliveries int
+	}{
+		{
+			name:               "Redelivery suppressed",
+			exactlyOnce:        true,
+			expectedDeliveries: 1,
+		},
+		{
+			name:               "Redelivery passed on without ExactlyOnce",
+			expectedDeliveries: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			h.AckTimeout = 300 * time.Millisecond
+			h.MaxRedeliveries = 3
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			sender, err := New(address)
+			require.NoError(t, err)
+			senderConn, err := sender.InitWebsocket()
+			require.NoError(t, err)
+			defer senderConn.Close()
+			go sender.WriteMessages(senderConn)
+			go sender.ReadMessages(senderConn)
+
+			recipient, err := New(address)
+			require.NoError(t, err)
+			recipient.ExactlyOnce = tt.exactlyOnce
+			firstConn, err := recipient.InitWebsocket()
+			require.NoError(t, err)
+			firstRead := make(chan error, 1)
+			go func() { firstRead <- recipient.ReadMessages(firstConn) }()
+
+			_, err = sender.SendReliable(types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("once")})
+			require.NoError(t, err)
+
+			// Received on the first connection, but the ack is lost as AckMessages isn't set yet
+			select {
+			case msg := <-recipient.Incoming():
+				require.Equal(t, "once", string(msg.Data))
+			case <-time.After(5 * time.Second):
+				t.Fatal("message wasn't delivered")
+			}
+
+			conn, err := recipient.Reconnect()
+			require.NoError(t, err)
+			defer conn.Close()
+			<-firstRead
+
+			// The hub redelivers the unacknowledged message on the new connection, which acks it this time
+			recipient.AckMessages = true
+			go recipient.WriteMessages(conn)
+			go recipient.ReadMessages(conn)
+
+			assert.Eventually(t, func() bool { return sender.Unacked() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+			deliveries := 1
+			for {
+				select {
+				case msg := <-recipient.Incoming():
+					require.Equal(t, "once", string(msg.Data))
+					deliveries++
+					continue
+				case <-time.After(2 * h.AckTimeout):
+				}
+				break
+			}
+			assert.Equal(t, tt.expectedDeliveries, deliveries)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// SetFilter has the hub only deliver this client messages matching filter, dropping the rest before they're sent.
+// It's tied to the websocket connection's session, so InitWebsocket must be called first.
+func (c *Client) SetFilter(filter types.Filter) error {
+	b, err := json.Marshal(filter)
+	if err != nil {
+		return fmt.Errorf("failed to Marshal filter: %s", err)
+	}
+	return c.sessionRequest("PUT", "/filter", bytes.NewReader(b), nil)
+}
+
+// ClearFilter has the hub deliver this client every message again
+func (c *Client) ClearFilter() error {
+	return c.sessionRequest("DELETE", "/filter", nil, nil)
+}
+
+// sessionRequest calls the hub's path with method and a JSON body, authenticated by the session token.
+// The response is unmarshalled into object, unless it's nil.
+func (c *Client) sessionRequest(method, path string, body io.Reader, object interface{}) error {
+	c.mu.Lock()
+	token := c.sessionToken
+	c.mu.Unlock()
+	if token == "" {
+		return fmt.Errorf("not connected, call InitWebsocket first")
+	}
+
+	req, err := http.NewRequest(method, c.url("http", path), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.timedClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach hub %s: %s", c.Address, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response from %s: %s", c.Address, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return hubError(resp.StatusCode, b)
+	}
+
+	if object == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, object); err != nil {
+		return fmt.Errorf("failed to unmarshal response from %s: %s", c.Address, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SetFilter(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	receiver, err := New(address)
+	require.NoError(t, err)
+	require.Error(t, receiver.SetFilter(types.Filter{}), "filter set before connecting")
+
+	receiverConn, err := receiver.InitWebsocket()
+	require.NoError(t, err)
+	defer receiverConn.Close()
+	go receiver.ReadMessages(receiverConn)
+
+	require.NoError(t, receiver.SetFilter(types.Filter{Metadata: map[string]string{"type": "alert"}}))
+
+	recipient := fmt.Sprint(receiver.ID)
+	require.NoError(t, sender.SendWithMetadata(recipient, []byte("chatter"), map[string]string{"type": "chat"}))
+	sender.Sending <- types.SendingMessage{Recipients: recipient, Data: []byte("no metadata")}
+	require.NoError(t, sender.SendWithMetadata(recipient, []byte("alert"), map[string]string{"type": "alert"}))
+
+	expectData := func(expected string) {
+		select {
+		case msg := <-receiver.Incoming():
+			require.Equal(t, expected, string(msg.Data))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q wasn't received", expected)
+		}
+	}
+	expectData("alert")
+
+	// Once cleared everything is delivered again
+	require.NoError(t, receiver.ClearFilter())
+	require.NoError(t, sender.SendWithMetadata(recipient, []byte("chatter"), map[string]string{"type": "chat"}))
+	expectData("chatter")
+}
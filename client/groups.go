@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// JoinGroup adds the client to the hub's group of that name, creating it if it doesn't exist, returning its members
+func (c *Client) JoinGroup(name string) (types.ListResponse, error) {
+	var resp types.ListResponse
+	return resp, c.do(context.Background(), c.url("http", fmt.Sprintf("/groups/join?name=%s&id=%d", url.QueryEscape(name), c.ID)), &resp)
+}
+
+// LeaveGroup removes the client from the hub's group of that name, returning its remaining members.
+// The hub deletes the group once its last member leaves.
+func (c *Client) LeaveGroup(name string) (types.ListResponse, error) {
+	var resp types.ListResponse
+	return resp, c.do(context.Background(), c.url("http", fmt.Sprintf("/groups/leave?name=%s&id=%d", url.QueryEscape(name), c.ID)), &resp)
+}
+
+// SendToGroup relays data to every member of the hub's group of that name, including the client if it's a member.
+// Use SendToRoomExceptSelf to leave the client out.
+func (c *Client) SendToGroup(name string, data []byte) error {
+	return c.postData("/groups/send", url.Values{"name": {name}}, data, nil)
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Groups(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	members := make([]*Client, 2)
+	for i := range members {
+		c, err := New(address)
+		require.NoError(t, err)
+		conn, err := c.InitWebsocket()
+		require.NoError(t, err)
+		defer conn.Close()
+		go c.ReadMessages(conn)
+
+		group, err := c.JoinGroup("team blue")
+		require.NoError(t, err)
+		assert.Contains(t, group.IDs, c.ID)
+		members[i] = c
+	}
+
+	require.NoError(t, members[0].SendToGroup("team blue", []byte("Hello team")))
+	for _, c := range members {
+		select {
+		case msg := <-c.Incoming():
+			assert.Equal(t, "Hello team", string(msg.Data))
+		case <-time.After(time.Second):
+			t.Fatalf("%d didn't receive the group message", c.ID)
+		}
+	}
+
+	group, err := members[0].LeaveGroup("team blue")
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{members[1].ID}, group.IDs)
+
+	// Once the last member leaves the group is gone
+	group, err = members[1].LeaveGroup("team blue")
+	require.NoError(t, err)
+	assert.Empty(t, group.IDs)
+	assert.Error(t, members[0].SendToGroup("team blue", []byte("Anyone there?")))
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIntegration_ConcurrentSendReceive has many clients send to random others at once, then checks every message
+// arrived exactly once at its intended recipient. Run it with -race to also catch unsynchronised access in the hub.
+func TestIntegration_ConcurrentSendReceive(t *testing.T) {
+	// A fixed number of messages rather than sending for a fixed time, so a slower run (e.g. with -race) isn't given more
+	// to deliver, with the wait for them scaled by how many there are
+	const (
+		clients   = 20
+		fanout    = 3 // Recipients per message
+		perClient = 25
+		sendEvery = 2 * time.Millisecond
+		// How long each delivery is allowed, generous enough for -race on a single CPU
+		perDelivery = 10 * time.Millisecond
+	)
+
+	// Big enough that a reader starved of CPU doesn't see its oldest messages dropped
+	defer func(size int) { IncomingBufferSize = size }(IncomingBufferSize)
+	IncomingBufferSize = clients * fanout * perClient
+
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	var (
+		mu            sync.Mutex
+		sent          = map[string]int{} // Per "sender>recipient" pair
+		received      = map[string]int{}
+		seen          = map[string]int{} // Per message, per recipient
+		totalSent     int
+		totalReceived int
+	)
+
+	all := make([]*Client, clients)
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := range all {
+		c, err := New(address)
+		require.NoError(t, err)
+		all[i] = c
+
+		conn, err := c.InitWebsocket()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		go c.WriteMessages(conn)
+		go c.ReadMessages(conn)
+
+		// Drain Incoming as fast as possible so its buffer never drops anything
+		readers.Add(1)
+		go func(c *Client) {
+			defer readers.Done()
+			for {
+				select {
+				case msg := <-c.Incoming():
+					var from uint64
+					var seq int
+					fmt.Sscanf(string(msg.Data), "%d:%d", &from, &seq)
+
+					mu.Lock()
+					received[fmt.Sprintf("%d>%d", from, c.ID)]++
+					seen[fmt.Sprintf("%s>%d", msg.Data, c.ID)]++
+					totalReceived++
+					mu.Unlock()
+				case <-stop:
+					return
+				}
+			}
+		}(c)
+	}
+
+	var senders sync.WaitGroup
+	for i, c := range all {
+		senders.Add(1)
+		go func(i int, c *Client) {
+			defer senders.Done()
+			random := rand.New(rand.NewSource(int64(i)))
+
+			for seq := 0; seq < perClient; seq++ {
+				// Several distinct recipients, never the sender itself
+				var recipients []string
+				var ids []uint64
+				for _, j := range random.Perm(clients) {
+					if j == i {
+						continue
+					}
+					recipients = append(recipients, fmt.Sprint(all[j].ID))
+					ids = append(ids, all[j].ID)
+					if len(ids) == fanout {
+						break
+					}
+				}
+
+				mu.Lock()
+				for _, id := range ids {
+					sent[fmt.Sprintf("%d>%d", c.ID, id)]++
+				}
+				totalSent += len(ids)
+				mu.Unlock()
+
+				c.Sending <- types.SendingMessage{Recipients: strings.Join(recipients, ","), Data: []byte(fmt.Sprintf("%d:%d", c.ID, seq))}
+				time.Sleep(sendEvery)
+			}
+		}(i, c)
+	}
+	senders.Wait()
+
+	// Wait for everything to arrive, then a little longer in case anything arrives twice
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return totalReceived >= totalSent
+	}, clients*fanout*perClient*perDelivery, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	readers.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.NotEmpty(t, sent)
+	require.Equal(t, sent, received, "messages sent vs received per sender>recipient pair")
+	for message, count := range seen {
+		require.Equal(t, 1, count, "%s received more than once", message)
+	}
+}
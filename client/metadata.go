@@ -0,0 +1,26 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// SendWithMetadata queues data on the Sending channel along with key/value metadata (e.g. trace-id, app-version),
+// which the hub relays untouched for recipients to read from types.SendingMessage.Metadata
+func (c *Client) SendWithMetadata(recipients string, data []byte, meta map[string]string) error {
+	if err := VerifyRecipients(recipients); err != nil {
+		return err
+	}
+	if !c.SplitOversize && int64(len(data)) > MaxDataSize {
+		return fmt.Errorf("data exceeded max size(%d) was: %d", MaxDataSize, len(data))
+	}
+
+	// Copied so the caller can reuse their map while the message waits to be written
+	metadata := make(map[string]string, len(meta))
+	for k, v := range meta {
+		metadata[k] = v
+	}
+
+	return c.Send(types.SendingMessage{Recipients: recipients, Data: data, Metadata: metadata})
+}
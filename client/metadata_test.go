@@ -0,0 +1,50 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendWithMetadata(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	receiver, err := New(address)
+	require.NoError(t, err)
+	receiverConn, err := receiver.InitWebsocket()
+	require.NoError(t, err)
+	defer receiverConn.Close()
+	go receiver.ReadMessages(receiverConn)
+
+	meta := map[string]string{
+		"trace-id":    "4bf92f3577b34da6",
+		"app-version": "1.2.3",
+	}
+	require.NoError(t, sender.SendWithMetadata(fmt.Sprint(receiver.ID), []byte("hello"), meta))
+
+	// Changing the map afterwards doesn't affect what was sent
+	meta["trace-id"] = "changed"
+
+	select {
+	case msg := <-receiver.Incoming():
+		require.Equal(t, "hello", string(msg.Data))
+		require.Equal(t, map[string]string{"trace-id": "4bf92f3577b34da6", "app-version": "1.2.3"}, msg.Metadata)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message wasn't received")
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// SendMultipart queues a message holding several named parts (e.g. text, an attachment and metadata) on the Sending channel.
+// The hub relays it like any other message, and recipients read the parts back with types.SendingMessage.Parts.
+func (c *Client) SendMultipart(recipients string, parts map[string][]byte) error {
+	if err := VerifyRecipients(recipients); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal parts: %v", err)
+	}
+	if int64(len(data)) > MaxDataSize {
+		return fmt.Errorf("parts exceeded max size(%d) was: %d", MaxDataSize, len(data))
+	}
+
+	return c.Send(types.SendingMessage{Recipients: recipients, Data: data, Multipart: true})
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendMultipart(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	receiver, err := New(address)
+	require.NoError(t, err)
+	receiverConn, err := receiver.InitWebsocket()
+	require.NoError(t, err)
+	defer receiverConn.Close()
+	go receiver.ReadMessages(receiverConn)
+
+	parts := map[string][]byte{
+		"text":       []byte("see attached"),
+		"attachment": {0x89, 'P', 'N', 'G', 0x00, 0xff},
+	}
+	require.NoError(t, sender.SendMultipart(fmt.Sprint(receiver.ID), parts))
+
+	select {
+	case msg := <-receiver.Incoming():
+		require.True(t, msg.Multipart)
+
+		received, err := msg.Parts()
+		require.NoError(t, err)
+		require.Equal(t, parts, received)
+
+		text, ok := msg.Part("text")
+		require.True(t, ok)
+		require.Equal(t, "see attached", string(text))
+
+		_, ok = msg.Part("missing")
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("multipart message wasn't received")
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import "github.com/StephenBirch/message-delivery-system/types"
+
+// Observer is notified of a clients connection lifecycle, see RegisterObserver
+type Observer interface {
+	// OnConnected is called once InitWebsocket has connected to the hub
+	OnConnected()
+	// OnDisconnected is called when ReadMessages loses the connection, with the error that ended it
+	OnDisconnected(err error)
+	// OnMessage is called for every message ReadMessages receives, including system messages
+	OnMessage(msg types.SendingMessage)
+	// OnError is called when WriteMessages fails to send a message, ReadMessages fails to decrypt one or reassemble a chunked
+	// one in time, or a ResendGaps resend fails
+	OnError(err error)
+}
+
+// AckObserver is an Observer also notified of the acks of messages the client sent, as each recipient acks them.
+// A recipient acks with types.AckDelivered, then types.AckProcessed if it calls AckProcessed. The hub acks with
+// types.AckFailed for a recipient it gave up on, or with a recipient of 0 for a message it refused outright.
+type AckObserver interface {
+	Observer
+	OnAck(messageID string, recipient uint64, status string)
+}
+
+// RegisterObserver adds an Observer to be called from InitWebsocket and the read/write loops.
+// Callbacks are made synchronously from those loops, so they should return quickly.
+func (c *Client) RegisterObserver(o Observer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observers = append(c.observers, o)
+}
+
+// notify calls fn for each registered observer
+func (c *Client) notify(fn func(o Observer)) {
+	c.mu.Lock()
+	observers := append([]Observer(nil), c.observers...)
+	c.mu.Unlock()
+
+	for _, o := range observers {
+		fn(o)
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingObserver passes every callback it receives onto events
+type recordingObserver struct {
+	events chan string
+}
+
+func (r *recordingObserver) OnConnected()             { r.events <- "connected" }
+func (r *recordingObserver) OnDisconnected(err error) { r.events <- "disconnected" }
+func (r *recordingObserver) OnMessage(msg types.SendingMessage) {
+	r.events <- "message " + string(msg.Data)
+}
+func (r *recordingObserver) OnError(err error) { r.events <- "error" }
+
+// expectEvent fails the test unless the next event the observer records is expected
+func expectEvent(t *testing.T, r *recordingObserver, expected string) {
+	select {
+	case event := <-r.events:
+		require.Equal(t, expected, event)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("observer didn't receive %q", expected)
+	}
+}
+
+func TestClient_RegisterObserver(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	observer := &recordingObserver{events: make(chan string, 10)}
+	c.RegisterObserver(observer)
+
+	conn, err := c.InitWebsocket()
+	require.NoError(t, err)
+	expectEvent(t, observer, "connected")
+
+	writeErrs := make(chan error, 1)
+	go func() { writeErrs <- c.WriteMessages(conn) }()
+	go c.ReadMessages(conn)
+
+	c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("to myself")}
+	expectEvent(t, observer, "message to myself")
+
+	conn.Close()
+	expectEvent(t, observer, "disconnected")
+
+	c.Sending <- types.SendingMessage{Recipients: fmt.Sprint(c.ID), Data: []byte("too late")}
+	expectEvent(t, observer, "error")
+	require.Error(t, <-writeErrs)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PingStats summarises the round trip latencies measured by Ping
+type PingStats struct {
+	Count int
+	Min   time.Duration
+	Avg   time.Duration
+	Max   time.Duration
+	P99   time.Duration
+}
+
+// Ping connects to the hub and measures the round trip of count messages the client sends itself, one every interval,
+// printing each latency to w followed by a summary. Each is timed with SendTimed, so it's from sending until the hub
+// acknowledges the message was handed to the client. It stops early if ctx is cancelled, summarising those measured.
+func (c *Client) Ping(ctx context.Context, w io.Writer, count int, interval time.Duration) (PingStats, error) {
+	if count <= 0 {
+		return PingStats{}, fmt.Errorf("count must be positive, was %d", count)
+	}
+
+	conn, err := c.InitWebsocket()
+	if err != nil {
+		return PingStats{}, fmt.Errorf("failed to init websocket: %v", err)
+	}
+	defer conn.Close()
+	go c.WriteMessages(conn)
+	go c.readAcks(conn)
+
+	var latencies []time.Duration
+	for i := 1; i <= count; i++ {
+		if i > 1 {
+			select {
+			case <-ctx.Done():
+				return summarise(w, latencies), nil
+			case <-time.After(interval):
+			}
+		}
+
+		latency, err := c.ping(ctx, []byte(fmt.Sprintf("ping %d", i)))
+		if ctx.Err() != nil {
+			return summarise(w, latencies), nil
+		}
+		if err != nil {
+			return summarise(w, latencies), fmt.Errorf("ping %d failed: %v", i, err)
+		}
+		latencies = append(latencies, latency)
+		fmt.Fprintf(w, "ping %d: %v\n", i, latency)
+	}
+
+	return summarise(w, latencies), nil
+}
+
+// ping sends data to the client itself with SendTimed, retrying until WriteMessages is running to send it
+func (c *Client) ping(ctx context.Context, data []byte) (time.Duration, error) {
+	for {
+		latency, err := c.SendTimed(c.ID, data)
+		if err != ErrNotConnected {
+			return latency, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// readAcks reads from conn until it's closed, recording acks but dropping the messages themselves, which are only pings
+func (c *Client) readAcks(conn *websocket.Conn) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.receive(message)
+	}
+}
+
+// summarise works out the stats of latencies and prints them to w
+func summarise(w io.Writer, latencies []time.Duration) PingStats {
+	stats := PingStats{Count: len(latencies)}
+	if len(latencies) == 0 {
+		fmt.Fprintln(w, "no pings completed")
+		return stats
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	stats.Min, stats.Max = sorted[0], sorted[len(sorted)-1]
+	stats.Avg = total / time.Duration(len(sorted))
+	// The smallest latency at least 99% of pings were as fast as
+	stats.P99 = sorted[(len(sorted)*99+99)/100-1]
+
+	fmt.Fprintf(w, "%d pings: min %v, avg %v, max %v, p99 %v\n", stats.Count, stats.Min, stats.Avg, stats.Max, stats.P99)
+	return stats
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Ping(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	stats, err := c.Ping(context.Background(), &out, 5, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	require.Equal(t, 5, stats.Count)
+	require.True(t, stats.Min > 0, "min wasn't positive: %v", stats.Min)
+	require.True(t, stats.Min <= stats.Avg && stats.Avg <= stats.Max, "stats out of order: %+v", stats)
+	require.True(t, stats.P99 <= stats.Max, "p99 above max: %+v", stats)
+	require.True(t, stats.Max < time.Second, "max too large for a local hub: %v", stats.Max)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 6)
+	require.True(t, strings.HasPrefix(lines[0], "ping 1: "), lines[0])
+	require.True(t, strings.HasPrefix(lines[5], "5 pings: min "), lines[5])
+
+	_, err = c.Ping(context.Background(), &out, 0, time.Millisecond)
+	require.EqualError(t, err, "count must be positive, was 0")
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// PollWait is how long each poll of LongPollTransport waits on the hub for messages before it's made again
+var PollWait = 30 * time.Second
+
+// receivePoll polls the hub's /poll endpoint for messages, passing each on like ReadMessages, until a poll fails or
+// ctx is cancelled
+func (c *Client) receivePoll(ctx context.Context) error {
+	for connected := false; ; connected = true {
+		messages, err := c.poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if connected {
+				c.notify(func(o Observer) { o.OnDisconnected(err) })
+			}
+			return err
+		}
+
+		if !connected {
+			c.setTransport(LongPollTransport)
+			c.notify(func(o Observer) { o.OnConnected() })
+		}
+		for _, message := range messages {
+			c.dispatch(message)
+		}
+	}
+}
+
+// poll makes a single poll of the hub's /poll endpoint, returning the messages it took for the client
+func (c *Client) poll(ctx context.Context) ([]json.RawMessage, error) {
+	query := url.Values{"id": {strconv.FormatUint(c.ID, 10)}, "wait": {PollWait.String()}}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("http", "/poll?"+query.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	c.authorize(req.Header)
+
+	// Polls last as long as PollWait, so they can't be bound by the HTTPTimeout
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach hub %s: %w", c.Address, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %s", c.Address, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, hubError(resp.StatusCode, b)
+	}
+
+	var polled types.PollResponse
+	if err := json.Unmarshal(b, &polled); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response from %s: %s", c.Address, err)
+	}
+	return polled.Messages, nil
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// PoolConcurrency is how many members a ClientPool registers with the hub at once
+var PoolConcurrency = 8
+
+// PooledMessage is a message received by a member of a ClientPool, tagged with the member's ID
+type PooledMessage struct {
+	ClientID uint64
+	types.SendingMessage
+}
+
+// ClientPool manages many clients of one hub, for applications like gateways acting as several identities at once.
+// Each member reconnects on its own like RunWithReconnect, and one giving up is dropped without affecting the rest.
+type ClientPool struct {
+	mu       sync.Mutex
+	members  map[uint64]*Client
+	failures map[uint64]error // Why each member that gave up was dropped
+
+	incoming chan PooledMessage
+	running  sync.WaitGroup
+}
+
+// NewClientPool registers size clients with the hub at address, PoolConcurrency at a time, then runs them until ctx
+// is cancelled. If any fails to register, those that did are stopped and the error returned.
+func NewClientPool(ctx context.Context, address string, size int) (*ClientPool, error) {
+	clients := make([]*Client, size)
+	errs := make([]error, size)
+
+	slots := make(chan struct{}, PoolConcurrency)
+	var registering sync.WaitGroup
+	for i := range clients {
+		registering.Add(1)
+		go func(i int) {
+			defer registering.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			clients[i], errs[i] = New(address)
+		}(i)
+	}
+	registering.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, c := range clients {
+				if c != nil {
+					c.Deregister()
+				}
+			}
+			return nil, fmt.Errorf("failed to create pool: %v", err)
+		}
+	}
+
+	p := &ClientPool{
+		members:  make(map[uint64]*Client, size),
+		failures: make(map[uint64]error),
+		incoming: make(chan PooledMessage, IncomingBufferSize),
+	}
+	for _, c := range clients {
+		p.members[c.ID] = c
+		p.running.Add(2)
+		go p.run(ctx, c)
+		go p.forward(ctx, c)
+	}
+
+	// Nothing sends on incoming once every member has stopped
+	go func() {
+		p.running.Wait()
+		close(p.incoming)
+	}()
+
+	return p, nil
+}
+
+// run runs member until ctx is cancelled, dropping it from the pool if it gives up reconnecting
+func (p *ClientPool) run(ctx context.Context, member *Client) {
+	defer p.running.Done()
+
+	err := member.RunWithReconnect(ctx)
+	if err == nil {
+		return
+	}
+
+	p.mu.Lock()
+	delete(p.members, member.ID)
+	p.failures[member.ID] = err
+	p.mu.Unlock()
+}
+
+// forward passes the messages member receives on to the pool's Incoming until ctx is cancelled
+func (p *ClientPool) forward(ctx context.Context, member *Client) {
+	defer p.running.Done()
+
+	for {
+		select {
+		case msg := <-member.Incoming():
+			select {
+			case p.incoming <- PooledMessage{ClientID: member.ID, SendingMessage: msg}:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Incoming returns the channel that the messages received by every member are delivered on, tagged with which member
+// received them. It's closed once the pool's context is cancelled and every member has stopped.
+func (p *ClientPool) Incoming() <-chan PooledMessage {
+	return p.incoming
+}
+
+// Send queues data to be sent to recipients by the member fromID
+func (p *ClientPool) Send(fromID uint64, recipients string, data []byte) error {
+	member, err := p.member(fromID)
+	if err != nil {
+		return err
+	}
+	return member.Send(types.SendingMessage{Recipients: recipients, Data: data})
+}
+
+// Client returns the member with the ID, for anything beyond Send
+func (p *ClientPool) Client(id uint64) (*Client, error) {
+	return p.member(id)
+}
+
+// member returns the member with the ID, or why the pool doesn't have one
+func (p *ClientPool) member(id uint64) (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if member, exists := p.members[id]; exists {
+		return member, nil
+	}
+	if err, failed := p.failures[id]; failed {
+		return nil, fmt.Errorf("client %d was dropped from the pool: %v", id, err)
+	}
+	return nil, fmt.Errorf("client %d isn't in the pool", id)
+}
+
+// IDs returns the IDs of the pool's members, in order
+func (p *ClientPool) IDs() []uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]uint64, 0, len(p.members))
+	for id := range p.members {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPool(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool, err := NewClientPool(ctx, strings.TrimPrefix(serv.URL, "http://"), 3)
+	require.NoError(t, err)
+	ids := pool.IDs()
+	require.Len(t, ids, 3)
+
+	// Each member sends to the next, so every message has a different sender and recipient
+	for i, from := range ids {
+		to := ids[(i+1)%len(ids)]
+		data := []byte(fmt.Sprintf("From %d to %d", from, to))
+		require.Eventually(t, func() bool { return pool.Send(from, fmt.Sprint(to), data) != ErrNotConnected }, time.Second, 10*time.Millisecond)
+	}
+
+	for range ids {
+		select {
+		case msg := <-pool.Incoming():
+			require.Equal(t, fmt.Sprintf("From %d to %d", msg.Sender, msg.ClientID), string(msg.Data))
+		case <-time.After(5 * time.Second):
+			t.Fatal("pool didn't receive every message")
+		}
+	}
+
+	err = pool.Send(1, fmt.Sprint(ids[0]), []byte("From nobody"))
+	require.EqualError(t, err, "client 1 isn't in the pool")
+
+	// Stopping the pool stops every member, then closes Incoming
+	cancel()
+	select {
+	case _, open := <-pool.Incoming():
+		require.False(t, open)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Incoming wasn't closed")
+	}
+}
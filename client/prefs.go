@@ -0,0 +1,31 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// Preferences returns the settings the hub holds for this client, e.g. its name, filter and whether it's muted.
+// It's authenticated by the websocket connection's session, so InitWebsocket must be called first.
+func (c *Client) Preferences() (types.Preferences, error) {
+	var prefs types.Preferences
+	return prefs, c.sessionRequest("GET", c.prefsPath(), nil, &prefs)
+}
+
+// UpdatePreferences replaces every setting the hub holds for this client with prefs,
+// so should be given the result of Preferences with the changes made to it
+func (c *Client) UpdatePreferences(prefs types.Preferences) error {
+	b, err := json.Marshal(prefs)
+	if err != nil {
+		return fmt.Errorf("failed to Marshal preferences: %s", err)
+	}
+	return c.sessionRequest("PUT", c.prefsPath(), bytes.NewReader(b), nil)
+}
+
+// prefsPath is the path of the hub's preferences endpoint for this client
+func (c *Client) prefsPath() string {
+	return fmt.Sprintf("/clients/%d/prefs", c.ID)
+}
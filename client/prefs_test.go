@@ -0,0 +1,66 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_UpdatePreferences(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	receiver, err := New(address)
+	require.NoError(t, err)
+	_, err = receiver.Preferences()
+	require.Error(t, err, "preferences fetched before connecting")
+
+	receiverConn, err := receiver.InitWebsocket()
+	require.NoError(t, err)
+	defer receiverConn.Close()
+	go receiver.ReadMessages(receiverConn)
+
+	prefs, err := receiver.Preferences()
+	require.NoError(t, err)
+	require.Equal(t, types.Preferences{Compress: types.GzipEncoding}, prefs)
+
+	prefs.Muted = true
+	require.NoError(t, receiver.UpdatePreferences(prefs))
+	updated, err := receiver.Preferences()
+	require.NoError(t, err)
+	require.Equal(t, prefs, updated)
+
+	// Muted, so messages from peers aren't delivered
+	recipient := fmt.Sprint(receiver.ID)
+	sender.Sending <- types.SendingMessage{Recipients: recipient, Data: []byte("muted")}
+	select {
+	case msg := <-receiver.Incoming():
+		t.Fatalf("%q was delivered while muted", msg.Data)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	prefs.Muted = false
+	require.NoError(t, receiver.UpdatePreferences(prefs))
+	sender.Sending <- types.SendingMessage{Recipients: recipient, Data: []byte("unmuted")}
+	select {
+	case msg := <-receiver.Incoming():
+		require.Equal(t, "unmuted", string(msg.Data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message wasn't delivered once unmuted")
+	}
+}
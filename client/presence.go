@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// WatchPresence streams who's online from the hub, starting with a types.PresenceSnapshot of every connected client
+// and followed by a types.PresenceJoin or types.PresenceLeave as each connects or disconnects.
+// The channel is closed once ctx is cancelled or the connection to the hub drops.
+func (c *Client) WatchPresence(ctx context.Context) (<-chan types.PresenceEvent, error) {
+	conn, _, err := c.dialer().DialContext(ctx, c.url("ws", "/presence"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial presence websocket: %s", err)
+	}
+
+	events := make(chan types.PresenceEvent)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(events)
+		defer close(done)
+		defer conn.Close()
+
+		for {
+			var event types.PresenceEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
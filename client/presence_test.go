@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+// nextPresence returns the next event from events, failing the test if none arrives
+func nextPresence(t *testing.T, events <-chan types.PresenceEvent) types.PresenceEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "presence events closed")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no presence event received")
+	}
+	return types.PresenceEvent{}
+}
+
+func TestClient_WatchPresence(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	online, err := New(address)
+	require.NoError(t, err)
+	onlineConn, err := online.InitWebsocket()
+	require.NoError(t, err)
+	defer onlineConn.Close()
+
+	watcher, err := New(address)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := watcher.WatchPresence(ctx)
+	require.NoError(t, err)
+
+	// The snapshot comes first
+	require.Equal(t, types.PresenceEvent{Type: types.PresenceSnapshot, Online: []uint64{online.ID}}, nextPresence(t, events))
+
+	// Followed by deltas
+	joining, err := New(address)
+	require.NoError(t, err)
+	joiningConn, err := joining.InitWebsocket()
+	require.NoError(t, err)
+	require.Equal(t, types.PresenceEvent{Type: types.PresenceJoin, ID: joining.ID}, nextPresence(t, events))
+
+	joiningConn.Close()
+	require.Equal(t, types.PresenceEvent{Type: types.PresenceLeave, ID: joining.ID}, nextPresence(t, events))
+
+	// Cancelling stops the stream
+	cancel()
+	select {
+	case _, ok := <-events:
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("presence events weren't closed after cancelling")
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReconnectInterval is how long RunWithReconnect waits between attempts to reconnect to the hub
+var ReconnectInterval = time.Second
+
+// RunWithReconnect connects to the hub then reads and writes messages like ReadMessages and WriteMessages, reconnecting
+// whenever the connection drops, until ctx is cancelled. After MaxReconnectAttempts failed attempts in a row it gives up,
+// notifying observers with OnDisconnected and returning the last error.
+func (c *Client) RunWithReconnect(ctx context.Context) error {
+	conn, err := c.InitWebsocket()
+	for attempts := 0; ; {
+		if err == nil {
+			attempts = 0
+			err = c.run(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if c.MaxReconnectAttempts > 0 && attempts >= c.MaxReconnectAttempts {
+			err = fmt.Errorf("gave up after %d reconnect attempts: %v", attempts, err)
+			c.notify(func(o Observer) { o.OnDisconnected(err) })
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(ReconnectInterval):
+		}
+
+		attempts++
+		conn, err = c.Reconnect()
+	}
+}
+
+// run reads and writes messages over conn until either fails or ctx is cancelled, then closes it
+func (c *Client) run(ctx context.Context, conn *websocket.Conn) error {
+	defer conn.Close()
+
+	errs := make(chan error, 2)
+	go func() { errs <- c.WriteMessages(conn) }()
+	go func() { errs <- c.ReadMessages(conn) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reconnectWithRetries calls Reconnect every ReconnectInterval until it succeeds, or MaxReconnectAttempts have failed
+func (c *Client) reconnectWithRetries() (*websocket.Conn, error) {
+	for attempts := 1; ; attempts++ {
+		conn, err := c.Reconnect()
+		if err == nil {
+			return conn, nil
+		}
+		if c.MaxReconnectAttempts > 0 && attempts >= c.MaxReconnectAttempts {
+			return nil, fmt.Errorf("gave up after %d reconnect attempts: %v", attempts, err)
+		}
+		time.Sleep(ReconnectInterval)
+	}
+}
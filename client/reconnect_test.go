@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_RunWithReconnect(t *testing.T) {
+	interval := ReconnectInterval
+	ReconnectInterval = 10 * time.Millisecond
+	defer func() { ReconnectInterval = interval }()
+
+	// Nothing listens on the address of a closed server
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadAddress := strings.TrimPrefix(dead.URL, "http://")
+	dead.Close()
+
+	// A hub that's up but turns every connection away
+	var dials int32
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			atomic.AddInt32(&dials, 1)
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer rejecting.Close()
+
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{
+			name:    "Dead address",
+			address: deadAddress,
+		},
+		{
+			name:    "Hub rejecting connections",
+			address: strings.TrimPrefix(rejecting.URL, "http://"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&dials, 0)
+
+			c := newClient(tt.address)
+			c.ID = 500
+			c.MaxReconnectAttempts = 3
+
+			observer := &recordingObserver{events: make(chan string, 10)}
+			c.RegisterObserver(observer)
+
+			done := make(chan error, 1)
+			go func() { done <- c.RunWithReconnect(context.Background()) }()
+
+			select {
+			case err := <-done:
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "3 reconnect attempts")
+			case <-time.After(5 * time.Second):
+				t.Fatal("RunWithReconnect didn't give up")
+			}
+			expectEvent(t, observer, "disconnected")
+
+			if tt.address != deadAddress {
+				// The first connection, then each reconnect attempt
+				require.Equal(t, int32(4), atomic.LoadInt32(&dials))
+			}
+		})
+	}
+}
+
+func TestClient_RunWithReconnectUntilCancelled(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+	c.MaxReconnectAttempts = 3
+
+	observer := &recordingObserver{events: make(chan string, 10)}
+	c.RegisterObserver(observer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.RunWithReconnect(ctx) }()
+	expectEvent(t, observer, "connected")
+
+	// A healthy connection is kept until the caller is done with it
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWithReconnect didn't return once cancelled")
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// RequestResend asks the hub for the messages it delivered to the client with Sequence from to to (inclusive),
+// which are then received like any other. The hub only keeps its latest messages, see hub.ResendBufferSize,
+// so those it no longer has are left out. It's authenticated by the session, so InitWebsocket must be called first.
+func (c *Client) RequestResend(from, to uint64) error {
+	var resp types.ResendResponse
+	if err := c.sessionRequest("GET", fmt.Sprintf("/resend?id=%d&from=%d&to=%d", c.ID, from, to), nil, &resp); err != nil {
+		return err
+	}
+
+	for _, frame := range resp.Messages {
+		if msg, _, ok := c.receive(frame); ok {
+			c.handle(msg)
+		}
+	}
+	return nil
+}
+
+// trackSequence notes the Sequence of a received message, asking for any it skipped with ResendGaps.
+// The hub numbers from 1 again once it forgets the client, so that's a fresh start rather than a gap.
+func (c *Client) trackSequence(sequence uint64) {
+	if sequence == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	last := c.lastSequence
+	if sequence > last || sequence == 1 {
+		c.lastSequence = sequence
+	}
+	c.mu.Unlock()
+
+	if !c.ResendGaps || last == 0 || sequence <= last+1 {
+		return
+	}
+	go func() {
+		if err := c.RequestResend(last+1, sequence-1); err != nil {
+			err = fmt.Errorf("failed to request resend of %d-%d: %v", last+1, sequence-1, err)
+			c.notify(func(o Observer) { o.OnError(err) })
+		}
+	}()
+}
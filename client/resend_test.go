@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_RequestResend(t *testing.T) {
+	h := hub.New()
+	h.ResendBufferSize = 10
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+	go sender.ReadMessages(senderConn)
+
+	recipient, err := New(address)
+	require.NoError(t, err)
+	recipient.ResendGaps = true
+	conn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+
+	send := func(data string) {
+		require.Eventually(t, func() bool {
+			return sender.Send(types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte(data)}) == nil
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// The first message is received, the next two reach the connection but are lost with it
+	send("1")
+	_, frame, err := conn.ReadMessage()
+	require.NoError(t, err)
+	recipient.dispatch(frame)
+
+	send("2")
+	send("3")
+	for i := 0; i < 2; i++ {
+		_, _, err := conn.ReadMessage()
+		require.NoError(t, err)
+	}
+
+	conn, err = recipient.Reconnect()
+	require.NoError(t, err)
+	defer conn.Close()
+	go recipient.ReadMessages(conn)
+
+	// Receiving 4 shows 2 and 3 were missed, which are asked for again
+	send("4")
+
+	var received []string
+	for len(received) < 4 {
+		select {
+		case msg := <-recipient.Incoming():
+			received = append(received, string(msg.Data))
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only received %v", received)
+		}
+	}
+	require.Equal(t, []string{"1", "4", "2", "3"}, received)
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// SendToRoom relays data to every member of room, the hub's group of that name, without needing a websocket
+func (c *Client) SendToRoom(room string, data []byte) error {
+	return c.postData("/groups/send", url.Values{"name": {room}}, data, nil)
+}
+
+// SendToRoomExceptSelf is SendToRoom for a member of room, which doesn't receive its own message
+func (c *Client) SendToRoomExceptSelf(room string, data []byte) error {
+	return c.postData("/groups/send", url.Values{"name": {room}, "from": {strconv.FormatUint(c.ID, 10)}}, data, nil)
+}
+
+// JoinAndAnnounce joins room like JoinGroup, and in the same step has the hub announce the client to the members already
+// in it. Each is sent a system message that the client joined, then data from the client, always in that order.
+func (c *Client) JoinAndAnnounce(room string, data []byte) error {
+	if int64(len(data)) > MaxDataSize {
+		return fmt.Errorf("data exceeded max size(%d) was: %d", MaxDataSize, len(data))
+	}
+
+	query := url.Values{"name": {room}, "id": {strconv.FormatUint(c.ID, 10)}}
+	return c.doBody(context.Background(), "POST", c.url("http", "/groups/join?"+query.Encode()), bytes.NewReader(data), nil)
+}
+
+// postData posts data to the hub's endpoint at path with query as the client, decoding the response into object if it isn't nil
+func (c *Client) postData(path string, query url.Values, data []byte, object interface{}) error {
+	if int64(len(data)) > MaxDataSize {
+		return fmt.Errorf("data exceeded max size(%d) was: %d", MaxDataSize, len(data))
+	}
+
+	req, err := http.NewRequest("POST", c.url("http", path+"?"+query.Encode()), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// Sending as the client needs its token, if it was issued one
+	c.authorize(req.Header)
+
+	resp, err := c.timedClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach hub %s: %s", c.Address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return hubError(resp.StatusCode, b)
+	}
+	if object == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(object); err != nil {
+		return fmt.Errorf("failed to unmarshal response from %s: %s", c.Address, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendToRoomExceptSelf(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	members := make([]*Client, 3)
+	for i := range members {
+		c, err := New(address)
+		require.NoError(t, err)
+		conn, err := c.InitWebsocket()
+		require.NoError(t, err)
+		defer conn.Close()
+		go c.ReadMessages(conn)
+
+		var room types.ListResponse
+		require.NoError(t, c.do(context.Background(), fmt.Sprintf("http://%s/groups/join?name=chat&id=%d", address, c.ID), &room))
+		members[i] = c
+	}
+
+	require.NoError(t, members[0].SendToRoomExceptSelf("chat", []byte("Hi all")))
+
+	for _, c := range members[1:] {
+		select {
+		case msg := <-c.Incoming():
+			require.Equal(t, "Hi all", string(msg.Data))
+			require.Equal(t, members[0].ID, msg.Sender)
+		case <-time.After(time.Second):
+			t.Fatalf("%d didn't receive the message", c.ID)
+		}
+	}
+
+	select {
+	case msg := <-members[0].Incoming():
+		t.Fatalf("sender received its own message: %s", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.Error(t, members[0].SendToRoomExceptSelf("unknown", []byte("Hi")))
+}
+
+func TestClient_JoinAndAnnounce(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	members := make([]*Client, 2)
+	for i := range members {
+		c, err := New(address)
+		require.NoError(t, err)
+		conn, err := c.InitWebsocket()
+		require.NoError(t, err)
+		defer conn.Close()
+		go c.ReadMessages(conn)
+
+		_, err = c.JoinGroup("chat")
+		require.NoError(t, err)
+		members[i] = c
+	}
+
+	newcomer, err := New(address)
+	require.NoError(t, err)
+	conn, err := newcomer.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+	go newcomer.ReadMessages(conn)
+
+	require.NoError(t, newcomer.JoinAndAnnounce("chat", []byte("Hi all")))
+
+	for _, c := range members {
+		var joined, announcement types.SendingMessage
+		select {
+		case joined = <-c.System():
+		case <-time.After(time.Second):
+			t.Fatalf("%d wasn't told of the join", c.ID)
+		}
+		select {
+		case announcement = <-c.Incoming():
+		case <-time.After(time.Second):
+			t.Fatalf("%d didn't receive the announcement", c.ID)
+		}
+
+		require.Equal(t, fmt.Sprintf("%d joined group chat", newcomer.ID), string(joined.Data))
+		require.Equal(t, newcomer.ID, joined.Sender)
+		require.Equal(t, "Hi all", string(announcement.Data))
+		require.Equal(t, newcomer.ID, announcement.Sender)
+		// The hub numbers everything it delivers to a member, so the join came first
+		require.Equal(t, joined.Sequence+1, announcement.Sequence)
+	}
+
+	select {
+	case msg := <-newcomer.Incoming():
+		t.Fatalf("newcomer received its own announcement: %s", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The newcomer is a member like any other
+	room, err := newcomer.LeaveGroup("chat")
+	require.NoError(t, err)
+	require.Len(t, room.IDs, 2)
+}
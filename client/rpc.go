@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// Handler implements an RPC method served by ServeRPC, taking the caller's JSON args and returning a result to be
+// marshalled back to it
+type Handler func(args json.RawMessage) (interface{}, error)
+
+// Call invokes method on the client target, which must be serving it with ServeRPC, waiting up to timeout for the reply.
+// args are marshalled as JSON and the result unmarshalled into reply, unless it's nil. Like SendSync it doesn't need a websocket.
+func (c *Client) Call(target uint64, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	req := types.RPCRequest{Method: method}
+	if args != nil {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("failed to marshal args of %s: %v", method, err)
+		}
+		req.Args = b
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.SendSync(fmt.Sprint(target), data, timeout)
+	if err != nil {
+		return err
+	}
+
+	var resp types.RPCResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return fmt.Errorf("failed to unmarshal reply to %s: %v", method, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s failed: %s", method, resp.Error)
+	}
+
+	if reply == nil || resp.Result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, reply); err != nil {
+		return fmt.Errorf("failed to unmarshal result of %s: %v", method, err)
+	}
+	return nil
+}
+
+// ServeRPC answers Calls made to the client with the handler of the method called, replying through the Sending channel.
+// Requests are picked out of the messages ReadMessages receives, so the read and write loops must be running.
+// Each is handled in its own goroutine, and calls to methods without a handler are answered with an error.
+func (c *Client) ServeRPC(handlers map[string]Handler) {
+	c.RegisterObserver(&rpcServer{client: c, handlers: handlers})
+}
+
+// rpcServer is the Observer ServeRPC registers to pick out requests
+type rpcServer struct {
+	client   *Client
+	handlers map[string]Handler
+}
+
+func (s *rpcServer) OnConnected()             {}
+func (s *rpcServer) OnDisconnected(err error) {}
+func (s *rpcServer) OnError(err error)        {}
+
+// OnMessage serves msg if it's an RPCRequest, which are always sent expecting a reply
+func (s *rpcServer) OnMessage(msg types.SendingMessage) {
+	if msg.System || msg.CorrelationID == "" {
+		return
+	}
+
+	var req types.RPCRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.Method == "" {
+		return
+	}
+
+	go s.serve(msg, req)
+}
+
+// serve calls the handler of req, replying to msg with its result
+func (s *rpcServer) serve(msg types.SendingMessage, req types.RPCRequest) {
+	var resp types.RPCResponse
+
+	handler, exists := s.handlers[req.Method]
+	if !exists {
+		resp.Error = fmt.Sprintf("unknown method %q", req.Method)
+	} else if result, err := handler(req.Args); err != nil {
+		resp.Error = err.Error()
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = fmt.Sprintf("failed to marshal result: %v", err)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	s.client.Reply(msg, data)
+}
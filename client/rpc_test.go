@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+type addArgs struct {
+	A, B int
+}
+
+func TestClient_Call(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		args          interface{}
+		expectedReply int
+		expectedErr   string
+	}{
+		{
+			name:          "Golden Path",
+			method:        "add",
+			args:          addArgs{A: 2, B: 3},
+			expectedReply: 5,
+		},
+		{
+			name:        "Handler error",
+			method:      "add",
+			args:        addArgs{A: -1},
+			expectedErr: "add failed: negative",
+		},
+		{
+			name:        "Unknown method",
+			method:      "subtract",
+			args:        addArgs{A: 2, B: 3},
+			expectedErr: "subtract failed: unknown method \"subtract\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			responder, err := New(address)
+			require.NoError(t, err)
+			conn, err := responder.InitWebsocket()
+			require.NoError(t, err)
+			defer conn.Close()
+			go responder.WriteMessages(conn)
+			go responder.ReadMessages(conn)
+
+			responder.ServeRPC(map[string]Handler{
+				"add": func(raw json.RawMessage) (interface{}, error) {
+					var args addArgs
+					if err := json.Unmarshal(raw, &args); err != nil {
+						return nil, err
+					}
+					if args.A < 0 || args.B < 0 {
+						return nil, errors.New("negative")
+					}
+					return args.A + args.B, nil
+				},
+			})
+
+			caller, err := New(address)
+			require.NoError(t, err)
+
+			var sum int
+			err = caller.Call(responder.ID, tt.method, tt.args, &sum, time.Second)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedReply, sum)
+		})
+	}
+}
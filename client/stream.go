@@ -0,0 +1,180 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+)
+
+// Transports Receive can get messages from the hub over
+const (
+	// WebsocketTransport is the hub's /ws endpoint, the same connection InitWebsocket makes
+	WebsocketTransport = "websocket"
+	// StreamTransport is the hub's /stream endpoint of server-sent events, for where websocket upgrades are blocked
+	StreamTransport = "stream"
+	// LongPollTransport is the hub's /poll endpoint, for where neither websockets nor event streams get through
+	LongPollTransport = "longpoll"
+)
+
+// Receive connects to the hub and passes everything received on to Incoming and System, like ReadMessages, until ctx is
+// cancelled or the connection drops. Unless ReceiveTransport forces one, a websocket is tried first, falling back to an
+// event stream if the upgrade is rejected, so callers consume messages the same way over any. Transport reports which
+// was used.
+func (c *Client) Receive(ctx context.Context) error {
+	switch c.ReceiveTransport {
+	case "":
+	case WebsocketTransport:
+		conn, err := c.InitWebsocket()
+		if err != nil {
+			return err
+		}
+		c.setTransport(WebsocketTransport)
+		return c.receiveWebsocket(ctx, conn)
+	case StreamTransport:
+		return c.receiveStream(ctx, c.dispatch)
+	case LongPollTransport:
+		return c.receivePoll(ctx)
+	default:
+		return fmt.Errorf("unknown transport %q", c.ReceiveTransport)
+	}
+
+	conn, err := c.InitWebsocket()
+	if err == nil {
+		c.setTransport(WebsocketTransport)
+		return c.receiveWebsocket(ctx, conn)
+	}
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		return err
+	}
+
+	return c.receiveStream(ctx, c.dispatch)
+}
+
+// Stream reads from the hub's /stream endpoint of server-sent events, a read-only alternative to the websocket for where
+// only plain HTTP gets through, pushing the Data of each message received onto out until ctx is cancelled.
+// Messages are received as ReadMessages receives them, decrypted, acked, deduplicated, reassembled and unwrapped, and
+// system messages still go to System rather than out.
+// It returns nil once ctx is cancelled, or the error that ended the stream.
+func (c *Client) Stream(ctx context.Context, out chan<- []byte) error {
+	return c.receiveStream(ctx, func(message []byte) {
+		msg, framed, ok := c.receive(message)
+		if !framed {
+			msg = types.SendingMessage{Data: message}
+		}
+		c.trackSequence(msg.Sequence)
+		if framed && !ok {
+			return
+		}
+		if framed {
+			if msg, ok = c.accept(msg); !ok {
+				return
+			}
+		}
+
+		select {
+		case out <- msg.Data:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// WithTransport sets ReceiveTransport, forcing Receive to use transport, returning c for convenience
+func (c *Client) WithTransport(transport string) *Client {
+	c.ReceiveTransport = transport
+	return c
+}
+
+// Transport returns the transport of the latest connection made by Receive, or "" if it hasn't connected
+func (c *Client) Transport() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transport
+}
+
+func (c *Client) setTransport(transport string) {
+	c.mu.Lock()
+	c.transport = transport
+	c.mu.Unlock()
+}
+
+// receiveWebsocket is ReadMessages, closing conn once ctx is cancelled
+func (c *Client) receiveWebsocket(ctx context.Context, conn *websocket.Conn) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err := c.ReadMessages(conn)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// receiveStream reads messages from the hub's /stream endpoint, passing each to handle, until it ends or ctx is cancelled
+func (c *Client) receiveStream(ctx context.Context, handle func(message []byte)) error {
+	req, err := http.NewRequest("GET", c.url("http", fmt.Sprintf("/stream?id=%d", c.ID)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %s", c.Address, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req.Header)
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach hub %s: %s", c.Address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return hubError(resp.StatusCode, b)
+	}
+
+	c.mu.Lock()
+	c.sessionToken = resp.Header.Get(types.SessionTokenHeader)
+	c.transport = StreamTransport
+	c.mu.Unlock()
+	c.notify(func(o Observer) { o.OnConnected() })
+
+	// Each event holds a framed message, which may be as large as the data it carries, in its data lines.
+	// The hub writes it on a single line, but the lines of an event are joined as the event stream format has them.
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 4096), 2*int(MaxDataSize))
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" && len(data) > 0:
+			handle([]byte(strings.Join(data, "\n")))
+			data = nil
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	err = fmt.Errorf("failed to read message: %v", err)
+	c.notify(func(o Observer) { o.OnDisconnected(err) })
+	return err
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Receive(t *testing.T) {
+	tests := []struct {
+		name              string
+		blockUpgrades     bool
+		expectedTransport string
+	}{
+		{
+			name:              "Websocket",
+			expectedTransport: WebsocketTransport,
+		},
+		{
+			name:              "Falls back when upgrades are blocked",
+			blockUpgrades:     true,
+			expectedTransport: StreamTransport,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Like a proxy that doesn't understand websockets
+				if tt.blockUpgrades && websocket.IsWebSocketUpgrade(r) {
+					http.Error(w, "upgrades not allowed", http.StatusForbidden)
+					return
+				}
+				h.Router.ServeHTTP(w, r)
+			}))
+			defer serv.Close()
+
+			c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+			require.NoError(t, err)
+			incoming := c.Incoming()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			received := make(chan error, 1)
+			go func() { received <- c.Receive(ctx) }()
+
+			require.Eventually(t, func() bool { return c.Transport() == tt.expectedTransport }, 5*time.Second, 10*time.Millisecond)
+
+			// Sent without a websocket, so it arrives however the client is connected
+			resp, err := http.Post(fmt.Sprintf("%s/send?ids=%d", serv.URL, c.ID), "application/octet-stream", strings.NewReader("hello"))
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			select {
+			case msg := <-incoming:
+				require.Equal(t, "hello", string(msg.Data))
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not received")
+			}
+
+			cancel()
+			select {
+			case err := <-received:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Receive didn't return once cancelled")
+			}
+		})
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan []byte)
+	streamed := make(chan error, 1)
+	go func() { streamed <- c.Stream(ctx, out) }()
+
+	require.Eventually(t, func() bool { return c.Transport() == StreamTransport }, 5*time.Second, 10*time.Millisecond)
+
+	for _, data := range []string{"hello", "over SSE"} {
+		resp, err := http.Post(fmt.Sprintf("%s/send?ids=%d", serv.URL, c.ID), "application/octet-stream", strings.NewReader(data))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		select {
+		case payload := <-out:
+			require.Equal(t, data, string(payload))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not streamed", data)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-streamed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream didn't return once cancelled")
+	}
+}
+
+func TestClient_StreamHandlesMessages(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	c, err := New(address)
+	require.NoError(t, err)
+	c.ExactlyOnce = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []byte, 10)
+	go c.Stream(ctx, out)
+	require.Eventually(t, func() bool { return c.Transport() == StreamTransport }, 5*time.Second, 10*time.Millisecond)
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	conn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	recipient := fmt.Sprint(c.ID)
+	for _, msg := range []types.SendingMessage{
+		{Recipients: recipient, Data: []byte("hel"), FileID: "f1", ChunkIndex: 0, ChunkCount: 2},
+		{Recipients: recipient, Data: []byte("lo"), FileID: "f1", ChunkIndex: 1, ChunkCount: 2},
+		{Recipients: recipient, Data: []byte("once"), MessageID: "m1"},
+		{Recipients: recipient, Data: []byte("once"), MessageID: "m1"},
+	} {
+		require.NoError(t, conn.WriteJSON(msg))
+	}
+
+	// Chunks are reassembled and the repeat is dropped, as they would be over the websocket
+	for _, expected := range []string{"hello", "once"} {
+		select {
+		case payload := <-out:
+			require.Equal(t, expected, string(payload))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not streamed", expected)
+		}
+	}
+	select {
+	case payload := <-out:
+		t.Fatalf("unexpected %q streamed", payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestClient_WithTransport(t *testing.T) {
+	defer func(wait time.Duration) { PollWait = wait }(PollWait)
+	PollWait = 100 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		transport     string
+		blockUpgrades bool
+		expectedError string
+	}{
+		{
+			name:      "Websocket",
+			transport: WebsocketTransport,
+		},
+		{
+			name:      "Stream",
+			transport: StreamTransport,
+		},
+		{
+			name:      "Long poll",
+			transport: LongPollTransport,
+		},
+		{
+			name:          "Websocket doesn't fall back",
+			transport:     WebsocketTransport,
+			blockUpgrades: true,
+			expectedError: "websocket: bad handshake",
+		},
+		{
+			name:          "Unknown transport",
+			transport:     "carrier-pigeon",
+			expectedError: `unknown transport "carrier-pigeon"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.blockUpgrades && websocket.IsWebSocketUpgrade(r) {
+					http.Error(w, "upgrades not allowed", http.StatusForbidden)
+					return
+				}
+				h.Router.ServeHTTP(w, r)
+			}))
+			defer serv.Close()
+
+			c, err := New(strings.TrimPrefix(serv.URL, "http://"))
+			require.NoError(t, err)
+			incoming := c.WithTransport(tt.transport).Incoming()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			received := make(chan error, 1)
+			go func() { received <- c.Receive(ctx) }()
+
+			if tt.expectedError != "" {
+				select {
+				case err := <-received:
+					require.Error(t, err)
+					require.Contains(t, err.Error(), tt.expectedError)
+				case <-time.After(5 * time.Second):
+					t.Fatal("Receive didn't fail")
+				}
+				return
+			}
+
+			// The transport is used even though a websocket would have been negotiated
+			require.Eventually(t, func() bool { return c.Transport() == tt.transport }, 5*time.Second, 10*time.Millisecond)
+
+			resp, err := http.Post(fmt.Sprintf("%s/send?ids=%d", serv.URL, c.ID), "application/octet-stream", strings.NewReader("hello"))
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			select {
+			case msg := <-incoming:
+				require.Equal(t, "hello", string(msg.Data))
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not received")
+			}
+
+			cancel()
+			select {
+			case err := <-received:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Receive didn't return once cancelled")
+			}
+		})
+	}
+}
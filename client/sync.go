@@ -0,0 +1,62 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// ErrNoReply is returned by SendSync when no recipient replies in time
+var ErrNoReply = errors.New("no reply before the wait elapsed")
+
+// SendSync sends data to recipients through the hub's /send-sync endpoint, then waits up to wait for the first of them to Reply.
+// It doesn't need a websocket, so suits simple request/response integrations.
+func (c *Client) SendSync(recipients string, data []byte, wait time.Duration) (types.SendingMessage, error) {
+	if err := VerifyRecipients(recipients); err != nil {
+		return types.SendingMessage{}, err
+	}
+
+	query := url.Values{"ids": {recipients}, "wait": {wait.String()}}
+	resp, err := c.httpClient().Post(c.url("http", "/send-sync?"+query.Encode()), "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return types.SendingMessage{}, fmt.Errorf("failed to reach hub %s: %s", c.Address, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return types.SendingMessage{}, fmt.Errorf("failed to read response from %s: %s", c.Address, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return types.SendingMessage{}, ErrNoReply
+	default:
+		return types.SendingMessage{}, hubError(resp.StatusCode, b)
+	}
+
+	var reply types.SendingMessage
+	if err := json.Unmarshal(b, &reply); err != nil {
+		return types.SendingMessage{}, fmt.Errorf("failed to unmarshal response from %s: %s", c.Address, err)
+	}
+	return reply, nil
+}
+
+// Reply queues data on the Sending channel as the reply to msg, which the hub returns to a waiting SendSync call.
+// Otherwise it's delivered to the sender of msg, if the hub told us who that was.
+func (c *Client) Reply(msg types.SendingMessage, data []byte) {
+	reply := types.SendingMessage{Data: data, CorrelationID: msg.CorrelationID}
+	if msg.Sender != 0 {
+		reply.Recipients = fmt.Sprint(msg.Sender)
+	}
+
+	c.Sending <- reply
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendSync(t *testing.T) {
+	tests := []struct {
+		name          string
+		reply         bool
+		expectedReply string
+		expectedErr   error
+	}{
+		{
+			name:          "Reply",
+			reply:         true,
+			expectedReply: "pong",
+		},
+		{
+			name:        "No reply",
+			expectedErr: ErrNoReply,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			responder, err := New(address)
+			require.NoError(t, err)
+			conn, err := responder.InitWebsocket()
+			require.NoError(t, err)
+			defer conn.Close()
+			go responder.WriteMessages(conn)
+			go responder.ReadMessages(conn)
+
+			go func() {
+				for msg := range responder.Incoming() {
+					if tt.reply {
+						responder.Reply(msg, []byte("pong"))
+					}
+				}
+			}()
+
+			// The caller never opens a websocket
+			caller, err := New(address)
+			require.NoError(t, err)
+
+			reply, err := caller.SendSync(fmt.Sprint(responder.ID), []byte("ping"), 500*time.Millisecond)
+			if tt.expectedErr != nil {
+				require.Equal(t, tt.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedReply, string(reply.Data))
+		})
+	}
+}
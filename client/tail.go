@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+)
+
+// Output formats Tail can print messages in
+const (
+	// TextFormat prints the data of each message on its own line
+	TextFormat = "text"
+	// JSONFormat prints each message as a line of JSON
+	JSONFormat = "json"
+)
+
+// TailRetryInterval is how long Tail waits between attempts to reconnect to the hub
+var TailRetryInterval = time.Second
+
+// Tail prints every message the client receives to w until ctx is cancelled, reconnecting whenever the connection drops.
+// Closing the connection on cancel lets the hub unregister the client.
+func (c *Client) Tail(ctx context.Context, w io.Writer, format string) error {
+	if format != TextFormat && format != JSONFormat {
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	for {
+		conn, err := c.Reconnect()
+		if err == nil {
+			c.tail(ctx, conn, w, format)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(TailRetryInterval):
+		}
+	}
+}
+
+// tail prints messages read from conn until it's closed, closing it itself once ctx is cancelled
+func (c *Client) tail(ctx context.Context, conn *websocket.Conn, w io.Writer, format string) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			err = fmt.Errorf("failed to read message: %v", err)
+			c.notify(func(o Observer) { o.OnDisconnected(err) })
+			conn.Close()
+			return
+		}
+
+		msg, framed, ok := c.receive(message)
+		if framed {
+			c.trackSequence(msg.Sequence)
+			if !ok {
+				continue
+			}
+			// Put through the same acking, deduplicating and reassembling as ReadMessages, though system messages
+			// are printed as well as passed on to System
+			accepted, ok := c.accept(msg)
+			if !ok && !msg.System {
+				continue
+			}
+			if ok {
+				msg = accepted
+			}
+		} else {
+			msg = types.SendingMessage{Data: message}
+		}
+
+		if err := printMessage(w, msg, format); err != nil {
+			c.notify(func(o Observer) { o.OnError(err) })
+		}
+	}
+}
+
+// printMessage writes msg to w as a single line in the given format
+func printMessage(w io.Writer, msg types.SendingMessage, format string) error {
+	if format == JSONFormat {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %v", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	if msg.System {
+		_, err := fmt.Fprintf(w, "System message: %s\n", msg.Data)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s\n", msg.Data)
+	return err
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe to write from Tail while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(b)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestClient_Tail(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{
+			name:     "Text",
+			format:   TextFormat,
+			expected: "hello tail\n",
+		},
+		{
+			name:     "JSON",
+			format:   JSONFormat,
+			expected: `"Data":"aGVsbG8gdGFpbA=="`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hub.New()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			address := strings.TrimPrefix(serv.URL, "http://")
+
+			tailer, err := New(address)
+			require.NoError(t, err)
+			observer := &recordingObserver{events: make(chan string, 10)}
+			tailer.RegisterObserver(observer)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var out syncBuffer
+			tailErrs := make(chan error, 1)
+			go func() { tailErrs <- tailer.Tail(ctx, &out, tt.format) }()
+			expectEvent(t, observer, "connected")
+
+			sender, err := New(address)
+			require.NoError(t, err)
+			conn, err := sender.InitWebsocket()
+			require.NoError(t, err)
+			defer conn.Close()
+			go sender.WriteMessages(conn)
+
+			sender.Sending <- types.SendingMessage{Recipients: fmt.Sprint(tailer.ID), Data: []byte("hello tail")}
+			require.Eventually(t, func() bool { return strings.Contains(out.String(), tt.expected) }, 5*time.Second, 10*time.Millisecond)
+
+			// Interrupting closes the connection, so the hub unregisters the tailer
+			cancel()
+			select {
+			case err := <-tailErrs:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Tail didn't return after being cancelled")
+			}
+			require.Eventually(t, func() bool {
+				_, err := tailer.Identify()
+				return err != nil
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestClient_TailUnknownFormat(t *testing.T) {
+	c := newClient("localhost:0")
+	require.EqualError(t, c.Tail(context.Background(), &bytes.Buffer{}, "xml"), `unknown output format "xml"`)
+}
+
+func TestClient_TailExactlyOnce(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	tailer, err := New(address)
+	require.NoError(t, err)
+	tailer.ExactlyOnce = true
+	observer := &recordingObserver{events: make(chan string, 10)}
+	tailer.RegisterObserver(observer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out syncBuffer
+	go tailer.Tail(ctx, &out, TextFormat)
+	expectEvent(t, observer, "connected")
+
+	sender, err := New(address)
+	require.NoError(t, err)
+	conn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer conn.Close()
+	go sender.WriteMessages(conn)
+
+	// Tail drops the duplicate like ReadMessages does
+	msg := types.SendingMessage{Recipients: fmt.Sprint(tailer.ID), MessageID: "m1", Data: []byte("once")}
+	sender.Sending <- msg
+	sender.Sending <- msg
+	sender.Sending <- types.SendingMessage{Recipients: fmt.Sprint(tailer.ID), Data: []byte("done")}
+	require.Eventually(t, func() bool { return strings.Contains(out.String(), "done\n") }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "once\ndone\n", out.String())
+}
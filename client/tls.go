@@ -0,0 +1,71 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// NewSecure creates a client like New, but connecting to the hub over TLS, configured by config if it isn't nil
+func NewSecure(address string, config *tls.Config) (*Client, error) {
+	client := newClient(address)
+	client.Secure = true
+	client.TLSConfig = config
+
+	return registered(client)
+}
+
+// url returns the address of path on the hub, with scheme (http or ws) made secure if Secure is set
+func (c *Client) url(scheme, path string) string {
+	if c.Secure {
+		scheme += "s"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Address, path)
+}
+
+// httpClient returns the client to make requests to the hub with, which uses TLSConfig if it's set.
+// It has no timeout, for requests like /stream that last as long as their context, see timedClient.
+func (c *Client) httpClient() *http.Client {
+	if c.TLSConfig == nil {
+		return http.DefaultClient
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Kept so connections are reused, until TLSConfig is changed
+	if c.httpc == nil || c.transportTLS != c.TLSConfig {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.TLSConfig
+		c.httpc, c.transportTLS = &http.Client{Transport: transport}, c.TLSConfig
+	}
+	return c.httpc
+}
+
+// timedClient is httpClient with the HTTPTimeout, for requests the hub should answer promptly
+func (c *Client) timedClient() *http.Client {
+	client := c.httpClient()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timedc == nil || c.timedc.Transport != client.Transport || c.timedc.Timeout != c.HTTPTimeout {
+		timed := *client
+		timed.Timeout = c.HTTPTimeout
+		c.timedc = &timed
+	}
+	return c.timedc
+}
+
+// dialer returns the dialer to open websockets to the hub with, which uses TLSConfig if it's set
+func (c *Client) dialer() *websocket.Dialer {
+	if c.TLSConfig == nil {
+		return websocket.DefaultDialer
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = c.TLSConfig
+	return &dialer
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Secure(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewTLSServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "https://")
+
+	// The test server's certificate is self-signed
+	roots := x509.NewCertPool()
+	roots.AddCert(serv.Certificate())
+	config := &tls.Config{RootCAs: roots}
+
+	_, err := New(address)
+	require.Error(t, err, "registered over plain HTTP")
+	_, err = NewSecure(address, nil)
+	require.Error(t, err, "trusted a self-signed certificate")
+
+	sender, err := NewSecure(address, config)
+	require.NoError(t, err)
+	recipient, err := NewSecure(address, config)
+	require.NoError(t, err)
+
+	id, err := recipient.Identify()
+	require.NoError(t, err)
+	require.Equal(t, recipient.ID, id)
+
+	senderConn, err := sender.InitWebsocket()
+	require.NoError(t, err)
+	defer senderConn.Close()
+	go sender.WriteMessages(senderConn)
+
+	recipientConn, err := recipient.InitWebsocket()
+	require.NoError(t, err)
+	defer recipientConn.Close()
+	go recipient.ReadMessages(recipientConn)
+
+	require.Eventually(t, func() bool {
+		return sender.Send(types.SendingMessage{Recipients: fmt.Sprint(recipient.ID), Data: []byte("secret")}) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	select {
+	case msg := <-recipient.Incoming():
+		require.Equal(t, "secret", string(msg.Data))
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered over wss://")
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ValidateTimeout is how long Validate waits for the hub to let the client register its ID again after the test connection
+var ValidateTimeout = 5 * time.Second
+
+// NewValidated creates a client like New, then checks its ID works with Validate, failing early if it doesn't
+func NewValidated(address string, dial bool) (*Client, error) {
+	client, err := New(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Validate(dial); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Validate checks the client's ID is usable, by having the hub Identify it and with dial by opening and closing a test
+// websocket, so a broken path to the hub fails at startup rather than on first use.
+// The hub forgets a client once its last connection closes, so the ID is registered again after the test connection,
+// which is why Validate should be called before InitWebsocket.
+func (c *Client) Validate(dial bool) error {
+	id, err := c.Identify()
+	if err != nil {
+		return fmt.Errorf("failed to identify client %d: %v", c.ID, err)
+	}
+	if id != c.ID {
+		return fmt.Errorf("hub identified client %d as %d", c.ID, id)
+	}
+
+	if !dial {
+		return nil
+	}
+
+	conn, resp, err := c.dialWebsocket()
+	if err != nil {
+		return fmt.Errorf("failed to dial test websocket: %w", err)
+	}
+	// 101 = Switching Protocols, expected for Upgrade requests
+	if resp.StatusCode != 101 {
+		conn.Close()
+		return fmt.Errorf("Non-101 return code: %d", resp.StatusCode)
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	// Fails until the hub notices the test connection close
+	deadline := time.Now().Add(ValidateTimeout)
+	for {
+		_, err := c.register(context.Background(), url.Values{"id": {strconv.FormatUint(c.ID, 10)}})
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to register client %d again after the test websocket: %v", c.ID, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
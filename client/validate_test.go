@@ -0,0 +1,79 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewValidated(t *testing.T) {
+	h := hub.New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// Everything but the websocket path reaches the hub, like a proxy that doesn't pass upgrades through
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			http.NotFound(w, r)
+			return
+		}
+		h.Router.ServeHTTP(w, r)
+	}))
+	defer broken.Close()
+
+	tests := []struct {
+		name        string
+		server      *httptest.Server
+		dial        bool
+		expectedErr bool
+	}{
+		{
+			name:   "Golden Path",
+			server: serv,
+			dial:   true,
+		},
+		{
+			name:        "Broken websocket path",
+			server:      broken,
+			dial:        true,
+			expectedErr: true,
+		},
+		{
+			name:   "Broken websocket path without the test dial",
+			server: broken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := strings.TrimPrefix(tt.server.URL, "http://")
+
+			// Plain New trusts the ID it's given
+			_, err := New(address)
+			require.NoError(t, err)
+
+			c, err := NewValidated(address, tt.dial)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// Still registered after any test connection, so it can connect for real where the path works
+			id, err := c.Identify()
+			require.NoError(t, err)
+			assert.Equal(t, c.ID, id)
+			if tt.server == broken {
+				return
+			}
+
+			conn, err := c.InitWebsocket()
+			require.NoError(t, err)
+			conn.Close()
+		})
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/StephenBirch/message-delivery-system/client"
 	"github.com/StephenBirch/message-delivery-system/types"
@@ -19,27 +22,97 @@ var (
 
 func main() {
 	address := flag.String("address", "localhost:8080", "The address&port of the hub")
+	secure := flag.Bool("secure", false, "Connect to the hub over TLS, with wss://")
+	validate := flag.Bool("validate", false, "Check the ID works with the hub, including a test websocket, before starting")
+	protocolToken := flag.String("protocol-token", "", "Token to present when connecting the websocket, for hubs that require one")
+	tail := flag.Bool("tail", false, "Print incoming messages until interrupted, reconnecting if the connection drops")
+	outputFormat := flag.String("output-format", client.TextFormat, "How --tail prints messages, text or json")
+	ping := flag.Bool("ping", false, "Measure the round trip latency to the hub, printing min/avg/max/p99, then exit")
+	pingCount := flag.Int("ping-count", 10, "How many round trips --ping measures")
+	pingInterval := flag.Duration("ping-interval", time.Second, "How long --ping waits between round trips")
 	flag.Parse()
 
-	c, err := client.New(*address)
+	var c *client.Client
+	var err error
+	if *secure {
+		c, err = client.NewSecure(*address, nil)
+	} else {
+		c, err = client.New(*address)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	c.ProtocolToken = *protocolToken
+	if *validate {
+		if err := c.Validate(true); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *ping {
+		fmt.Fprintf(os.Stderr, "Pinging hub %s. Your ID: %d\n", *address, c.ID)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+
+		if _, err := c.Ping(ctx, os.Stdout, *pingCount, *pingInterval); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *tail {
+		fmt.Fprintf(os.Stderr, "Tailing messages from hub %s. Your ID: %d\n", *address, c.ID)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+
+		if err := c.Tail(ctx, os.Stdout, *outputFormat); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintln(os.Stderr, "Goodbye")
+		return
+	}
 
 	conn, err := c.InitWebsocket()
 	if err != nil {
 		log.Fatalf("Failed to init websocket: %v", err)
 	}
-	defer conn.Close()
+	defer func() { c.Conn().Close() }()
 
+	// Messages that fail to write are retried on a new connection rather than lost
+	c.ReconnectOnWriteError = true
 	go func() {
 		err := c.WriteMessages(conn)
 		log.Fatalf("Websocket connection closed, exiting. Error was %v", err)
 	}()
 
 	go func() {
-		err := c.ReadMessages(conn)
-		log.Fatalf("Websocket connection closed, exiting. Error was %v", err)
+		for {
+			err := c.ReadMessages(conn)
+			// WriteMessages reconnected, so carry on reading from the new connection
+			if latest := c.Conn(); latest != conn {
+				conn = latest
+				continue
+			}
+			log.Fatalf("Websocket connection closed, exiting. Error was %v", err)
+		}
+	}()
+
+	go func() {
+		for msg := range c.System() {
+			fmt.Printf("System message: %s\n", msg.Data)
+		}
 	}()
 
 	fmt.Printf("\nConnected to hub %s. Your ID: %d\n", *address, c.ID)
@@ -88,7 +161,9 @@ func main() {
 				continue
 			}
 
-			c.Sending <- types.SendingMessage{Recipients: recipients, Data: scanner.Bytes()}
+			if err := c.Send(types.SendingMessage{Recipients: recipients, Data: scanner.Bytes()}); err != nil {
+				fmt.Printf("Failed to send message: %s\n", err)
+			}
 			continue
 		// Relay message from file
 		case "4":
@@ -118,7 +193,9 @@ func main() {
 				continue
 			}
 
-			c.Sending <- types.SendingMessage{Recipients: recipients, Data: b}
+			if err := c.Send(types.SendingMessage{Recipients: recipients, Data: b}); err != nil {
+				fmt.Printf("Failed to send message: %s\n", err)
+			}
 			continue
 		// Exit
 		case "5":
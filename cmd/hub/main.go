@@ -1,16 +1,65 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/StephenBirch/message-delivery-system/hub"
 )
 
 func main() {
 	port := flag.Int("port", 8080, "The port where the hub will be exposed")
+	adminToken := flag.String("admin-token", "", "Bearer token required by admin endpoints, which are disabled if empty")
+	protocolToken := flag.String("protocol-token", "", "Token clients must present when connecting their websocket, any client can connect if empty")
+	registrationsPerMinute := flag.Int("registrations-per-minute", 0, "How many times each IP can register per minute, 0 means unlimited")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated IPs or CIDR ranges of proxies whose X-Forwarded-For gives the client's IP, none are trusted if empty")
+	tlsCert := flag.String("tls-cert", "", "Certificate file to serve TLS with, along with --tls-key, so clients connect with wss://")
+	tlsKey := flag.String("tls-key", "", "Private key file of the --tls-cert")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for in-flight and buffered messages to be delivered when shutting down")
+	requestTimeout := flag.Duration("request-timeout", 0, "How long a request may take before it's abandoned with a 503, 0 means no limit")
 	flag.Parse()
 
 	h := hub.New()
-	h.Router.Run(fmt.Sprintf(":%d", *port))
+	h.AdminToken = *adminToken
+	h.ProtocolToken = *protocolToken
+	h.RegistrationsPerMinute = *registrationsPerMinute
+	h.RequestTimeout = *requestTimeout
+	if *trustedProxies != "" {
+		h.TrustedProxies = strings.Split(*trustedProxies, ",")
+	}
+
+	// Shut down cleanly on SIGINT/SIGTERM, delivering what's buffered before closing connections
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := h.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown didn't finish cleanly: %v", err)
+		}
+		close(stopped)
+	}()
+
+	addr := fmt.Sprintf(":%d", *port)
+	var err error
+	if *tlsCert != "" || *tlsKey != "" {
+		err = h.ServeTLS(addr, *tlsCert, *tlsKey)
+	} else {
+		err = h.Serve(addr)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
 }
@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// ack tells sender that recipient has accepted message messageID
+func (h *Hub) ack(sender, recipient uint64, messageID string) {
+	ch, exists := h.getClient(sender)
+	if !exists {
+		return
+	}
+
+	if err := h.enqueue(sender, ch, types.SendingMessage{MessageID: messageID, Ack: true, Sender: recipient}, nil); err != nil {
+		h.Log.Errorf("Unable to ack message: %v client=%d message_id=%s", err, sender, messageID)
+	}
+}
+
+// nack tells sender the hub gave up on message messageID for recipient, or refused it outright if recipient is 0, so
+// a sender waiting on the ack isn't left waiting forever. Messages without a MessageID aren't acked, so aren't nacked.
+func (h *Hub) nack(sender, recipient uint64, messageID, reason string) {
+	if messageID == "" {
+		return
+	}
+	ch, exists := h.getClient(sender)
+	if !exists {
+		return
+	}
+
+	msg := types.SendingMessage{MessageID: messageID, Ack: true, AckStatus: types.AckFailed, Sender: recipient, Data: []byte(reason)}
+	if err := h.enqueue(sender, ch, msg, nil); err != nil {
+		h.Log.Errorf("Unable to nack message: %v client=%d message_id=%s", err, sender, messageID)
+	}
+}
+
+// refuse tells id why the hub refused msg it sent, failing it for every recipient
+func (h *Hub) refuse(id uint64, msg types.SendingMessage, text string) {
+	h.systemMessage(id, text)
+	h.nack(id, 0, msg.MessageID, text)
+}
+
+// handedIDsSize is how many of the latest MessageIDs handed to each recipient are remembered, for passing on its processed acks
+var handedIDsSize = 1024
+
+// handed records that the message with messageID from sender was handed to seq's recipient, so a processed ack for it
+// goes back to sender. The oldest are forgotten past handedIDsSize.
+func (h *Hub) handed(seq *sequencer, sender uint64, messageID string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if seq.senders == nil {
+		seq.senders = make(map[string]uint64)
+	}
+	if _, exists := seq.senders[messageID]; !exists {
+		seq.senderOrder = append(seq.senderOrder, messageID)
+	}
+	seq.senders[messageID] = sender
+	for len(seq.senderOrder) > handedIDsSize {
+		delete(seq.senders, seq.senderOrder[0])
+		seq.senderOrder = seq.senderOrder[1:]
+	}
+}
+
+// processed passes on recipient's ack that it processed a message to the sender the hub handed the message over from.
+// Acks for messages the recipient wasn't handed are dropped, so a client can only tell a sender about messages it
+// actually received from it.
+// Unlike a delivery ack the hub has nothing to settle, the recipient acks processing in its own time.
+func (h *Hub) processed(recipient uint64, ack types.SendingMessage) {
+	h.Lock()
+	var sender uint64
+	var exists bool
+	if seq, ok := h.sequencers[recipient]; ok {
+		sender, exists = seq.senders[ack.MessageID]
+	}
+	h.Unlock()
+	if !exists {
+		h.Log.Infof("Dropping processed ack for a message the client wasn't handed client=%d message_id=%s", recipient, ack.MessageID)
+		return
+	}
+
+	ch, exists := h.getClient(sender)
+	if !exists {
+		return
+	}
+
+	msg := types.SendingMessage{MessageID: ack.MessageID, Ack: true, AckStatus: types.AckProcessed, Sender: recipient}
+	if err := h.enqueue(sender, ch, msg, nil); err != nil {
+		h.Log.Errorf("Unable to pass on processed ack: %v client=%d message_id=%s", err, sender, ack.MessageID)
+	}
+}
+
+// systemMessage tells id about a problem with something it sent, e.g. recipients that don't exist
+func (h *Hub) systemMessage(id uint64, text string) {
+	ch, exists := h.getClient(id)
+	if !exists {
+		return
+	}
+
+	if err := h.enqueue(id, ch, types.SendingMessage{Data: []byte(text), System: true}, nil); err != nil {
+		h.Log.Errorf("Unable to send system message: %v client=%d size=%d", err, id, len(text))
+	}
+}
@@ -0,0 +1,31 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin is middleware only letting requests through that carry the AdminToken as a bearer token.
+// Admin endpoints are disabled entirely while no AdminToken is set.
+func (h *Hub) requireAdmin(c *gin.Context) {
+	if h.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Admin endpoints are disabled"})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "message": "Admin token required"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Invalid admin token"})
+		return
+	}
+
+	c.Next()
+}
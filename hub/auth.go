@@ -0,0 +1,129 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// issuedToken is a token issued to a client by POST /register
+type issuedToken struct {
+	value    string
+	issuedAt time.Time
+	lastUsed time.Time // When the token was last presented, see authorized
+}
+
+// registerWithToken is register, also issuing the client a token in a types.RegisterResponse. The client must present
+// it as a bearer token to connect to /ws or /stream, send as itself on /send, /identify and /deregister, so others who
+// know its ID can't act as it. The token lasts as long as the registration.
+func (h *Hub) registerWithToken(c *gin.Context) {
+	token, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": err.Error()})
+		return
+	}
+
+	id, ok := h.registerClient(c, token)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, types.RegisterResponse{
+		ID:              id,
+		ServerTime:      h.Clock.Now(),
+		ProtocolVersion: types.ProtocolVersion,
+		Token:           token,
+	})
+}
+
+// authorized checks a request acting as client id carries the client's token as a bearer token, if it registered with one.
+// It responds with an error and returns false if the token is missing or wrong.
+func (h *Hub) authorized(c *gin.Context, id uint64) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.authorizedLocked(c, id)
+}
+
+// authorizedLocked is authorized with the lock already held
+func (h *Hub) authorizedLocked(c *gin.Context, id uint64) bool {
+	issued, exists := h.tokens[id]
+	if !exists {
+		return true
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "message": "Token required"})
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(issued.value)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Invalid token"})
+		return false
+	}
+	issued.lastUsed = h.Clock.Now()
+	return true
+}
+
+// rejectRevoked is middleware rejecting requests that present a revoked token, whichever client they act as
+func (h *Hub) rejectRevoked(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.Next()
+		return
+	}
+
+	h.Lock()
+	_, revoked := h.revokedTokens[token]
+	h.Unlock()
+	if revoked {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "message": "Token revoked"})
+		return
+	}
+
+	c.Next()
+}
+
+// listTokens lists the tokens issued to registered clients, by client ID, without the tokens themselves
+func (h *Hub) listTokens(c *gin.Context) {
+	var resp types.TokensResponse
+	h.Lock()
+	for id, issued := range h.tokens {
+		resp.Tokens = append(resp.Tokens, types.TokenInfo{ID: id, IssuedAt: issued.issuedAt, LastUsed: issued.lastUsed})
+	}
+	h.Unlock()
+	sort.Slice(resp.Tokens, func(i, j int) bool { return resp.Tokens[i].ID < resp.Tokens[j].ID })
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// revokeToken revokes the token issued to the client with the given ID, so it's rejected from then on, and deregisters
+// the client, closing its connections. The ID is free to be registered again.
+func (h *Hub) revokeToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	issued, exists := h.tokens[id]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"status": "Not Found", "message": "No token issued to client"})
+		return
+	}
+
+	h.revokedTokens[issued.value] = struct{}{}
+	h.deregisterLocked(id, closeRevoked)
+
+	c.JSON(http.StatusOK, id)
+}
@@ -0,0 +1,209 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerWithToken registers id with POST /register, returning the token it was issued
+func registerWithToken(t *testing.T, h *Hub, id uint64) string {
+	req, err := http.NewRequest("POST", fmt.Sprintf("/register?id=%d", id), nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp types.RegisterResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(t, id, resp.ID)
+	require.NotEmpty(t, resp.Token)
+	return resp.Token
+}
+
+func TestHub_TokenAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		// issued presents the token the client was issued, otherwise token is presented
+		issued        bool
+		token         string
+		expectedCode  int
+		expectedError gin.H
+	}{
+		{
+			name:         "Golden Path",
+			issued:       true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Missing token",
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: gin.H{"status": "Unauthorized", "message": "Token required"},
+		},
+		{
+			name:          "Wrong token",
+			token:         "not-the-token",
+			expectedCode:  http.StatusForbidden,
+			expectedError: gin.H{"status": "Forbidden", "message": "Invalid token"},
+		},
+	}
+	requests := []struct {
+		method, path string
+	}{
+		{"GET", "/identify?id=500"},
+		{"POST", "/send?ids=600&from=500"},
+		{"POST", "/groups/send?name=room&from=500"},
+		{"GET", "/deregister?id=500"},
+	}
+	for _, tt := range tests {
+		for _, r := range requests {
+			t.Run(tt.name+" "+r.path, func(t *testing.T) {
+				h := New()
+				h.SeedClients(600)
+				h.Groups["room"] = map[uint64]struct{}{600: {}}
+				token := registerWithToken(t, h, 500)
+				if !tt.issued {
+					token = tt.token
+				}
+
+				req, err := http.NewRequest(r.method, r.path, strings.NewReader("Hi"))
+				require.NoError(t, err)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+
+				w := httptest.NewRecorder()
+				h.Router.ServeHTTP(w, req)
+				assert.Equal(t, tt.expectedCode, w.Code)
+
+				if tt.expectedError != nil {
+					var errorBody gin.H
+					require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+					assert.Equal(t, tt.expectedError, errorBody)
+
+					_, registered := h.getClient(500)
+					assert.True(t, registered)
+				}
+			})
+		}
+
+		t.Run(tt.name+" /ws", func(t *testing.T) {
+			h := New()
+			token := registerWithToken(t, h, 500)
+			if !tt.issued {
+				token = tt.token
+			}
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			header := http.Header{}
+			if token != "" {
+				header.Set("Authorization", "Bearer "+token)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), header)
+			require.NotNil(t, resp)
+
+			if tt.expectedError != nil {
+				assert.Equal(t, tt.expectedCode, resp.StatusCode)
+				assert.Equal(t, websocket.ErrBadHandshake, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			conn.Close()
+		})
+	}
+}
+
+func TestHub_TokenForgotten(t *testing.T) {
+	h := New()
+	token := registerWithToken(t, h, 500)
+
+	req, err := http.NewRequest("GET", "/deregister?id=500", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The token ends with the registration, so the ID can be registered afresh without it
+	h.SeedClients(500)
+	req, err = http.NewRequest("GET", "/identify?id=500", nil)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHub_RevokeToken(t *testing.T) {
+	h := New()
+	h.AdminToken = "admin"
+	token := registerWithToken(t, h, 500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// request runs a request presenting bearer, returning the recorded response
+	request := func(method, path, bearer string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")),
+		http.Header{"Authorization": {"Bearer " + token}})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, http.StatusOK, request("GET", "/identify?id=500", token).Code)
+
+	w := request("GET", "/admin/tokens", "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	var tokens types.TokensResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+	require.Len(t, tokens.Tokens, 1)
+	assert.Equal(t, uint64(500), tokens.Tokens[0].ID)
+	assert.True(t, tokens.Tokens[0].LastUsed.After(tokens.Tokens[0].IssuedAt), "use of the token wasn't recorded")
+
+	require.Equal(t, http.StatusOK, request("DELETE", "/admin/tokens/500", "admin").Code)
+
+	// The client's connection is closed
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			netErr, ok := err.(net.Error)
+			assert.False(t, ok && netErr.Timeout(), "websocket left open")
+			break
+		}
+	}
+
+	// And the token is rejected from then on
+	w = request("GET", "/identify?id=500", token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	var errorBody gin.H
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+	assert.Equal(t, gin.H{"status": "Unauthorized", "message": "Token revoked"}, errorBody)
+
+	w = request("GET", "/admin/tokens", "admin")
+	tokens = types.TokensResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+	assert.Empty(t, tokens.Tokens)
+
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/admin/tokens/500", "admin").Code)
+}
@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// broadcast relays the body to every registered client, apart from the one given by the optional "exclude" query.
+// Clients are sent to at once, each given up on after the SendTimeout, so a slow client doesn't hold up the rest.
+// Responds with a types.BroadcastResponse of how many clients were handed the message.
+func (h *Hub) broadcast(c *gin.Context) {
+	var exclude uint64
+	if c.Query("exclude") != "" {
+		var err error
+		exclude, err = strconv.ParseUint(c.Query("exclude"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return
+		}
+	}
+
+	// Broadcasts are anonymous, so they're limited by the caller's IP
+	if !h.limitSend(c, 0) {
+		return
+	}
+
+	if c.Request.Body == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Body expected for a broadcast call"})
+		return
+	}
+	b, err := readLimitedBody(c, h.SendReadTimeout, int64(h.MaxDataSize))
+	if err == errReadTimeout {
+		c.JSON(http.StatusRequestTimeout, gin.H{"status": "Request Timeout", "message": "Timed out reading body"})
+		return
+	}
+	if err == errBodyTooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "Request Entity Too Large", "message": fmt.Sprintf("Maximum data size is %d bytes", h.MaxDataSize)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "No body found"})
+		return
+	}
+
+	h.Lock()
+	channels := make(map[uint64]chan []byte, len(h.Clients))
+	for id, ch := range h.Clients {
+		if id != exclude && ch != nil {
+			channels[id] = ch
+		}
+	}
+	h.Unlock()
+
+	var (
+		mu   sync.Mutex
+		resp types.BroadcastResponse
+		wg   sync.WaitGroup
+	)
+	msg := types.SendingMessage{Data: b}
+	h.stamp(&msg)
+	for id, ch := range channels {
+		wg.Add(1)
+		go func(id uint64, ch chan []byte) {
+			defer wg.Done()
+
+			ctx, cancel := h.sendTimeout(c.Request.Context())
+			defer cancel()
+			abort, release := h.abortSend(ctx)
+			defer release()
+
+			err := h.enqueue(id, ch, msg, abort)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if !counted(err) {
+					h.counters.failed()
+				}
+				resp.Failed = append(resp.Failed, id)
+				return
+			}
+			h.counters.relayed(len(msg.Data))
+			resp.Delivered++
+		}(id, ch)
+	}
+	wg.Wait()
+
+	sort.Slice(resp.Failed, func(i, j int) bool { return resp.Failed[i] < resp.Failed[j] })
+	c.JSON(http.StatusOK, resp)
+}
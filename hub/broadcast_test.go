@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_broadcast(t *testing.T) {
+	tests := []struct {
+		name              string
+		query             string
+		expectedCode      int
+		expectedDelivered int
+		expectedReceivers []uint64
+	}{
+		{
+			name:              "Golden Path",
+			query:             "",
+			expectedCode:      200,
+			expectedDelivered: 3,
+			expectedReceivers: []uint64{500, 600, 700},
+		},
+		{
+			name:              "Excluding a client",
+			query:             "?exclude=700",
+			expectedCode:      200,
+			expectedDelivered: 2,
+			expectedReceivers: []uint64{500, 600},
+		},
+		{
+			name:         "Invalid exclude",
+			query:        "?exclude=notanid",
+			expectedCode: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500, 600, 700)
+
+			req, err := http.NewRequest("POST", "/broadcast"+tt.query, strings.NewReader("Hi everyone"))
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode != 200 {
+				return
+			}
+
+			var resp types.BroadcastResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, types.BroadcastResponse{Delivered: tt.expectedDelivered}, resp)
+
+			var receivers []uint64
+			for _, id := range []uint64{500, 600, 700} {
+				ch, _ := h.getClient(id)
+				if len(ch) == 0 {
+					continue
+				}
+				var msg types.SendingMessage
+				require.NoError(t, json.Unmarshal(<-ch, &msg))
+				assert.Equal(t, "Hi everyone", string(msg.Data))
+				receivers = append(receivers, id)
+			}
+			assert.Equal(t, tt.expectedReceivers, receivers)
+		})
+	}
+}
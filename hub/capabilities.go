@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// capabilities describes the protocol the hub speaks. It's built from the router, types and config as they are,
+// so it can't drift from what the hub actually does.
+func (h *Hub) capabilities(c *gin.Context) {
+	var endpoints []types.Endpoint
+	for _, route := range h.Router.Routes() {
+		endpoints = append(endpoints, types.Endpoint{Method: route.Method, Path: route.Path})
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+
+	c.JSON(http.StatusOK, types.CapabilitiesResponse{
+		ProtocolVersion: types.ProtocolVersion,
+		Endpoints:       endpoints,
+		Message:         messageFields(reflect.TypeOf(types.SendingMessage{})),
+		ContentTypes:    []string{"application/json", types.RegisterResponseType, eventStreamType, "text/csv"},
+		Compression:     []string{types.GzipEncoding},
+		Limits: types.Limits{
+			MaxRecipients:          h.MaxRecipients,
+			MaxDataSize:            h.MaxDataSize,
+			MaxMessageSize:         h.MaxMessageSize,
+			MaxQueryLength:         maxQueryLength,
+			SendReadTimeout:        h.SendReadTimeout,
+			SendTimeout:            h.SendTimeout,
+			RequestTimeout:         h.RequestTimeout,
+			ClientBufferSize:       h.ClientBufferSize,
+			QueueSize:              h.QueueSize,
+			QueueBytes:             h.QueueBytes,
+			RecipientRate:          h.RecipientRate,
+			RecipientBurst:         h.RecipientBurst,
+			SenderRate:             h.SenderRate,
+			SenderBurst:            h.SenderBurst,
+			MaxGroups:              h.MaxGroups,
+			RegistrationsPerMinute: h.RegistrationsPerMinute,
+		},
+	})
+}
+
+// messageFields describes each field of the struct t as encoding/json would marshal it
+func messageFields(t reflect.Type) []types.MessageField {
+	var fields []types.MessageField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		field := types.MessageField{Name: f.Name}
+		if tag[0] != "" {
+			field.Name = tag[0]
+		}
+		for _, option := range tag[1:] {
+			if option == "omitempty" {
+				field.Optional = true
+			}
+		}
+
+		field.Type, field.Format = jsonType(f.Type)
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// jsonType returns the JSON type values of t are marshalled to, along with their format where the type alone isn't enough
+func jsonType(t reflect.Type) (string, string) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "string", "date-time"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string", ""
+	case reflect.Bool:
+		return "boolean", ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer", ""
+	case reflect.Float32, reflect.Float64:
+		return "number", ""
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string", "base64"
+		}
+		return "array", ""
+	case reflect.Map, reflect.Struct:
+		return "object", ""
+	default:
+		return t.Kind().String(), ""
+	}
+}
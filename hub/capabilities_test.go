@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_capabilities(t *testing.T) {
+	h := New()
+	h.QueueSize = 10
+	h.QueueBytes = 4096
+	h.RequestTimeout = 30 * time.Second
+	h.MaxGroups = 5
+
+	req, err := http.NewRequest("GET", "/capabilities", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var doc types.CapabilitiesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+
+	assert.Equal(t, types.ProtocolVersion, doc.ProtocolVersion)
+	assert.Contains(t, doc.Endpoints, types.Endpoint{Method: "POST", Path: "/send"})
+	assert.Contains(t, doc.Endpoints, types.Endpoint{Method: "GET", Path: "/ws"})
+	assert.Contains(t, doc.Endpoints, types.Endpoint{Method: "GET", Path: "/capabilities"})
+	assert.Equal(t, []string{types.GzipEncoding}, doc.Compression)
+
+	assert.Equal(t, types.Limits{
+		MaxRecipients:    defaultMaxRecipients,
+		MaxDataSize:      defaultMaxDataSize,
+		MaxMessageSize:   defaultMaxMessageSize,
+		MaxQueryLength:   maxQueryLength,
+		SendReadTimeout:  defaultSendReadTimeout,
+		SendTimeout:      defaultSendTimeout,
+		RequestTimeout:   30 * time.Second,
+		ClientBufferSize: defaultClientBufferSize,
+		QueueSize:        10,
+		QueueBytes:       4096,
+		MaxGroups:        5,
+	}, doc.Limits)
+
+	// Every field of the message is described
+	require.Len(t, doc.Message, reflect.TypeOf(types.SendingMessage{}).NumField())
+	for _, expected := range []types.MessageField{
+		{Name: "Recipients", Type: "string"},
+		{Name: "Data", Type: "string", Format: "base64"},
+		{Name: "Sender", Type: "integer", Optional: true},
+		{Name: "DeliverAt", Type: "string", Format: "date-time"},
+		{Name: "Encrypted", Type: "boolean", Optional: true},
+		{Name: "MessageID", Type: "string", Optional: true},
+	} {
+		assert.Contains(t, doc.Message, expected)
+	}
+}
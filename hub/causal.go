@@ -0,0 +1,16 @@
+package hub
+
+import (
+	"sync/atomic"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// stamp gives msg the hub's next Lamport timestamp while CausalOrdering is set. It's called once as each message is
+// accepted, before it's delivered to anyone, so a recipient replying to it can only be stamped later.
+func (h *Hub) stamp(msg *types.SendingMessage) {
+	if !h.CausalOrdering {
+		return
+	}
+	msg.Lamport = atomic.AddUint64(&h.lamport, 1)
+}
@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_CausalOrdering(t *testing.T) {
+	tests := []struct {
+		name             string
+		causalOrdering   bool
+		expectedLamports []uint64
+	}{
+		{
+			name:             "Golden Path",
+			causalOrdering:   true,
+			expectedLamports: []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name:             "Not enabled",
+			expectedLamports: []uint64{0, 0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.CausalOrdering = tt.causalOrdering
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			h.SeedClients(500, 600, 700)
+			recipient := dialAs(t, serv, 500)
+			defer recipient.Close()
+			senders := map[uint64]*websocket.Conn{600: dialAs(t, serv, 600), 700: dialAs(t, serv, 700)}
+			for _, conn := range senders {
+				defer conn.Close()
+			}
+
+			receive := func() types.SendingMessage {
+				var msg types.SendingMessage
+				require.NoError(t, recipient.ReadJSON(&msg))
+				return msg
+			}
+
+			// The senders take turns, a sender's own stamp being ignored
+			var lamports []uint64
+			for _, sender := range []uint64{600, 700, 600, 700} {
+				writeFrame(t, senders[sender], types.SendingMessage{Recipients: "500", Data: []byte("Hi"), Lamport: 100})
+				msg := receive()
+				assert.Equal(t, sender, msg.Sender)
+				lamports = append(lamports, msg.Lamport)
+			}
+
+			// Messages sent over HTTP are on the same clock
+			req, err := http.NewRequest("POST", "/send?ids=500", bytes.NewBufferString("Hi"))
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			require.Equal(t, 200, w.Code)
+			lamports = append(lamports, receive().Lamport)
+
+			assert.Equal(t, tt.expectedLamports, lamports)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package hub
+
+import "time"
+
+// Clock is where the hub reads the time from and waits on it, so time dependent behaviour can be tested without sleeping
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func())
+}
+
+// RealClock is the Clock of the system's own time
+type RealClock struct{}
+
+// Now returns time.Now()
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After wraps time.After
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// AfterFunc wraps time.AfterFunc
+func (RealClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
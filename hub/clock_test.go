@@ -0,0 +1,109 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock that only moves when Advance is called
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), f: f})
+}
+
+// Advance moves the clock on by d, running any timers that fall due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []fakeTimer
+	remaining := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			remaining = append(remaining, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = remaining
+	c.mu.Unlock()
+
+	for _, t := range due {
+		go t.f()
+	}
+}
+
+func TestHub_Clock(t *testing.T) {
+	clock := newFakeClock()
+	h := New()
+	h.Clock = clock
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Last seen times come from the clock
+	require.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return h.lastSeen[500].Equal(clock.Now())
+	}, time.Second, 10*time.Millisecond)
+
+	// Scheduled an hour ahead, so nothing arrives until the clock is moved on
+	msg := types.SendingMessage{Recipients: "500", Data: []byte("an hour later"), DeliverAt: clock.Now().Add(time.Hour)}
+	h.schedule(0, msg.DeliverAt, []uint64{500}, msg)
+
+	clock.Advance(time.Hour - time.Second)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(0), getStats(t, h).MessagesRelayed, "message delivered before it was due")
+
+	clock.Advance(time.Second)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, received, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var receivedMsg types.SendingMessage
+	require.NoError(t, json.Unmarshal(received, &receivedMsg))
+	assert.Equal(t, "an hour later", string(receivedMsg.Data))
+}
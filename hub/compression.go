@@ -0,0 +1,55 @@
+package hub
+
+import (
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// validCompression checks the optional "compress" query names an encoding the hub can compress messages with,
+// responding with an error and returning false if not
+func validCompression(c *gin.Context) bool {
+	switch c.Query("compress") {
+	case "", types.GzipEncoding:
+		return true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unsupported compression, only " + types.GzipEncoding + " is available"})
+		return false
+	}
+}
+
+// storeCompression records that id can decompress messages with encoding
+func (h *Hub) storeCompression(id uint64, encoding string) {
+	if encoding == "" {
+		return
+	}
+
+	h.Lock()
+	h.encodings[id] = encoding
+	h.Unlock()
+}
+
+// encodeFor compresses the Data of msg if id registered as able to decompress it, and it comes out smaller.
+// Encrypted data doesn't compress, so is left as it is.
+func (h *Hub) encodeFor(id uint64, msg types.SendingMessage) types.SendingMessage {
+	h.Lock()
+	encoding := h.encodings[id]
+	h.Unlock()
+
+	if encoding != types.GzipEncoding || msg.Encrypted || len(msg.Data) == 0 {
+		return msg
+	}
+
+	data, err := gzipData(msg.Data)
+	if err != nil {
+		h.Log.Errorf("Unable to compress message: %v client=%d size=%d", err, id, len(msg.Data))
+		return msg
+	}
+
+	if len(data) >= len(msg.Data) {
+		return msg
+	}
+	msg.Data, msg.Encoding = data, encoding
+	return msg
+}
@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_compression(t *testing.T) {
+	h := New()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	register := func(query string) int {
+		req, err := http.NewRequest("GET", "/register?"+query, nil)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, 400, register("id=502&compress=brotli"))
+	require.Equal(t, 200, register("id=500&compress=gzip"))
+	require.Equal(t, 200, register("id=501"))
+
+	conns := map[uint64]*websocket.Conn{}
+	for _, id := range []uint64{500, 501} {
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%d", address, id), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		conns[id] = conn
+	}
+
+	tests := []struct {
+		name             string
+		data             string
+		expectedEncoding map[uint64]string
+	}{
+		{
+			name:             "Compressible",
+			data:             strings.Repeat("compress me ", 100),
+			expectedEncoding: map[uint64]string{500: types.GzipEncoding, 501: ""},
+		},
+		{
+			name:             "Too small to benefit",
+			data:             "hi",
+			expectedEncoding: map[uint64]string{500: "", 501: ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/send?ids=500,501", strings.NewReader(tt.data))
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			require.Equal(t, 200, w.Code)
+
+			for id, conn := range conns {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				var msg types.SendingMessage
+				require.NoError(t, conn.ReadJSON(&msg))
+				assert.Equal(t, tt.expectedEncoding[id], msg.Encoding, "encoding for %d", id)
+
+				data := msg.Data
+				if msg.Encoding == types.GzipEncoding {
+					data, err = gunzip(msg.Data, 0)
+					require.NoError(t, err)
+					assert.Less(t, len(msg.Data), len(tt.data))
+				}
+				assert.Equal(t, tt.data, string(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	deadLetterBuffer      = 64  // How many dead letters are held for DeadLetters before the oldest are dropped
+	defaultDeadLetterSize = 100 // How many recent dead letters /deadletter lists by default
+)
+
+// DeadLetters returns the channel messages are passed to once they can't be delivered, e.g. after exhausting their redeliveries
+func (h *Hub) DeadLetters() <-chan types.DeadLetter {
+	return h.deadLetters
+}
+
+// deadLetter records that msg couldn't be delivered to recipient, counting it as a failure, see keepDeadLetter
+func (h *Hub) deadLetter(recipient uint64, msg types.SendingMessage, attempts int, reason string) {
+	h.counters.failed()
+	h.keepDeadLetter(recipient, msg, attempts, reason)
+}
+
+// keepDeadLetter records that msg couldn't be delivered to recipient, for callers that count it themselves.
+// It's kept for /deadletter and passed to DeadLetters, making room by dropping the oldest dead letter if nobody is draining them.
+func (h *Hub) keepDeadLetter(recipient uint64, msg types.SendingMessage, attempts int, reason string) {
+	h.Lock()
+	defer h.Unlock()
+	h.keepDeadLetterLocked(recipient, msg, attempts, reason)
+}
+
+// keepDeadLetterLocked is keepDeadLetter with the lock already held
+func (h *Hub) keepDeadLetterLocked(recipient uint64, msg types.SendingMessage, attempts int, reason string) {
+	h.Log.Infof("Dead lettering message: %s client=%d size=%d message_id=%s", reason, recipient, len(msg.Data), msg.MessageID)
+
+	letter := types.DeadLetter{Recipient: recipient, Message: msg, Attempts: attempts, Reason: reason, At: h.Clock.Now()}
+
+	h.deadLetterLog = append(h.deadLetterLog, letter)
+	if over := len(h.deadLetterLog) - h.DeadLetterSize; over > 0 {
+		h.deadLetterLog = append([]types.DeadLetter(nil), h.deadLetterLog[over:]...)
+	}
+
+	for {
+		select {
+		case h.deadLetters <- letter:
+			return
+		default:
+		}
+
+		select {
+		case <-h.deadLetters:
+		default:
+		}
+	}
+}
+
+// listDeadLetters returns the most recent DeadLetterSize messages the hub couldn't deliver, oldest first.
+// Only their metadata is listed, the Data is left out.
+func (h *Hub) listDeadLetters(c *gin.Context) {
+	h.Lock()
+	letters := make([]types.DeadLetter, len(h.deadLetterLog))
+	copy(letters, h.deadLetterLog)
+	h.Unlock()
+
+	for i := range letters {
+		letters[i].Message.Data = nil
+	}
+
+	c.JSON(http.StatusOK, types.DeadLettersResponse{DeadLetters: letters})
+}
@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listDeadLettersOf fetches /deadletter from h as the admin
+func listDeadLettersOf(t *testing.T, h *Hub) types.DeadLettersResponse {
+	req, err := http.NewRequest("GET", "/deadletter", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var resp types.DeadLettersResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+func TestHub_listDeadLetters(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+	h.DeadLetterSize = 2
+	assert.Empty(t, listDeadLettersOf(t, h).DeadLetters)
+
+	// Unknown recipients are rejected, and dead lettered
+	for _, id := range []uint64{500, 600, 700} {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%d", id), bytes.NewBufferString("Hi"))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		require.Equal(t, 400, w.Code)
+	}
+
+	// Only the most recent DeadLetterSize are kept, without their data
+	resp := listDeadLettersOf(t, h)
+	require.Len(t, resp.DeadLetters, 2)
+	for i, id := range []uint64{600, 700} {
+		letter := resp.DeadLetters[i]
+		assert.Equal(t, id, letter.Recipient)
+		assert.Equal(t, "Recipient not registered", letter.Reason)
+		assert.Equal(t, fmt.Sprint(id), letter.Message.Recipients)
+		assert.Nil(t, letter.Message.Data)
+		assert.False(t, letter.At.IsZero())
+	}
+	assert.Equal(t, uint64(3), getStats(t, h).Failures)
+}
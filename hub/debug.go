@@ -0,0 +1,27 @@
+package hub
+
+import (
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// debugState summarises the hub's internal state for operators, e.g. to spot flapping clients from the connection churn
+func (h *Hub) debugState(c *gin.Context) {
+	h.Lock()
+	state := types.DebugState{
+		Clients:   len(h.Clients),
+		Connected: len(h.sessions),
+		Churn: types.ConnectionChurn{
+			Opened: h.instruments.opened.Value(),
+			Closed: h.instruments.closed.Values(),
+		},
+	}
+	for _, s := range h.sessions {
+		state.Connections += len(s.devices)
+	}
+	h.Unlock()
+
+	c.JSON(http.StatusOK, state)
+}
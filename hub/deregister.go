@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errRecipientGone = errors.New("recipient was deregistered")
+
+// deregister takes a query of an ID, removing the client from the hub and closing its connections so it can leave
+// without having to drop its websocket. Clients registered with a token must present it, see authorized.
+func (h *Hub) deregister(c *gin.Context) {
+	if c.Query("id") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID is required"})
+		return
+	}
+
+	parsedID, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if _, exists := h.Clients[parsedID]; !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return
+	}
+	if !h.authorizedLocked(c, parsedID) {
+		return
+	}
+
+	h.deregisterLocked(parsedID, closeDeregistered)
+
+	c.JSON(http.StatusOK, parsedID)
+}
+
+// deregisterLocked removes the client from the hub, closing its connections for reason, the lock must be held
+func (h *Hub) deregisterLocked(id uint64, reason string) {
+	// The last device to go unregisters the client, otherwise it never connected and is forgotten here
+	if s, exists := h.sessions[id]; exists {
+		for _, d := range append([]*device(nil), s.devices...) {
+			d.close()
+			h.disconnectDeviceLocked(id, d, reason)
+		}
+	} else {
+		h.forgetClientLocked(id)
+	}
+	// Kept across connections for /resend and group sends, but the client has left for good
+	delete(h.sequencers, id)
+	h.leaveAllGroupsLocked(id)
+	h.dropQueuedLocked(id, "Recipient deregistered")
+	delete(h.formerTokens, id)
+}
+
+// departure returns a channel closed once the client id is forgotten, for a send blocked handing it a message on ch to give up.
+// registered is false if ch is no longer id's channel, the client having already gone.
+func (h *Hub) departure(id uint64, ch chan []byte) (gone <-chan struct{}, registered bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	if current, exists := h.Clients[id]; !exists || current != ch {
+		return nil, false
+	}
+	signal, exists := h.departures[ch]
+	if !exists {
+		signal = make(chan struct{})
+		h.departures[ch] = signal
+	}
+	return signal, true
+}
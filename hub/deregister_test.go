@@ -0,0 +1,192 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_deregister(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		inputID       string
+		clients       []uint64
+		remaining     []uint64
+	}{
+		{
+			name:         "Golden Path",
+			expectedCode: 200,
+			inputID:      "500",
+			clients:      []uint64{500, 600},
+			remaining:    []uint64{600},
+		},
+		{
+			name:          "No ID",
+			expectedCode:  400,
+			expectedError: gin.H{"message": "ID is required", "status": "Bad Request"},
+			clients:       []uint64{500},
+			remaining:     []uint64{500},
+		},
+		{
+			name:          "Not uint64 parsable",
+			expectedCode:  400,
+			inputID:       "notuint64",
+			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
+			clients:       []uint64{500},
+			remaining:     []uint64{500},
+		},
+		{
+			name:          "ID not registered",
+			expectedCode:  400,
+			inputID:       "700",
+			expectedError: gin.H{"message": "ID not registered", "status": "Bad Request"},
+			clients:       []uint64{500},
+			remaining:     []uint64{500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(tt.clients...)
+
+			req, err := http.NewRequest("GET", fmt.Sprintf("/deregister?id=%s", tt.inputID), nil)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+
+			req, err = http.NewRequest("GET", "/users?id=0", nil)
+			require.NoError(t, err)
+
+			w = httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			var users types.ListResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+			assert.ElementsMatch(t, tt.remaining, users.IDs)
+		})
+	}
+}
+
+func TestHub_deregisterConnected(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	conn := dialAs(t, serv, 500)
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", "/deregister?id=500", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	// The hub closes the websocket, so reads fail rather than waiting on messages that will never come
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			netErr, ok := err.(net.Error)
+			assert.False(t, ok && netErr.Timeout(), "websocket left open")
+			break
+		}
+	}
+
+	_, exists := h.getClient(500)
+	assert.False(t, exists)
+
+	// The ID is free to be registered again
+	req, err = http.NewRequest("GET", "/register?id=500", nil)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestHub_deregisterWhileSending(t *testing.T) {
+	tests := []struct {
+		name string
+		// full fills the recipient's channel first, so the send blocks until the recipient is deregistered
+		full bool
+		// blocked waits for the send to block before deregistering, rather than racing them
+		blocked      bool
+		expectedCode []int
+	}{
+		{
+			name: "Room in channel",
+			// Either handed over before the recipient went, or reported as not registered
+			expectedCode: []int{200, 400},
+		},
+		{
+			name:         "Channel full",
+			full:         true,
+			expectedCode: []int{400},
+		},
+		{
+			name:         "Blocked on full channel",
+			full:         true,
+			blocked:      true,
+			expectedCode: []int{400},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				h := New()
+				h.ClientBufferSize = 1
+				h.SendTimeout = 0
+				h.SeedClients(500)
+				if tt.full {
+					require.Equal(t, 200, sendTo(t, h, "500").Code)
+				}
+
+				sent := make(chan int, 1)
+				deregistered := make(chan int, 1)
+				go func() { sent <- sendTo(t, h, "500").Code }()
+				if tt.blocked {
+					require.Eventually(t, func() bool { return enqueueing(h, 500) }, time.Second, time.Millisecond)
+				}
+				go func() {
+					req, _ := http.NewRequest("GET", "/deregister?id=500", nil)
+					w := httptest.NewRecorder()
+					h.Router.ServeHTTP(w, req)
+					deregistered <- w.Code
+				}()
+
+				select {
+				case code := <-sent:
+					assert.Contains(t, tt.expectedCode, code)
+				case <-time.After(5 * time.Second):
+					t.Fatal("send hung on a deregistered recipient")
+				}
+				assert.Equal(t, 200, <-deregistered)
+
+				_, exists := h.getClient(500)
+				assert.False(t, exists)
+			}
+		})
+	}
+}
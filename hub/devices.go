@@ -0,0 +1,300 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/ratelimit"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// DeliveryPolicy decides which of a client's connected devices receive each message sent to that client
+type DeliveryPolicy int
+
+const (
+	// Fanout delivers every message to all of the client's devices
+	Fanout DeliveryPolicy = iota
+	// RoundRobin delivers each message to a single device, taking turns between them in proportion to their weights,
+	// see deviceWeight
+	RoundRobin
+	// MostRecent delivers every message only to the most recently connected device
+	MostRecent
+)
+
+// deviceConn is what a device's messages are written down, a websocket or an event stream
+type deviceConn interface {
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// device is a single connection belonging to a client
+type device struct {
+	conn  deviceConn
+	token string // Session token issued on connecting, see types.SessionTokenHeader
+	out   chan []byte
+	done  chan struct{}
+	once  sync.Once
+	// stop asks the device's writer to close the connection once it's written what it has, see closeClients
+	stop     chan struct{}
+	stopOnce sync.Once
+	// weight is the device's share of RoundRobin turns, and current its standing in the ongoing round
+	weight  int
+	current int
+}
+
+// close stops the device's writer and closes the underlying connection, safe to call more than once
+func (d *device) close() {
+	d.once.Do(func() {
+		close(d.done)
+		d.conn.Close()
+	})
+}
+
+// session holds every device connected for a single client ID, in the order they connected
+type session struct {
+	devices []*device
+	limiter *ratelimit.Limiter // Enforces the RecipientRate, nil if there isn't one
+	done    chan struct{}
+}
+
+// deviceWeight parses the "weight" query of a connection, its share of RoundRobin turns, which is 1 if it isn't given.
+// It responds with an error and returns false if it isn't a positive number.
+func deviceWeight(c *gin.Context) (int, bool) {
+	if c.Query("weight") == "" {
+		return 1, true
+	}
+	weight, err := strconv.Atoi(c.Query("weight"))
+	if err != nil || weight <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Weight must be a positive number"})
+		return 0, false
+	}
+	return weight, true
+}
+
+// connectDevice adds conn as a device of id with a weight of RoundRobin turns, starting the pump that moves messages
+// from the clients channel to its devices if this is the first device connected
+func (h *Hub) connectDevice(id uint64, conn deviceConn, token string, weight int) *device {
+	d := &device{
+		conn:   conn,
+		token:  token,
+		out:    make(chan []byte),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		weight: weight,
+	}
+
+	h.Lock()
+	h.sessionTokens[token] = id
+	s, exists := h.sessions[id]
+	if !exists {
+		s = &session{limiter: h.newRecipientLimiter(), done: make(chan struct{})}
+		h.sessions[id] = s
+		h.instruments.connected.Inc()
+		go h.pump(id, h.Clients[id], s)
+		go h.flushQueued(id)
+		h.notifyPresence(types.PresenceEvent{Type: types.PresenceJoin, ID: id})
+	}
+	s.devices = append(s.devices, d)
+	h.lastSeen[id] = h.Clock.Now()
+	h.Unlock()
+	h.instruments.opened.Inc()
+
+	go h.writeDevice(id, d)
+
+	return d
+}
+
+// disconnectDevice removes d from id's session, unregistering the client once its last device is gone.
+// reason is why the connection closed, counted for /metrics the first time the device is removed.
+func (h *Hub) disconnectDevice(id uint64, d *device, reason string) {
+	d.close()
+
+	h.Lock()
+	defer h.Unlock()
+	h.disconnectDeviceLocked(id, d, reason)
+}
+
+// disconnectDeviceLocked is disconnectDevice for callers already holding the lock, once d is closed
+func (h *Hub) disconnectDeviceLocked(id uint64, d *device, reason string) {
+	delete(h.sessionTokens, d.token)
+
+	s, exists := h.sessions[id]
+	if !exists {
+		return
+	}
+
+	for i, existing := range s.devices {
+		if existing == d {
+			s.devices = append(s.devices[:i], s.devices[i+1:]...)
+			h.releaseConnectionLocked(id)
+			h.instruments.closed.Inc(reason)
+			break
+		}
+	}
+
+	if len(s.devices) == 0 {
+		close(s.done)
+		delete(h.sessions, id)
+		h.instruments.connected.Dec()
+		h.forgetClientLocked(id)
+		h.notifyPresence(types.PresenceEvent{Type: types.PresenceLeave, ID: id})
+	}
+}
+
+// forgetClientLocked unregisters id, dropping everything held about it, with the lock held
+func (h *Hub) forgetClientLocked(id uint64) {
+	if gone, exists := h.departures[h.Clients[id]]; exists {
+		close(gone)
+		delete(h.departures, h.Clients[id])
+	}
+	// Only the client that registered with the token can inherit what's kept for it
+	if issued, exists := h.tokens[id]; exists && h.keptLocked(id) {
+		h.formerTokens[id] = issued.value
+	}
+	delete(h.Clients, id)
+	delete(h.publicKeys, id)
+	delete(h.names, id)
+	delete(h.lastSeen, id)
+	if h.ResendBufferSize <= 0 {
+		delete(h.sequencers, id)
+	}
+	delete(h.encodings, id)
+	delete(h.envelopeVersions, id)
+	delete(h.filters, id)
+	delete(h.muted, id)
+	delete(h.maxDataSizes, id)
+	delete(h.buffered, id)
+	delete(h.tokens, id)
+	delete(h.senderLimits, id)
+}
+
+// writeDevice writes everything handed to the device down its websocket until it's closed or stopped
+func (h *Hub) writeDevice(id uint64, d *device) {
+	for {
+		select {
+		case msg := <-d.out:
+			if err := d.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				h.Log.Errorf("Error writing message: %v client=%d size=%d", err, id, len(msg))
+				h.disconnectDevice(id, d, closeWriteError)
+				return
+			}
+		case <-d.stop:
+			// Websockets are told why they're closing, event streams just end
+			if ws, ok := d.conn.(*websocket.Conn); ok {
+				closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "Hub is shutting down")
+				ws.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+			}
+			h.disconnectDevice(id, d, closeShutdown)
+			return
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// pump takes messages from a clients channel and hands them to its devices per the DeliveryPolicy.
+// With a QueueSize or QueueBytes, messages are taken as soon as they arrive and held in a queue until the devices are ready for them.
+func (h *Hub) pump(id uint64, ch chan []byte, s *session) {
+	if h.QueueSize <= 0 && h.QueueBytes <= 0 {
+		for {
+			select {
+			case msg := <-ch:
+				h.deliver(id, s, msg)
+				h.countBuffered(id, -1)
+			case <-s.done:
+				return
+			}
+		}
+	}
+
+	q := newFrameQueue(h.QueueSize, h.QueueBytes, h.OverflowPolicy)
+	go func() {
+		for {
+			msg, ok := q.pop(s.done)
+			if !ok {
+				return
+			}
+			h.deliver(id, s, msg)
+			h.countBuffered(id, -1)
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-ch:
+			var priority struct{ Priority int }
+			if err := json.Unmarshal(msg, &priority); err != nil {
+				h.Log.Errorf("Unable to read priority of message: %v client=%d size=%d", err, id, len(msg))
+			}
+
+			evicted, pushed := q.push(queuedFrame{msg, priority.Priority}, s.done)
+			if !pushed {
+				return
+			}
+			for _, f := range evicted {
+				h.Log.Infof("Queue is full, dropped a message client=%d size=%d priority=%d", id, len(f.frame), f.priority)
+				h.counters.failed()
+				h.evicted(id, len(f.frame))
+				h.countBuffered(id, -1)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// deliver hands msg to the devices of a session picked by the DeliveryPolicy, once the ThrottlePolicy allows.
+// It's dropped if it expired while it waited, e.g. in the channel of a client that was offline.
+func (h *Hub) deliver(id uint64, s *session, msg []byte) {
+	if h.expired(id, msg) {
+		return
+	}
+	if !h.throttle(id, s) {
+		return
+	}
+
+	for _, d := range h.pickDevices(s) {
+		select {
+		case d.out <- msg:
+		case <-d.done:
+			h.Log.Debugf("Device disconnected before message could be written client=%d size=%d", id, len(msg))
+			h.counters.failed()
+		}
+	}
+}
+
+// pickDevices returns the devices of a session that should receive the next message
+func (h *Hub) pickDevices(s *session) []*device {
+	h.Lock()
+	defer h.Unlock()
+
+	if len(s.devices) == 0 {
+		return nil
+	}
+
+	switch h.DeliveryPolicy {
+	case RoundRobin:
+		// Smooth weighted round robin, each device gains its weight every message and the one furthest ahead pays the
+		// total back, so turns interleave rather than coming in runs
+		var picked *device
+		total := 0
+		for _, d := range s.devices {
+			d.current += d.weight
+			total += d.weight
+			if picked == nil || d.current > picked.current {
+				picked = d
+			}
+		}
+		picked.current -= total
+		return []*device{picked}
+	case MostRecent:
+		return []*device{s.devices[len(s.devices)-1]}
+	default:
+		return append([]*device(nil), s.devices...)
+	}
+}
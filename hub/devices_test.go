@@ -0,0 +1,136 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectedDevices returns how many devices the hub currently has connected for id
+func connectedDevices(h *Hub, id uint64) int {
+	h.Lock()
+	defer h.Unlock()
+	if s, exists := h.sessions[id]; exists {
+		return len(s.devices)
+	}
+	return 0
+}
+
+// countFrames reads from conn until nothing arrives for a second, returning how many frames were read
+func countFrames(conn *websocket.Conn) int {
+	count := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+func TestHub_deviceWeightInvalid(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	for _, weight := range []string{"0", "-1", "heavy"} {
+		req, err := http.NewRequest("GET", "/ws?id=500&weight="+weight, nil)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		var errorBody gin.H
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+		assert.Equal(t, gin.H{"status": "Bad Request", "message": "Weight must be a positive number"}, errorBody)
+	}
+}
+
+func TestHub_DeliveryPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         DeliveryPolicy
+		firstWeight    string
+		messages       int
+		expectedFirst  int
+		expectedSecond int
+	}{
+		{
+			name:           "Fanout",
+			policy:         Fanout,
+			messages:       4,
+			expectedFirst:  4,
+			expectedSecond: 4,
+		},
+		{
+			name:           "RoundRobin",
+			policy:         RoundRobin,
+			messages:       4,
+			expectedFirst:  2,
+			expectedSecond: 2,
+		},
+		{
+			name:           "Weighted RoundRobin",
+			policy:         RoundRobin,
+			firstWeight:    "3",
+			messages:       8,
+			expectedFirst:  6,
+			expectedSecond: 2,
+		},
+		{
+			name:           "MostRecent",
+			policy:         MostRecent,
+			messages:       4,
+			expectedFirst:  0,
+			expectedSecond: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.DeliveryPolicy = tt.policy
+			h.SeedClients(500)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			address := fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://"))
+
+			// Connect one at a time so which device is the most recent is known
+			firstAddress := address
+			if tt.firstWeight != "" {
+				firstAddress += "&weight=" + tt.firstWeight
+			}
+			first, _, err := websocket.DefaultDialer.Dial(firstAddress, nil)
+			require.NoError(t, err)
+			defer first.Close()
+			require.Eventually(t, func() bool { return connectedDevices(h, 500) == 1 }, time.Second, 10*time.Millisecond)
+
+			second, _, err := websocket.DefaultDialer.Dial(address, nil)
+			require.NoError(t, err)
+			defer second.Close()
+			require.Eventually(t, func() bool { return connectedDevices(h, 500) == 2 }, time.Second, 10*time.Millisecond)
+
+			for i := 0; i < tt.messages; i++ {
+				req, err := http.NewRequest("POST", "/send?ids=500", bytes.NewBufferString("Hi"))
+				require.NoError(t, err)
+
+				w := httptest.NewRecorder()
+				h.Router.ServeHTTP(w, req)
+				require.Equal(t, 200, w.Code)
+			}
+
+			assert.Equal(t, tt.expectedFirst, countFrames(first))
+			assert.Equal(t, tt.expectedSecond, countFrames(second))
+		})
+	}
+}
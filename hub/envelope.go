@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validEnvelopeVersion checks the optional "envelope" query is a positive version, responding with an error and
+// returning false if not
+func validEnvelopeVersion(c *gin.Context) bool {
+	if c.Query("envelope") == "" {
+		return true
+	}
+
+	if version, err := strconv.Atoi(c.Query("envelope")); err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Envelope version must be a positive integer"})
+		return false
+	}
+	return true
+}
+
+// storeEnvelopeVersion records the newest types.Envelope version id reads, if it gave one
+func (h *Hub) storeEnvelopeVersion(id uint64, version string) {
+	if version == "" {
+		return
+	}
+
+	v, _ := strconv.Atoi(version)
+	h.Lock()
+	h.envelopeVersions[id] = v
+	h.Unlock()
+}
+
+// envelopeVersion returns the newest types.Envelope version the client registered as reading, for peers to negotiate
+// the version they send it. Envelopes are relayed like any other message whatever their version, so this is 0 for
+// clients that didn't say, which read plain messages only.
+func (h *Hub) envelopeVersion(c *gin.Context) {
+	if c.Query("id") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID is required"})
+		return
+	}
+
+	parsedID, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return
+	}
+
+	h.Lock()
+	_, registered := h.Clients[parsedID]
+	version := h.envelopeVersions[parsedID]
+	h.Unlock()
+
+	if !registered {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return
+	}
+
+	c.JSON(http.StatusOK, version)
+}
@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_envelopeVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		register        string
+		lookup          string
+		expectedCode    int
+		expectedError   gin.H
+		expectedVersion int
+	}{
+		{
+			name:            "Golden Path",
+			register:        "/register?id=500&envelope=2",
+			lookup:          "/envelope?id=500",
+			expectedCode:    200,
+			expectedVersion: 2,
+		},
+		{
+			name:         "Plain client",
+			register:     "/register?id=500",
+			lookup:       "/envelope?id=500",
+			expectedCode: 200,
+		},
+		{
+			name:          "Invalid version",
+			register:      "/register?id=500&envelope=zero",
+			expectedCode:  400,
+			expectedError: gin.H{"message": "Envelope version must be a positive integer", "status": "Bad Request"},
+		},
+		{
+			name:          "ID not registered",
+			register:      "/register?id=500&envelope=1",
+			lookup:        "/envelope?id=600",
+			expectedCode:  400,
+			expectedError: gin.H{"message": "ID not registered", "status": "Bad Request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+
+			req, err := http.NewRequest("GET", tt.register, nil)
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			if tt.lookup != "" {
+				require.Equal(t, 200, w.Code)
+				req, err = http.NewRequest("GET", tt.lookup, nil)
+				require.NoError(t, err)
+				w = httptest.NewRecorder()
+				h.Router.ServeHTTP(w, req)
+			}
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var version int
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&version))
+			assert.Equal(t, tt.expectedVersion, version)
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+var errExpired = errors.New("message expired before it was delivered")
+
+// pastExpiry reports whether msg is past its ExpiresAt
+func (h *Hub) pastExpiry(msg types.SendingMessage) bool {
+	return !msg.ExpiresAt.IsZero() && !h.Clock.Now().Before(msg.ExpiresAt)
+}
+
+// expired reports whether the framed message, about to be delivered to id, is past its ExpiresAt, counting it if so.
+// Having been accepted for id, it's dead lettered.
+func (h *Hub) expired(id uint64, frame []byte) bool {
+	var msg types.SendingMessage
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		h.Log.Errorf("Unable to read expiry of message: %v client=%d size=%d", err, id, len(frame))
+		return false
+	}
+	if !h.pastExpiry(msg) {
+		return false
+	}
+
+	h.Log.Infof("Dropping message that expired before it was delivered client=%d size=%d expires_at=%s", id, len(frame), msg.ExpiresAt.Format(time.RFC3339Nano))
+	h.counters.expire()
+	h.keepDeadLetter(id, msg, 0, errExpired.Error())
+	return true
+}
@@ -0,0 +1,144 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_ExpiresAt(t *testing.T) {
+	tests := []struct {
+		name            string
+		ttl             string
+		poll            bool
+		expectedData    []string
+		expectedExpired uint64
+	}{
+		{
+			name:            "Expired while offline",
+			ttl:             "50ms",
+			expectedData:    []string{"fresh"},
+			expectedExpired: 1,
+		},
+		{
+			name:         "Delivered in time",
+			ttl:          "1m",
+			expectedData: []string{"stale", "fresh"},
+		},
+		{
+			name:            "Expired before it was polled",
+			ttl:             "50ms",
+			poll:            true,
+			expectedData:    []string{"fresh"},
+			expectedExpired: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500)
+
+			send := func(query, data string) {
+				req, err := http.NewRequest("POST", "/send?ids=500"+query, strings.NewReader(data))
+				require.NoError(t, err)
+				w := httptest.NewRecorder()
+				h.Router.ServeHTTP(w, req)
+				require.Equal(t, 200, w.Code)
+			}
+
+			// Sent while the client is offline, so it waits in the client's channel
+			send("&ttl="+tt.ttl, "stale")
+			time.Sleep(100 * time.Millisecond)
+			send("", "fresh")
+
+			var data []string
+			if tt.poll {
+				req, err := http.NewRequest("GET", "/poll?id=500&wait=10ms", nil)
+				require.NoError(t, err)
+				w := httptest.NewRecorder()
+				h.Router.ServeHTTP(w, req)
+				require.Equal(t, 200, w.Code)
+
+				var resp types.PollResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				for _, raw := range resp.Messages {
+					var msg types.SendingMessage
+					require.NoError(t, json.Unmarshal(raw, &msg))
+					data = append(data, string(msg.Data))
+				}
+			} else {
+				serv := httptest.NewServer(h.Router)
+				defer serv.Close()
+				conn := dialAs(t, serv, 500)
+				defer conn.Close()
+
+				for range tt.expectedData {
+					require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+					var msg types.SendingMessage
+					require.NoError(t, conn.ReadJSON(&msg))
+					data = append(data, string(msg.Data))
+				}
+			}
+
+			assert.Equal(t, tt.expectedData, data)
+			assert.Equal(t, tt.expectedExpired, getStats(t, h).Expired)
+			// Expired messages are dead lettered rather than counted as failures too
+			assert.Len(t, h.deadLetterLog, int(tt.expectedExpired))
+			assert.Zero(t, getStats(t, h).Failures)
+		})
+	}
+}
+
+func TestHub_ExpiresAtBeforeHandOver(t *testing.T) {
+	h := New()
+	h.SeedClients(500, 600)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	sender := dialAs(t, serv, 500)
+	defer sender.Close()
+
+	writeFrame(t, sender, types.SendingMessage{Recipients: "600", Data: []byte("data"), MessageID: "m1", ExpiresAt: time.Now().Add(-time.Second)})
+
+	// Told it expired rather than acked
+	var msg types.SendingMessage
+	require.NoError(t, sender.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, sender.ReadJSON(&msg))
+	assert.True(t, msg.System)
+	assert.Equal(t, "Message to 600 expired before it was delivered", string(msg.Data))
+
+	stats := getStats(t, h)
+	assert.Zero(t, stats.MessagesRelayed)
+	assert.Equal(t, uint64(1), stats.Expired)
+	ch, _ := h.getClient(600)
+	assert.Empty(t, ch)
+}
+
+func TestHub_ExpiresAtInvalid(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	for ttl, expected := range map[string]string{
+		"-1s":   "TTL must be positive",
+		"never": `time: invalid duration "never"`,
+	} {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=500&ttl=%s", ttl), strings.NewReader("data"))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		var errorBody gin.H
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+		assert.Equal(t, gin.H{"status": "Bad Request", "message": expected}, errorBody)
+	}
+}
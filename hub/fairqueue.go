@@ -0,0 +1,102 @@
+package hub
+
+import "sync"
+
+// fairTurns takes turns at a recipient's sequencer by weighted fair queueing, see Hub.FairQueueing.
+// Each turn a sender asks for is tagged with a virtual finish time 1/weight after their previous one (or now, if later),
+// and the waiting turn with the earliest finish goes next. A sender with many waiting turns has them spread out,
+// so a sender asking for its first turn in a while goes ahead of most of them.
+type fairTurns struct {
+	mu      sync.Mutex
+	busy    bool
+	virtual float64            // Finish time of the turn being taken
+	finish  map[uint64]float64 // Finish time of each sender's latest turn
+	waiting []*fairTurn        // In the order they were asked for, so ties go to the earliest
+}
+
+// fairTurn is a sender's turn waiting to be taken
+type fairTurn struct {
+	finish float64
+	ready  chan struct{} // Closed once it's the turn's go
+}
+
+func newFairTurns() *fairTurns {
+	return &fairTurns{finish: make(map[uint64]float64)}
+}
+
+// acquire waits for sender's turn, returning false if abort closes first
+func (f *fairTurns) acquire(sender uint64, weight int, abort <-chan struct{}) bool {
+	if weight < 1 {
+		weight = 1
+	}
+
+	f.mu.Lock()
+	start := f.virtual
+	if f.finish[sender] > start {
+		start = f.finish[sender]
+	}
+	t := &fairTurn{finish: start + 1/float64(weight), ready: make(chan struct{})}
+	f.finish[sender] = t.finish
+
+	if !f.busy {
+		f.busy = true
+		f.virtual = t.finish
+		f.mu.Unlock()
+		return true
+	}
+	f.waiting = append(f.waiting, t)
+	f.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return true
+	case <-abort:
+	}
+
+	f.mu.Lock()
+	for i, waiting := range f.waiting {
+		if waiting == t {
+			f.waiting = append(f.waiting[:i], f.waiting[i+1:]...)
+			f.mu.Unlock()
+			return false
+		}
+	}
+	f.mu.Unlock()
+
+	// It was given the turn as it gave up, so pass it on
+	f.release()
+	return false
+}
+
+// release ends the current turn, handing the next to the waiting turn with the earliest finish
+func (f *fairTurns) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.waiting) == 0 {
+		// Idle, so nobody is owed anything and the history can be forgotten
+		f.busy = false
+		f.virtual = 0
+		f.finish = make(map[uint64]float64)
+		return
+	}
+
+	next := 0
+	for i, t := range f.waiting {
+		if t.finish < f.waiting[next].finish {
+			next = i
+		}
+	}
+	t := f.waiting[next]
+	f.waiting = append(f.waiting[:next], f.waiting[next+1:]...)
+	f.virtual = t.finish
+	close(t.ready)
+}
+
+// senderWeight is the share of a recipient's deliveries sender is given under FairQueueing
+func (h *Hub) senderWeight(sender uint64) int {
+	if h.SenderWeight == nil {
+		return 1
+	}
+	return h.SenderWeight(sender)
+}
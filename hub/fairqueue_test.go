@@ -0,0 +1,148 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairTurns(t *testing.T) {
+	f := newFairTurns()
+	require.True(t, f.acquire(1, 1, nil))
+
+	var mu sync.Mutex
+	var order []uint64
+	var wg sync.WaitGroup
+	queue := func(sender uint64, weight int) {
+		waiting := len(f.waiting)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.True(t, f.acquire(sender, weight, nil))
+			mu.Lock()
+			order = append(order, sender)
+			mu.Unlock()
+			f.release()
+		}()
+		require.Eventually(t, func() bool {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return len(f.waiting) == waiting+1
+		}, time.Second, time.Millisecond)
+	}
+
+	// Sender 1 queues up a backlog before 2 and 3 ask for a turn each, 3 with double the weight
+	for i := 0; i < 6; i++ {
+		queue(1, 1)
+	}
+	queue(2, 1)
+	queue(3, 2)
+
+	f.release()
+	wg.Wait()
+	assert.Equal(t, []uint64{3, 1, 2, 1, 1, 1, 1, 1}, order)
+
+	// Once idle the history is forgotten
+	assert.False(t, f.busy)
+	assert.Empty(t, f.finish)
+}
+
+func TestHub_FairQueueing(t *testing.T) {
+	const (
+		floodSenders = 30
+		floodCount   = 5
+	)
+	quiet := []uint64{700, 701, 702}
+
+	h := New()
+	h.FairQueueing = true
+	// Slow the recipient down so senders queue up behind it
+	h.RecipientRate = 200
+	h.RecipientBurst = 1
+	h.ClientBufferSize = 0
+	h.SeedClients(append([]uint64{500, 600}, quiet...)...)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	recipient, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+	require.NoError(t, err)
+	defer recipient.Close()
+
+	send := func(from uint64) {
+		resp, err := http.Post(fmt.Sprintf("%s/send?ids=500&from=%d", serv.URL, from), "application/octet-stream", strings.NewReader("Hi"))
+		if err != nil {
+			t.Errorf("failed to send: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	// 600 floods the recipient from many requests at once
+	var wg sync.WaitGroup
+	for i := 0; i < floodSenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < floodCount; j++ {
+				send(600)
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	senders := make(chan uint64, floodSenders*floodCount+len(quiet))
+	go func() {
+		for {
+			_, b, err := recipient.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg types.SendingMessage
+			if err := json.Unmarshal(b, &msg); err != nil {
+				t.Errorf("failed to unmarshal message: %v", err)
+				return
+			}
+			senders <- msg.Sender
+		}
+	}()
+
+	// Let the flood back up, then the quiet senders send one message each
+	time.Sleep(100 * time.Millisecond)
+	for len(senders) > 0 {
+		<-senders
+	}
+	for _, id := range quiet {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			send(id)
+		}(id)
+	}
+
+	// Count how many messages arrive from then on until the quiet senders' have all arrived
+	received := 0
+	waitingFor := map[uint64]bool{700: true, 701: true, 702: true}
+	for len(waitingFor) > 0 {
+		select {
+		case sender := <-senders:
+			received++
+			delete(waitingFor, sender)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("quiet senders' messages never arrived, still waiting on %v", waitingFor)
+		}
+	}
+
+	// Served first come first served they'd wait behind the whole backlog of 600's requests, roughly floodSenders
+	assert.Less(t, received, floodSenders/2, "quiet senders were starved")
+}
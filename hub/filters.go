@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// setFilter replaces the filter of the client holding the session token with the one in the body, returning it
+func (h *Hub) setFilter(c *gin.Context) {
+	id, ok := h.sessionClient(c)
+	if !ok {
+		return
+	}
+
+	var filter types.Filter
+	if err := c.ShouldBindJSON(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse filter"})
+		return
+	}
+
+	h.Lock()
+	h.filters[id] = filter
+	h.Unlock()
+
+	c.JSON(http.StatusOK, filter)
+}
+
+// clearFilter removes the filter of the client holding the session token, so it's delivered everything again
+func (h *Hub) clearFilter(c *gin.Context) {
+	id, ok := h.sessionClient(c)
+	if !ok {
+		return
+	}
+
+	h.Lock()
+	delete(h.filters, id)
+	h.Unlock()
+
+	c.JSON(http.StatusOK, types.Filter{})
+}
+
+// filtered reports whether msg should be dropped rather than delivered to id, because they're muted,
+// it's larger than their MaxDataSize, or it doesn't match their filter. Messages from the hub itself and acks always get through.
+func (h *Hub) filtered(id uint64, msg types.SendingMessage) bool {
+	if msg.System || msg.Ack {
+		return false
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if _, muted := h.muted[id]; muted {
+		return true
+	}
+	if max, exists := h.maxDataSizes[id]; exists && len(msg.Data) > max {
+		return true
+	}
+	filter, exists := h.filters[id]
+	return exists && !filter.Matches(msg)
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_setFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		token            string
+		body             string
+		expectedCode     int
+		expectedResponse interface{}
+		expectedFilter   *types.Filter
+	}{
+		{
+			name:             "Missing token",
+			body:             `{"Metadata":{"type":"alert"}}`,
+			expectedCode:     401,
+			expectedResponse: gin.H{"status": "Unauthorized", "message": "Session token required"},
+		},
+		{
+			name:             "Unknown token",
+			token:            "unknown",
+			body:             `{"Metadata":{"type":"alert"}}`,
+			expectedCode:     403,
+			expectedResponse: gin.H{"status": "Forbidden", "message": "Session token not recognised"},
+		},
+		{
+			name:             "Invalid body",
+			token:            "token",
+			body:             `{"Metadata":`,
+			expectedCode:     400,
+			expectedResponse: gin.H{"status": "Bad Request", "message": "Unable to parse filter"},
+		},
+		{
+			name:             "Valid",
+			token:            "token",
+			body:             `{"Metadata":{"type":"alert"}}`,
+			expectedCode:     200,
+			expectedResponse: gin.H{"Metadata": map[string]interface{}{"type": "alert"}},
+			expectedFilter:   &types.Filter{Metadata: map[string]string{"type": "alert"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.sessionTokens["token"] = 500
+
+			req, err := http.NewRequest("PUT", "/filter", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			var resp gin.H
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, tt.expectedResponse, resp)
+
+			filter, exists := h.filters[500]
+			if tt.expectedFilter == nil {
+				assert.False(t, exists)
+				return
+			}
+			assert.Equal(t, *tt.expectedFilter, filter)
+		})
+	}
+}
+
+func TestHub_filtered(t *testing.T) {
+	h := New()
+	h.filters[500] = types.Filter{Metadata: map[string]string{"type": "alert"}}
+
+	alert := types.SendingMessage{Data: []byte("alert"), Metadata: map[string]string{"type": "alert", "trace-id": "1"}}
+	chat := types.SendingMessage{Data: []byte("chat"), Metadata: map[string]string{"type": "chat"}}
+
+	assert.False(t, h.filtered(500, alert))
+	assert.True(t, h.filtered(500, chat))
+	assert.True(t, h.filtered(500, types.SendingMessage{Data: []byte("none")}))
+
+	// Hub messages always get through
+	assert.False(t, h.filtered(500, types.SendingMessage{Data: []byte("shutting down"), System: true}))
+	// As does everything for clients without a filter
+	assert.False(t, h.filtered(501, chat))
+}
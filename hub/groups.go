@@ -0,0 +1,254 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// groupMember parses the "name" and "id" queries used by the group endpoints, checking the id is registered.
+// It responds with an error and returns false if either is invalid.
+func (h *Hub) groupMember(c *gin.Context) (string, uint64, bool) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Group name is required"})
+		return "", 0, false
+	}
+
+	if c.Query("id") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID is required"})
+		return "", 0, false
+	}
+
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return "", 0, false
+	}
+
+	if _, exists := h.getClient(id); !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return "", 0, false
+	}
+
+	return name, id, true
+}
+
+// members returns the sorted IDs in a group, the lock must be held
+func (h *Hub) members(name string) types.ListResponse {
+	var resp types.ListResponse
+	for id := range h.Groups[name] {
+		resp.IDs = append(resp.IDs, id)
+	}
+	sort.Slice(resp.IDs, func(i, j int) bool { return resp.IDs[i] < resp.IDs[j] })
+	return resp
+}
+
+// joinGroup adds the client to the named group, creating it if it doesn't exist yet as long as MaxGroups isn't reached.
+// Returns the members of the group after joining.
+func (h *Hub) joinGroup(c *gin.Context) {
+	name, id, ok := h.groupMember(c)
+	if !ok {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.joinGroupLocked(c, name, id) {
+		return
+	}
+
+	c.JSON(http.StatusOK, h.members(name))
+}
+
+// joinGroupLocked adds id to the named group, creating it if it doesn't exist yet as long as MaxGroups isn't reached.
+// It responds with an error and returns false if the group can't be created. The lock must be held.
+func (h *Hub) joinGroupLocked(c *gin.Context, name string, id uint64) bool {
+	group, exists := h.Groups[name]
+	if !exists {
+		if h.MaxGroups > 0 && len(h.Groups) >= h.MaxGroups {
+			c.JSON(http.StatusTooManyRequests, gin.H{"status": "Too Many Requests", "message": "Maximum number of groups reached"})
+			return false
+		}
+		group = make(map[uint64]struct{})
+		h.Groups[name] = group
+	}
+	group[id] = struct{}{}
+	return true
+}
+
+// joinAndAnnounce adds the client to the named group like joinGroup, then announces it to the members already in it:
+// each is sent a system message that the client joined, then the body from the client. Members are taken as the client
+// joins, and each is handed both before the response, so none sees the announcement without or before the join.
+// Returns the members of the group after joining.
+func (h *Hub) joinAndAnnounce(c *gin.Context) {
+	name, id, ok := h.groupMember(c)
+	if !ok {
+		return
+	}
+	// The announcement is sent as the client, so only it can make it
+	if !h.authorized(c, id) {
+		return
+	}
+
+	if c.Request.Body == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Body expected for a sendmessage call"})
+		return
+	}
+	b, err := readLimitedBody(c, h.SendReadTimeout, int64(h.MaxDataSize))
+	if err == errReadTimeout {
+		c.JSON(http.StatusRequestTimeout, gin.H{"status": "Request Timeout", "message": "Timed out reading body"})
+		return
+	}
+	if err == errBodyTooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "Request Entity Too Large", "message": fmt.Sprintf("Maximum data size is %d bytes", h.MaxDataSize)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "No body found"})
+		return
+	}
+
+	// Members stay in groups after disconnecting, only those still registered are announced to
+	h.Lock()
+	var ids []uint64
+	var recipients []string
+	for _, member := range h.members(name).IDs {
+		if _, registered := h.Clients[member]; registered && member != id {
+			ids = append(ids, member)
+			recipients = append(recipients, strconv.FormatUint(member, 10))
+		}
+	}
+	if !h.joinGroupLocked(c, name, id) {
+		h.Unlock()
+		return
+	}
+	resp := h.members(name)
+	h.Unlock()
+
+	joined := types.SendingMessage{
+		Recipients: strings.Join(recipients, ","),
+		Data:       []byte(fmt.Sprintf("%d joined group %s", id, name)),
+		Sender:     id,
+		System:     true,
+		Metadata:   map[string]string{"group": name},
+	}
+	announcement := types.SendingMessage{Recipients: joined.Recipients, Data: b, Sender: id}
+	h.stamp(&joined)
+	h.stamp(&announcement)
+	if !h.relay(c, joined, ids) {
+		return
+	}
+	if !h.relay(c, announcement, ids) {
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// leaveGroup removes the client from the named group, deleting the group once it has no members left.
+// Returns the members of the group after leaving.
+func (h *Hub) leaveGroup(c *gin.Context) {
+	name, id, ok := h.groupMember(c)
+	if !ok {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if _, member := h.Groups[name][id]; !member {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not a member of group"})
+		return
+	}
+
+	delete(h.Groups[name], id)
+	resp := h.members(name)
+	if len(h.Groups[name]) == 0 {
+		delete(h.Groups, name)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// leaveAllGroupsLocked removes the client from every group it's a member of, deleting those left empty, the lock must be held
+func (h *Hub) leaveAllGroupsLocked(id uint64) {
+	for name, group := range h.Groups {
+		delete(group, id)
+		if len(group) == 0 {
+			delete(h.Groups, name)
+		}
+	}
+}
+
+// sendGroup relays the body to every member of the named group. The sender is optional, but when given as "from"
+// it's excluded, so a member posting to its own group doesn't receive its message.
+func (h *Hub) sendGroup(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Group name is required"})
+		return
+	}
+
+	var sender uint64
+	if c.Query("from") != "" {
+		var err error
+		sender, err = strconv.ParseUint(c.Query("from"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return
+		}
+		// Only the sender itself can send as a client registered with a token
+		if !h.authorized(c, sender) {
+			return
+		}
+	}
+	if !h.limitSend(c, sender) {
+		return
+	}
+
+	if c.Request.Body == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Body expected for a sendmessage call"})
+		return
+	}
+	b, err := readLimitedBody(c, h.SendReadTimeout, int64(h.MaxDataSize))
+	if err == errReadTimeout {
+		c.JSON(http.StatusRequestTimeout, gin.H{"status": "Request Timeout", "message": "Timed out reading body"})
+		return
+	}
+	if err == errBodyTooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "Request Entity Too Large", "message": fmt.Sprintf("Maximum data size is %d bytes", h.MaxDataSize)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "No body found"})
+		return
+	}
+
+	// Members stay in groups after disconnecting, only those still registered are sent to
+	h.Lock()
+	_, exists := h.Groups[name]
+	var ids []uint64
+	var recipients []string
+	for _, id := range h.members(name).IDs {
+		if _, registered := h.Clients[id]; registered {
+			ids = append(ids, id)
+			recipients = append(recipients, strconv.FormatUint(id, 10))
+		}
+	}
+	h.Unlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"status": "Not Found", "message": "Group not found"})
+		return
+	}
+
+	msg := types.SendingMessage{Recipients: strings.Join(recipients, ","), Data: b, Sender: sender}
+	h.stamp(&msg)
+	h.relay(c, msg, ids)
+}
@@ -0,0 +1,259 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groupRequest runs a GET against one of the group endpoints, returning the recorded response
+func groupRequest(t *testing.T, h *Hub, action, name, id string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", fmt.Sprintf("/groups/%s?name=%s&id=%s", action, name, id), nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHub_joinGroup(t *testing.T) {
+	tests := []struct {
+		name            string
+		expectedCode    int
+		expectedError   gin.H
+		expectedMembers []uint64
+		group, id       string
+	}{
+		{
+			name:            "Golden Path",
+			expectedCode:    200,
+			group:           "red",
+			id:              "500",
+			expectedMembers: []uint64{500},
+		},
+		{
+			name:          "No name",
+			expectedCode:  400,
+			id:            "500",
+			expectedError: gin.H{"message": "Group name is required", "status": "Bad Request"},
+		},
+		{
+			name:          "No ID",
+			expectedCode:  400,
+			group:         "red",
+			expectedError: gin.H{"message": "ID is required", "status": "Bad Request"},
+		},
+		{
+			name:          "ID not uint64",
+			expectedCode:  400,
+			group:         "red",
+			id:            "notuint64",
+			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
+		},
+		{
+			name:          "ID not registered",
+			expectedCode:  400,
+			group:         "red",
+			id:            "200",
+			expectedError: gin.H{"message": "ID not registered", "status": "Bad Request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500)
+
+			w := groupRequest(t, h, "join", tt.group, tt.id)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var members types.ListResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&members))
+			assert.Equal(t, tt.expectedMembers, members.IDs)
+		})
+	}
+}
+
+func TestHub_MaxGroups(t *testing.T) {
+	h := New()
+	h.MaxGroups = 2
+	h.SeedClients(500, 600)
+
+	// Up to the cap, each join creates a new group
+	require.Equal(t, 200, groupRequest(t, h, "join", "red", "500").Code)
+	require.Equal(t, 200, groupRequest(t, h, "join", "blue", "500").Code)
+
+	// Creating one more is rejected
+	w := groupRequest(t, h, "join", "green", "500")
+	assert.Equal(t, 429, w.Code)
+
+	var errorBody gin.H
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+	assert.Equal(t, gin.H{"message": "Maximum number of groups reached", "status": "Too Many Requests"}, errorBody)
+	assert.Len(t, h.Groups, 2)
+
+	// Joining a group that already exists still works
+	assert.Equal(t, 200, groupRequest(t, h, "join", "red", "600").Code)
+
+	// Once a group is emptied it's deleted, making room for another
+	require.Equal(t, 200, groupRequest(t, h, "leave", "blue", "500").Code)
+	assert.Equal(t, 200, groupRequest(t, h, "join", "green", "500").Code)
+}
+
+func TestHub_groupLifecycle(t *testing.T) {
+	h := New()
+	h.SeedClients(500, 600)
+
+	// Joining creates the group
+	require.Equal(t, 200, groupRequest(t, h, "join", "red", "500").Code)
+	require.Equal(t, 200, groupRequest(t, h, "join", "red", "600").Code)
+	require.Equal(t, 200, groupRequest(t, h, "join", "blue", "500").Code)
+	assert.Len(t, h.Groups, 2)
+
+	// Leaving a group the client isn't in is rejected
+	w := groupRequest(t, h, "leave", "blue", "600")
+	assert.Equal(t, 400, w.Code)
+	var errorBody gin.H
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+	assert.Equal(t, gin.H{"message": "ID not a member of group", "status": "Bad Request"}, errorBody)
+
+	// Deregistering removes the client from all its groups, deleting those it was the last member of
+	req, err := http.NewRequest("GET", "/deregister?id=500", nil)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, map[string]map[uint64]struct{}{"red": {600: {}}}, h.Groups)
+
+	// Leaving as the last member deletes the group
+	w = groupRequest(t, h, "leave", "red", "600")
+	require.Equal(t, 200, w.Code)
+	var members types.ListResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&members))
+	assert.Empty(t, members.IDs)
+	assert.Empty(t, h.Groups)
+}
+
+func TestHub_sendGroup(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		expectedCode  int
+		expectedError gin.H
+		received      map[uint64]bool
+	}{
+		{
+			name:         "Whole group",
+			query:        "name=red",
+			expectedCode: 200,
+			received:     map[uint64]bool{500: true, 600: true, 700: true},
+		},
+		{
+			name:         "Except the sender",
+			query:        "name=red&from=500",
+			expectedCode: 200,
+			received:     map[uint64]bool{600: true, 700: true},
+		},
+		{
+			name:          "No name",
+			query:         "from=500",
+			expectedCode:  400,
+			expectedError: gin.H{"message": "Group name is required", "status": "Bad Request"},
+		},
+		{
+			name:          "Unknown group",
+			query:         "name=blue",
+			expectedCode:  404,
+			expectedError: gin.H{"message": "Group not found", "status": "Not Found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			// 800 joined then disconnected, so is skipped
+			h.SeedClients(800)
+			groupRequest(t, h, "join", "red", "800")
+			h.Lock()
+			delete(h.Clients, 800)
+			h.Unlock()
+
+			h.Clients[500] = make(chan []byte, 1)
+			h.Clients[600] = make(chan []byte, 1)
+			h.Clients[700] = make(chan []byte, 1)
+			for _, id := range []string{"500", "600", "700"} {
+				require.Equal(t, 200, groupRequest(t, h, "join", "red", id).Code)
+			}
+
+			req, err := http.NewRequest("POST", "/groups/send?"+tt.query, bytes.NewBufferString("Hi"))
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+
+			for _, id := range []uint64{500, 600, 700} {
+				assert.Equal(t, tt.received[id], len(h.Clients[id]) == 1, "client %d", id)
+			}
+		})
+	}
+}
+
+func TestHub_groupsKeptForOwner(t *testing.T) {
+	tests := []struct {
+		name           string
+		presentToken   bool
+		expectedGroups map[string]map[uint64]struct{}
+	}{
+		{
+			name:           "Same owner",
+			presentToken:   true,
+			expectedGroups: map[string]map[uint64]struct{}{"red": {500: {}}},
+		},
+		{
+			name:           "New owner",
+			expectedGroups: map[string]map[uint64]struct{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			token := registerWithToken(t, h, 500)
+
+			// Members stay in groups once their connection drops, until the ID is taken by someone else
+			h.Lock()
+			h.Groups["red"] = map[uint64]struct{}{500: {}}
+			h.forgetClientLocked(500)
+			h.Unlock()
+
+			if !tt.presentToken {
+				token = ""
+			}
+			reregister(t, h, 500, token)
+
+			h.Lock()
+			defer h.Unlock()
+			assert.Equal(t, tt.expectedGroups, h.Groups)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package hub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriter compresses everything a handler writes to the response
+type gzipWriter struct {
+	gin.ResponseWriter
+	zw *gzip.Writer
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	return g.zw.Write(b)
+}
+
+func (g *gzipWriter) WriteString(s string) (int, error) {
+	return g.zw.Write([]byte(s))
+}
+
+// WriteHeader drops any Content-Length the handler set, as it's the length before compressing
+func (g *gzipWriter) WriteHeader(code int) {
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends on what's been compressed so far, for handlers that stream their response
+func (g *gzipWriter) Flush() {
+	g.zw.Flush()
+	g.ResponseWriter.Flush()
+}
+
+// compressed is middleware gzipping the response for clients that send Accept-Encoding: gzip
+func compressed(c *gin.Context) {
+	if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Next()
+		return
+	}
+
+	c.Header("Content-Encoding", "gzip")
+	c.Header("Vary", "Accept-Encoding")
+
+	zw := gzip.NewWriter(c.Writer)
+	defer zw.Close()
+	c.Writer = &gzipWriter{ResponseWriter: c.Writer, zw: zw}
+
+	c.Next()
+}
+
+// errDecompressedTooLarge is returned by gunzip for data that decompresses to more than its limit
+var errDecompressedTooLarge = errors.New("decompressed data is too large")
+
+// gunzip decompresses a gzip encoded body, giving up with errDecompressedTooLarge once it's more than limit bytes so a
+// small body can't decompress into an unbounded one. 0 means no limit.
+func gunzip(b []byte, limit int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	if limit <= 0 {
+		return ioutil.ReadAll(zr)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errDecompressedTooLarge
+	}
+	return data, nil
+}
+
+// gzipData compresses b with gzip
+func gzipData(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,29 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// validHandshake checks the ProtocolToken a client dialing /ws presented in types.ProtocolTokenHeader, responding with
+// an error and returning false if it's missing or wrong. Any client can connect while no ProtocolToken is set.
+func (h *Hub) validHandshake(c *gin.Context) bool {
+	if h.ProtocolToken == "" {
+		return true
+	}
+
+	token := c.GetHeader(types.ProtocolTokenHeader)
+	if token == "" {
+		c.JSON(http.StatusUpgradeRequired, gin.H{"status": "Upgrade Required", "message": "Protocol token required"})
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.ProtocolToken)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Invalid protocol token"})
+		return false
+	}
+	return true
+}
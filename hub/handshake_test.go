@@ -0,0 +1,71 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_ProtocolToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		protocolToken string
+		token         string
+		expectedCode  int
+	}{
+		{
+			name:         "No protocol token required",
+			expectedCode: http.StatusSwitchingProtocols,
+		},
+		{
+			name:          "Valid token",
+			protocolToken: "v1-secret",
+			token:         "v1-secret",
+			expectedCode:  http.StatusSwitchingProtocols,
+		},
+		{
+			name:          "Missing token",
+			protocolToken: "v1-secret",
+			expectedCode:  http.StatusUpgradeRequired,
+		},
+		{
+			name:          "Wrong token",
+			protocolToken: "v1-secret",
+			token:         "v0-secret",
+			expectedCode:  http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.ProtocolToken = tt.protocolToken
+			h.SeedClients(500)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set(types.ProtocolTokenHeader, tt.token)
+			}
+
+			conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), header)
+			require.NotNil(t, resp)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.expectedCode != http.StatusSwitchingProtocols {
+				assert.Equal(t, websocket.ErrBadHandshake, err)
+				return
+			}
+			require.NoError(t, err)
+			conn.Close()
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package hub
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -16,74 +19,515 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var maxAttempts = 5 // If somehow the uint64 is taken try this many times
+var (
+	maxAttempts             = 5                        // If somehow the uint64 is taken try this many times
+	announceTimeout         = 5 * time.Second          // How long Announce waits on each client before giving up on it
+	defaultSendReadTimeout  = 10 * time.Second         // How long /send waits for the whole body to arrive
+	defaultSendTimeout      = 5 * time.Second          // How long /send waits on each recipient to accept the message
+	maxQueryLength          = 8 * 1024                 // Longest query string /send accepts, enough for 255 IDs
+	defaultMaxRecipients    = 255                      // Most clients a single send can be addressed to
+	defaultMaxDataSize      = 1024000                  // Largest Data a single send can carry, as clients limit it to
+	defaultMaxMessageSize   = 100 * defaultMaxDataSize // Largest message clients split into chunks, as they limit it to
+	defaultClientBufferSize = 64                       // How many messages each client's channel holds before the OverflowPolicy applies
+	envelopeOverhead        = 64 * 1024                // Room in a JSON message for everything besides its Data and recipients
+
+	errReadTimeout  = errors.New("timed out reading body")
+	errBodyTooLarge = errors.New("body too large")
+)
 
 // Hub struct represents a Hub, with both the Gin router and client map
 type Hub struct {
 	sync.Mutex
 	Router  *gin.Engine
 	Clients map[uint64]chan []byte
+	Groups  map[string]map[uint64]struct{}
+
+	// IDGenerator provides the IDs handed out by register when the client doesn't request one
+	IDGenerator IDGenerator
+	// IDValidator, when set, enforces rules on the IDs register hands out, e.g. a reserved range or an external directory.
+	// Requested IDs it returns an error for are refused with the error's message, and generated ones are generated again.
+	IDValidator func(uint64) error
+	// RecipientResolver turns the recipients of a message into the IDs it's delivered to
+	RecipientResolver RecipientResolver
+	// DeliveryPolicy picks which devices receive a message when a client has several connected
+	DeliveryPolicy DeliveryPolicy
+	// SendReadTimeout bounds how long /send spends reading its body, so slow uploads can't tie up the handler
+	SendReadTimeout time.Duration
+	// MaxRecipients is the most clients a single message can be addressed to, whether it's sent on /send or the websocket.
+	// 0 means no limit.
+	MaxRecipients int
+	// MaxDataSize is the largest Data, in bytes, a single message can carry, whether it's sent on /send or the websocket.
+	// 0 means no limit.
+	MaxDataSize int
+	// MaxMessageSize is the largest message a sender can split into chunks of MaxDataSize, bounding how many chunks a
+	// message can claim to have so recipients don't set aside room for more than that. 0 means no limit
+	MaxMessageSize int
+	// SendTimeout bounds how long /send waits on each recipient to accept the message, a recipient that isn't draining
+	// its messages fails the send with a 504 rather than holding the request forever. 0 means no limit.
+	SendTimeout time.Duration
+	// SchedulePolicy decides what happens to scheduled messages whose recipient isn't connected when they fall due
+	SchedulePolicy SchedulePolicy
+	// CompressQueued gzips the Data of messages ScheduleQueue holds for disconnected clients, to save memory on large
+	// offline queues. They're decompressed again before delivery.
+	CompressQueued bool
+	// MaxQueued caps how many scheduled messages ScheduleQueue holds for each disconnected client, those past it are
+	// dead lettered. 0 means no limit.
+	MaxQueued int
+	// ProtocolToken, when set, must be presented by clients in types.ProtocolTokenHeader when dialing /ws, keeping
+	// incompatible or unauthorised clients off the websocket. Those without it are rejected before the upgrade.
+	ProtocolToken string
+	// AdminToken must be presented as a bearer token to use admin endpoints, which are disabled while it's empty
+	AdminToken string
+	// MaxGroups caps how many groups can exist at once to bound memory, 0 means unlimited
+	MaxGroups int
+	// TrustedProxies are the IPs or CIDR ranges of proxies the hub runs behind, whose X-Forwarded-For is trusted to give
+	// the client's IP for logging and IP rate limits. Empty by default, trusting no proxy, so clients can't spoof their IP.
+	TrustedProxies []string
+	// RegistrationsPerMinute limits how many times each client IP can register, 0 means unlimited
+	RegistrationsPerMinute int
+	// MaxConnectionsPerClient caps how many websockets (or event streams) a single ID can have open at once, 0 means unlimited
+	MaxConnectionsPerClient int
+	// RecipientRate limits how many messages a second each client is delivered, however many senders there are. 0 means unlimited
+	RecipientRate float64
+	// RecipientBurst is how many messages a client can be delivered at once, despite RecipientRate
+	RecipientBurst int
+	// ThrottlePolicy decides what happens to messages over a client's RecipientRate
+	ThrottlePolicy ThrottlePolicy
+	// SenderRate limits how many messages a second each client can send, on the websocket or /send, so one client can't
+	// flood the hub. Messages over it are dropped and the sender told why. 0 means unlimited
+	SenderRate float64
+	// SenderBurst is how many messages a client can send at once, despite SenderRate
+	SenderBurst int
+	// MaxSenderViolations is how many messages a client can send over its SenderRate before its connections are closed,
+	// 0 means they never are
+	MaxSenderViolations int
+	// DetailedRegistration makes register always respond with a types.RegisterResponse rather than the bare ID
+	DetailedRegistration bool
+	// ClientBufferSize is how many messages each client's channel holds while it's being drained, so a single slow
+	// consumer doesn't hold up its senders. Once it's full the OverflowPolicy applies. Takes effect for clients registered
+	// after it's set.
+	ClientBufferSize int
+	// QueueSize is how many messages are held for a connected client while its devices are busy, 0 means none are
+	QueueSize int
+	// QueueBytes caps the total size of the messages held for a connected client, 0 means no cap beyond QueueSize
+	QueueBytes int
+	// OverflowPolicy decides what happens to messages for a client whose queue or channel buffer is full
+	OverflowPolicy OverflowPolicy
+	// AckTimeout is how long a recipient has to acknowledge a message with a MessageID before the RedeliveryPolicy applies.
+	// The sender's ack then waits on the recipient's. 0 means the hub acknowledges messages itself once it hands them over.
+	AckTimeout time.Duration
+	// RedeliveryPolicy decides what happens to messages that aren't acknowledged within the AckTimeout
+	RedeliveryPolicy RedeliveryPolicy
+	// MaxRedeliveries is how many times RedeliverResend sends a message again before it's dead lettered
+	MaxRedeliveries int
+	// DeadLetterSize is how many of the most recent undeliverable messages are kept for /deadletter
+	DeadLetterSize int
+	// ResendBufferSize is how many of the latest messages delivered to each client are kept for it to ask for again on
+	// /resend, e.g. those lost with a dropped connection. While it's set a client's Sequence numbers and kept messages
+	// outlive its connections, until it deregisters. 0 means none are kept.
+	ResendBufferSize int
+	// ResendBufferAge is how long messages are kept for /resend, within the ResendBufferSize, 0 means until they're pushed out
+	ResendBufferAge time.Duration
+	// FairQueueing shares a busy recipient between the senders waiting on it by weighted fair queueing, rather than
+	// serving them first come first served, so a flood from one sender can't starve the others. Senders are told apart by
+	// the Sender of their messages.
+	FairQueueing bool
+	// CausalOrdering stamps every message the hub accepts with a Lamport timestamp, see types.SendingMessage.Lamport
+	CausalOrdering bool
+	// SenderWeight gives each sender's share of a recipient under FairQueueing, nil gives every sender the same share
+	SenderWeight func(sender uint64) int
+	// Clock is the source of time for scheduling, timeouts and last seen times, RealClock by default
+	Clock Clock
+	// Log is where the hub logs, StdLogger by default
+	Log Logger
+	// RequestTimeout bounds how long a request may take, handlers still waiting on it respond 503. 0 means no limit.
+	// Websocket connections and event streams are long lived so aren't subject to it.
+	RequestTimeout time.Duration
+
+	server     *http.Server
+	sessions   map[uint64]*session
+	publicKeys map[uint64]string
+	names      map[uint64]string
+	lastSeen   map[uint64]time.Time
+	counters   counters
+	lamport    uint64 // Latest Lamport timestamp stamped while CausalOrdering is set, only accessed atomically
+	queued     map[uint64][]types.SendingMessage
+	sequencers map[uint64]*sequencer
+	// sessionTokens maps the token issued to each connected device to its client
+	sessionTokens map[string]uint64
+	// tokens holds the token each client registered with POST /register must present to act as itself, see authorized
+	tokens map[uint64]*issuedToken
+	// formerTokens holds the token of each forgotten client the hub kept state for, see inheritLocked
+	formerTokens map[uint64]string
+	// revokedTokens holds the tokens revoked on /admin/tokens, which are rejected wherever they're presented
+	revokedTokens map[string]struct{}
+	// registrationLimiters enforces RegistrationsPerMinute, by client IP
+	registrationLimiters map[string]*ipLimiter
+	// presenceWatchers holds the event channel of each /presence connection
+	presenceWatchers map[chan types.PresenceEvent]struct{}
+	// replies holds the channel of each /send-sync call waiting for a reply, by CorrelationID
+	replies map[string]chan types.SendingMessage
+	// evictedBytes totals the size of messages each client's queue has evicted, until the stats are reset
+	evictedBytes map[uint64]uint64
+	// pending holds the messages being held for recipients, in the order they were accepted
+	pending []*pendingMessage
+	// encodings holds how each client that asked for compressed messages can decompress them
+	encodings map[uint64]string
+	// envelopeVersions holds the newest envelope version each client that gave one reads
+	envelopeVersions map[uint64]int
+	// filters holds the filter each client set, messages not matching it aren't delivered to them
+	filters map[uint64]types.Filter
+	// muted holds the clients that asked not to be delivered messages from peers
+	muted map[uint64]struct{}
+	// maxDataSizes holds the largest Data each client that set one wants delivered
+	maxDataSizes map[uint64]int
+	// awaitingAcks holds the messages recipients are yet to acknowledge, while AckTimeout is set
+	awaitingAcks map[ackKey]*awaitingAck
+	// deadLetters holds messages that couldn't be delivered, see DeadLetters
+	deadLetters chan types.DeadLetter
+	// deadLetterLog holds the most recent dead letters, oldest first, see DeadLetterSize
+	deadLetterLog []types.DeadLetter
+	// connections counts the open and opening connections of each client, enforcing MaxConnectionsPerClient
+	connections map[uint64]int
+	// instruments are the metrics served on /metrics
+	instruments *instruments
+	// senderLimits enforces SenderRate, by client
+	senderLimits map[uint64]*senderLimit
+	// addressLimiters enforces SenderRate on anonymous HTTP senders, by client IP
+	addressLimiters map[string]*ipLimiter
+	// buffered counts the messages handed to each client's channel that are yet to be delivered, which Shutdown flushes
+	buffered map[uint64]int
+	// departures are closed when the client with the channel is forgotten, releasing sends blocked on it, see departure
+	departures map[chan []byte]chan struct{}
+
+	shuttingDown  bool
+	sends         sync.WaitGroup // In-flight sends, which Shutdown waits for
+	stopSends     chan struct{}  // Closed to abort in-flight sends when Shutdown gives up waiting for them
+	stopSendsOnce sync.Once
+	// shutdownScheduled is set once /admin/announce-shutdown has been called
+	shutdownScheduled bool
+	// paused is set between Pause and Resume, while new sends are turned away
+	paused bool
 }
 
 // New creates a Hub object, initing a map of all clients & setting the router up
 func New() *Hub {
 	h := &Hub{
-		Clients: make(map[uint64]chan []byte),
+		Clients:              make(map[uint64]chan []byte),
+		Groups:               make(map[string]map[uint64]struct{}),
+		IDGenerator:          NewRandomIDGenerator(),
+		RecipientResolver:    CSVResolver{},
+		SendReadTimeout:      defaultSendReadTimeout,
+		MaxRecipients:        defaultMaxRecipients,
+		MaxDataSize:          defaultMaxDataSize,
+		MaxMessageSize:       defaultMaxMessageSize,
+		SendTimeout:          defaultSendTimeout,
+		ClientBufferSize:     defaultClientBufferSize,
+		Clock:                RealClock{},
+		Log:                  StdLogger{},
+		DeadLetterSize:       defaultDeadLetterSize,
+		MaxQueued:            defaultMaxQueued,
+		sessions:             make(map[uint64]*session),
+		publicKeys:           make(map[uint64]string),
+		names:                make(map[uint64]string),
+		lastSeen:             make(map[uint64]time.Time),
+		queued:               make(map[uint64][]types.SendingMessage),
+		sequencers:           make(map[uint64]*sequencer),
+		sessionTokens:        make(map[string]uint64),
+		tokens:               make(map[uint64]*issuedToken),
+		formerTokens:         make(map[uint64]string),
+		revokedTokens:        make(map[string]struct{}),
+		replies:              make(map[string]chan types.SendingMessage),
+		registrationLimiters: make(map[string]*ipLimiter),
+		stopSends:            make(chan struct{}),
+		presenceWatchers:     make(map[chan types.PresenceEvent]struct{}),
+		evictedBytes:         make(map[uint64]uint64),
+		encodings:            make(map[uint64]string),
+		envelopeVersions:     make(map[uint64]int),
+		filters:              make(map[uint64]types.Filter),
+		muted:                make(map[uint64]struct{}),
+		maxDataSizes:         make(map[uint64]int),
+		awaitingAcks:         make(map[ackKey]*awaitingAck),
+		deadLetters:          make(chan types.DeadLetter, deadLetterBuffer),
+		connections:          make(map[uint64]int),
+		buffered:             make(map[uint64]int),
+		senderLimits:         make(map[uint64]*senderLimit),
+		addressLimiters:      make(map[string]*ipLimiter),
+		departures:           make(map[chan []byte]chan struct{}),
+		instruments:          newInstruments(),
 	}
+	h.counters.instruments = h.instruments
 	h.Router = h.setup()
 
 	return h
 }
 
+// SeedClients registers ids as if each had called /register, e.g. to set up a hub in tests
+func (h *Hub) SeedClients(ids ...uint64) {
+	for _, id := range ids {
+		if h.addClient(id, "", "") {
+			h.seen(id)
+		}
+	}
+}
+
+// getClient returns the channel of a registered client.
+// Clients is only accessed with the lock held, so handlers should go through this and addClient rather than use it directly.
+func (h *Hub) getClient(id uint64) (chan []byte, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	ch, exists := h.Clients[id]
+	return ch, exists && ch != nil
+}
+
+// addClient registers id with a new channel, returning false if it's already in use.
+// A token, if given, must then be presented to act as the client, see authorized. proof is the token the register
+// presented, if any, letting it inherit what the hub kept from id's previous registration, see inheritLocked.
+func (h *Hub) addClient(id uint64, token, proof string) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if _, exists := h.Clients[id]; exists {
+		return false
+	}
+	h.inheritLocked(id, proof)
+	h.Clients[id] = make(chan []byte, h.ClientBufferSize)
+	if token != "" {
+		now := h.Clock.Now()
+		h.tokens[id] = &issuedToken{value: token, issuedAt: now, lastUsed: now}
+	}
+	return true
+}
+
 func (h *Hub) setup() *gin.Engine {
 	router := gin.Default()
+	// X-Forwarded-For is only trusted from TrustedProxies, see resolveClientIP
+	router.ForwardedByClientIP = false
+	router.Use(h.resolveClientIP)
+	router.Use(h.requestTimeout)
+	router.Use(h.rejectRevoked)
 
-	router.GET("/register", h.register)
+	router.GET("/register", h.limitRegistrations, h.register)
+	router.POST("/register", h.limitRegistrations, h.registerWithToken)
 	router.GET("/ws", h.websocketInit)
+	router.GET("/stream", h.stream)
+	router.GET("/poll", h.poll)
 	router.GET("/identify", h.selfIdentify)
-	router.GET("/users", h.listUsers)
+	router.GET("/deregister", h.deregister)
+	router.GET("/whoami", h.whoami)
+	router.GET("/users", compressed, h.listUsers)
+	router.GET("/users/export", h.requireAdmin, compressed, h.exportUsers)
+	router.GET("/groups/join", h.joinGroup)
+	router.GET("/groups/leave", h.leaveGroup)
+	router.GET("/pubkey", h.publicKey)
+	router.GET("/envelope", h.envelopeVersion)
+	router.GET("/presence", h.watchPresence)
+	router.GET("/stats", h.stats)
+	router.GET("/pending", h.listPending)
+	router.GET("/capabilities", h.capabilities)
+	router.GET("/deadletter", h.requireAdmin, h.listDeadLetters)
+	router.GET("/resend", h.resend)
+	router.GET("/metrics", h.metrics)
+	router.GET("/debug/state", h.requireAdmin, h.debugState)
+	router.GET("/admin/tokens", h.requireAdmin, h.listTokens)
 
-	router.POST("/send", h.sendMessage)
+	router.POST("/send", h.trackSend, h.sendMessage)
+	router.POST("/send-sync", h.trackSend, h.sendSync)
+	router.POST("/groups/send", h.trackSend, h.sendGroup)
+	router.POST("/groups/join", h.trackSend, h.joinAndAnnounce)
+	router.POST("/broadcast", h.trackSend, h.broadcast)
+	router.POST("/stats/reset", h.requireAdmin, h.resetStats)
+	router.POST("/selftest", h.requireAdmin, h.selfTest)
+	router.POST("/admin/announce-shutdown", h.requireAdmin, h.announceShutdown)
+
+	router.PUT("/filter", h.setFilter)
+	router.DELETE("/filter", h.clearFilter)
+	router.DELETE("/admin/tokens/:id", h.requireAdmin, h.revokeToken)
+	router.GET("/clients/:id/prefs", h.getPreferences)
+	router.PUT("/clients/:id/prefs", h.updatePreferences)
 
 	return router
 }
 
+// Serve runs the hub on addr, blocking until it fails or Shutdown is called
+func (h *Hub) Serve(addr string) error {
+	return h.newServer(addr).ListenAndServe()
+}
+
+// ServeTLS is Serve over TLS, with the certificate and key read from certFile and keyFile, so clients connect with
+// https:// and wss://
+func (h *Hub) ServeTLS(addr, certFile, keyFile string) error {
+	return h.newServer(addr).ListenAndServeTLS(certFile, keyFile)
+}
+
+// newServer creates the server that Serve runs the hub with, which Shutdown stops
+func (h *Hub) newServer(addr string) *http.Server {
+	h.Lock()
+	defer h.Unlock()
+
+	h.server = &http.Server{
+		Addr:    addr,
+		Handler: h.Router,
+	}
+	return h.server
+}
+
+// Shutdown warns every connected client with a system message and lets in-flight sends finish, then stops the server
+// started by Serve (if any). Sends still waiting on their recipients when ctx ends are aborted with 503.
+// Messages buffered for connected clients are delivered before their connections are closed, as far as ctx allows,
+// then every client is unregistered.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	announced := make(chan struct{})
+	go func() {
+		h.announce(ctx, []byte("Hub is shutting down"))
+		close(announced)
+	}()
+
+	err := h.drainSends(ctx)
+	<-announced
+
+	if flushErr := h.flush(ctx); err == nil {
+		err = flushErr
+	}
+	if closeErr := h.closeClients(ctx); err == nil {
+		err = closeErr
+	}
+
+	h.Lock()
+	server := h.server
+	h.Unlock()
+
+	if server != nil {
+		if serverErr := server.Shutdown(ctx); serverErr != nil {
+			return serverErr
+		}
+	}
+	return err
+}
+
+// Announce delivers data as a system message to every client, waiting up to announceTimeout for each to accept it
+func (h *Hub) Announce(data []byte) {
+	h.announce(context.Background(), data)
+}
+
+// announce is Announce, also giving up on clients yet to accept the message once ctx ends
+func (h *Hub) announce(ctx context.Context, data []byte) {
+	h.Lock()
+	channels := make(map[uint64]chan []byte, len(h.Clients))
+	for id, ch := range h.Clients {
+		channels[id] = ch
+	}
+	h.Unlock()
+
+	var wg sync.WaitGroup
+	for id, ch := range channels {
+		wg.Add(1)
+		go func(id uint64, ch chan []byte) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, announceTimeout)
+			defer cancel()
+
+			if err := h.enqueue(id, ch, types.SendingMessage{Data: data, System: true}, ctx.Done()); err != nil {
+				h.Log.Errorf("Failed announcing system message: %v client=%d size=%d", err, id, len(data))
+			}
+		}(id, ch)
+	}
+	wg.Wait()
+}
+
+// storePublicKey records the public key a client registered with, if it gave one
+func (h *Hub) storePublicKey(id uint64, key string) {
+	if key == "" {
+		return
+	}
+
+	h.Lock()
+	h.publicKeys[id] = key
+	h.Unlock()
+}
+
 // register takes an optional query "id", returns back the client id if its available, otherwise generates a random one.
+// An ID in use can be reclaimed if nothing is connected as it, see reclaim.
+// An optional "pubkey" query is kept for peers to fetch from /pubkey, and an optional "name" is shown in /users/export.
+// An optional "compress" query (only gzip) has messages compressed for the client where that makes them smaller.
 func (h *Hub) register(c *gin.Context) {
+	if id, ok := h.registerClient(c, ""); ok {
+		h.registered(c, id)
+	}
+}
+
+// registerClient registers the client described by the register queries, with token if it's given.
+// It responds with an error and returns false if the client can't be registered.
+func (h *Hub) registerClient(c *gin.Context, token string) (uint64, bool) {
+	if !validPublicKey(c) || !validCompression(c) || !validEnvelopeVersion(c) {
+		return 0, false
+	}
+
+	var newID uint64
 	// If they don't provide an id, generate a random one
 	if c.Query("id") == "" {
-		r := rand.New(rand.NewSource(time.Now().UnixNano()))
-		newID := r.Uint64()
-		for attempts := 0; !h.idInUse(newID); attempts++ {
-			if attempts > maxAttempts {
-				c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": "Failed to find ID not in use"})
-				return
-			}
-			newID = r.Uint64()
+		// Another registration could take the generated ID before it's added, which is as good as a collision
+		var ok bool
+		newID, ok = h.generateID()
+		if !ok || !h.addClient(newID, token, "") {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": "Failed to find ID not in use"})
+			return 0, false
+		}
+	} else {
+		// If they provide an ID, check its an uint64
+		var err error
+		newID, err = strconv.ParseUint(c.Query("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return 0, false
+		}
+		if err := h.validateID(newID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return 0, false
 		}
 
-		h.Clients[newID] = make(chan []byte)
-		c.JSON(http.StatusOK, newID)
-		return
+		// Then init a new channel for the ID, as long as its not already in use or it can be reclaimed
+		proof := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !h.addClient(newID, token, proof) && !h.reclaim(c, newID, token) {
+			return 0, false
+		}
 	}
 
-	// If they provide an ID, check its an uint64
-	newID, err := strconv.ParseUint(c.Query("id"), 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
-		return
+	h.storePublicKey(newID, c.Query("pubkey"))
+	h.storeName(newID, c.Query("name"))
+	h.storeCompression(newID, c.Query("compress"))
+	h.storeEnvelopeVersion(newID, c.Query("envelope"))
+	h.seen(newID)
+	return newID, true
+}
+
+// generateID returns a valid ID from the IDGenerator that isn't in use, or false if none was found within maxAttempts
+func (h *Hub) generateID() (uint64, bool) {
+	newID := h.IDGenerator.NextID()
+	// Keep generating only while the candidate collides with a registered client or the IDValidator refuses it
+	for attempts := 0; h.idInUse(newID) || h.validateID(newID) != nil; attempts++ {
+		if attempts > maxAttempts {
+			return 0, false
+		}
+		newID = h.IDGenerator.NextID()
 	}
+	return newID, true
+}
 
-	// Then check if its already in use
-	if _, exists := h.Clients[newID]; exists {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID already in use"})
+// registered responds to a successful register with the bare ID, or a types.RegisterResponse if
+// DetailedRegistration is set or the client Accepts types.RegisterResponseType
+func (h *Hub) registered(c *gin.Context, id uint64) {
+	if !h.DetailedRegistration && !strings.Contains(c.GetHeader("Accept"), types.RegisterResponseType) {
+		c.JSON(http.StatusOK, id)
 		return
 	}
 
-	// Init a new channel for the ID
-	h.Clients[newID] = make(chan []byte)
-
-	c.JSON(http.StatusOK, newID)
+	c.JSON(http.StatusOK, types.RegisterResponse{
+		ID:              id,
+		ServerTime:      h.Clock.Now(),
+		ProtocolVersion: types.ProtocolVersion,
+	})
 }
 
 // listUsers returns back an array of all userID's in use
@@ -100,64 +544,269 @@ func (h *Hub) listUsers(c *gin.Context) {
 	}
 
 	var users types.ListResponse
+	h.Lock()
 	for userid := range h.Clients {
 		// We don't want to add our own ID
 		if userid != parsedID {
 			users.IDs = append(users.IDs, userid)
 		}
 	}
+	h.Unlock()
 
 	c.JSON(http.StatusOK, users)
 }
 
-// sendMessages takes csv of clientIDs, and a Body containing byte array. It then puts the byte array in the channel of each types.
+// sendMessages takes recipients (csv of clientIDs by default, see RecipientResolver), and a Body containing byte array. It then puts the byte array in the channel of each types.
+// Alternatively the recipients and data can be given as a JSON SendingMessage body, for recipient lists too long for the query.
+// Bodies can be gzip compressed, with Content-Encoding: gzip.
+// An optional "from" query names the sender, who is skipped if also listed as a recipient.
 func (h *Hub) sendMessage(c *gin.Context) {
-	if c.Query("ids") == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "IDs are required (csv)"})
+	msg, ids, ok := h.readSend(c)
+	if !ok {
 		return
 	}
+	if !h.limitSend(c, msg.Sender) {
+		return
+	}
+
+	h.relay(c, msg, ids)
+}
+
+// maxEnvelopeSize is the most a JSON SendingMessage can take up while within MaxDataSize and MaxRecipients, 0 if
+// either has no limit
+func (h *Hub) maxEnvelopeSize() int64 {
+	if h.MaxDataSize <= 0 || h.MaxRecipients <= 0 {
+		return 0
+	}
+	// Data is base64 encoded, and each recipient takes up to a 20 digit ID and a comma
+	return int64(base64.StdEncoding.EncodedLen(h.MaxDataSize)) + int64(h.MaxRecipients)*21 + int64(envelopeOverhead)
+}
+
+// readSend reads the message and its resolved recipients from a /send style request.
+// It responds with an error and returns false if the request is invalid.
+func (h *Hub) readSend(c *gin.Context) (types.SendingMessage, []uint64, bool) {
+	// Check before anything parses the query, huge recipient lists belong in the body
+	if len(c.Request.URL.RawQuery) > maxQueryLength {
+		c.JSON(http.StatusRequestURITooLong, gin.H{"status": "URI Too Long", "message": "Query string too long, send large recipient lists as a JSON body (Content-Type: application/json) instead"})
+		return types.SendingMessage{}, nil, false
+	}
+
+	// Without ids in the query, a JSON body holds both the recipients and data
+	jsonBody := c.Query("ids") == "" && c.ContentType() == "application/json"
+
+	if c.Query("ids") == "" && !jsonBody {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "IDs are required (csv)"})
+		return types.SendingMessage{}, nil, false
+	}
 
 	if c.Request.Body == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Body expected for a sendmessage call"})
-		return
+		return types.SendingMessage{}, nil, false
 	}
 
-	b, err := ioutil.ReadAll(c.Request.Body)
+	// A raw body is all Data, anything else carries the recipients and the rest of the message, or may be compressed
+	limit := int64(h.MaxDataSize)
+	if jsonBody || c.GetHeader("Content-Encoding") == "gzip" {
+		limit = h.maxEnvelopeSize()
+	}
+	b, err := readLimitedBody(c, h.SendReadTimeout, limit)
+	if err == errReadTimeout {
+		c.JSON(http.StatusRequestTimeout, gin.H{"status": "Request Timeout", "message": "Timed out reading body"})
+		return types.SendingMessage{}, nil, false
+	}
+	if err == errBodyTooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "Request Entity Too Large", "message": fmt.Sprintf("Maximum data size is %d bytes", h.MaxDataSize)})
+		return types.SendingMessage{}, nil, false
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "No JSON body found"})
-		return
+		return types.SendingMessage{}, nil, false
 	}
 
-	ids := strings.Split(c.Query("ids"), ",")
+	if c.GetHeader("Content-Encoding") == "gzip" {
+		// A raw body is all Data, a JSON one carries the recipients and the rest of the message too
+		limit := int64(h.MaxDataSize)
+		if jsonBody {
+			limit = h.maxEnvelopeSize()
+		}
+		b, err = gunzip(b, limit)
+		if err == errDecompressedTooLarge {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "Request Entity Too Large", "message": fmt.Sprintf("Maximum data size is %d bytes", h.MaxDataSize)})
+			return types.SendingMessage{}, nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": fmt.Sprintf("Invalid gzip body: %v", err)})
+			return types.SendingMessage{}, nil, false
+		}
+	}
 
-	if len(ids) > 255 {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Maximum number of clients to send messages is 255"})
-		return
+	recipients := c.Query("ids")
+	var metadata map[string]string
+	// The message can be given a time to live, after which it's dropped if it hasn't been delivered
+	var expiresAt time.Time
+	if c.Query("ttl") != "" {
+		ttl, err := time.ParseDuration(c.Query("ttl"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return types.SendingMessage{}, nil, false
+		}
+		if ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "TTL must be positive"})
+			return types.SendingMessage{}, nil, false
+		}
+		expiresAt = h.Clock.Now().Add(ttl)
+	}
+	if jsonBody {
+		var msg types.SendingMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return types.SendingMessage{}, nil, false
+		}
+		if msg.Recipients == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "IDs are required (csv)"})
+			return types.SendingMessage{}, nil, false
+		}
+		recipients, b, metadata = msg.Recipients, msg.Data, msg.Metadata
+		if !msg.ExpiresAt.IsZero() {
+			expiresAt = msg.ExpiresAt
+		}
 	}
 
-	for _, id := range ids {
-		parsedID, err := strconv.ParseUint(id, 10, 64)
+	ids, err := h.RecipientResolver.Resolve(recipients)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return types.SendingMessage{}, nil, false
+	}
+
+	if h.MaxRecipients > 0 && len(ids) > h.MaxRecipients {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": fmt.Sprintf("Maximum number of clients to send messages is %d", h.MaxRecipients)})
+		return types.SendingMessage{}, nil, false
+	}
+
+	if h.MaxDataSize > 0 && len(b) > h.MaxDataSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "Request Entity Too Large", "message": fmt.Sprintf("Maximum data size is %d bytes", h.MaxDataSize)})
+		return types.SendingMessage{}, nil, false
+	}
+
+	// The sender is optional, but when given it's excluded from the recipients so it doesn't receive its own message
+	var sender uint64
+	if c.Query("from") != "" {
+		sender, err = strconv.ParseUint(c.Query("from"), 10, 64)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
-			return
+			return types.SendingMessage{}, nil, false
+		}
+		// Only the sender itself can send as a client registered with a token
+		if !h.authorized(c, sender) {
+			return types.SendingMessage{}, nil, false
 		}
+	}
+
+	msg := types.SendingMessage{Recipients: recipients, Data: b, Sender: sender, Metadata: metadata, ExpiresAt: expiresAt}
+	h.stamp(&msg)
+	return msg, ids, true
+}
 
-		ch, exists := h.Clients[parsedID]
-		if !exists || ch == nil {
+// relay hands msg to each of ids other than its sender. It responds with an error and returns false if any can't be given it.
+func (h *Hub) relay(c *gin.Context, msg types.SendingMessage, ids []uint64) bool {
+	var delivered []uint64
+	for _, parsedID := range ids {
+		if msg.Sender != 0 && parsedID == msg.Sender {
+			continue
+		}
+
+		ch, exists := h.getClient(parsedID)
+		if !exists {
+			h.deadLetter(parsedID, msg, 0, "Recipient not registered")
 			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
-			return
+			return false
+		}
+
+		// Add the framed message onto the clients channel
+		ctx, cancel := h.sendTimeout(c.Request.Context())
+		abort, release := h.abortSend(ctx)
+		err := h.enqueue(parsedID, ch, msg, abort)
+		release()
+		cancel()
+		if err == errRecipientGone {
+			h.deadLetter(parsedID, msg, 0, "Recipient deregistered before the message was handed over")
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered", "delivered": delivered})
+			return false
 		}
+		if err == errExpired {
+			c.JSON(http.StatusGone, gin.H{"status": "Gone", "message": fmt.Sprintf("message expired before it was delivered to %d", parsedID), "delivered": delivered})
+			return false
+		}
+		if err == errDropped {
+			// Already counted as a failure by the OverflowPolicy
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "Service Unavailable", "message": fmt.Sprintf("recipient %d's buffer is full, message dropped", parsedID), "delivered": delivered})
+			return false
+		}
+		if err == errEnqueueAborted {
+			h.counters.failed()
+			select {
+			case <-h.stopSends:
+				c.JSON(http.StatusServiceUnavailable, gin.H{"status": "Service Unavailable", "message": "Hub is shutting down"})
+				return false
+			default:
+			}
+			// Otherwise, unless it was the request that ended, which requestTimeout responds to, the recipient ran out of time
+			if c.Request.Context().Err() == nil {
+				c.JSON(http.StatusGatewayTimeout, gin.H{"status": "Gateway Timeout", "message": fmt.Sprintf("recipient %d not accepting messages", parsedID), "delivered": delivered})
+			}
+			return false
+		}
+		if err != nil {
+			h.counters.failed()
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": err.Error()})
+			return false
+		}
+		h.counters.relayed(len(msg.Data))
+		delivered = append(delivered, parsedID)
+	}
+	return true
+}
+
+// readBody reads all of body, giving up with errReadTimeout if it takes longer than timeout.
+// On timeout the read is left running in the background, and ends once the server closes the body.
+func readBody(body io.Reader, timeout time.Duration) ([]byte, error) {
+	type result struct {
+		b   []byte
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		b, err := ioutil.ReadAll(body)
+		done <- result{b, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.b, r.err
+	case <-time.After(timeout):
+		return nil, errReadTimeout
+	}
+}
 
-		b = append(b, byte('\n'))
+// readLimitedBody reads the request body as readBody does, giving up with errBodyTooLarge once it's more than limit
+// bytes rather than holding however much the client sends. 0 means no limit.
+func readLimitedBody(c *gin.Context, timeout time.Duration, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return readBody(c.Request.Body, timeout)
+	}
 
-		// Add the byte array onto the clients channel
-		ch <- b
+	// Allowing a byte past the limit tells a body that's too large apart from one that's exactly the limit
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1)
+	b, err := readBody(c.Request.Body, timeout)
+	if int64(len(b)) > limit {
+		return nil, errBodyTooLarge
 	}
+	return b, err
 }
 
-// selfIdentify takes a query of an ID, it check that it exists and is valid. Returning back the ID if it is
-// Note: this method is written as such since there's no authentication in this simple solution. If there was authentication via token etc,
-// that would be used to maintain a map of userIDs to authentication method.
+// selfIdentify takes a query of an ID, it check that it exists and is valid. Returning back the ID if it is.
+// Clients registered with a token must present it, see authorized.
 func (h *Hub) selfIdentify(c *gin.Context) {
 	if c.Query("id") == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID is required"})
@@ -170,20 +819,22 @@ func (h *Hub) selfIdentify(c *gin.Context) {
 		return
 	}
 
-	if ch, exists := h.Clients[parsedID]; !exists || ch == nil {
+	if _, exists := h.getClient(parsedID); !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
 		return
 	}
 
+	if !h.authorized(c, parsedID) {
+		return
+	}
+
 	c.JSON(http.StatusOK, parsedID)
 }
 
-// idInUse is used to check the client map to see if it exists
+// idInUse reports whether id is already registered to a client
 func (h *Hub) idInUse(id uint64) bool {
-	if _, exists := h.Clients[id]; !exists {
-		return true
-	}
-	return false
+	_, exists := h.getClient(id)
+	return exists
 }
 
 var upgrader = websocket.Upgrader{
@@ -204,64 +855,178 @@ func (h *Hub) websocketInit(c *gin.Context) {
 		return
 	}
 
-	if ch, exists := h.Clients[connectedID]; !exists || ch == nil {
+	if _, exists := h.getClient(connectedID); !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
 		return
 	}
 
-	// Upgrade connection to a websocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if !h.authorized(c, connectedID) || !h.validHandshake(c) {
+		return
+	}
+
+	weight, ok := deviceWeight(c)
+	if !ok {
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": err.Error()})
+		return
+	}
+
+	if !h.reserveConnection(c, connectedID) {
+		return
+	}
+
+	// Upgrade connection to a websocket, handing the client the token identifying this connection
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, http.Header{types.SessionTokenHeader: {token}})
 	if err != nil {
+		h.releaseConnection(connectedID)
 		return
 	}
 
+	// Frames too large to hold a message within the limits close the connection before they're read into memory
+	if limit := h.maxEnvelopeSize(); limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+
+	// Each connection is a device of the client, outgoing messages are handed to it by the client's pump
+	d := h.connectDevice(connectedID, conn, token, weight)
+
 	// Handles incoming messages
 	go func() {
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
-				log.Printf("Error reading message from %d: %v", connectedID, err)
-				conn.Close()
-				delete(h.Clients, connectedID)
+				h.Log.Errorf("Error reading message: %v client=%d", err, connectedID)
+				h.disconnectDevice(connectedID, d, readCloseReason(err))
 				break
 			}
+			h.seen(connectedID)
 
 			var incomingMessage types.SendingMessage
 			err = json.Unmarshal(msg, &incomingMessage)
 			if err != nil {
-				log.Printf("Unable unmarshal message bound for %d: %v", connectedID, err)
+				h.Log.Errorf("Unable to unmarshal message: %v client=%d size=%d", err, connectedID, len(msg))
 				continue
 			}
 
-			ids := strings.Split(incomingMessage.Recipients, ",")
-
-			for _, id := range ids {
-
-				parsedID, err := strconv.ParseUint(id, 10, 64)
-				if err != nil {
-					log.Printf("Unable to parse recipient %v: %v", id, err)
+			// Recipients acknowledge the messages they receive, when the hub waits on that, and those they process
+			if incomingMessage.Ack && incomingMessage.AckStatus == types.AckProcessed {
+				// Passed on like any other message, so they're held to the SenderRate too
+				if !h.allowSend(connectedID) {
+					h.systemMessage(connectedID, h.senderRateMessage())
 					continue
 				}
+				h.processed(connectedID, incomingMessage)
+				continue
+			}
+			if incomingMessage.Ack {
+				h.recipientAcked(connectedID, incomingMessage.MessageID)
+				continue
+			}
+
+			// Peers can't pose as the hub or each other, or pick their own place in the recipient's stream
+			incomingMessage.Sender = connectedID
+			incomingMessage.System = false
+			incomingMessage.Sequence = 0
+			incomingMessage.Encoding = ""
+			incomingMessage.Lamport = 0
 
-				h.Clients[parsedID] <- incomingMessage.Data
+			if !h.allowSend(connectedID) {
+				h.refuse(connectedID, incomingMessage, h.senderRateMessage())
+				continue
+			}
+			if h.isPaused() {
+				h.refuse(connectedID, incomingMessage, "Message dropped, hub is paused")
+				continue
 			}
-		}
-	}()
 
-	// Handles outgoing messages
-	go func() {
-		for {
-			select {
-			case msg := <-h.Clients[connectedID]:
-				err := conn.WriteMessage(1, msg)
+			if h.MaxDataSize > 0 && len(incomingMessage.Data) > h.MaxDataSize {
+				h.Log.Errorf("Dropping message larger than the maximum data size %d client=%d size=%d", h.MaxDataSize, connectedID, len(incomingMessage.Data))
+				h.refuse(connectedID, incomingMessage, fmt.Sprintf("Message dropped, maximum data size is %d bytes", h.MaxDataSize))
+				continue
+			}
+
+			if !h.validChunk(incomingMessage) {
+				h.Log.Errorf("Dropping chunk %d of %d, outside the maximum %d chunks client=%d size=%d", incomingMessage.ChunkIndex, incomingMessage.ChunkCount, h.maxChunks(), connectedID, len(incomingMessage.Data))
+				h.refuse(connectedID, incomingMessage, fmt.Sprintf("Message dropped, chunk %d of %d is invalid, messages can be split into at most %d chunks", incomingMessage.ChunkIndex, incomingMessage.ChunkCount, h.maxChunks()))
+				continue
+			}
+
+			// Replies to a /send-sync call go back to the caller rather than being relayed, though they're held to the
+			// same limits
+			if incomingMessage.CorrelationID != "" && h.reply(incomingMessage) {
+				continue
+			}
+
+			ids, err := h.RecipientResolver.Resolve(incomingMessage.Recipients)
+			if err != nil {
+				h.Log.Errorf("Unable to resolve recipients %v: %v client=%d size=%d", incomingMessage.Recipients, err, connectedID, len(incomingMessage.Data))
+				h.counters.failed()
+				h.nack(connectedID, 0, incomingMessage.MessageID, fmt.Sprintf("Unable to resolve recipients: %v", err))
+				continue
+			}
+
+			// A single frame can't fan out any wider than /send can
+			if h.MaxRecipients > 0 && len(ids) > h.MaxRecipients {
+				h.Log.Errorf("Dropping message addressed to %d recipients, more than the maximum %d client=%d size=%d", len(ids), h.MaxRecipients, connectedID, len(incomingMessage.Data))
+				h.refuse(connectedID, incomingMessage, fmt.Sprintf("Message dropped, maximum number of clients to send messages is %d", h.MaxRecipients))
+				continue
+			}
+
+			h.stamp(&incomingMessage)
+
+			// Messages for the future are held by the hub until they're due
+			if incomingMessage.DeliverAt.After(h.Clock.Now()) {
+				if unknown := h.schedule(connectedID, incomingMessage.DeliverAt, ids, incomingMessage); len(unknown) > 0 {
+					h.systemMessage(connectedID, "Recipients not registered: "+strings.Join(unknown, ","))
+				}
+				continue
+			}
+
+			var unknown []string
+			for _, parsedID := range ids {
+				ch, exists := h.getClient(parsedID)
+				if !exists {
+					h.deadLetter(parsedID, incomingMessage, 0, "Recipient not registered")
+					h.nack(connectedID, parsedID, incomingMessage.MessageID, "Recipient not registered")
+					unknown = append(unknown, strconv.FormatUint(parsedID, 10))
+					continue
+				}
+				err := h.enqueue(parsedID, ch, incomingMessage, nil)
+				if err == errDropped {
+					// Already counted as a failure by the OverflowPolicy, the sender is told rather than acked
+					h.systemMessage(connectedID, fmt.Sprintf("Message to %d dropped, its buffer is full", parsedID))
+					h.nack(connectedID, parsedID, incomingMessage.MessageID, "Dropped, the recipient's buffer is full")
+					continue
+				}
+				if err == errExpired {
+					h.systemMessage(connectedID, fmt.Sprintf("Message to %d expired before it was delivered", parsedID))
+					h.nack(connectedID, parsedID, incomingMessage.MessageID, "Expired before it was delivered")
+					continue
+				}
 				if err != nil {
-					log.Printf("Error writing message to %d: %v", connectedID, err)
-					conn.Close()
-					delete(h.Clients, connectedID)
-					break
+					h.Log.Errorf("Unable to relay message: %v client=%d recipient=%d size=%d", err, connectedID, parsedID, len(incomingMessage.Data))
+					h.counters.failed()
+					h.nack(connectedID, parsedID, incomingMessage.MessageID, err.Error())
+					continue
 				}
+				h.counters.relayed(len(incomingMessage.Data))
+
+				if incomingMessage.MessageID == "" {
+					continue
+				}
+				if h.AckTimeout > 0 {
+					h.awaitAck(connectedID, parsedID, incomingMessage)
+				} else {
+					h.ack(connectedID, parsedID, incomingMessage.MessageID)
+				}
+			}
+			if len(unknown) > 0 {
+				h.systemMessage(connectedID, "Recipients not registered: "+strings.Join(unknown, ","))
 			}
 		}
 	}()
-
 }
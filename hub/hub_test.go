@@ -2,12 +2,15 @@ package hub
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,16 +27,14 @@ func TestHub_selfIdentify(t *testing.T) {
 		expectedCode      int
 		expectedError     gin.H
 		inputID, outputID string
-		clients           map[uint64]chan []byte
+		clients           []uint64
 	}{
 		{
 			name:         "Golden Path",
 			inputID:      "2387695293",
 			outputID:     "2387695293",
 			expectedCode: 200,
-			clients: map[uint64]chan []byte{
-				2387695293: make(chan []byte),
-			},
+			clients:      []uint64{2387695293},
 		},
 		{
 			name:          "Client doesn't exist",
@@ -45,25 +46,21 @@ func TestHub_selfIdentify(t *testing.T) {
 			name:          "No ID given",
 			expectedCode:  400,
 			expectedError: gin.H{"message": "ID is required", "status": "Bad Request"},
-			clients: map[uint64]chan []byte{
-				2387695293: make(chan []byte),
-			},
+			clients:       []uint64{2387695293},
 		},
 		{
 			name:          "ID given but not a uint64",
 			expectedCode:  400,
 			inputID:       "notuint64",
 			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
-			clients: map[uint64]chan []byte{
-				2387695293: make(chan []byte),
-			},
+			clients:       []uint64{2387695293},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
 			h := New()
-			h.Clients = tt.clients
+			h.SeedClients(tt.clients...)
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/identify?id=%s", tt.inputID), nil)
 			require.NoError(t, err)
@@ -93,62 +90,54 @@ func TestHub_listUsers(t *testing.T) {
 		expectedLength int
 		expectedCode   int
 		id             string
-		clients        map[uint64]chan []byte
+		clients        []uint64
 	}{
 		{
 			name:           "Single",
 			expectedLength: 1,
 			expectedCode:   200,
-			clients: map[uint64]chan []byte{
-				100: make(chan []byte),
-			},
-			id: "0",
+			clients:        []uint64{100},
+			id:             "0",
 		},
 		{
 			name:           "Double",
 			expectedLength: 2,
 			expectedCode:   200,
-			clients: map[uint64]chan []byte{
-				100: make(chan []byte),
-				200: make(chan []byte),
-			},
-			id: "0",
+			clients:        []uint64{100, 200},
+			id:             "0",
 		},
 		{
 			name:           "Double including self",
 			expectedLength: 1,
 			expectedCode:   200,
-			clients: map[uint64]chan []byte{
-				100: make(chan []byte),
-				200: make(chan []byte),
-			},
-			id: "100",
+			clients:        []uint64{100, 200},
+			id:             "100",
 		},
 		{
 			name:           "Just a coke",
 			expectedLength: 0,
 			expectedCode:   200,
-			clients:        map[uint64]chan []byte{},
+			clients:        nil,
 			id:             "0",
 		},
 		{
 			name:           "No ID",
 			expectedLength: 0,
 			expectedCode:   400,
-			clients:        map[uint64]chan []byte{},
+			clients:        nil,
 		},
 		{
 			name:           "Invalid ID",
 			expectedLength: 0,
 			expectedCode:   400,
-			clients:        map[uint64]chan []byte{},
+			clients:        nil,
 			id:             "invalid",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := New()
-			h.Clients = tt.clients
+			h.SeedClients(tt.clients...)
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/users?id=%s", tt.id), nil)
 			require.NoError(t, err)
@@ -214,30 +203,28 @@ func TestHub_registerOwnID(t *testing.T) {
 		expectedError gin.H
 		inputID       string
 		outputID      uint64
-		clients       map[uint64]chan []byte
+		clients       []uint64
 	}{
 		{
 			name:         "Golden Path",
 			expectedCode: 200,
 			inputID:      "9001",
 			outputID:     uint64(9001),
-			clients:      map[uint64]chan []byte{},
+			clients:      nil,
 		},
 		{
 			name:          "Not uint64 parsable",
 			expectedCode:  400,
 			inputID:       "notuint64",
 			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
-			clients:       map[uint64]chan []byte{},
+			clients:       nil,
 		},
 		{
 			name:          "ID already exists",
 			expectedCode:  400,
 			inputID:       "500",
 			expectedError: gin.H{"message": "ID already in use", "status": "Bad Request"},
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			clients:       []uint64{500},
 		},
 	}
 	for _, tt := range tests {
@@ -245,7 +232,7 @@ func TestHub_registerOwnID(t *testing.T) {
 
 			h := New()
 
-			h.Clients = tt.clients
+			h.SeedClients(tt.clients...)
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/register?id=%s", tt.inputID), nil)
 			require.NoError(t, err)
@@ -272,6 +259,59 @@ func TestHub_registerOwnID(t *testing.T) {
 	}
 }
 
+func TestHub_registerDetailed(t *testing.T) {
+	tests := []struct {
+		name             string
+		accept           string
+		detailed         bool
+		expectedDetailed bool
+	}{
+		{
+			name:             "Accept header",
+			accept:           types.RegisterResponseType,
+			expectedDetailed: true,
+		},
+		{
+			name:             "Hub option",
+			detailed:         true,
+			expectedDetailed: true,
+		},
+		{
+			name:   "Bare ID by default",
+			accept: "application/json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.DetailedRegistration = tt.detailed
+
+			req, err := http.NewRequest("GET", "/register?id=9001", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", tt.accept)
+
+			w := httptest.NewRecorder()
+			before := time.Now()
+			h.Router.ServeHTTP(w, req)
+
+			require.Equal(t, 200, w.Code)
+
+			if !tt.expectedDetailed {
+				id, err := strconv.ParseUint(w.Body.String(), 10, 64)
+				require.NoError(t, err)
+				assert.Equal(t, uint64(9001), id)
+				return
+			}
+
+			var resp types.RegisterResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, uint64(9001), resp.ID)
+			assert.Equal(t, types.ProtocolVersion, resp.ProtocolVersion)
+			assert.WithinDuration(t, before, resp.ServerTime, 5*time.Second)
+		})
+	}
+}
+
 func TestHub_sendMessage(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -279,42 +319,34 @@ func TestHub_sendMessage(t *testing.T) {
 		expectedError gin.H
 		inputID       string
 		inputBody     io.Reader
-		clients       map[uint64]chan []byte
+		clients       []uint64
 	}{
 		{
 			name:         "Golden Path",
 			expectedCode: 200,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
-			inputID:   "500",
-			inputBody: bytes.NewBuffer([]byte("Hi")),
+			clients:      []uint64{500},
+			inputID:      "500",
+			inputBody:    bytes.NewBuffer([]byte("Hi")),
 		},
 		{
-			name:         "No ids",
-			expectedCode: 400,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			name:          "No ids",
+			expectedCode:  400,
+			clients:       []uint64{500},
 			inputBody:     bytes.NewBuffer([]byte("Hi")),
 			expectedError: gin.H{"message": "IDs are required (csv)", "status": "Bad Request"},
 		},
 		{
-			name:         "No body",
-			expectedCode: 400,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			name:          "No body",
+			expectedCode:  400,
+			clients:       []uint64{500},
 			inputID:       "500",
 			expectedError: gin.H{"message": "Body expected for a sendmessage call", "status": "Bad Request"},
 			inputBody:     nil,
 		},
 		{
-			name:         "id not uint64",
-			expectedCode: 400,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			name:          "id not uint64",
+			expectedCode:  400,
+			clients:       []uint64{500},
 			inputID:       "notuint64",
 			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
 			inputBody:     bytes.NewBuffer([]byte("Hi")),
@@ -330,7 +362,7 @@ func TestHub_sendMessage(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := New()
-			h.Clients = tt.clients
+			h.SeedClients(tt.clients...)
 
 			req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%s", tt.inputID), tt.inputBody)
 			require.NoError(t, err)
@@ -338,10 +370,17 @@ func TestHub_sendMessage(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// go func needed since channels are used from within, needs to be threaded
-			go func() { h.Router.ServeHTTP(w, req) }()
+			done := make(chan struct{})
+			go func() {
+				h.Router.ServeHTTP(w, req)
+				close(done)
+			}()
 
-			// time for request to finish
-			time.Sleep(time.Second)
+			// time for request to finish, sends to a client that isn't reading wait on it though
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+			}
 
 			assert.Equal(t, tt.expectedCode, w.Code)
 
@@ -362,43 +401,35 @@ func TestHub_websocketInit(t *testing.T) {
 		expectedError gin.H
 		inputID       string
 		inputBody     types.SendingMessage
-		clients       map[uint64]chan []byte
+		clients       []uint64
 	}{
 		{
 			name:         "Golden Path",
 			expectedCode: 200,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
-			inputID: "500",
+			clients:      []uint64{500},
+			inputID:      "500",
 			inputBody: types.SendingMessage{
 				Recipients: "500",
 				Data:       []byte("asdfbuyho"),
 			},
 		},
 		{
-			name:         "no id",
-			expectedCode: 400,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			name:          "no id",
+			expectedCode:  400,
+			clients:       []uint64{500},
 			expectedError: gin.H{"message": "ID is required", "status": "Bad Request"},
 		},
 		{
-			name:         "id not uint64",
-			expectedCode: 400,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			name:          "id not uint64",
+			expectedCode:  400,
+			clients:       []uint64{500},
 			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
 			inputID:       "notuint64",
 		},
 		{
-			name:         "id doesn't exist",
-			expectedCode: 400,
-			clients: map[uint64]chan []byte{
-				500: make(chan []byte),
-			},
+			name:          "id doesn't exist",
+			expectedCode:  400,
+			clients:       []uint64{500},
 			expectedError: gin.H{"message": "ID not registered", "status": "Bad Request"},
 			inputID:       "200",
 		},
@@ -406,12 +437,12 @@ func TestHub_websocketInit(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := New()
-			h.Clients = tt.clients
+			h.SeedClients(tt.clients...)
 
-			// go func needed since channels are used from within, needs to be threaded
-			go func() { h.Router.Run("localhost:8080") }()
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
 
-			conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://localhost:8080/ws?id=%s", tt.inputID), nil)
+			conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%s", strings.TrimPrefix(serv.URL, "http://"), tt.inputID), nil)
 			require.Equal(t, tt.expectedError != nil, err != nil)
 
 			if tt.expectedError != nil {
@@ -431,9 +462,17 @@ func TestHub_websocketInit(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// go func needed since channels are used from within, needs to be threaded
-			go func() { h.Router.ServeHTTP(w, req) }()
+			done := make(chan struct{})
+			go func() {
+				h.Router.ServeHTTP(w, req)
+				close(done)
+			}()
 
-			time.Sleep(time.Second)
+			// time for request to finish, sends to a client that isn't reading wait on it though
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+			}
 
 			assert.Equal(t, w.Code, 200)
 
@@ -441,3 +480,630 @@ func TestHub_websocketInit(t *testing.T) {
 		})
 	}
 }
+
+// slowReader yields a single byte every delay, until it has given size bytes
+type slowReader struct {
+	delay time.Duration
+	size  int
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.size == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	p[0] = 'a'
+	r.size--
+	return 1, nil
+}
+
+func TestHub_websocketSender(t *testing.T) {
+	tests := []struct {
+		name   string
+		sender uint64
+	}{
+		{
+			name: "No sender",
+		},
+		{
+			name:   "Posing as another client",
+			sender: 700,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500, 600, 700)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			sender := dialAs(t, serv, 500)
+			defer sender.Close()
+			recipient := dialAs(t, serv, 600)
+			defer recipient.Close()
+
+			writeFrame(t, sender, types.SendingMessage{Recipients: "600", Data: []byte("Hi"), Sender: tt.sender})
+
+			require.NoError(t, recipient.SetReadDeadline(time.Now().Add(time.Second)))
+			var received types.SendingMessage
+			require.NoError(t, recipient.ReadJSON(&received))
+
+			// Always the client whose websocket it came in on
+			assert.Equal(t, uint64(500), received.Sender)
+			assert.Equal(t, []byte("Hi"), received.Data)
+		})
+	}
+}
+
+func TestHub_websocketUnknownRecipient(t *testing.T) {
+	h := New()
+	h.SeedClients(500, 600)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	sender := dialAs(t, serv, 500)
+	defer sender.Close()
+	recipient := dialAs(t, serv, 600)
+	defer recipient.Close()
+	require.NoError(t, sender.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, recipient.SetReadDeadline(time.Now().Add(time.Second)))
+
+	writeFrame(t, sender, types.SendingMessage{Recipients: "600,999", Data: []byte("first")})
+
+	var received types.SendingMessage
+	require.NoError(t, recipient.ReadJSON(&received))
+	assert.Equal(t, []byte("first"), received.Data)
+
+	// The sender is told which recipients don't exist
+	var notice types.SendingMessage
+	require.NoError(t, sender.ReadJSON(&notice))
+	assert.True(t, notice.System)
+	assert.Equal(t, "Recipients not registered: 999", string(notice.Data))
+
+	// And its connection is still relaying
+	writeFrame(t, sender, types.SendingMessage{Recipients: "600", Data: []byte("second")})
+	require.NoError(t, recipient.ReadJSON(&received))
+	assert.Equal(t, []byte("second"), received.Data)
+
+	select {
+	case letter := <-h.DeadLetters():
+		assert.Equal(t, uint64(999), letter.Recipient)
+	default:
+		t.Fatal("unknown recipient wasn't dead lettered")
+	}
+}
+
+func TestHub_processedAck(t *testing.T) {
+	h := New()
+	h.SeedClients(500, 600, 700)
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	sender := dialAs(t, serv, 500)
+	defer sender.Close()
+	recipient := dialAs(t, serv, 600)
+	defer recipient.Close()
+	bystander := dialAs(t, serv, 700)
+	defer bystander.Close()
+
+	writeFrame(t, sender, types.SendingMessage{Recipients: "600", Data: []byte("data"), MessageID: "m1"})
+	var msg types.SendingMessage
+	require.NoError(t, recipient.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, recipient.ReadJSON(&msg))
+	require.Equal(t, "m1", msg.MessageID)
+
+	// Acks naming another client, or for messages never handed over, don't reach it
+	writeFrame(t, recipient, types.SendingMessage{Recipients: "700", MessageID: "m1", Ack: true, AckStatus: types.AckProcessed})
+	writeFrame(t, recipient, types.SendingMessage{Recipients: "700", MessageID: "m2", Ack: true, AckStatus: types.AckProcessed})
+
+	for _, expected := range []string{types.AckDelivered, types.AckProcessed} {
+		var ack types.SendingMessage
+		require.NoError(t, sender.SetReadDeadline(time.Now().Add(time.Second)))
+		require.NoError(t, sender.ReadJSON(&ack))
+		assert.True(t, ack.Ack)
+		assert.Equal(t, "m1", ack.MessageID)
+		assert.Equal(t, uint64(600), ack.Sender)
+		if expected == types.AckProcessed {
+			assert.Equal(t, expected, ack.AckStatus)
+		}
+	}
+
+	require.NoError(t, bystander.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	_, _, err := bystander.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestHub_sendMessageReadTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		timeout       time.Duration
+		inputBody     io.Reader
+	}{
+		{
+			name:         "Within timeout",
+			expectedCode: 200,
+			timeout:      time.Second,
+			inputBody:    &slowReader{delay: 10 * time.Millisecond, size: 5},
+		},
+		{
+			name:          "Slow upload",
+			expectedCode:  408,
+			expectedError: gin.H{"message": "Timed out reading body", "status": "Request Timeout"},
+			timeout:       100 * time.Millisecond,
+			inputBody:     &slowReader{delay: 50 * time.Millisecond, size: 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SendReadTimeout = tt.timeout
+			h.Clients = map[uint64]chan []byte{
+				500: make(chan []byte, 1),
+			}
+
+			req, err := http.NewRequest("POST", "/send?ids=500", tt.inputBody)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+
+			start := time.Now()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			// Aborted shortly after the deadline, rather than waiting out the whole upload
+			assert.Less(t, int64(time.Since(start)), int64(tt.timeout+time.Second))
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+		})
+	}
+}
+
+func TestHub_sendMessageSendTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		inputIDs      string
+	}{
+		{
+			name:         "Recipients accepting",
+			expectedCode: 200,
+			inputIDs:     "500,600",
+		},
+		{
+			name:          "Recipient not accepting",
+			expectedCode:  504,
+			expectedError: gin.H{"message": "recipient 700 not accepting messages", "status": "Gateway Timeout", "delivered": []interface{}{float64(500), float64(600)}},
+			inputIDs:      "500,600,700",
+		},
+		{
+			name:          "First recipient not accepting",
+			expectedCode:  504,
+			expectedError: gin.H{"message": "recipient 700 not accepting messages", "status": "Gateway Timeout", "delivered": nil},
+			inputIDs:      "700,500",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SendTimeout = 50 * time.Millisecond
+			// 700 is registered, but nothing is draining its channel
+			h.Clients = map[uint64]chan []byte{
+				500: make(chan []byte, 1),
+				600: make(chan []byte, 1),
+				700: make(chan []byte),
+			}
+
+			req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%s", tt.inputIDs), bytes.NewBufferString("Hi"))
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+
+			start := time.Now()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+		})
+	}
+}
+
+func TestHub_sendMessageFrom(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		inputIDs      string
+		from          string
+		received      map[uint64]bool
+	}{
+		{
+			name:         "Sender in recipients",
+			expectedCode: 200,
+			inputIDs:     "500,600,700",
+			from:         "500",
+			received:     map[uint64]bool{500: false, 600: true, 700: true},
+		},
+		{
+			name:         "Sender not in recipients",
+			expectedCode: 200,
+			inputIDs:     "600",
+			from:         "500",
+			received:     map[uint64]bool{500: false, 600: true, 700: false},
+		},
+		{
+			name:          "from not uint64",
+			expectedCode:  400,
+			inputIDs:      "600",
+			from:          "notuint64",
+			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"notuint64\": invalid syntax", "status": "Bad Request"},
+			received:      map[uint64]bool{500: false, 600: false, 700: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.Clients = map[uint64]chan []byte{
+				500: make(chan []byte, 1),
+				600: make(chan []byte, 1),
+				700: make(chan []byte, 1),
+			}
+
+			req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%s&from=%s", tt.inputIDs, tt.from), bytes.NewBufferString("Hi"))
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+
+			for id, expected := range tt.received {
+				select {
+				case b := <-h.Clients[id]:
+					require.True(t, expected, "%d received a message", id)
+
+					var msg types.SendingMessage
+					require.NoError(t, json.Unmarshal(b, &msg))
+					assert.Equal(t, uint64(500), msg.Sender)
+					assert.Equal(t, []byte("Hi"), msg.Data)
+				default:
+					require.False(t, expected, "%d didn't receive a message", id)
+				}
+			}
+		})
+	}
+}
+
+func TestHub_sendMessageLongQuery(t *testing.T) {
+	ids := make([]string, 3000)
+	for i := range ids {
+		ids[i] = strconv.Itoa(1000000 + i)
+	}
+
+	h := New()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%s", strings.Join(ids, ",")), bytes.NewBufferString("Hi"))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, 414, w.Code)
+
+	var errorBody gin.H
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+	assert.Equal(t, gin.H{"message": "Query string too long, send large recipient lists as a JSON body (Content-Type: application/json) instead", "status": "URI Too Long"}, errorBody)
+}
+
+func TestHub_sendMessageJSONBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		inputBody     string
+		received      map[uint64]bool
+		metadata      map[string]string
+	}{
+		{
+			name:         "Golden Path",
+			expectedCode: 200,
+			inputBody:    `{"Recipients": "500,600", "Data": "SGk="}`,
+			received:     map[uint64]bool{500: true, 600: true},
+		},
+		{
+			name:         "With metadata",
+			expectedCode: 200,
+			inputBody:    `{"Recipients": "500,600", "Data": "SGk=", "Metadata": {"trace-id": "abc"}}`,
+			received:     map[uint64]bool{500: true, 600: true},
+			metadata:     map[string]string{"trace-id": "abc"},
+		},
+		{
+			name:          "No recipients",
+			expectedCode:  400,
+			inputBody:     `{"Data": "SGk="}`,
+			expectedError: gin.H{"message": "IDs are required (csv)", "status": "Bad Request"},
+			received:      map[uint64]bool{500: false, 600: false},
+		},
+		{
+			name:          "Not JSON",
+			expectedCode:  400,
+			inputBody:     `Hi`,
+			expectedError: gin.H{"message": "invalid character 'H' looking for beginning of value", "status": "Bad Request"},
+			received:      map[uint64]bool{500: false, 600: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.Clients = map[uint64]chan []byte{
+				500: make(chan []byte, 1),
+				600: make(chan []byte, 1),
+			}
+
+			req, err := http.NewRequest("POST", "/send", bytes.NewBufferString(tt.inputBody))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+
+			for id, expected := range tt.received {
+				select {
+				case b := <-h.Clients[id]:
+					require.True(t, expected, "%d received a message", id)
+
+					var msg types.SendingMessage
+					require.NoError(t, json.Unmarshal(b, &msg))
+					assert.Equal(t, []byte("Hi"), msg.Data)
+					assert.Equal(t, tt.metadata, msg.Metadata)
+				default:
+					require.False(t, expected, "%d didn't receive a message", id)
+				}
+			}
+		})
+	}
+}
+
+func TestHub_listUsersGzip(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		expectGzip     bool
+	}{
+		{
+			name:           "Gzip requested",
+			acceptEncoding: "gzip",
+			expectGzip:     true,
+		},
+		{
+			name: "Gzip not requested",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			for id := uint64(1); id <= 1000; id++ {
+				h.SeedClients(id)
+			}
+
+			req, err := http.NewRequest("GET", "/users?id=0", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			require.Equal(t, 200, w.Code)
+
+			body := io.Reader(w.Body)
+			if tt.expectGzip {
+				require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+				zr, err := gzip.NewReader(w.Body)
+				require.NoError(t, err)
+				body = zr
+			} else {
+				require.Empty(t, w.Header().Get("Content-Encoding"))
+			}
+
+			var users types.ListResponse
+			require.NoError(t, json.NewDecoder(body).Decode(&users))
+			assert.Len(t, users.IDs, 1000)
+		})
+	}
+}
+
+func TestHub_sendMessageGzipBody(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte("Hi"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	// Tiny compressed, but more than MaxDataSize once it's decompressed
+	var bomb bytes.Buffer
+	zw = gzip.NewWriter(&bomb)
+	_, err = zw.Write(make([]byte, defaultMaxDataSize+1))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		inputBody     []byte
+	}{
+		{
+			name:         "Golden Path",
+			expectedCode: 200,
+			inputBody:    compressed.Bytes(),
+		},
+		{
+			name:          "Too large decompressed",
+			expectedCode:  413,
+			inputBody:     bomb.Bytes(),
+			expectedError: gin.H{"message": fmt.Sprintf("Maximum data size is %d bytes", defaultMaxDataSize), "status": "Request Entity Too Large"},
+		},
+		{
+			name:          "Not gzip",
+			expectedCode:  400,
+			inputBody:     []byte("Hi"),
+			expectedError: gin.H{"message": "Invalid gzip body: unexpected EOF", "status": "Bad Request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.Clients = map[uint64]chan []byte{
+				500: make(chan []byte, 1),
+			}
+
+			req, err := http.NewRequest("POST", "/send?ids=500", bytes.NewBuffer(tt.inputBody))
+			require.NoError(t, err)
+			req.Header.Set("Content-Encoding", "gzip")
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var msg types.SendingMessage
+			require.NoError(t, json.Unmarshal(<-h.Clients[500], &msg))
+			assert.Equal(t, []byte("Hi"), msg.Data)
+		})
+	}
+}
+
+func TestHub_SeedClients(t *testing.T) {
+	h := New()
+	h.SeedClients(300, 100, 200)
+	// Seeding an ID again leaves it as it was
+	ch := h.Clients[100]
+	h.SeedClients(100)
+	assert.Equal(t, ch, h.Clients[100])
+
+	req, err := http.NewRequest("GET", "/users?id=0", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var resp types.ListResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.ElementsMatch(t, []uint64{100, 200, 300}, resp.IDs)
+}
+
+// Run with -race to catch unlocked access to Clients
+func TestHub_concurrentRegisterAndSend(t *testing.T) {
+	const clients = 50
+
+	h := New()
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	recipient, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+	require.NoError(t, err)
+	defer recipient.Close()
+
+	received := make(chan struct{}, clients)
+	go func() {
+		for {
+			if _, _, err := recipient.ReadMessage(); err != nil {
+				return
+			}
+			received <- struct{}{}
+		}
+	}()
+
+	get := func(path string, object interface{}) error {
+		resp, err := http.Get(serv.URL + path)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return json.NewDecoder(resp.Body).Decode(object)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var id uint64
+			if err := get("/register", &id); err != nil {
+				t.Errorf("failed to register: %v", err)
+				return
+			}
+			if err := get(fmt.Sprintf("/identify?id=%d", id), &id); err != nil {
+				t.Errorf("failed to identify: %v", err)
+			}
+			var users types.ListResponse
+			if err := get(fmt.Sprintf("/users?id=%d", id), &users); err != nil {
+				t.Errorf("failed to list users: %v", err)
+			}
+
+			resp, err := http.Post(fmt.Sprintf("%s/send?ids=500&from=%d", serv.URL, id), "application/octet-stream", strings.NewReader("Hi"))
+			if err != nil {
+				t.Errorf("failed to send: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("send responded %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < clients; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d messages were received", i, clients)
+		}
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	assert.Len(t, h.Clients, clients+1)
+}
@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// IDGenerator is used by register to produce candidate IDs for clients that don't supply their own
+type IDGenerator interface {
+	NextID() uint64
+}
+
+// validateID checks id against the IDValidator, if there is one
+func (h *Hub) validateID(id uint64) error {
+	if h.IDValidator == nil {
+		return nil
+	}
+	return h.IDValidator(id)
+}
+
+// RandomIDGenerator hands out uniformly random IDs, relying on register to retry on the rare collision
+type RandomIDGenerator struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomIDGenerator creates a RandomIDGenerator seeded from the current time
+func NewRandomIDGenerator() *RandomIDGenerator {
+	return &RandomIDGenerator{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// NextID returns a random uint64
+func (g *RandomIDGenerator) NextID() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.rnd.Uint64()
+}
+
+// counterMultiplier is odd, so multiplying by it is a bijection on uint32 and scrambles the counter without collisions
+const counterMultiplier = 0x9E3779B1
+
+// CounterIDGenerator combines a monotonic counter (low 32 bits) with random high bits.
+// The counter guarantees no two IDs it hands out collide until it wraps after 2^32 IDs,
+// while the scrambled counter and random high bits keep IDs from being guessable or sequential.
+type CounterIDGenerator struct {
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	counter uint32
+}
+
+// NewCounterIDGenerator creates a CounterIDGenerator starting from a random counter offset
+func NewCounterIDGenerator() *CounterIDGenerator {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &CounterIDGenerator{rnd: r, counter: r.Uint32()}
+}
+
+// NextID returns the next ID, unique amongst all IDs previously returned by this generator
+func (g *CounterIDGenerator) NextID() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.counter++
+	return uint64(g.rnd.Uint32())<<32 | uint64(g.counter*counterMultiplier)
+}
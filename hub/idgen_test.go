@@ -0,0 +1,163 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingGenerator wraps an IDGenerator, recording how many IDs were asked for
+type countingGenerator struct {
+	IDGenerator
+	calls int
+}
+
+func (g *countingGenerator) NextID() uint64 {
+	g.calls++
+	return g.IDGenerator.NextID()
+}
+
+func TestCounterIDGenerator_NextID(t *testing.T) {
+	g := NewCounterIDGenerator()
+
+	seen := make(map[uint64]struct{})
+	var previous uint64
+	for i := 0; i < 100000; i++ {
+		id := g.NextID()
+
+		_, exists := seen[id]
+		require.False(t, exists, "ID %d handed out twice", id)
+		seen[id] = struct{}{}
+
+		// IDs shouldn't simply count upwards
+		require.NotEqual(t, previous+1, id)
+		previous = id
+	}
+}
+
+func TestHub_registerNoRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator IDGenerator
+		clients   int
+	}{
+		{
+			name:      "Counter generator",
+			generator: NewCounterIDGenerator(),
+			clients:   2000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			counter := &countingGenerator{IDGenerator: tt.generator}
+			h.IDGenerator = counter
+
+			for i := 0; i < tt.clients; i++ {
+				req, err := http.NewRequest("GET", "/register", nil)
+				require.NoError(t, err)
+
+				w := httptest.NewRecorder()
+
+				h.Router.ServeHTTP(w, req)
+
+				require.Equal(t, 200, w.Code)
+			}
+
+			assert.Equal(t, tt.clients, len(h.Clients))
+			// One generated ID per registration means the collision retry loop never ran
+			assert.Equal(t, tt.clients, counter.calls)
+		})
+	}
+}
+
+// sequenceGenerator hands out ids in order
+type sequenceGenerator struct {
+	ids []uint64
+}
+
+func (g *sequenceGenerator) NextID() uint64 {
+	id := g.ids[0]
+	g.ids = g.ids[1:]
+	return id
+}
+
+func TestHub_registerCollision(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+	// The first candidate is taken, so the second is used
+	h.IDGenerator = &sequenceGenerator{ids: []uint64{500, 600}}
+
+	req, err := http.NewRequest("GET", "/register", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "600", w.Body.String())
+	assert.True(t, h.idInUse(500))
+	assert.True(t, h.idInUse(600))
+	assert.False(t, h.idInUse(700))
+}
+
+func TestHub_IDValidator(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		expectedCode  int
+		expectedBody  string
+		expectedError gin.H
+	}{
+		{
+			name:         "Golden Path",
+			query:        "id=1001",
+			expectedCode: 200,
+			expectedBody: "1001",
+		},
+		{
+			name:          "Below the reserved range",
+			query:         "id=1000",
+			expectedCode:  400,
+			expectedError: gin.H{"status": "Bad Request", "message": "IDs must be above 1000"},
+		},
+		{
+			name:         "Generated IDs are generated again until valid",
+			expectedCode: 200,
+			expectedBody: "2000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.IDGenerator = &sequenceGenerator{ids: []uint64{5, 1000, 2000}}
+			h.IDValidator = func(id uint64) error {
+				if id <= 1000 {
+					return errors.New("IDs must be above 1000")
+				}
+				return nil
+			}
+
+			req, err := http.NewRequest("GET", "/register?"+tt.query, nil)
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				assert.False(t, h.idInUse(1000))
+				return
+			}
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validPublicKey checks the optional "pubkey" query is base64 (raw URL encoding), responding with an error and returning false if not.
+// The hub never interprets the key, it's only held so peers can encrypt to its owner.
+func validPublicKey(c *gin.Context) bool {
+	if c.Query("pubkey") == "" {
+		return true
+	}
+
+	if _, err := base64.RawURLEncoding.DecodeString(c.Query("pubkey")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "pubkey must be raw URL base64 encoded"})
+		return false
+	}
+	return true
+}
+
+// publicKey takes a query of an ID, returning back the public key that client registered with
+func (h *Hub) publicKey(c *gin.Context) {
+	if c.Query("id") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID is required"})
+		return
+	}
+
+	parsedID, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return
+	}
+
+	h.Lock()
+	key, exists := h.publicKeys[parsedID]
+	h.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"status": "Not Found", "message": "ID has no public key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/ratelimit"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// ipLimiter is the limiter of a single IP, along with when it was last used
+type ipLimiter struct {
+	limiter  *ratelimit.Limiter
+	lastSeen time.Time
+}
+
+// limitRegistrations is middleware allowing each client IP up to RegistrationsPerMinute registrations, responding 429 beyond that
+func (h *Hub) limitRegistrations(c *gin.Context) {
+	if h.RegistrationsPerMinute <= 0 {
+		c.Next()
+		return
+	}
+
+	now := h.Clock.Now()
+	ip := c.ClientIP()
+
+	h.Lock()
+	l, exists := h.registrationLimiters[ip]
+	if !exists {
+		// A limiter idle for a minute has refilled, so is no different to a new one and can be forgotten
+		for other, idle := range h.registrationLimiters {
+			if now.Sub(idle.lastSeen) > time.Minute {
+				delete(h.registrationLimiters, other)
+			}
+		}
+
+		l = &ipLimiter{limiter: ratelimit.New(float64(h.RegistrationsPerMinute)/60, h.RegistrationsPerMinute)}
+		h.registrationLimiters[ip] = l
+	}
+	l.lastSeen = now
+	h.Unlock()
+
+	if !l.limiter.Allow() {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"status": "Too Many Requests", "message": "Too many registrations, try again later"})
+		return
+	}
+
+	c.Next()
+}
+
+// reserveConnection counts a connection being opened by id, responding 429 and returning false if it already has
+// MaxConnectionsPerClient. The reservation is released when the device disconnects, or by releaseConnection if it never connects.
+func (h *Hub) reserveConnection(c *gin.Context, id uint64) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.MaxConnectionsPerClient > 0 && h.connections[id] >= h.MaxConnectionsPerClient {
+		c.JSON(http.StatusTooManyRequests, gin.H{"status": "Too Many Requests", "message": "Too many connections for this ID"})
+		return false
+	}
+	h.connections[id]++
+	return true
+}
+
+// releaseConnection gives back a connection reserved by reserveConnection
+func (h *Hub) releaseConnection(id uint64) {
+	h.Lock()
+	defer h.Unlock()
+	h.releaseConnectionLocked(id)
+}
+
+// releaseConnectionLocked is releaseConnection for callers already holding the lock
+func (h *Hub) releaseConnectionLocked(id uint64) {
+	h.connections[id]--
+	if h.connections[id] <= 0 {
+		delete(h.connections, id)
+	}
+}
+
+// maxChunks is how many chunks of MaxDataSize a message of MaxMessageSize is split into, 0 meaning there's no limit
+func (h *Hub) maxChunks() int {
+	if h.MaxMessageSize <= 0 || h.MaxDataSize <= 0 {
+		return 0
+	}
+	return (h.MaxMessageSize + h.MaxDataSize - 1) / h.MaxDataSize
+}
+
+// validChunk reports whether msg, if it's a chunk of a split message, has an index within the count of chunks it
+// claims, and a count within maxChunks
+func (h *Hub) validChunk(msg types.SendingMessage) bool {
+	if msg.ChunkCount == 0 {
+		return msg.ChunkIndex == 0
+	}
+	if msg.ChunkCount < 0 || msg.ChunkIndex < 0 || msg.ChunkIndex >= msg.ChunkCount {
+		return false
+	}
+	return h.maxChunks() == 0 || msg.ChunkCount <= h.maxChunks()
+}
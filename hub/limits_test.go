@@ -0,0 +1,220 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerFrom runs a GET against /register as if it came from ip
+func registerFrom(t *testing.T, h *Hub, ip string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/register", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = ip + ":1234"
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHub_RegistrationsPerMinute(t *testing.T) {
+	h := New()
+	h.RegistrationsPerMinute = 3
+
+	for i := 0; i < h.RegistrationsPerMinute; i++ {
+		require.Equal(t, 200, registerFrom(t, h, "10.0.0.1").Code)
+	}
+
+	// The next registration from the same IP is throttled
+	w := registerFrom(t, h, "10.0.0.1")
+	assert.Equal(t, 429, w.Code)
+
+	var errorBody gin.H
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+	assert.Equal(t, gin.H{"message": "Too many registrations, try again later", "status": "Too Many Requests"}, errorBody)
+	assert.Len(t, h.Clients, 3)
+
+	// Other IPs aren't affected
+	assert.Equal(t, 200, registerFrom(t, h, "10.0.0.2").Code)
+}
+
+func TestHub_RegistrationsUnlimited(t *testing.T) {
+	h := New()
+
+	for i := 0; i < 100; i++ {
+		require.Equal(t, 200, registerFrom(t, h, "10.0.0.1").Code)
+	}
+}
+
+func TestHub_MaxConnectionsPerClient(t *testing.T) {
+	h := New()
+	h.MaxConnectionsPerClient = 2
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://"))
+
+	first, _, err := websocket.DefaultDialer.Dial(address, nil)
+	require.NoError(t, err)
+	second, _, err := websocket.DefaultDialer.Dial(address, nil)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// A third connection for the same ID is refused
+	_, resp, err := websocket.DefaultDialer.Dial(address, nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Closing one frees up a slot
+	first.Close()
+	assert.Eventually(t, func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(address, nil)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHub_websocketLimits(t *testing.T) {
+	tests := []struct {
+		name            string
+		recipients      int
+		size            int
+		chunkIndex      int
+		chunkCount      int
+		unlimited       bool
+		expectedDropped string
+	}{
+		{
+			name:       "Golden Path",
+			recipients: defaultMaxRecipients,
+			size:       10,
+		},
+		{
+			name:            "Too many recipients",
+			recipients:      300,
+			size:            10,
+			expectedDropped: "Message dropped, maximum number of clients to send messages is 255",
+		},
+		{
+			name:            "Data too large",
+			recipients:      1,
+			size:            defaultMaxDataSize + 1,
+			expectedDropped: "Message dropped, maximum data size is 1024000 bytes",
+		},
+		{
+			name:            "Too many chunks",
+			recipients:      1,
+			size:            10,
+			chunkCount:      1 << 40,
+			expectedDropped: "Message dropped, chunk 0 of 1099511627776 is invalid, messages can be split into at most 100 chunks",
+		},
+		{
+			name:            "Chunk outside its message",
+			recipients:      1,
+			size:            10,
+			chunkIndex:      3,
+			chunkCount:      3,
+			expectedDropped: "Message dropped, chunk 3 of 3 is invalid, messages can be split into at most 100 chunks",
+		},
+		{
+			name:       "No recipient limit",
+			recipients: 300,
+			size:       10,
+			unlimited:  true,
+		},
+		{
+			name:       "No data size limit",
+			recipients: 1,
+			size:       defaultMaxDataSize + 1,
+			unlimited:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			if tt.unlimited {
+				h.MaxRecipients = 0
+				h.MaxDataSize = 0
+			}
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			var recipients []string
+			for id := uint64(1); id <= uint64(tt.recipients); id++ {
+				h.SeedClients(id)
+				recipients = append(recipients, fmt.Sprint(id))
+			}
+
+			h.SeedClients(1000)
+			sender := dialAs(t, serv, 1000)
+			defer sender.Close()
+			writeFrame(t, sender, types.SendingMessage{Recipients: strings.Join(recipients, ","), Data: make([]byte, tt.size), FileID: "file", ChunkIndex: tt.chunkIndex, ChunkCount: tt.chunkCount})
+
+			if tt.expectedDropped == "" {
+				require.Eventually(t, func() bool { return atomic.LoadUint64(&h.counters.messages) == uint64(tt.recipients) }, 5*time.Second, 10*time.Millisecond)
+				return
+			}
+
+			var msg types.SendingMessage
+			require.NoError(t, sender.SetReadDeadline(time.Now().Add(5*time.Second)))
+			require.NoError(t, sender.ReadJSON(&msg))
+			assert.True(t, msg.System)
+			assert.Equal(t, tt.expectedDropped, string(msg.Data))
+
+			// Frames are handled in order, so the dropped one was done with before the system message was sent
+			for id := uint64(1); id <= uint64(tt.recipients); id++ {
+				ch, _ := h.getClient(id)
+				assert.Len(t, ch, 0, "recipient %d was delivered the message", id)
+			}
+		})
+	}
+}
+
+func TestHub_ReadLimits(t *testing.T) {
+	h := New()
+	h.MaxDataSize = 10
+	h.MaxRecipients = 1
+	h.SeedClients(500, 1000)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// Within the data and recipient limits, but padded past anything a message within them could take up
+	oversized := types.SendingMessage{Recipients: "500", Data: []byte("Hi"), Metadata: map[string]string{"padding": strings.Repeat("x", int(h.maxEnvelopeSize()))}}
+
+	body, err := json.Marshal(oversized)
+	require.NoError(t, err)
+	req, err := http.NewRequest("POST", "/send", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// The websocket is closed rather than reading the frame
+	sender := dialAs(t, serv, 1000)
+	defer sender.Close()
+	writeFrame(t, sender, oversized)
+	require.NoError(t, sender.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err = sender.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "unexpected error %v", err)
+
+	ch, _ := h.getClient(500)
+	assert.Len(t, ch, 0)
+}
@@ -0,0 +1,29 @@
+package hub
+
+import "log"
+
+// Logger is what the hub logs through, so it can be plugged into a structured logger such as zap or logrus.
+// Messages carry their fields as key=value pairs after the text, e.g. client=500 size=12.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StdLogger is the default Logger, writing to the standard library's logger with the level as a prefix
+type StdLogger struct{}
+
+// Debugf logs a message at debug level
+func (StdLogger) Debugf(format string, args ...interface{}) {
+	log.Printf("DEBUG "+format, args...)
+}
+
+// Infof logs a message at info level
+func (StdLogger) Infof(format string, args ...interface{}) {
+	log.Printf("INFO "+format, args...)
+}
+
+// Errorf logs a message at error level
+func (StdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR "+format, args...)
+}
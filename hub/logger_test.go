@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// capturingLogger records every message logged, by level
+type capturingLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *capturingLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) { l.log("debug", format, args...) }
+func (l *capturingLogger) Infof(format string, args ...interface{})  { l.log("info", format, args...) }
+func (l *capturingLogger) Errorf(format string, args ...interface{}) { l.log("error", format, args...) }
+
+// logged reports whether an entry at level containing text has been logged
+func (l *capturingLogger) logged(level, text string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.entries {
+		if strings.HasPrefix(entry, level+" ") && strings.Contains(entry, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHub_Log(t *testing.T) {
+	logger := &capturingLogger{}
+	h := New()
+	h.Log = logger
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	conn := dialAs(t, serv, 500)
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return logger.logged("error", "client=500") }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"net/http"
+
+	"github.com/StephenBirch/message-delivery-system/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Reasons connections are closed for, the labels of hub_connections_closed_total
+const (
+	closeClient       = "client_closed" // The client closed the connection
+	closeReadError    = "read_error"    // Reading from the connection failed without the client closing it
+	closeWriteError   = "write_error"   // Writing to the connection failed
+	closeDeregistered = "deregistered"  // The client deregistered
+	closeRevoked      = "revoked"       // An admin revoked the client's token
+	closeThrottled    = "throttled"     // The client kept sending faster than the SenderRate
+	closeShutdown     = "shutdown"      // The hub shut down
+)
+
+// readCloseReason is the reason a websocket is closed after reading from it failed with err
+func readCloseReason(err error) string {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return closeClient
+	}
+	return closeReadError
+}
+
+// instruments are the hub's Prometheus metrics, served on /metrics
+type instruments struct {
+	registry  *metrics.Registry
+	connected *metrics.Gauge
+	relayed   *metrics.Counter
+	bytes     *metrics.Counter
+	failed    *metrics.Counter
+	expired   *metrics.Counter
+	latency   *metrics.Histogram
+	opened    *metrics.Counter
+	closed    *metrics.CounterVec
+}
+
+// newInstruments creates the hub's metrics, registered in the order they're served
+func newInstruments() *instruments {
+	r := metrics.NewRegistry()
+	return &instruments{
+		registry:  r,
+		connected: r.NewGauge("hub_connected_clients", "Clients with a websocket or event stream connected."),
+		relayed:   r.NewCounter("hub_messages_relayed_total", "Messages handed to their recipients."),
+		bytes:     r.NewCounter("hub_bytes_relayed_total", "Bytes of data handed to recipients."),
+		failed:    r.NewCounter("hub_delivery_failures_total", "Messages that couldn't be delivered to a recipient."),
+		expired:   r.NewCounter("hub_messages_expired_total", "Messages dropped for expiring before they were delivered."),
+		latency: r.NewHistogram("hub_delivery_latency_seconds",
+			"Time from the hub accepting a message for a recipient to handing it over, including waiting on the recipient.",
+			metrics.DefaultBuckets),
+		opened: r.NewCounter("hub_connections_opened_total", "Websocket and event stream connections opened."),
+		closed: r.NewCounterVec("hub_connections_closed_total", "Websocket and event stream connections closed, by reason.", "reason"),
+	}
+}
+
+// metrics serves the hub's metrics in the Prometheus text exposition format, for scraping
+func (h *Hub) metrics(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	h.instruments.registry.Write(c.Writer)
+}
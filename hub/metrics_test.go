@@ -0,0 +1,134 @@
+package hub
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/metrics"
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// churn returns the connection churn from /debug/state
+func churn(t *testing.T, h *Hub) types.DebugState {
+	req, err := http.NewRequest("GET", "/debug/state", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+h.AdminToken)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var state types.DebugState
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&state))
+	return state
+}
+
+func TestHub_ConnectionChurn(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+	h.SeedClients(500, 600, 700, 800)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	conns := make(map[uint64]*websocket.Conn)
+	for _, id := range []uint64{500, 600, 700, 800} {
+		conns[id] = dialAs(t, serv, id)
+		defer conns[id].Close()
+	}
+	require.Eventually(t, func() bool { return churn(t, h).Connections == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	// 500 says goodbye, 600 just drops and 700 deregisters, leaving 800 connected
+	require.NoError(t, conns[500].WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	conns[600].Close()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/deregister?id=700", nil)
+	require.NoError(t, err)
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	expected := types.DebugState{
+		Clients:     1,
+		Connected:   1,
+		Connections: 1,
+		Churn: types.ConnectionChurn{
+			Opened: 4,
+			Closed: map[string]uint64{closeClient: 1, closeReadError: 1, closeDeregistered: 1},
+		},
+	}
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual(expected, churn(t, h)) }, 5*time.Second, 10*time.Millisecond)
+
+	assert.Contains(t, scrape(t, h), `# HELP hub_connections_opened_total Websocket and event stream connections opened.
+# TYPE hub_connections_opened_total counter
+hub_connections_opened_total 4
+# HELP hub_connections_closed_total Websocket and event stream connections closed, by reason.
+# TYPE hub_connections_closed_total counter
+hub_connections_closed_total{reason="client_closed"} 1
+hub_connections_closed_total{reason="deregistered"} 1
+hub_connections_closed_total{reason="read_error"} 1
+`)
+}
+
+// scrape returns the body served on /metrics
+func scrape(t *testing.T, h *Hub) string {
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHub_Metrics(t *testing.T) {
+	h := New()
+	// Latency is timed by the hub's Clock, so a clock that stands still records none
+	h.Clock = newFakeClock()
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// Register a client, connect it and send it a message, then one to a client that isn't registered
+	req, err := http.NewRequest("GET", "/register?id=500", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	conn := dialAs(t, serv, 500)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return h.instruments.connected.Value() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, 200, sendTo(t, h, "500").Code)
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, 400, sendTo(t, h, "600").Code)
+
+	body := scrape(t, h)
+	for _, expected := range []string{
+		"hub_connected_clients 1\n",
+		"hub_messages_relayed_total 1\n",
+		"hub_bytes_relayed_total 4\n",
+		"hub_delivery_failures_total 1\n",
+		"hub_delivery_latency_seconds_count 1\n",
+		"hub_delivery_latency_seconds_sum 0\n",
+		"hub_connections_opened_total 1\n",
+	} {
+		assert.Contains(t, body, expected)
+	}
+
+	// The gauge goes back down once the client disconnects
+	conn.Close()
+	assert.Eventually(t, func() bool { return strings.Contains(scrape(t, h), "hub_connected_clients 0\n") }, 5*time.Second, 10*time.Millisecond)
+}
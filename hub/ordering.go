@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+var errEnqueueAborted = errors.New("gave up waiting to enqueue message")
+
+// counted reports whether enqueue already counted the message it failed with err, so it isn't counted again as a failure
+func counted(err error) bool {
+	return err == errDropped || err == errExpired
+}
+
+// sequencer is the single enqueue path of a recipient, lock is held while a message is stamped and handed over.
+// Under FairQueueing turns are taken instead.
+type sequencer struct {
+	lock  chan struct{}
+	turns *fairTurns
+	next  uint64
+	// recent holds the latest frames handed over, oldest first, for /resend. Guarded by the hub's lock rather than lock.
+	recent []resendFrame
+	// dropped is the latest Sequence dropped from recent, for being older than the ResendBufferAge or past the ResendBufferSize
+	dropped uint64
+	// senders holds the sender of each of the latest MessageIDs handed over, for processed acks, with senderOrder
+	// listing them oldest first. Guarded by the hub's lock rather than lock.
+	senders     map[string]uint64
+	senderOrder []string
+}
+
+// sequencer returns the sequencer of id, creating it if needed
+func (h *Hub) sequencer(id uint64) *sequencer {
+	h.Lock()
+	defer h.Unlock()
+
+	seq, exists := h.sequencers[id]
+	if !exists {
+		seq = &sequencer{lock: make(chan struct{}, 1), turns: newFairTurns()}
+		h.sequencers[id] = seq
+	}
+	return seq
+}
+
+// enqueue stamps msg with id's next Sequence then hands it to ch, returning errEnqueueAborted if abort closes first.
+// If ch's buffer is full the OverflowPolicy applies, see handOver, returning errDropped if msg is dropped for it.
+// Messages past their ExpiresAt aren't handed over, returning errExpired.
+// Enqueues for a recipient are serialized, so they receive messages in the order the hub accepted them.
+// Under FairQueueing, senders waiting on a busy recipient are let through in fair shares rather than first come first served.
+// The message is compressed first if the recipient asked for that, see encodeFor.
+// Messages the recipient's filter rejects are dropped without error, as the recipient asked for them not to be delivered.
+func (h *Hub) enqueue(id uint64, ch chan []byte, msg types.SendingMessage, abort <-chan struct{}) error {
+	if h.filtered(id, msg) {
+		return nil
+	}
+	accepted := h.Clock.Now()
+	seq := h.sequencer(id)
+
+	if h.FairQueueing {
+		if !seq.turns.acquire(msg.Sender, h.senderWeight(msg.Sender), abort) {
+			return errEnqueueAborted
+		}
+		defer seq.turns.release()
+	} else {
+		select {
+		case seq.lock <- struct{}{}:
+		case <-abort:
+			return errEnqueueAborted
+		}
+		defer func() { <-seq.lock }()
+	}
+
+	// Checked once it's msg's turn, as waiting for it may have taken past the expiry
+	if h.pastExpiry(msg) {
+		h.counters.expire()
+		return errExpired
+	}
+
+	msg.Sequence = seq.next + 1
+	frame, err := json.Marshal(h.encodeFor(id, msg))
+	if err != nil {
+		return err
+	}
+
+	handed, err := h.handOver(id, ch, frame, abort)
+	if err != nil || !handed {
+		return err
+	}
+	seq.next++
+	h.remember(seq, msg.Sequence, frame)
+	if msg.MessageID != "" && msg.Sender != 0 && !msg.Ack && !msg.System {
+		h.handed(seq, msg.Sender, msg.MessageID)
+	}
+	h.instruments.latency.Observe(h.Clock.Now().Sub(accepted).Seconds())
+	return nil
+}
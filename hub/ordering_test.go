@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_enqueueOrdering(t *testing.T) {
+	const perSender = 50
+
+	h := New()
+	h.SeedClients(500, 600, 700)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	dial := func(id uint64) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%d", strings.TrimPrefix(serv.URL, "http://"), id), nil)
+		require.NoError(t, err)
+		return conn
+	}
+
+	recipient := dial(500)
+	defer recipient.Close()
+
+	// Both senders write to the recipient at the same time
+	for _, sender := range []uint64{600, 700} {
+		conn := dial(sender)
+		defer conn.Close()
+
+		go func(sender uint64, conn *websocket.Conn) {
+			for i := 0; i < perSender; i++ {
+				msg := types.SendingMessage{Recipients: "500", Data: []byte(fmt.Sprintf("%d-%d", sender, i)), Sequence: 1000}
+				b, err := json.Marshal(msg)
+				if err != nil {
+					t.Errorf("failed to marshal message: %v", err)
+					return
+				}
+				if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+					t.Errorf("failed to write message: %v", err)
+					return
+				}
+			}
+		}(sender, conn)
+	}
+
+	next := map[string]int{"600": 0, "700": 0}
+	for expectedSequence := uint64(1); expectedSequence <= 2*perSender; expectedSequence++ {
+		recipient.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, b, err := recipient.ReadMessage()
+		require.NoError(t, err)
+
+		var msg types.SendingMessage
+		require.NoError(t, json.Unmarshal(b, &msg))
+
+		// Sequences are stamped by the hub in the order it accepted the messages, ignoring what the sender set
+		assert.Equal(t, expectedSequence, msg.Sequence)
+
+		// Which means each sender's messages arrive in the order they were sent
+		parts := strings.SplitN(string(msg.Data), "-", 2)
+		require.Len(t, parts, 2)
+		assert.Equal(t, fmt.Sprintf("%s-%d", parts[0], next[parts[0]]), string(msg.Data))
+		next[parts[0]]++
+	}
+}
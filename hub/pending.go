@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// pendingMessage is a message the hub is holding for recipients, e.g. until it's due or they reconnect
+type pendingMessage struct {
+	sender     uint64
+	messageID  string
+	recipients map[uint64]struct{}
+}
+
+// holdPending records msg from sender as pending for each of ids until settlePending is called for them.
+// Only messages with a MessageID are recorded, as without one the sender has no way to tell them apart.
+func (h *Hub) holdPending(sender uint64, msg types.SendingMessage, ids []uint64) {
+	if msg.MessageID == "" {
+		return
+	}
+
+	p := &pendingMessage{sender: sender, messageID: msg.MessageID, recipients: make(map[uint64]struct{}, len(ids))}
+	for _, id := range ids {
+		p.recipients[id] = struct{}{}
+	}
+
+	h.Lock()
+	h.pending = append(h.pending, p)
+	h.Unlock()
+}
+
+// settlePending records that msg is no longer pending for id, whether it was delivered or given up on
+func (h *Hub) settlePending(msg types.SendingMessage, id uint64) {
+	if msg.MessageID == "" {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.settlePendingLocked(msg, id)
+}
+
+// settlePendingLocked is settlePending with the lock already held
+func (h *Hub) settlePendingLocked(msg types.SendingMessage, id uint64) {
+	for i, p := range h.pending {
+		if p.messageID != msg.MessageID {
+			continue
+		}
+		if _, exists := p.recipients[id]; !exists {
+			continue
+		}
+
+		delete(p.recipients, id)
+		if len(p.recipients) == 0 {
+			h.pending = append(h.pending[:i], h.pending[i+1:]...)
+		}
+		return
+	}
+}
+
+// listPending returns the messages the client in the "from" query sent that the hub is still holding, oldest first.
+// It takes either the AdminToken or a session token of that client as a bearer token.
+func (h *Hub) listPending(c *gin.Context) {
+	from, err := strconv.ParseUint(c.Query("from"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse ID"})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "message": "Admin or session token required"})
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	isAdmin := h.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) == 1
+	if id, exists := h.sessionTokens[token]; !isAdmin && (!exists || id != from) {
+		c.JSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Token doesn't belong to the sender"})
+		return
+	}
+
+	pending := []types.PendingMessage{}
+	for _, p := range h.pending {
+		if p.sender != from {
+			continue
+		}
+
+		recipients := make([]uint64, 0, len(p.recipients))
+		for id := range p.recipients {
+			recipients = append(recipients, id)
+		}
+		sort.Slice(recipients, func(i, j int) bool { return recipients[i] < recipients[j] })
+
+		pending = append(pending, types.PendingMessage{MessageID: p.messageID, Recipients: recipients})
+	}
+
+	c.JSON(http.StatusOK, pending)
+}
@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pendingRequest runs a GET against /pending for from with the given bearer token
+func pendingRequest(t *testing.T, h *Hub, from, token string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/pending?from="+from, nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+// pendingOf decodes the messages listed by a successful /pending response
+func pendingOf(t *testing.T, w *httptest.ResponseRecorder) []types.PendingMessage {
+	require.Equal(t, 200, w.Code)
+
+	var pending []types.PendingMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	return pending
+}
+
+func TestHub_listPending(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+	h.SchedulePolicy = ScheduleQueue
+	// 500 is registered but offline until later
+	h.SeedClients(400, 500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	senderConn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=400", address), nil)
+	require.NoError(t, err)
+	defer senderConn.Close()
+	senderToken := resp.Header.Get(types.SessionTokenHeader)
+
+	require.NoError(t, senderConn.WriteJSON(types.SendingMessage{
+		Recipients: "500",
+		Data:       []byte("later"),
+		MessageID:  "m1",
+		DeliverAt:  time.Now().Add(50 * time.Millisecond),
+	}))
+
+	expected := []types.PendingMessage{{MessageID: "m1", Recipients: []uint64{500}}}
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, pendingOf(t, pendingRequest(t, h, "400", senderToken)))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Falls due while 500 is offline, so is still held
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		name         string
+		from         string
+		token        string
+		expectedCode int
+		expected     []types.PendingMessage
+	}{
+		{
+			name:         "Sender",
+			from:         "400",
+			token:        senderToken,
+			expectedCode: 200,
+			expected:     expected,
+		},
+		{
+			name:         "Admin",
+			from:         "400",
+			token:        "secret",
+			expectedCode: 200,
+			expected:     expected,
+		},
+		{
+			name:         "Nothing pending from another client",
+			from:         "500",
+			token:        "secret",
+			expectedCode: 200,
+			expected:     []types.PendingMessage{},
+		},
+		{
+			name:         "Sender's token for another client",
+			from:         "500",
+			token:        senderToken,
+			expectedCode: 403,
+		},
+		{
+			name:         "No token",
+			from:         "400",
+			expectedCode: 401,
+		},
+		{
+			name:         "Bad ID",
+			from:         "abc",
+			token:        "secret",
+			expectedCode: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := pendingRequest(t, h, tt.from, tt.token)
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode == 200 {
+				assert.Equal(t, tt.expected, pendingOf(t, w))
+			}
+		})
+	}
+
+	// Once 500 connects and is delivered the message it's no longer pending
+	recipientConn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", address), nil)
+	require.NoError(t, err)
+	defer recipientConn.Close()
+
+	recipientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var received types.SendingMessage
+	require.NoError(t, recipientConn.ReadJSON(&received))
+	assert.Equal(t, "m1", received.MessageID)
+
+	assert.Eventually(t, func() bool {
+		return len(pendingOf(t, pendingRequest(t, h, "400", senderToken))) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
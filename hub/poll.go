@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPollWait = 30 * time.Second // How long /poll waits for a message when the client doesn't say
+	pollBatchSize   = 100              // Most messages a single /poll responds with
+)
+
+// poll is an alternative to /ws and /stream for receiving, where neither gets through, e.g. proxies that buffer responses.
+// It waits up to the "wait" query (30s by default) for a message for the client, responding with it and any others waiting
+// as soon as there is one, or with none once the wait is over. The client polls again for more.
+// Polls take messages straight from the client's channel, so they're refused while it has a connection getting them.
+// A polling client has no session, so like any disconnected client it's listed as offline, and scheduled messages that
+// fall due for it are held or dropped under the SchedulePolicy rather than polled, until it connects on /ws or /stream.
+func (h *Hub) poll(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse ID"})
+		return
+	}
+
+	wait := defaultPollWait
+	if c.Query("wait") != "" {
+		wait, err = time.ParseDuration(c.Query("wait"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return
+		}
+		if wait <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Wait must be positive"})
+			return
+		}
+	}
+
+	ch, registered := h.getClient(id)
+	if !registered {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return
+	}
+
+	if !h.authorized(c, id) {
+		return
+	}
+
+	h.Lock()
+	_, connected := h.sessions[id]
+	h.Unlock()
+	if connected {
+		c.JSON(http.StatusConflict, gin.H{"status": "Conflict", "message": "Client is connected, its messages are delivered to its connection"})
+		return
+	}
+	h.seen(id)
+
+	gone, registered := h.departure(id, ch)
+	if !registered {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return
+	}
+
+	resp := types.PollResponse{Messages: []json.RawMessage{}}
+	take := func(frame []byte) {
+		if !h.expired(id, frame) {
+			resp.Messages = append(resp.Messages, frame)
+		}
+		h.countBuffered(id, -1)
+	}
+
+	var took bool
+	select {
+	case frame := <-ch:
+		take(frame)
+		took = true
+	case <-h.Clock.After(wait):
+	case <-c.Request.Context().Done():
+	case <-gone:
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return
+	}
+
+	// Take whatever else is waiting without holding up the response
+	for waiting := took; waiting && len(resp.Messages) < pollBatchSize; {
+		select {
+		case frame := <-ch:
+			take(frame)
+		default:
+			waiting = false
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_poll(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		sends         int
+		connected     bool
+		expectedCode  int
+		expectedError gin.H
+		expectedData  []string
+	}{
+		{
+			name:         "Golden Path",
+			query:        "id=500&wait=1s",
+			sends:        2,
+			expectedCode: 200,
+			expectedData: []string{"data", "data"},
+		},
+		{
+			name:         "Nothing arrives in the wait",
+			query:        "id=500&wait=10ms",
+			expectedCode: 200,
+			expectedData: []string{},
+		},
+		{
+			name:          "Not registered",
+			query:         "id=700",
+			expectedCode:  400,
+			expectedError: gin.H{"status": "Bad Request", "message": "ID not registered"},
+		},
+		{
+			name:          "Bad wait",
+			query:         "id=500&wait=-1s",
+			expectedCode:  400,
+			expectedError: gin.H{"status": "Bad Request", "message": "Wait must be positive"},
+		},
+		{
+			name:          "Connected",
+			query:         "id=500",
+			connected:     true,
+			expectedCode:  409,
+			expectedError: gin.H{"status": "Conflict", "message": "Client is connected, its messages are delivered to its connection"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500)
+
+			if tt.connected {
+				serv := httptest.NewServer(h.Router)
+				defer serv.Close()
+				conn := dialAs(t, serv, 500)
+				defer conn.Close()
+			}
+			for i := 0; i < tt.sends; i++ {
+				require.Equal(t, 200, sendTo(t, h, "500").Code)
+			}
+
+			req, err := http.NewRequest("GET", fmt.Sprintf("/poll?%s", tt.query), nil)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			require.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var resp types.PollResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			data := []string{}
+			for i, raw := range resp.Messages {
+				var msg types.SendingMessage
+				require.NoError(t, json.Unmarshal(raw, &msg))
+				assert.Equal(t, uint64(i+1), msg.Sequence)
+				data = append(data, string(msg.Data))
+			}
+			assert.Equal(t, tt.expectedData, data)
+		})
+	}
+}
+
+func TestHub_pollWaits(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	polled := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "/poll?id=500", nil)
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		polled <- w
+	}()
+
+	// The poll is answered as soon as a message arrives, rather than at the end of its wait
+	require.Equal(t, 200, sendTo(t, h, "500").Code)
+	w := <-polled
+	require.Equal(t, 200, w.Code)
+
+	var resp types.PollResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Len(t, resp.Messages, 1)
+}
+
+func TestHub_pollScheduled(t *testing.T) {
+	h := New()
+	h.SchedulePolicy = ScheduleQueue
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// Falls due while 500 is only polling, so it's held for its next connection rather than polled
+	h.schedule(0, time.Now().Add(50*time.Millisecond), []uint64{500}, types.SendingMessage{Recipients: "500", Data: []byte("later")})
+	req, err := http.NewRequest("GET", "/poll?id=500&wait=200ms", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var resp types.PollResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Empty(t, resp.Messages)
+
+	conn := dialAs(t, serv, 500)
+	defer conn.Close()
+
+	var msg types.SendingMessage
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "later", string(msg.Data))
+}
@@ -0,0 +1,106 @@
+package hub
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// prefsClient parses the client ID from the path, checking the bearer session token belongs to it.
+// It responds with an error and returns false if not.
+func (h *Hub) prefsClient(c *gin.Context) (uint64, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse ID"})
+		return 0, false
+	}
+
+	tokenID, ok := h.sessionClient(c)
+	if !ok {
+		return 0, false
+	}
+	if tokenID != id {
+		c.JSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Token doesn't belong to the client"})
+		return 0, false
+	}
+	return id, true
+}
+
+// preferences gathers the settings the hub holds for id, the lock must be held
+func (h *Hub) preferences(id uint64) types.Preferences {
+	_, muted := h.muted[id]
+	return types.Preferences{
+		Name:        h.names[id],
+		Compress:    h.encodings[id],
+		Filter:      h.filters[id],
+		Muted:       muted,
+		MaxDataSize: h.maxDataSizes[id],
+	}
+}
+
+// getPreferences returns the settings the hub holds for the client in the path
+func (h *Hub) getPreferences(c *gin.Context) {
+	id, ok := h.prefsClient(c)
+	if !ok {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	c.JSON(http.StatusOK, h.preferences(id))
+}
+
+// updatePreferences replaces the settings the hub holds for the client in the path with those in the body, returning them
+func (h *Hub) updatePreferences(c *gin.Context) {
+	id, ok := h.prefsClient(c)
+	if !ok {
+		return
+	}
+
+	var prefs types.Preferences
+	if err := c.ShouldBindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse preferences"})
+		return
+	}
+	if prefs.Compress != "" && prefs.Compress != types.GzipEncoding {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unsupported compression, only " + types.GzipEncoding + " is available"})
+		return
+	}
+	if prefs.MaxDataSize < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "MaxDataSize can't be negative"})
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if prefs.Name != "" {
+		h.names[id] = prefs.Name
+	} else {
+		delete(h.names, id)
+	}
+	if prefs.Compress != "" {
+		h.encodings[id] = prefs.Compress
+	} else {
+		delete(h.encodings, id)
+	}
+	if len(prefs.Filter.Metadata) > 0 {
+		h.filters[id] = prefs.Filter
+	} else {
+		delete(h.filters, id)
+	}
+	if prefs.Muted {
+		h.muted[id] = struct{}{}
+	} else {
+		delete(h.muted, id)
+	}
+	if prefs.MaxDataSize > 0 {
+		h.maxDataSizes[id] = prefs.MaxDataSize
+	} else {
+		delete(h.maxDataSizes, id)
+	}
+
+	c.JSON(http.StatusOK, h.preferences(id))
+}
@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_updatePreferences(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		body             string
+		expectedCode     int
+		expectedResponse interface{}
+	}{
+		{
+			name:             "Valid",
+			path:             "/clients/500/prefs",
+			body:             `{"Name":"alice","Compress":"gzip","Filter":{"Metadata":{"type":"alert"}},"Muted":true,"MaxDataSize":10}`,
+			expectedCode:     200,
+			expectedResponse: types.Preferences{Name: "alice", Compress: "gzip", Filter: types.Filter{Metadata: map[string]string{"type": "alert"}}, Muted: true, MaxDataSize: 10},
+		},
+		{
+			name:             "Another client",
+			path:             "/clients/600/prefs",
+			body:             `{"Muted":true}`,
+			expectedCode:     403,
+			expectedResponse: gin.H{"status": "Forbidden", "message": "Token doesn't belong to the client"},
+		},
+		{
+			name:             "Invalid ID",
+			path:             "/clients/notuint64/prefs",
+			body:             `{"Muted":true}`,
+			expectedCode:     400,
+			expectedResponse: gin.H{"status": "Bad Request", "message": "Unable to parse ID"},
+		},
+		{
+			name:             "Unsupported compression",
+			path:             "/clients/500/prefs",
+			body:             `{"Compress":"br"}`,
+			expectedCode:     400,
+			expectedResponse: gin.H{"status": "Bad Request", "message": "Unsupported compression, only gzip is available"},
+		},
+		{
+			name:             "Negative MaxDataSize",
+			path:             "/clients/500/prefs",
+			body:             `{"MaxDataSize":-1}`,
+			expectedCode:     400,
+			expectedResponse: gin.H{"status": "Bad Request", "message": "MaxDataSize can't be negative"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500, 600)
+			h.sessionTokens["token"] = 500
+
+			req, err := http.NewRequest("PUT", tt.path, bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer token")
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if prefs, ok := tt.expectedResponse.(types.Preferences); ok {
+				var resp types.Preferences
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Equal(t, prefs, resp)
+				return
+			}
+			var resp gin.H
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, tt.expectedResponse, resp)
+		})
+	}
+}
+
+func TestHub_filteredByPreferences(t *testing.T) {
+	h := New()
+	h.maxDataSizes[500] = 5
+	h.muted[600] = struct{}{}
+
+	assert.False(t, h.filtered(500, types.SendingMessage{Data: []byte("small")}))
+	assert.True(t, h.filtered(500, types.SendingMessage{Data: []byte("too large")}))
+	assert.True(t, h.filtered(600, types.SendingMessage{Data: []byte("muted")}))
+	assert.False(t, h.filtered(600, types.SendingMessage{Data: []byte("shutting down"), System: true}))
+}
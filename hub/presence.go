@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"sort"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// presenceBufferSize is how many presence events a watcher can fall behind by before it's disconnected
+var presenceBufferSize = 64
+
+// watchPresence upgrades to a websocket streaming types.PresenceEvent, starting with a snapshot of the connected clients.
+// The snapshot is taken as the watcher is added, so every later join and leave follows it without gaps or repeats.
+func (h *Hub) watchPresence(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+
+	events := make(chan types.PresenceEvent, presenceBufferSize)
+
+	h.Lock()
+	snapshot := types.PresenceEvent{Type: types.PresenceSnapshot, Online: []uint64{}}
+	for id := range h.sessions {
+		snapshot.Online = append(snapshot.Online, id)
+	}
+	sort.Slice(snapshot.Online, func(i, j int) bool { return snapshot.Online[i] < snapshot.Online[j] })
+	events <- snapshot
+	h.presenceWatchers[events] = struct{}{}
+	h.Unlock()
+
+	// Nothing is expected from the watcher, reading just notices when it goes away
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				h.Lock()
+				h.removePresenceWatcher(events)
+				h.Unlock()
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer conn.Close()
+		for event := range events {
+			if err := conn.WriteJSON(event); err != nil {
+				h.Log.Errorf("Error writing presence event: %v", err)
+				h.Lock()
+				h.removePresenceWatcher(events)
+				h.Unlock()
+				return
+			}
+		}
+	}()
+}
+
+// notifyPresence sends event to every presence watcher, disconnecting any too far behind to take it. The lock must be held.
+func (h *Hub) notifyPresence(event types.PresenceEvent) {
+	for events := range h.presenceWatchers {
+		select {
+		case events <- event:
+		default:
+			h.Log.Infof("Presence watcher fell behind, disconnecting it")
+			h.removePresenceWatcher(events)
+		}
+	}
+}
+
+// removePresenceWatcher stops sending events to a watcher, which closes its connection. The lock must be held.
+func (h *Hub) removePresenceWatcher(events chan types.PresenceEvent) {
+	if _, watching := h.presenceWatchers[events]; watching {
+		delete(h.presenceWatchers, events)
+		close(events)
+	}
+}
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_watchPresence(t *testing.T) {
+	h := New()
+	h.SeedClients(500, 600, 700)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	dial := func(path string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s%s", address, path), nil)
+		require.NoError(t, err)
+		return conn
+	}
+
+	// 700 is registered but not connected, so isn't online
+	for _, id := range []uint64{600, 500} {
+		conn := dial(fmt.Sprintf("/ws?id=%d", id))
+		defer conn.Close()
+	}
+	require.Eventually(t, func() bool { return connectedDevices(h, 500) == 1 && connectedDevices(h, 600) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	watcher := dial("/presence")
+	defer watcher.Close()
+
+	next := func() types.PresenceEvent {
+		var event types.PresenceEvent
+		watcher.SetReadDeadline(time.Now().Add(5 * time.Second))
+		require.NoError(t, watcher.ReadJSON(&event))
+		return event
+	}
+
+	assert.Equal(t, types.PresenceEvent{Type: types.PresenceSnapshot, Online: []uint64{500, 600}}, next())
+
+	conn := dial("/ws?id=700")
+	assert.Equal(t, types.PresenceEvent{Type: types.PresenceJoin, ID: 700}, next())
+
+	conn.Close()
+	assert.Equal(t, types.PresenceEvent{Type: types.PresenceLeave, ID: 700}, next())
+
+	// Once the watcher goes away it's no longer sent events
+	watcher.Close()
+	assert.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return len(h.presenceWatchers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,55 @@
+package hub
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveClientIP is middleware replacing the request's RemoteAddr with the client's own address from X-Forwarded-For,
+// when it was forwarded by one of the TrustedProxies. c.ClientIP(), and so the logs and IP rate limits, then give the
+// client rather than the proxy. Without a trusted proxy X-Forwarded-For is ignored, as any client could have set it.
+func (h *Hub) resolveClientIP(c *gin.Context) {
+	peer, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil || !h.trustedProxy(peer) {
+		c.Next()
+		return
+	}
+
+	// Each proxy appends the address it received from, so the client is the rightmost that isn't a trusted proxy
+	forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		c.Request.RemoteAddr = net.JoinHostPort(ip, "0")
+		if !h.trustedProxy(ip) {
+			break
+		}
+	}
+
+	c.Next()
+}
+
+// trustedProxy reports whether ip is one of the TrustedProxies, given as IPs or CIDR ranges. Invalid entries match nothing.
+func (h *Hub) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, proxy := range h.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+			continue
+		}
+		if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
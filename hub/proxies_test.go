@@ -0,0 +1,88 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_TrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		expectedIP     string
+	}{
+		{
+			name:         "No trusted proxies",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: "203.0.113.5",
+			expectedIP:   "192.0.2.1",
+		},
+		{
+			name:           "Untrusted proxy",
+			trustedProxies: []string{"192.0.2.2"},
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   "203.0.113.5",
+			expectedIP:     "192.0.2.1",
+		},
+		{
+			name:           "Trusted proxy",
+			trustedProxies: []string{"192.0.2.1"},
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   "203.0.113.5",
+			expectedIP:     "203.0.113.5",
+		},
+		{
+			name:           "Trusted proxy without X-Forwarded-For",
+			trustedProxies: []string{"192.0.2.1"},
+			remoteAddr:     "192.0.2.1:1234",
+			expectedIP:     "192.0.2.1",
+		},
+		{
+			name:           "Chain of trusted proxies",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   "203.0.113.5, 10.1.1.1",
+			expectedIP:     "203.0.113.5",
+		},
+		{
+			name:           "Spoofed by the client",
+			trustedProxies: []string{"192.0.2.1"},
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   "198.51.100.7, 203.0.113.5",
+			expectedIP:     "203.0.113.5",
+		},
+		{
+			name:           "Invalid entry",
+			trustedProxies: []string{"not an ip", "192.0.2.1"},
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   "203.0.113.5",
+			expectedIP:     "203.0.113.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.TrustedProxies = tt.trustedProxies
+			h.Router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			req, err := http.NewRequest("GET", "/ip", nil)
+			require.NoError(t, err)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedIP, w.Body.String())
+		})
+	}
+}
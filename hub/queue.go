@@ -0,0 +1,187 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+)
+
+var errDropped = errors.New("recipient's buffer is full, message dropped")
+
+// OverflowPolicy decides what happens to a message for a client whose queue is full, see Hub.QueueSize and Hub.QueueBytes,
+// or whose channel buffer is full, see Hub.ClientBufferSize
+type OverflowPolicy int
+
+const (
+	// Block stops taking messages for the client until its queue has room, holding up senders
+	Block OverflowPolicy = iota
+	// DropOldest evicts the oldest of the lowest priority messages to make room
+	DropOldest
+	// DropNewest drops the newest of the lowest priority messages, which may be the one arriving
+	DropNewest
+)
+
+// handOver puts frame on the client's channel ch. If ch's buffer is full, Block waits for room (returning errEnqueueAborted
+// if abort closes first, or errRecipientGone if the client is deregistered, as nothing will make room then), while
+// DropNewest drops frame, returning errDropped, and DropOldest evicts the oldest frame in ch to make room for it. Dropped
+// frames are reported as failures and evicted bytes. handed is false if frame itself was dropped.
+func (h *Hub) handOver(id uint64, ch chan []byte, frame []byte, abort <-chan struct{}) (handed bool, err error) {
+	// Counted before it's handed over, so the pump can't deliver it first
+	h.countBuffered(id, 1)
+
+	if h.OverflowPolicy == Block {
+		gone, registered := h.departure(id, ch)
+		if !registered {
+			h.countBuffered(id, -1)
+			return false, errRecipientGone
+		}
+		select {
+		case ch <- frame:
+			return true, nil
+		case <-abort:
+			h.countBuffered(id, -1)
+			return false, errEnqueueAborted
+		case <-gone:
+			// Shutdown aborts sends before it forgets clients, so that's the reason when both have happened
+			select {
+			case <-h.stopSends:
+				return false, errEnqueueAborted
+			default:
+				return false, errRecipientGone
+			}
+		}
+	}
+
+	for {
+		select {
+		case ch <- frame:
+			return true, nil
+		default:
+		}
+
+		dropped := frame
+		if h.OverflowPolicy == DropOldest {
+			select {
+			case dropped = <-ch:
+			default:
+				// Drained in the meantime, so there's room now
+				continue
+			}
+		}
+
+		h.Log.Infof("Channel is full, dropped a message client=%d", id)
+		h.counters.failed()
+		h.evicted(id, len(dropped))
+		h.countBuffered(id, -1)
+		if h.OverflowPolicy == DropNewest {
+			return false, errDropped
+		}
+	}
+}
+
+// queuedFrame is a framed message waiting in a client's queue
+type queuedFrame struct {
+	frame    []byte
+	priority int
+}
+
+// frameQueue holds the messages of a connected client waiting to be written to its devices, in the order they arrived.
+// It's full once it holds size frames or maxBytes bytes of them, whichever comes first, a limit of 0 being no limit.
+type frameQueue struct {
+	mu       sync.Mutex
+	frames   []queuedFrame
+	bytes    int // Total length of the queued frames
+	size     int
+	maxBytes int
+	policy   OverflowPolicy
+	ready    chan struct{} // Signalled when a frame is pushed
+	room     chan struct{} // Signalled when a frame is popped
+}
+
+func newFrameQueue(size, maxBytes int, policy OverflowPolicy) *frameQueue {
+	return &frameQueue{
+		size:     size,
+		maxBytes: maxBytes,
+		policy:   policy,
+		ready:    make(chan struct{}, 1),
+		room:     make(chan struct{}, 1),
+	}
+}
+
+// overflows reports whether adding f would take the queue past either of its limits
+func (q *frameQueue) overflows(f queuedFrame) bool {
+	return (q.size > 0 && len(q.frames) >= q.size) || (q.maxBytes > 0 && q.bytes+len(f.frame) > q.maxBytes)
+}
+
+// signal wakes up whoever is waiting on ch, if anyone
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push adds f to the back of the queue. If the queue is full the policy either drops frames until f fits, which are
+// returned as evicted (and may include f itself), or waits for room. pushed is false if done closed while waiting.
+func (q *frameQueue) push(f queuedFrame, done <-chan struct{}) (evicted []queuedFrame, pushed bool) {
+	q.mu.Lock()
+	// An empty queue always takes f under Block, even if it's over the byte budget alone, otherwise it would wait forever
+	for len(q.frames) > 0 && q.overflows(f) && q.policy == Block {
+		q.mu.Unlock()
+		select {
+		case <-q.room:
+		case <-done:
+			return nil, false
+		}
+		q.mu.Lock()
+	}
+	defer q.mu.Unlock()
+	defer signal(q.ready)
+
+	for q.overflows(f) && q.policy != Block {
+		// The victim is picked from the queued frames and f, oldest first, among those of the lowest priority
+		victim := -1
+		candidates := append(append([]queuedFrame(nil), q.frames...), f)
+		for i, candidate := range candidates {
+			switch {
+			case victim == -1, candidate.priority < candidates[victim].priority:
+				victim = i
+			case candidate.priority == candidates[victim].priority && q.policy == DropNewest:
+				victim = i
+			}
+		}
+
+		evicted = append(evicted, candidates[victim])
+		if victim == len(q.frames) {
+			return evicted, true
+		}
+		q.bytes -= len(q.frames[victim].frame)
+		q.frames = append(q.frames[:victim], q.frames[victim+1:]...)
+	}
+
+	q.frames = append(q.frames, f)
+	q.bytes += len(f.frame)
+	return evicted, true
+}
+
+// pop removes and returns the frame at the front of the queue, waiting for one if it's empty. ok is false if done closed first.
+func (q *frameQueue) pop(done <-chan struct{}) (frame []byte, ok bool) {
+	for {
+		q.mu.Lock()
+		if len(q.frames) > 0 {
+			f := q.frames[0]
+			q.frames = q.frames[1:]
+			q.bytes -= len(f.frame)
+			q.mu.Unlock()
+
+			signal(q.room)
+			return f.frame, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-done:
+			return nil, false
+		}
+	}
+}
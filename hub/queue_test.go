@@ -0,0 +1,334 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// framesOf returns the data of each queued frame, in order
+func framesOf(q *frameQueue) []string {
+	var frames []string
+	for _, f := range q.frames {
+		frames = append(frames, string(f.frame))
+	}
+	return frames
+}
+
+func TestFrameQueue_push(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          OverflowPolicy
+		queued          []queuedFrame
+		push            queuedFrame
+		expectedEvicted string
+		expectedFrames  []string
+	}{
+		{
+			name:            "DropOldest evicts the oldest low priority message",
+			policy:          DropOldest,
+			queued:          []queuedFrame{{[]byte("high1"), 5}, {[]byte("low1"), 0}, {[]byte("low2"), 0}},
+			push:            queuedFrame{[]byte("high2"), 5},
+			expectedEvicted: "low1",
+			expectedFrames:  []string{"high1", "low2", "high2"},
+		},
+		{
+			name:            "DropNewest evicts the newest low priority message",
+			policy:          DropNewest,
+			queued:          []queuedFrame{{[]byte("high1"), 5}, {[]byte("low1"), 0}, {[]byte("low2"), 0}},
+			push:            queuedFrame{[]byte("high2"), 5},
+			expectedEvicted: "low2",
+			expectedFrames:  []string{"high1", "low1", "high2"},
+		},
+		{
+			name:            "DropOldest drops an incoming message lower than everything queued",
+			policy:          DropOldest,
+			queued:          []queuedFrame{{[]byte("high1"), 5}, {[]byte("high2"), 5}, {[]byte("high3"), 5}},
+			push:            queuedFrame{[]byte("low1"), 0},
+			expectedEvicted: "low1",
+			expectedFrames:  []string{"high1", "high2", "high3"},
+		},
+		{
+			name:            "DropNewest drops the incoming message on a tie",
+			policy:          DropNewest,
+			queued:          []queuedFrame{{[]byte("low1"), 0}, {[]byte("low2"), 0}, {[]byte("low3"), 0}},
+			push:            queuedFrame{[]byte("low4"), 0},
+			expectedEvicted: "low4",
+			expectedFrames:  []string{"low1", "low2", "low3"},
+		},
+		{
+			name:            "DropOldest without priorities",
+			policy:          DropOldest,
+			queued:          []queuedFrame{{[]byte("1"), 0}, {[]byte("2"), 0}, {[]byte("3"), 0}},
+			push:            queuedFrame{[]byte("4"), 0},
+			expectedEvicted: "1",
+			expectedFrames:  []string{"2", "3", "4"},
+		},
+		{
+			name:           "Room to spare",
+			policy:         DropOldest,
+			queued:         []queuedFrame{{[]byte("1"), 0}},
+			push:           queuedFrame{[]byte("2"), 0},
+			expectedFrames: []string{"1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newFrameQueue(3, 0, tt.policy)
+			q.frames = tt.queued
+
+			evicted, pushed := q.push(tt.push, nil)
+			require.True(t, pushed)
+
+			if tt.expectedEvicted == "" {
+				assert.Empty(t, evicted)
+			} else {
+				require.Len(t, evicted, 1)
+				assert.Equal(t, tt.expectedEvicted, string(evicted[0].frame))
+			}
+			assert.Equal(t, tt.expectedFrames, framesOf(q))
+		})
+	}
+}
+
+func TestFrameQueue_maxBytes(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          OverflowPolicy
+		push            []string
+		expectedEvicted []string
+		expectedFrames  []string
+	}{
+		{
+			name:            "DropOldest evicts until a large message fits",
+			policy:          DropOldest,
+			push:            []string{"aaa", "bbb", "ccc", "dddddddd"},
+			expectedEvicted: []string{"aaa", "bbb"},
+			expectedFrames:  []string{"ccc", "dddddddd"},
+		},
+		{
+			name:            "DropNewest drops the large message",
+			policy:          DropNewest,
+			push:            []string{"aaa", "bbb", "ccc", "dddddddd"},
+			expectedEvicted: []string{"dddddddd"},
+			expectedFrames:  []string{"aaa", "bbb", "ccc"},
+		},
+		{
+			name:            "A message over the whole budget is dropped",
+			policy:          DropOldest,
+			push:            []string{"aaa", "bbbbbbbbbbbbbbbb"},
+			expectedEvicted: []string{"aaa", "bbbbbbbbbbbbbbbb"},
+		},
+		{
+			name:           "Within budget",
+			policy:         DropOldest,
+			push:           []string{"aaa", "bbb", "ccc"},
+			expectedFrames: []string{"aaa", "bbb", "ccc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newFrameQueue(0, 12, tt.policy)
+
+			var evicted []string
+			for _, frame := range tt.push {
+				dropped, pushed := q.push(queuedFrame{[]byte(frame), 0}, nil)
+				require.True(t, pushed)
+				for _, f := range dropped {
+					evicted = append(evicted, string(f.frame))
+				}
+			}
+			assert.Equal(t, tt.expectedEvicted, evicted)
+			assert.Equal(t, tt.expectedFrames, framesOf(q))
+
+			// The byte count tracks what's queued, through pops too
+			queued := 0
+			for _, frame := range tt.expectedFrames {
+				queued += len(frame)
+			}
+			assert.Equal(t, queued, q.bytes)
+			for range tt.expectedFrames {
+				_, ok := q.pop(nil)
+				require.True(t, ok)
+			}
+			assert.Equal(t, 0, q.bytes)
+		})
+	}
+}
+
+func TestHub_QueueBytes(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+	// Holds delivery up after the first message, so the rest back up in the queue
+	h.RecipientRate = 2
+	h.RecipientBurst = 1
+
+	// Each message frames to the same size, up to 9 of them keep a single digit Sequence
+	frame, err := json.Marshal(types.SendingMessage{Recipients: "500", Data: []byte("payload"), Sequence: 1})
+	require.NoError(t, err)
+	h.QueueBytes = 3 * len(frame)
+	h.OverflowPolicy = DropOldest
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 9; i++ {
+		require.NoError(t, h.enqueue(500, h.Clients[500], types.SendingMessage{Recipients: "500", Data: []byte("payload")}, nil))
+	}
+
+	// The first is delivered and another waits on the rate, leaving room for 3 of the rest
+	assert.Eventually(t, func() bool {
+		return getStats(t, h).Failures >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stats := getStats(t, h)
+	assert.Equal(t, map[uint64]uint64{500: stats.Failures * uint64(len(frame))}, stats.EvictedBytes)
+}
+
+func TestFrameQueue_Block(t *testing.T) {
+	q := newFrameQueue(1, 0, Block)
+	_, pushed := q.push(queuedFrame{[]byte("1"), 0}, nil)
+	require.True(t, pushed)
+
+	// A full queue holds up the push until there's room
+	done := make(chan bool, 1)
+	go func() {
+		_, pushed := q.push(queuedFrame{[]byte("2"), 0}, nil)
+		done <- pushed
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("push didn't wait for room")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	frame, ok := q.pop(nil)
+	require.True(t, ok)
+	assert.Equal(t, "1", string(frame))
+
+	select {
+	case pushed := <-done:
+		assert.True(t, pushed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("push didn't complete once there was room")
+	}
+	assert.Equal(t, []string{"2"}, framesOf(q))
+
+	// Giving up while waiting leaves the queue as it was
+	abort := make(chan struct{})
+	close(abort)
+	_, pushed = q.push(queuedFrame{[]byte("3"), 0}, abort)
+	assert.False(t, pushed)
+	assert.Equal(t, []string{"2"}, framesOf(q))
+}
+
+func TestHub_ClientBufferSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         OverflowPolicy
+		expectedErr    error
+		expectedData   []string
+		expectedFailed uint64
+	}{
+		{
+			name:         "Block waits for room",
+			policy:       Block,
+			expectedErr:  errEnqueueAborted,
+			expectedData: []string{"1", "2"},
+		},
+		{
+			name:           "DropNewest drops the arriving message",
+			policy:         DropNewest,
+			expectedErr:    errDropped,
+			expectedData:   []string{"1", "2"},
+			expectedFailed: 1,
+		},
+		{
+			name:           "DropOldest evicts the oldest buffered message",
+			policy:         DropOldest,
+			expectedData:   []string{"2", "3"},
+			expectedFailed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.ClientBufferSize = 2
+			h.OverflowPolicy = tt.policy
+			// Registered but never connected, so nothing drains its buffer
+			h.SeedClients(500)
+			ch, _ := h.getClient(500)
+			require.Equal(t, 2, cap(ch))
+
+			for _, data := range []string{"1", "2"} {
+				require.NoError(t, h.enqueue(500, ch, types.SendingMessage{Recipients: "500", Data: []byte(data)}, nil))
+			}
+
+			// Given up on shortly if it has to wait
+			abort := make(chan struct{})
+			time.AfterFunc(100*time.Millisecond, func() { close(abort) })
+			err := h.enqueue(500, ch, types.SendingMessage{Recipients: "500", Data: []byte("3")}, abort)
+			assert.Equal(t, tt.expectedErr, err)
+
+			var data []string
+			for len(ch) > 0 {
+				var msg types.SendingMessage
+				require.NoError(t, json.Unmarshal(<-ch, &msg))
+				data = append(data, string(msg.Data))
+			}
+			assert.Equal(t, tt.expectedData, data)
+
+			stats := getStats(t, h)
+			assert.Equal(t, tt.expectedFailed, stats.Failures)
+			if tt.expectedFailed > 0 {
+				assert.Len(t, stats.EvictedBytes, 1)
+			}
+		})
+	}
+}
+
+func TestHub_DropNewestReported(t *testing.T) {
+	h := New()
+	h.ClientBufferSize = 1
+	h.OverflowPolicy = DropNewest
+	// Registered but never connected, so nothing drains its buffer
+	h.SeedClients(500, 600)
+
+	w := sendTo(t, h, "600")
+	require.Equal(t, 200, w.Code)
+
+	w = sendTo(t, h, "600")
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), "buffer is full")
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	sender := dialAs(t, serv, 500)
+	defer sender.Close()
+
+	writeFrame(t, sender, types.SendingMessage{Recipients: "600", Data: []byte("data"), MessageID: "m1"})
+
+	// Told it was dropped rather than acked
+	var msg types.SendingMessage
+	require.NoError(t, sender.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, sender.ReadJSON(&msg))
+	assert.True(t, msg.System)
+	assert.Equal(t, "Message to 600 dropped, its buffer is full", string(msg.Data))
+
+	stats := getStats(t, h)
+	assert.Equal(t, uint64(1), stats.MessagesRelayed)
+	assert.Equal(t, uint64(2), stats.Failures)
+}
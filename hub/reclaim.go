@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reclaim lets a register for an ID that's already registered take it over, as long as nothing is connected as it, e.g.
+// a client that crashed and restarted before ever connecting. The registration's token must be presented if it has one,
+// otherwise the register must ask with "reclaim=true". A register made with a token replaces the registration's token with it.
+// It responds with an error and returns false if the ID can't be reclaimed.
+func (h *Hub) reclaim(c *gin.Context, id uint64, token string) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	_, protected := h.tokens[id]
+	if c.Query("reclaim") != "true" && !(protected && c.GetHeader("Authorization") != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID already in use"})
+		return false
+	}
+	if !h.authorizedLocked(c, id) {
+		return false
+	}
+
+	// Opening connections count too, as they're about to be in use
+	if _, connected := h.sessions[id]; connected || h.connections[id] > 0 {
+		c.JSON(http.StatusConflict, gin.H{"status": "Conflict", "message": "ID in use by an active connection"})
+		return false
+	}
+
+	if token != "" {
+		now := h.Clock.Now()
+		h.tokens[id] = &issuedToken{value: token, issuedAt: now, lastUsed: now}
+	}
+	return true
+}
+
+// keptLocked reports whether the hub keeps state for id once it's forgotten, e.g. its resend buffer, queued scheduled
+// messages or group memberships, with the lock held
+func (h *Hub) keptLocked(id uint64) bool {
+	if _, kept := h.sequencers[id]; kept || len(h.queued[id]) > 0 {
+		return true
+	}
+	for _, group := range h.Groups {
+		if _, member := group[id]; member {
+			return true
+		}
+	}
+	return false
+}
+
+// inheritLocked lets a new registration of id keep what the hub kept from its previous registration, as long as proof is
+// the token that registration was issued. Otherwise the ID has a new owner, so it's all dropped rather than handed to
+// them. The lock must be held.
+func (h *Hub) inheritLocked(id uint64, proof string) {
+	former, exists := h.formerTokens[id]
+	delete(h.formerTokens, id)
+	if exists && proof != "" && subtle.ConstantTimeCompare([]byte(proof), []byte(former)) == 1 {
+		return
+	}
+	delete(h.sequencers, id)
+	h.dropQueuedLocked(id, "Recipient registered again by someone else")
+	h.leaveAllGroupsLocked(id)
+}
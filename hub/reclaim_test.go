@@ -0,0 +1,141 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_reclaim(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		query         string
+		withToken     bool // Registered with a token
+		presentToken  bool // Present the token registered with, otherwise bearer
+		bearer        string
+		connected     bool
+		expectedCode  int
+		expectedError gin.H
+	}{
+		{
+			name:         "Reclaimed with flag",
+			method:       "GET",
+			query:        "id=500&reclaim=true",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "No flag",
+			method:        "GET",
+			query:         "id=500",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: gin.H{"status": "Bad Request", "message": "ID already in use"},
+		},
+		{
+			name:          "Active connection",
+			method:        "GET",
+			query:         "id=500&reclaim=true",
+			connected:     true,
+			expectedCode:  http.StatusConflict,
+			expectedError: gin.H{"status": "Conflict", "message": "ID in use by an active connection"},
+		},
+		{
+			name:         "Reclaimed with token",
+			method:       "POST",
+			query:        "id=500",
+			withToken:    true,
+			presentToken: true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Token registration without token",
+			method:        "POST",
+			query:         "id=500&reclaim=true",
+			withToken:     true,
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: gin.H{"status": "Unauthorized", "message": "Token required"},
+		},
+		{
+			name:          "Token registration with wrong token",
+			method:        "POST",
+			query:         "id=500",
+			withToken:     true,
+			bearer:        "not-the-token",
+			expectedCode:  http.StatusForbidden,
+			expectedError: gin.H{"status": "Forbidden", "message": "Invalid token"},
+		},
+		{
+			name:          "Token registration with active connection",
+			method:        "POST",
+			query:         "id=500",
+			withToken:     true,
+			presentToken:  true,
+			connected:     true,
+			expectedCode:  http.StatusConflict,
+			expectedError: gin.H{"status": "Conflict", "message": "ID in use by an active connection"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			var token string
+			if tt.withToken {
+				token = registerWithToken(t, h, 500)
+			} else {
+				h.SeedClients(500)
+			}
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+			if tt.connected {
+				conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")),
+					http.Header{"Authorization": {"Bearer " + token}})
+				require.NoError(t, err)
+				defer conn.Close()
+				require.Eventually(t, func() bool { return h.instruments.connected.Value() == 1 }, 5*time.Second, 10*time.Millisecond)
+			}
+
+			req, err := http.NewRequest(tt.method, "/register?"+tt.query, nil)
+			require.NoError(t, err)
+			bearer := tt.bearer
+			if tt.presentToken {
+				bearer = token
+			}
+			if bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+bearer)
+			}
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			// The ID now belongs to whoever reclaimed it, with a new token if they registered for one
+			if tt.method == "POST" {
+				var resp types.RegisterResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Equal(t, uint64(500), resp.ID)
+				assert.NotEqual(t, token, resp.Token)
+				assert.Equal(t, resp.Token, h.tokens[500].value)
+			}
+			_, registered := h.getClient(500)
+			assert.True(t, registered)
+		})
+	}
+}
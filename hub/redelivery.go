@@ -0,0 +1,121 @@
+package hub
+
+import "github.com/StephenBirch/message-delivery-system/types"
+
+// RedeliveryPolicy decides what happens to a message its recipient doesn't acknowledge within the AckTimeout
+type RedeliveryPolicy int
+
+const (
+	// RedeliverResend sends the message again, up to MaxRedeliveries times before it's dead lettered
+	RedeliverResend RedeliveryPolicy = iota
+	// RedeliverDeadLetter dead letters the message straight away
+	RedeliverDeadLetter
+	// RedeliverDrop discards the message
+	RedeliverDrop
+)
+
+// ackKey identifies a message awaiting acknowledgement from one recipient
+type ackKey struct {
+	recipient uint64
+	messageID string
+}
+
+// awaitingAck is a message delivered to a recipient that hasn't acknowledged it yet
+type awaitingAck struct {
+	sender   uint64
+	msg      types.SendingMessage
+	attempts int // How many times the message has been delivered
+}
+
+// awaitAck waits up to AckTimeout for recipient to acknowledge msg from sender, applying the RedeliveryPolicy if it doesn't
+func (h *Hub) awaitAck(sender, recipient uint64, msg types.SendingMessage) {
+	key := ackKey{recipient, msg.MessageID}
+	a := &awaitingAck{sender: sender, msg: msg, attempts: 1}
+
+	h.Lock()
+	h.awaitingAcks[key] = a
+	h.Unlock()
+
+	h.armAckTimeout(key, a, 1)
+}
+
+// armAckTimeout applies the RedeliveryPolicy once AckTimeout passes, unless the attempt was acknowledged by then
+func (h *Hub) armAckTimeout(key ackKey, a *awaitingAck, attempt int) {
+	h.Clock.AfterFunc(h.AckTimeout, func() {
+		h.Lock()
+		if h.awaitingAcks[key] != a || a.attempts != attempt {
+			h.Unlock()
+			return
+		}
+		if h.RedeliveryPolicy != RedeliverResend || a.attempts > h.MaxRedeliveries {
+			delete(h.awaitingAcks, key)
+		}
+		h.Unlock()
+
+		switch {
+		case h.RedeliveryPolicy == RedeliverDrop:
+			h.Log.Infof("Dropping message not acknowledged client=%d message_id=%s", key.recipient, key.messageID)
+			h.counters.failed()
+			h.nack(a.sender, key.recipient, key.messageID, "Not acknowledged")
+		case h.RedeliveryPolicy == RedeliverDeadLetter:
+			h.deadLetter(key.recipient, a.msg, a.attempts, "Not acknowledged")
+			h.nack(a.sender, key.recipient, key.messageID, "Not acknowledged")
+		case a.attempts > h.MaxRedeliveries:
+			h.deadLetter(key.recipient, a.msg, a.attempts, "Not acknowledged after redelivery")
+			h.nack(a.sender, key.recipient, key.messageID, "Not acknowledged after redelivery")
+		default:
+			h.redeliver(key, a)
+		}
+	})
+}
+
+// redeliver sends a message awaiting acknowledgement to its recipient again
+func (h *Hub) redeliver(key ackKey, a *awaitingAck) {
+	h.Lock()
+	ch := h.Clients[key.recipient]
+	s, connected := h.sessions[key.recipient]
+	if !connected {
+		delete(h.awaitingAcks, key)
+	}
+	h.Unlock()
+
+	if !connected {
+		h.deadLetter(key.recipient, a.msg, a.attempts, "Recipient disconnected before acknowledging")
+		h.nack(a.sender, key.recipient, key.messageID, "Recipient disconnected before acknowledging")
+		return
+	}
+
+	if err := h.enqueue(key.recipient, ch, a.msg, s.done); err != nil {
+		h.Lock()
+		delete(h.awaitingAcks, key)
+		h.Unlock()
+		if counted(err) {
+			h.keepDeadLetter(key.recipient, a.msg, a.attempts, err.Error())
+		} else {
+			h.deadLetter(key.recipient, a.msg, a.attempts, err.Error())
+		}
+		h.nack(a.sender, key.recipient, key.messageID, err.Error())
+		return
+	}
+
+	h.Lock()
+	a.attempts++
+	attempt := a.attempts
+	h.Unlock()
+	h.armAckTimeout(key, a, attempt)
+}
+
+// recipientAcked settles a message recipient acknowledged, passing the ack on to its sender
+func (h *Hub) recipientAcked(recipient uint64, messageID string) {
+	key := ackKey{recipient, messageID}
+
+	h.Lock()
+	a, exists := h.awaitingAcks[key]
+	delete(h.awaitingAcks, key)
+	h.Unlock()
+	if !exists {
+		return
+	}
+
+	h.ack(a.sender, recipient, messageID)
+}
@@ -0,0 +1,146 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialAs opens a websocket to the hub at serv as client id
+func dialAs(t *testing.T, serv *httptest.Server, id uint64) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%d", strings.TrimPrefix(serv.URL, "http://"), id), nil)
+	require.NoError(t, err)
+	return conn
+}
+
+// writeFrame writes msg down conn
+func writeFrame(t *testing.T, conn *websocket.Conn, msg types.SendingMessage) {
+	b, err := json.Marshal(msg)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, b))
+}
+
+func TestHub_RedeliveryPolicy(t *testing.T) {
+	tests := []struct {
+		name               string
+		policy             RedeliveryPolicy
+		expectedDeliveries int
+		expectedDeadLetter *types.DeadLetter
+	}{
+		{
+			name:               "Resend",
+			policy:             RedeliverResend,
+			expectedDeliveries: 3,
+			expectedDeadLetter: &types.DeadLetter{Recipient: 600, Attempts: 3, Reason: "Not acknowledged after redelivery"},
+		},
+		{
+			name:               "Dead letter",
+			policy:             RedeliverDeadLetter,
+			expectedDeliveries: 1,
+			expectedDeadLetter: &types.DeadLetter{Recipient: 600, Attempts: 1, Reason: "Not acknowledged"},
+		},
+		{
+			name:               "Drop",
+			policy:             RedeliverDrop,
+			expectedDeliveries: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.AckTimeout = 50 * time.Millisecond
+			h.MaxRedeliveries = 2
+			h.RedeliveryPolicy = tt.policy
+			h.SeedClients(500, 600)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			sender := dialAs(t, serv, 500)
+			defer sender.Close()
+			recipient := dialAs(t, serv, 600)
+			defer recipient.Close()
+
+			// The recipient reads the message but never acks it
+			msg := types.SendingMessage{Recipients: "600", Data: []byte("reliable"), MessageID: "m1"}
+			writeFrame(t, sender, msg)
+
+			deliveries := 0
+			for {
+				recipient.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+				_, b, err := recipient.ReadMessage()
+				if err != nil {
+					break
+				}
+				var received types.SendingMessage
+				require.NoError(t, json.Unmarshal(b, &received))
+				assert.Equal(t, "m1", received.MessageID)
+				deliveries++
+			}
+			assert.Equal(t, tt.expectedDeliveries, deliveries)
+
+			select {
+			case letter := <-h.DeadLetters():
+				require.NotNil(t, tt.expectedDeadLetter, "message was dead lettered")
+				assert.Equal(t, tt.expectedDeadLetter.Recipient, letter.Recipient)
+				assert.Equal(t, tt.expectedDeadLetter.Attempts, letter.Attempts)
+				assert.Equal(t, tt.expectedDeadLetter.Reason, letter.Reason)
+				assert.Equal(t, msg.Data, letter.Message.Data)
+			default:
+				require.Nil(t, tt.expectedDeadLetter, "message wasn't dead lettered")
+			}
+
+			// Whichever the policy, the sender is told the hub gave up on the message
+			var nack types.SendingMessage
+			require.NoError(t, sender.SetReadDeadline(time.Now().Add(time.Second)))
+			require.NoError(t, sender.ReadJSON(&nack))
+			assert.True(t, nack.Ack)
+			assert.Equal(t, types.AckFailed, nack.AckStatus)
+			assert.Equal(t, "m1", nack.MessageID)
+			assert.Equal(t, uint64(600), nack.Sender)
+		})
+	}
+}
+
+func TestHub_AckTimeoutAcked(t *testing.T) {
+	h := New()
+	h.AckTimeout = 50 * time.Millisecond
+	h.SeedClients(500, 600)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	sender := dialAs(t, serv, 500)
+	defer sender.Close()
+	recipient := dialAs(t, serv, 600)
+	defer recipient.Close()
+
+	writeFrame(t, sender, types.SendingMessage{Recipients: "600", Data: []byte("reliable"), MessageID: "m1"})
+
+	recipient.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := recipient.ReadMessage()
+	require.NoError(t, err)
+	writeFrame(t, recipient, types.SendingMessage{MessageID: "m1", Ack: true})
+
+	// The sender's ack waits on the recipient's
+	sender.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := sender.ReadMessage()
+	require.NoError(t, err)
+	var ack types.SendingMessage
+	require.NoError(t, json.Unmarshal(b, &ack))
+	assert.Equal(t, types.SendingMessage{MessageID: "m1", Ack: true, Sender: 600, Sequence: 1}, ack)
+
+	// Nor is it redelivered or dead lettered
+	recipient.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = recipient.ReadMessage()
+	assert.Error(t, err, "acknowledged message was redelivered")
+	assert.Len(t, h.DeadLetters(), 0)
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// resendFrame is a frame delivered to a client along with its Sequence, kept for /resend
+type resendFrame struct {
+	sequence uint64
+	frame    []byte
+	at       time.Time // When it was delivered, see ResendBufferAge
+}
+
+// remember keeps frame in seq's recent frames while ResendBufferSize is set, dropping the oldest beyond it
+func (h *Hub) remember(seq *sequencer, sequence uint64, frame []byte) {
+	if h.ResendBufferSize <= 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	seq.recent = append(seq.recent, resendFrame{sequence, frame, h.Clock.Now()})
+	if over := len(seq.recent) - h.ResendBufferSize; over > 0 {
+		seq.dropped = seq.recent[over-1].sequence
+		seq.recent = append([]resendFrame(nil), seq.recent[over:]...)
+	}
+	h.expireRecentLocked(seq)
+}
+
+// expireRecentLocked drops the frames in seq's recent frames older than the ResendBufferAge, with the lock held
+func (h *Hub) expireRecentLocked(seq *sequencer) {
+	if h.ResendBufferAge <= 0 {
+		return
+	}
+
+	cutoff := h.Clock.Now().Add(-h.ResendBufferAge)
+	expired := 0
+	for expired < len(seq.recent) && seq.recent[expired].at.Before(cutoff) {
+		seq.dropped = seq.recent[expired].sequence
+		expired++
+	}
+	if expired > 0 {
+		seq.recent = append([]resendFrame(nil), seq.recent[expired:]...)
+	}
+}
+
+// resend returns the frames delivered to the client with Sequence from to to (inclusive), that are still kept, so it can
+// fill in gaps in what it received. It's authenticated by the session token, which must belong to the client.
+// A range reaching back to messages the buffer has dropped, for their age or its size, is refused with 410 Gone, rather
+// than returning only part of it.
+func (h *Hub) resend(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse ID"})
+		return
+	}
+
+	from, fromErr := strconv.ParseUint(c.Query("from"), 10, 64)
+	to, toErr := strconv.ParseUint(c.Query("to"), 10, 64)
+	if fromErr != nil || toErr != nil || from == 0 || to < from {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "from and to must be a range of sequence numbers, starting at 1"})
+		return
+	}
+
+	tokenID, ok := h.sessionClient(c)
+	if !ok {
+		return
+	}
+	if tokenID != id {
+		c.JSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Token doesn't belong to the client"})
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	resp := types.ResendResponse{Messages: []json.RawMessage{}}
+	if seq, exists := h.sequencers[id]; exists {
+		h.expireRecentLocked(seq)
+		if from <= seq.dropped {
+			c.JSON(http.StatusGone, gin.H{"status": "Gone", "message": fmt.Sprintf("Messages up to sequence %d are no longer kept by the resend buffer", seq.dropped)})
+			return
+		}
+		for _, f := range seq.recent {
+			if f.sequence >= from && f.sequence <= to {
+				resp.Messages = append(resp.Messages, f.frame)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
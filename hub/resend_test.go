@@ -0,0 +1,304 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_resend(t *testing.T) {
+	tests := []struct {
+		name              string
+		query             string
+		expectedCode      int
+		expectedSequences []uint64
+		expectedError     gin.H
+	}{
+		{
+			name:              "Range kept",
+			query:             "id=500&from=3&to=5",
+			expectedCode:      200,
+			expectedSequences: []uint64{3, 4, 5},
+		},
+		{
+			name:          "Range partly evicted",
+			query:         "id=500&from=1&to=5",
+			expectedCode:  410,
+			expectedError: gin.H{"status": "Gone", "message": "Messages up to sequence 2 are no longer kept by the resend buffer"},
+		},
+		{
+			name:              "Single message",
+			query:             "id=500&from=4&to=4",
+			expectedCode:      200,
+			expectedSequences: []uint64{4},
+		},
+		{
+			name:          "Nothing kept",
+			query:         "id=500&from=1&to=2",
+			expectedCode:  410,
+			expectedError: gin.H{"status": "Gone", "message": "Messages up to sequence 2 are no longer kept by the resend buffer"},
+		},
+		{
+			name:          "Backwards range",
+			query:         "id=500&from=4&to=3",
+			expectedCode:  400,
+			expectedError: gin.H{"status": "Bad Request", "message": "from and to must be a range of sequence numbers, starting at 1"},
+		},
+		{
+			name:          "From 0",
+			query:         "id=500&from=0&to=3",
+			expectedCode:  400,
+			expectedError: gin.H{"status": "Bad Request", "message": "from and to must be a range of sequence numbers, starting at 1"},
+		},
+		{
+			name:          "Invalid ID",
+			query:         "id=notuint64&from=1&to=3",
+			expectedCode:  400,
+			expectedError: gin.H{"status": "Bad Request", "message": "Unable to parse ID"},
+		},
+		{
+			name:          "Another client",
+			query:         "id=600&from=1&to=3",
+			expectedCode:  403,
+			expectedError: gin.H{"status": "Forbidden", "message": "Token doesn't belong to the client"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.ResendBufferSize = 3
+			h.SeedClients(600)
+			h.Clients[500] = make(chan []byte, 5)
+			h.sessionTokens["token"] = 500
+
+			for i := 1; i <= 5; i++ {
+				require.NoError(t, h.enqueue(500, h.Clients[500], types.SendingMessage{Data: []byte(fmt.Sprint(i))}, nil))
+			}
+
+			req, err := http.NewRequest("GET", "/resend?"+tt.query, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer token")
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var resp types.ResendResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+			var sequences []uint64
+			for _, frame := range resp.Messages {
+				var msg types.SendingMessage
+				require.NoError(t, json.Unmarshal(frame, &msg))
+				assert.Equal(t, fmt.Sprint(msg.Sequence), string(msg.Data))
+				sequences = append(sequences, msg.Sequence)
+			}
+			assert.Equal(t, tt.expectedSequences, sequences)
+		})
+	}
+}
+
+// dialWithToken connects to serv as id, presenting token if it's given, returning the connection and its session token
+func dialWithToken(t *testing.T, serv *httptest.Server, id uint64, token string) (*websocket.Conn, string) {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%d", strings.TrimPrefix(serv.URL, "http://"), id), header)
+	require.NoError(t, err)
+	return conn, resp.Header.Get(types.SessionTokenHeader)
+}
+
+// reregister registers id again with a GET, or a POST presenting token if it's given, returning the token issued
+func reregister(t *testing.T, h *Hub, id uint64, token string) string {
+	method := "GET"
+	if token != "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("/register?id=%d", id), nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var resp types.RegisterResponse
+	if token != "" {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	}
+	return resp.Token
+}
+
+func TestHub_ResendBufferSizeKeepsSequence(t *testing.T) {
+	h := New()
+	h.ResendBufferSize = 10
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// receive connects as 500 with token, returning the Sequence of the next message sent to it
+	receive := func(token string) uint64 {
+		conn, _ := dialWithToken(t, serv, 500, token)
+		defer conn.Close()
+
+		req, err := http.NewRequest("POST", "/send?ids=500", bytes.NewBufferString("Hi"))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code)
+
+		var msg types.SendingMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg.Sequence
+	}
+	forgotten := func() bool {
+		_, exists := h.getClient(500)
+		return !exists
+	}
+
+	token := registerWithToken(t, h, 500)
+	assert.Equal(t, uint64(1), receive(token))
+	require.Eventually(t, forgotten, time.Second, 10*time.Millisecond)
+
+	// Numbering carries on from before the client disconnected, once it registers again with its token
+	token = reregister(t, h, 500, token)
+	assert.Equal(t, uint64(2), receive(token))
+	require.Eventually(t, forgotten, time.Second, 10*time.Millisecond)
+
+	// Until it deregisters
+	token = reregister(t, h, 500, token)
+	req, err := http.NewRequest("GET", "/deregister?id=500", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	h.SeedClients(500)
+	assert.Equal(t, uint64(1), receive(""))
+}
+
+func TestHub_ResendBufferNewOwner(t *testing.T) {
+	h := New()
+	h.ResendBufferSize = 10
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// The first owner of 5 is sent a message, then drops
+	token := registerWithToken(t, h, 5)
+	conn, _ := dialWithToken(t, serv, 5, token)
+	require.Equal(t, 200, sendTo(t, h, "5").Code)
+	var msg types.SendingMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	conn.Close()
+	require.Eventually(t, func() bool { return !h.idInUse(5) }, time.Second, 10*time.Millisecond)
+
+	// Whoever registers the ID next, without the token, starts afresh
+	reregister(t, h, 5, "")
+	conn, session := dialWithToken(t, serv, 5, "")
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", "/resend?id=5&from=1&to=10", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+session)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var resp types.ResendResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Empty(t, resp.Messages)
+}
+
+func TestHub_ResendBufferAge(t *testing.T) {
+	tests := []struct {
+		name              string
+		query             string
+		expectedCode      int
+		expectedSequences []uint64
+		expectedError     gin.H
+	}{
+		{
+			name:              "Recent range",
+			query:             "id=500&from=3&to=4",
+			expectedCode:      200,
+			expectedSequences: []uint64{3, 4},
+		},
+		{
+			name:          "Range partly aged out",
+			query:         "id=500&from=2&to=4",
+			expectedCode:  410,
+			expectedError: gin.H{"status": "Gone", "message": "Messages up to sequence 2 are no longer kept by the resend buffer"},
+		},
+		{
+			name:          "Range aged out",
+			query:         "id=500&from=1&to=2",
+			expectedCode:  410,
+			expectedError: gin.H{"status": "Gone", "message": "Messages up to sequence 2 are no longer kept by the resend buffer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			h := New()
+			h.Clock = clock
+			h.ResendBufferSize = 10
+			h.ResendBufferAge = time.Minute
+			h.Clients[500] = make(chan []byte, 5)
+			h.sessionTokens["token"] = 500
+
+			// Two messages that age out, then two that don't
+			for i := 1; i <= 4; i++ {
+				if i == 3 {
+					clock.Advance(90 * time.Second)
+				}
+				require.NoError(t, h.enqueue(500, h.Clients[500], types.SendingMessage{Data: []byte(fmt.Sprint(i))}, nil))
+			}
+
+			req, err := http.NewRequest("GET", "/resend?"+tt.query, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer token")
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var resp types.ResendResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+			var sequences []uint64
+			for _, frame := range resp.Messages {
+				var msg types.SendingMessage
+				require.NoError(t, json.Unmarshal(frame, &msg))
+				sequences = append(sequences, msg.Sequence)
+			}
+			assert.Equal(t, tt.expectedSequences, sequences)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package hub
+
+import (
+	"strconv"
+	"strings"
+)
+
+// RecipientResolver turns a raw recipient spec, as given to /send or in a SendingMessage, into the client IDs to deliver to.
+// Implementations can add their own addressing schemes, e.g. names or aliases for sets of clients.
+type RecipientResolver interface {
+	Resolve(spec string) ([]uint64, error)
+}
+
+// ResolverFunc allows a plain function to be used as a RecipientResolver
+type ResolverFunc func(spec string) ([]uint64, error)
+
+// Resolve calls f(spec)
+func (f ResolverFunc) Resolve(spec string) ([]uint64, error) {
+	return f(spec)
+}
+
+// CSVResolver is the default RecipientResolver, reading the spec as comma separated client IDs
+type CSVResolver struct{}
+
+// Resolve parses each comma separated entry of spec as a uint64 ID
+func (CSVResolver) Resolve(spec string) ([]uint64, error) {
+	var ids []uint64
+	for _, id := range strings.Split(spec, ",") {
+		parsedID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, parsedID)
+	}
+	return ids, nil
+}
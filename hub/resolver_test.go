@@ -0,0 +1,129 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expectedIDs []uint64
+		wantErr     bool
+	}{
+		{
+			name:        "Single",
+			spec:        "500",
+			expectedIDs: []uint64{500},
+		},
+		{
+			name:        "Multiple",
+			spec:        "500,600,700",
+			expectedIDs: []uint64{500, 600, 700},
+		},
+		{
+			name:    "Not uint64",
+			spec:    "500,notuint64",
+			wantErr: true,
+		},
+		{
+			name:    "Trailing comma",
+			spec:    "500,",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := CSVResolver{}.Resolve(tt.spec)
+			require.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestHub_RecipientResolver(t *testing.T) {
+	// Resolves "@team" to a fixed set of clients, and anything else by the default rules
+	teamResolver := ResolverFunc(func(spec string) ([]uint64, error) {
+		if spec == "@team" {
+			return []uint64{500, 600}, nil
+		}
+		return CSVResolver{}.Resolve(spec)
+	})
+
+	tests := []struct {
+		name          string
+		expectedCode  int
+		expectedError gin.H
+		inputIDs      string
+		received      map[uint64]bool
+	}{
+		{
+			name:         "Custom addressing",
+			expectedCode: 200,
+			inputIDs:     "@team",
+			received:     map[uint64]bool{500: true, 600: true, 700: false},
+		},
+		{
+			name:         "Falls back to IDs",
+			expectedCode: 200,
+			inputIDs:     "700",
+			received:     map[uint64]bool{500: false, 600: false, 700: true},
+		},
+		{
+			name:          "Unknown alias",
+			expectedCode:  400,
+			inputIDs:      "@everyone",
+			expectedError: gin.H{"message": "strconv.ParseUint: parsing \"@everyone\": invalid syntax", "status": "Bad Request"},
+			received:      map[uint64]bool{500: false, 600: false, 700: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.RecipientResolver = teamResolver
+			h.Clients = map[uint64]chan []byte{
+				500: make(chan []byte, 1),
+				600: make(chan []byte, 1),
+				700: make(chan []byte, 1),
+			}
+
+			req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%s", tt.inputIDs), bytes.NewBufferString("Hi"))
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+
+			for id, expected := range tt.received {
+				select {
+				case b := <-h.Clients[id]:
+					require.True(t, expected, "%d received a message", id)
+
+					var msg types.SendingMessage
+					require.NoError(t, json.Unmarshal(b, &msg))
+					assert.Equal(t, tt.inputIDs, msg.Recipients)
+				default:
+					require.False(t, expected, "%d didn't receive a message", id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package hub
+
+import (
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rosterFlushRows is how many rows exportUsers writes between flushes
+var rosterFlushRows = 100
+
+// storeName records the name a client registered with, if it gave one
+func (h *Hub) storeName(id uint64, name string) {
+	if name == "" {
+		return
+	}
+
+	h.Lock()
+	h.names[id] = name
+	h.Unlock()
+}
+
+// seen records activity from a client
+func (h *Hub) seen(id uint64) {
+	h.Lock()
+	h.lastSeen[id] = h.Clock.Now()
+	h.Unlock()
+}
+
+// exportUsers streams every registered client as CSV, with their name, whether they're connected, and when they were last seen
+func (h *Hub) exportUsers(c *gin.Context) {
+	type row struct {
+		id        uint64
+		name      string
+		connected bool
+		lastSeen  time.Time
+	}
+
+	h.Lock()
+	rows := make([]row, 0, len(h.Clients))
+	for id := range h.Clients {
+		_, connected := h.sessions[id]
+		rows = append(rows, row{id, h.names[id], connected, h.lastSeen[id]})
+	}
+	h.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="users.csv"`)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "name", "connected", "last_seen"})
+	for i, r := range rows {
+		w.Write([]string{
+			strconv.FormatUint(r.id, 10),
+			r.name,
+			strconv.FormatBool(r.connected),
+			r.lastSeen.UTC().Format(time.RFC3339),
+		})
+
+		if (i+1)%rosterFlushRows == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,94 @@
+package hub
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_exportUsers(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+	address := strings.TrimPrefix(serv.URL, "http://")
+
+	require.Equal(t, 200, registerFrom(t, h, "10.0.0.1").Code)
+	req, err := http.NewRequest("GET", "/register?id=500&name=alice", nil)
+	require.NoError(t, err)
+	h.Router.ServeHTTP(httptest.NewRecorder(), req)
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", address), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Gated behind the admin token
+	req, err = http.NewRequest("GET", "/users/export", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 401, w.Code)
+
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+
+	// A header, then a line per client
+	require.Len(t, records, 3)
+	assert.Equal(t, []string{"id", "name", "connected", "last_seen"}, records[0])
+
+	var alice []string
+	for _, record := range records[1:] {
+		if record[0] == "500" {
+			alice = record
+			continue
+		}
+		assert.Equal(t, "", record[1])
+		assert.Equal(t, "false", record[2])
+	}
+	require.NotNil(t, alice, "registered client missing from export")
+	assert.Equal(t, "alice", alice[1])
+	assert.Equal(t, "true", alice[2])
+
+	lastSeen, err := time.Parse(time.RFC3339, alice[3])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), lastSeen, time.Minute)
+}
+
+func TestHub_exportUsersGzip(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+	h.SeedClients(100, 200)
+
+	req, err := http.NewRequest("GET", "/users/export", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	records, err := csv.NewReader(zr).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+}
@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+)
+
+// SchedulePolicy decides what happens to a scheduled message whose recipient isn't connected when it falls due
+type SchedulePolicy int
+
+const (
+	// ScheduleDrop discards the message
+	ScheduleDrop SchedulePolicy = iota
+	// ScheduleQueue holds the message until the recipient next connects
+	ScheduleQueue
+)
+
+const defaultMaxQueued = 100 // How many scheduled messages ScheduleQueue holds for each disconnected client by default
+
+// schedule holds msg from sender until at, then delivers it to each of ids. Recipients that aren't registered are dead
+// lettered straight away rather than held, and returned so the sender can be told.
+func (h *Hub) schedule(sender uint64, at time.Time, ids []uint64, msg types.SendingMessage) (unknown []string) {
+	var registered []uint64
+	for _, id := range ids {
+		if _, exists := h.getClient(id); !exists {
+			h.deadLetter(id, msg, 0, "Recipient not registered")
+			h.nack(sender, id, msg.MessageID, "Recipient not registered")
+			unknown = append(unknown, strconv.FormatUint(id, 10))
+			continue
+		}
+		registered = append(registered, id)
+	}
+	if len(registered) == 0 {
+		return unknown
+	}
+
+	h.holdPending(sender, msg, registered)
+	h.Clock.AfterFunc(at.Sub(h.Clock.Now()), func() {
+		for _, id := range registered {
+			h.deliverScheduled(id, msg)
+		}
+	})
+	return unknown
+}
+
+// deliverScheduled hands a due message to its recipient if they're connected, otherwise applies the SchedulePolicy
+func (h *Hub) deliverScheduled(id uint64, msg types.SendingMessage) {
+	h.Lock()
+	ch, registered := h.Clients[id]
+	s, connected := h.sessions[id]
+	if !connected {
+		reason := "Recipient not connected when the scheduled message fell due"
+		switch {
+		case !registered:
+			reason = "Recipient not registered when the scheduled message fell due"
+		case h.SchedulePolicy == ScheduleQueue && h.MaxQueued > 0 && len(h.queued[id]) >= h.MaxQueued:
+			reason = fmt.Sprintf("Recipient already has %d scheduled messages queued", h.MaxQueued)
+		case h.SchedulePolicy == ScheduleQueue:
+			h.queued[id] = append(h.queued[id], h.compressQueued(id, msg))
+			h.Unlock()
+			return
+		}
+		h.Unlock()
+
+		h.deadLetter(id, msg, 0, reason)
+		h.settlePending(msg, id)
+		return
+	}
+	h.Unlock()
+
+	err := h.enqueue(id, ch, msg, s.done)
+	if err == errEnqueueAborted {
+		// Disconnected before the message could be handed over, try again under the policy
+		h.deliverScheduled(id, msg)
+		return
+	}
+	if err != nil {
+		h.Log.Errorf("Unable to deliver scheduled message: %v client=%d size=%d", err, id, len(msg.Data))
+		if !counted(err) {
+			h.counters.failed()
+		}
+		h.settlePending(msg, id)
+		return
+	}
+	h.counters.relayed(len(msg.Data))
+	h.settlePending(msg, id)
+}
+
+// flushQueued delivers any scheduled messages that were held for a client while it was disconnected
+func (h *Hub) flushQueued(id uint64) {
+	h.Lock()
+	queued := h.queued[id]
+	delete(h.queued, id)
+	h.Unlock()
+
+	for _, msg := range queued {
+		msg, err := decompressQueued(msg)
+		if err != nil {
+			h.deadLetter(id, msg, 0, fmt.Sprintf("Unable to decompress queued message: %v", err))
+			h.settlePending(msg, id)
+			continue
+		}
+		h.deliverScheduled(id, msg)
+	}
+}
+
+// dropQueuedLocked dead letters the scheduled messages queued for id, for when they'll never be delivered to whoever
+// they were meant for. The lock must be held.
+func (h *Hub) dropQueuedLocked(id uint64, reason string) {
+	for _, msg := range h.queued[id] {
+		h.counters.failed()
+		h.keepDeadLetterLocked(id, msg, 0, reason)
+		h.settlePendingLocked(msg, id)
+	}
+	delete(h.queued, id)
+}
+
+// compressQueued gzips the Data of msg while CompressQueued is set, leaving it as it is if that doesn't make it smaller
+func (h *Hub) compressQueued(id uint64, msg types.SendingMessage) types.SendingMessage {
+	if !h.CompressQueued || msg.Encoding != "" || len(msg.Data) == 0 {
+		return msg
+	}
+
+	data, err := gzipData(msg.Data)
+	if err != nil {
+		h.Log.Errorf("Unable to compress queued message: %v client=%d size=%d", err, id, len(msg.Data))
+		return msg
+	}
+	if len(data) >= len(msg.Data) {
+		return msg
+	}
+	msg.Data, msg.Encoding = data, types.GzipEncoding
+	return msg
+}
+
+// decompressQueued undoes compressQueued
+func decompressQueued(msg types.SendingMessage) (types.SendingMessage, error) {
+	if msg.Encoding != types.GzipEncoding {
+		return msg, nil
+	}
+
+	// Only ever compressed from Data the hub already accepted
+	data, err := gunzip(msg.Data, 0)
+	if err != nil {
+		return msg, err
+	}
+	msg.Data, msg.Encoding = data, ""
+	return msg, nil
+}
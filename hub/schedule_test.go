@@ -0,0 +1,195 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_SchedulePolicy(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           SchedulePolicy
+		expectedDelivery bool
+	}{
+		{
+			name:   "Drop",
+			policy: ScheduleDrop,
+		},
+		{
+			name:             "Queue",
+			policy:           ScheduleQueue,
+			expectedDelivery: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SchedulePolicy = tt.policy
+			h.SeedClients(500)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			msg := types.SendingMessage{Recipients: "500", Data: []byte("later")}
+
+			// Falls due while 500 is registered but hasn't connected
+			h.schedule(0, time.Now().Add(50*time.Millisecond), []uint64{500}, msg)
+			time.Sleep(200 * time.Millisecond)
+
+			conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, received, err := conn.ReadMessage()
+
+			stats := getStats(t, h)
+			if !tt.expectedDelivery {
+				require.Error(t, err, "dropped message was delivered")
+				assert.Equal(t, uint64(1), stats.Failures)
+				return
+			}
+
+			require.NoError(t, err)
+			var receivedMsg types.SendingMessage
+			require.NoError(t, json.Unmarshal(received, &receivedMsg))
+			msg.Sequence = 1
+			assert.Equal(t, msg, receivedMsg)
+			assert.Equal(t, uint64(1), stats.MessagesRelayed)
+		})
+	}
+}
+
+func TestHub_CompressQueued(t *testing.T) {
+	h := New()
+	h.SchedulePolicy = ScheduleQueue
+	h.CompressQueued = true
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	payloads := [][]byte{
+		[]byte(strings.Repeat("compress me ", 100)),
+		[]byte("tiny"), // Only bigger for compressing, so held as it is
+	}
+	for _, data := range payloads {
+		h.deliverScheduled(500, types.SendingMessage{Recipients: "500", Data: data})
+	}
+
+	h.Lock()
+	queued := append([]types.SendingMessage(nil), h.queued[500]...)
+	h.Unlock()
+	require.Len(t, queued, 2)
+	assert.Equal(t, types.GzipEncoding, queued[0].Encoding)
+	assert.Less(t, len(queued[0].Data), len(payloads[0]))
+	assert.Equal(t, "", queued[1].Encoding)
+	assert.Equal(t, payloads[1], queued[1].Data)
+
+	conn := dialAs(t, serv, 500)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	for _, data := range payloads {
+		var received types.SendingMessage
+		require.NoError(t, conn.ReadJSON(&received))
+		assert.Equal(t, data, received.Data)
+		assert.Equal(t, "", received.Encoding)
+	}
+}
+
+func TestHub_ScheduleUnknownRecipient(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	msg := types.SendingMessage{Recipients: "500,501", Data: []byte("later")}
+	unknown := h.schedule(0, time.Now().Add(time.Hour), []uint64{500, 501}, msg)
+	assert.Equal(t, []string{"501"}, unknown)
+
+	h.Lock()
+	letters := append([]types.DeadLetter(nil), h.deadLetterLog...)
+	h.Unlock()
+	require.Len(t, letters, 1)
+	assert.Equal(t, uint64(501), letters[0].Recipient)
+	assert.Equal(t, uint64(1), getStats(t, h).Failures)
+}
+
+func TestHub_MaxQueued(t *testing.T) {
+	h := New()
+	h.SchedulePolicy = ScheduleQueue
+	h.MaxQueued = 2
+	h.SeedClients(500)
+
+	for i := 0; i < 3; i++ {
+		h.deliverScheduled(500, types.SendingMessage{Recipients: "500", Data: []byte(fmt.Sprint(i))})
+	}
+
+	h.Lock()
+	queued := len(h.queued[500])
+	h.Unlock()
+	assert.Equal(t, 2, queued)
+	assert.Equal(t, uint64(1), getStats(t, h).Failures)
+}
+
+func TestHub_QueuedDropped(t *testing.T) {
+	tests := []struct {
+		name string
+		// leave takes 500 away, returning the token a new registration presents
+		leave          func(h *Hub, token string) string
+		expectedQueued int
+	}{
+		{
+			name: "Deregistered",
+			leave: func(h *Hub, token string) string {
+				h.Lock()
+				h.deregisterLocked(500, "Deregistered")
+				h.Unlock()
+				return token
+			},
+		},
+		{
+			name: "New owner",
+			leave: func(h *Hub, token string) string {
+				h.Lock()
+				h.forgetClientLocked(500)
+				h.Unlock()
+				return ""
+			},
+		},
+		{
+			name: "Same owner",
+			leave: func(h *Hub, token string) string {
+				h.Lock()
+				h.forgetClientLocked(500)
+				h.Unlock()
+				return token
+			},
+			expectedQueued: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SchedulePolicy = ScheduleQueue
+			token := registerWithToken(t, h, 500)
+			h.deliverScheduled(500, types.SendingMessage{Recipients: "500", Data: []byte("later")})
+
+			reregister(t, h, 500, tt.leave(h, token))
+
+			h.Lock()
+			queued := len(h.queued[500])
+			h.Unlock()
+			assert.Equal(t, tt.expectedQueued, queued)
+			assert.Equal(t, uint64(1-tt.expectedQueued), getStats(t, h).Failures)
+		})
+	}
+}
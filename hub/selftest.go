@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// selfTestTimeout is how long /selftest waits for its message to come back
+var selfTestTimeout = 5 * time.Second
+
+// selfTest checks the whole relay path end to end, by registering a temporary client, connecting it to the hub
+// the request came in on, and timing a message it sends itself. The client is unregistered once it disconnects.
+func (h *Hub) selfTest(c *gin.Context) {
+	id, ok := h.generateID()
+	if !ok || !h.addClient(id, "", "") {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": "Failed to find ID not in use"})
+		return
+	}
+	h.storeName(id, "selftest")
+
+	latency, err := h.roundTrip(c.Request, id)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "Service Unavailable", "message": fmt.Sprintf("Self test failed: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.SelfTestResponse{Success: true, Latency: latency})
+}
+
+// roundTrip connects to the hub as id, over the listener and scheme req came in on, returning how long a message to
+// itself takes to arrive
+func (h *Hub) roundTrip(req *http.Request, id uint64) (time.Duration, error) {
+	// The listener's own address, rather than the Host the request names, so the test can't be pointed elsewhere
+	host := req.Host
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		host = addr.String()
+	}
+
+	scheme, dialer := "ws", *websocket.DefaultDialer
+	if req.TLS != nil {
+		// Dialing the hub's own listener, so there's no one else the certificate could need to rule out
+		scheme, dialer.TLSClientConfig = "wss", &tls.Config{InsecureSkipVerify: true}
+	}
+
+	header := http.Header{}
+	if h.ProtocolToken != "" {
+		header.Set(types.ProtocolTokenHeader, h.ProtocolToken)
+	}
+
+	conn, _, err := dialer.Dial(fmt.Sprintf("%s://%s/ws?id=%d", scheme, host, id), header)
+	if err != nil {
+		// Never connected, so the hub won't clean up after it
+		h.Lock()
+		h.forgetClientLocked(id)
+		h.Unlock()
+		return 0, fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	start := time.Now()
+
+	if err := conn.WriteJSON(types.SendingMessage{Recipients: strconv.FormatUint(id, 10), Data: payload}); err != nil {
+		return 0, fmt.Errorf("failed to send: %v", err)
+	}
+
+	conn.SetReadDeadline(start.Add(selfTestTimeout))
+	for {
+		var msg types.SendingMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return 0, fmt.Errorf("failed to receive: %v", err)
+		}
+
+		// Anything else, e.g. an announcement, isn't what we're waiting for
+		if !msg.System && string(msg.Data) == string(payload) {
+			return time.Since(start), nil
+		}
+	}
+}
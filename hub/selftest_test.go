@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_selfTest(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{name: "No token", code: 401},
+		{name: "Wrong token", token: "wrong", code: 403},
+		{name: "Healthy hub", token: "secret", code: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", serv.URL+"/selftest", nil)
+			require.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.code, resp.StatusCode)
+
+			if tt.code != 200 {
+				return
+			}
+
+			var result types.SelfTestResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.True(t, result.Success)
+			assert.True(t, result.Latency > 0)
+
+			// The temporary client is gone once it disconnects
+			assert.Eventually(t, func() bool {
+				h.Lock()
+				defer h.Unlock()
+				return len(h.Clients) == 0 && len(h.sessions) == 0
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestHub_selfTestConnection(t *testing.T) {
+	tests := []struct {
+		name          string
+		tls           bool
+		protocolToken string
+	}{
+		{
+			name:          "Protocol token",
+			protocolToken: "v2",
+		},
+		{
+			name: "TLS",
+			tls:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.AdminToken = "secret"
+			h.ProtocolToken = tt.protocolToken
+
+			serv := httptest.NewUnstartedServer(h.Router)
+			if tt.tls {
+				serv.StartTLS()
+			} else {
+				serv.Start()
+			}
+			defer serv.Close()
+
+			req, err := http.NewRequest("POST", serv.URL+"/selftest", nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer secret")
+
+			resp, err := serv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, 200, resp.StatusCode)
+
+			var result types.SelfTestResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.True(t, result.Success)
+		})
+	}
+}
+
+func TestHub_selfTestCleanup(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+
+	// Nothing to connect to, so the temporary client never connects
+	req, err := http.NewRequest("POST", "/selftest", nil)
+	require.NoError(t, err)
+	req.Host = "127.0.0.1:1"
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 503, w.Code)
+
+	h.Lock()
+	defer h.Unlock()
+	assert.Empty(t, h.Clients)
+	assert.Empty(t, h.names)
+}
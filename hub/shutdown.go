@@ -0,0 +1,207 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	scheduledShutdownGrace = 10 * time.Second      // How long a shutdown scheduled by announceShutdown waits for in-flight sends
+	flushInterval          = 10 * time.Millisecond // How often Shutdown checks whether buffered messages have been delivered
+)
+
+// trackSend is middleware counting the request as an in-flight send that Shutdown waits for.
+// Once Shutdown has begun, or while the hub is paused, new sends are turned away with 503.
+func (h *Hub) trackSend(c *gin.Context) {
+	h.Lock()
+	if h.shuttingDown {
+		h.Unlock()
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"status": "Service Unavailable", "message": "Hub is shutting down"})
+		return
+	}
+	if h.paused {
+		h.Unlock()
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"status": "Service Unavailable", "message": "Hub is paused"})
+		return
+	}
+	h.sends.Add(1)
+	h.Unlock()
+
+	defer h.sends.Done()
+	c.Next()
+}
+
+// Pause turns away new sends until Resume, warning every client with a system message. Clients stay connected and
+// messages already accepted are still delivered.
+func (h *Hub) Pause() {
+	h.Lock()
+	if h.paused {
+		h.Unlock()
+		return
+	}
+	h.paused = true
+	h.Unlock()
+
+	h.Announce([]byte("Hub is paused, sends are refused until it resumes"))
+}
+
+// Resume accepts sends again after Pause, telling every client with a system message
+func (h *Hub) Resume() {
+	h.Lock()
+	if !h.paused {
+		h.Unlock()
+		return
+	}
+	h.paused = false
+	h.Unlock()
+
+	h.Announce([]byte("Hub has resumed"))
+}
+
+// isPaused reports whether the hub is between Pause and Resume
+func (h *Hub) isPaused() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.paused
+}
+
+// drainSends stops new sends, then waits for those in flight to finish. If ctx ends first, the sends
+// still waiting on a recipient are aborted so they respond with 503, and ctx's error is returned.
+func (h *Hub) drainSends(ctx context.Context) error {
+	h.Lock()
+	h.shuttingDown = true
+	h.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		h.sends.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Both may be ready at once, in which case nothing needs aborting
+	select {
+	case <-drained:
+		return nil
+	default:
+		h.stopSendsOnce.Do(func() { close(h.stopSends) })
+		return ctx.Err()
+	}
+}
+
+// announceShutdown warns every connected client with a system message that the hub will shut down after the "in"
+// query (e.g. 30s), giving them a chance to save state or move elsewhere, then calls Shutdown once it's passed.
+func (h *Hub) announceShutdown(c *gin.Context) {
+	in, err := time.ParseDuration(c.Query("in"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+		return
+	}
+	if in <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "In must be positive"})
+		return
+	}
+
+	h.Lock()
+	if h.shutdownScheduled || h.shuttingDown {
+		h.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"status": "Conflict", "message": "Shutdown already scheduled"})
+		return
+	}
+	h.shutdownScheduled = true
+	h.Unlock()
+
+	at := h.Clock.After(in)
+	go func() {
+		h.Announce([]byte(fmt.Sprintf("Hub is shutting down in %s", in)))
+		<-at
+
+		ctx, cancel := context.WithTimeout(context.Background(), scheduledShutdownGrace)
+		defer cancel()
+		if err := h.Shutdown(ctx); err != nil {
+			h.Log.Errorf("Scheduled shutdown didn't finish cleanly: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "Accepted", "message": fmt.Sprintf("Shutting down in %s", in)})
+}
+
+// countBuffered adjusts how many messages are buffered for id by delta. Messages are counted as they're handed to the
+// client's channel and uncounted once its pump has delivered or dropped them.
+func (h *Hub) countBuffered(id uint64, delta int) {
+	h.Lock()
+	defer h.Unlock()
+
+	// Forgotten along with the client, so there's nothing left to uncount
+	if _, exists := h.buffered[id]; !exists && delta < 0 {
+		return
+	}
+	h.buffered[id] += delta
+	if h.buffered[id] <= 0 {
+		delete(h.buffered, id)
+	}
+}
+
+// flush waits for the messages buffered for connected clients to be delivered, returning ctx's error if it ends first.
+// Messages for clients that aren't connected can't be delivered, so they aren't waited for.
+func (h *Hub) flush(ctx context.Context) error {
+	for {
+		h.Lock()
+		flushed := true
+		for id := range h.sessions {
+			if h.buffered[id] > 0 {
+				flushed = false
+				break
+			}
+		}
+		h.Unlock()
+
+		if flushed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flushInterval):
+		}
+	}
+}
+
+// closeClients closes every connected device once it's written what it has, waiting for them to close until ctx ends,
+// and unregisters every client. Their channels are dropped rather than closed, as a late enqueue would panic on them.
+func (h *Hub) closeClients(ctx context.Context) error {
+	h.Lock()
+	var sessions []*session
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+		for _, d := range s.devices {
+			d.stopOnce.Do(func() { close(d.stop) })
+		}
+	}
+	// Connected clients are unregistered as their last device closes
+	for id := range h.Clients {
+		if _, connected := h.sessions[id]; !connected {
+			h.forgetClientLocked(id)
+		}
+	}
+	h.Unlock()
+
+	for _, s := range sessions {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sendTo runs a POST against /send for the given recipients
+func sendTo(t *testing.T, h *Hub, ids string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/send?ids="+ids, strings.NewReader("data"))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+// enqueueing reports whether a message is waiting to be handed to id
+func enqueueing(h *Hub, id uint64) bool {
+	h.Lock()
+	seq, exists := h.sequencers[id]
+	h.Unlock()
+	return exists && len(seq.lock) == 1
+}
+
+func TestHub_ShutdownDrainsSends(t *testing.T) {
+	tests := []struct {
+		name          string
+		received      bool
+		expectedCode  int
+		expectedError gin.H
+		expectedErr   error
+	}{
+		{
+			name:         "In-flight send completes",
+			received:     true,
+			expectedCode: 200,
+		},
+		{
+			name:          "In-flight send aborted",
+			expectedCode:  503,
+			expectedError: gin.H{"message": "Hub is shutting down", "status": "Service Unavailable"},
+			expectedErr:   context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.ClientBufferSize = 0
+			h.SeedClients(500)
+
+			// Nobody is taking messages for 500, so the send is held up fanning out
+			sent := make(chan *httptest.ResponseRecorder, 1)
+			go func() { sent <- sendTo(t, h, "500") }()
+			require.Eventually(t, func() bool { return enqueueing(h, 500) }, 5*time.Second, 10*time.Millisecond)
+
+			if tt.received {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					<-h.Clients[500]
+				}()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			assert.Equal(t, tt.expectedErr, h.Shutdown(ctx))
+
+			var w *httptest.ResponseRecorder
+			select {
+			case w = <-sent:
+			case <-time.After(5 * time.Second):
+				t.Fatal("in-flight send never returned")
+			}
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+			}
+
+			// Sends arriving after shutdown are turned away
+			w = sendTo(t, h, "500")
+			assert.Equal(t, 503, w.Code)
+		})
+	}
+}
+
+func TestHub_announceShutdown(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+	h.SeedClients(500, 501)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	var conns []*websocket.Conn
+	for _, id := range []uint64{500, 501} {
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=%d", strings.TrimPrefix(serv.URL, "http://"), id), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	require.Eventually(t, func() bool {
+		return connectedDevices(h, 500) == 1 && connectedDevices(h, 501) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	announce := func(in, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/admin/announce-shutdown?in="+in, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 403, announce("200ms", "wrong").Code)
+	assert.Equal(t, 400, announce("soon", "secret").Code)
+	assert.Equal(t, 400, announce("-1s", "secret").Code)
+
+	start := time.Now()
+	require.Equal(t, 202, announce("200ms", "secret").Code)
+	assert.Equal(t, 409, announce("200ms", "secret").Code)
+
+	// Every client is warned with the countdown, then again as the shutdown starts
+	for _, conn := range conns {
+		for _, expected := range []string{"Hub is shutting down in 200ms", "Hub is shutting down"} {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var msg types.SendingMessage
+			require.NoError(t, conn.ReadJSON(&msg))
+			assert.True(t, msg.System)
+			assert.Equal(t, expected, string(msg.Data))
+		}
+	}
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+
+	// Sends are turned away once the shutdown is underway
+	assert.Eventually(t, func() bool { return sendTo(t, h, "500").Code == 503 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHub_Pause(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	h.Pause()
+	w := sendTo(t, h, "500")
+	assert.Equal(t, 503, w.Code)
+
+	var errorBody gin.H
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+	assert.Equal(t, gin.H{"message": "Hub is paused", "status": "Service Unavailable"}, errorBody)
+
+	h.Resume()
+	require.Equal(t, 200, sendTo(t, h, "500").Code)
+
+	var received []string
+	for len(h.Clients[500]) > 0 {
+		var msg types.SendingMessage
+		require.NoError(t, json.Unmarshal(<-h.Clients[500], &msg))
+		received = append(received, string(msg.Data))
+	}
+	assert.Equal(t, []string{"Hub is paused, sends are refused until it resumes", "Hub has resumed", "data"}, received)
+}
+
+func TestHub_ShutdownFlushesBuffered(t *testing.T) {
+	h := New()
+	// Slow deliveries down so messages are still buffered when the shutdown starts
+	h.RecipientRate = 5
+	h.RecipientBurst = 1
+	h.SeedClients(500, 600)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	conn := dialAs(t, serv, 500)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return connectedDevices(h, 500) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, 200, sendTo(t, h, "500").Code)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- h.Shutdown(ctx)
+	}()
+
+	// Every message sent before the shutdown is delivered, then the connection is closed
+	var delivered int
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg types.SendingMessage
+		err := conn.ReadJSON(&msg)
+		if err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+			break
+		}
+		if !msg.System {
+			assert.Equal(t, "data", string(msg.Data))
+			delivered++
+		}
+	}
+	assert.Equal(t, 3, delivered)
+
+	select {
+	case err := <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown never returned")
+	}
+
+	// Every client is unregistered, connected or not
+	assert.False(t, h.idInUse(500))
+	assert.False(t, h.idInUse(600))
+}
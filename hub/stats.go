@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+// counters are the hub-wide totals served on /stats, only accessed atomically
+type counters struct {
+	messages  uint64
+	bytes     uint64
+	failures  uint64
+	throttled uint64
+	expired   uint64
+	// instruments are also counted into for /metrics, where the totals are never reset
+	instruments *instruments
+}
+
+// relayed counts a message of size bytes being handed to a recipient
+func (s *counters) relayed(size int) {
+	atomic.AddUint64(&s.messages, 1)
+	atomic.AddUint64(&s.bytes, uint64(size))
+	s.instruments.relayed.Inc()
+	s.instruments.bytes.Add(uint64(size))
+}
+
+// failed counts a message that couldn't be delivered to a recipient
+func (s *counters) failed() {
+	atomic.AddUint64(&s.failures, 1)
+	s.instruments.failed.Inc()
+}
+
+// throttle counts a message dropped for exceeding its recipient's inbound rate or its sender's outbound rate
+func (s *counters) throttle() {
+	atomic.AddUint64(&s.throttled, 1)
+}
+
+// expire counts a message dropped for expiring before it was delivered
+func (s *counters) expire() {
+	atomic.AddUint64(&s.expired, 1)
+	s.instruments.expired.Inc()
+}
+
+// evicted counts a message of size bytes evicted from the queue of id
+func (h *Hub) evicted(id uint64, size int) {
+	h.Lock()
+	h.evictedBytes[id] += uint64(size)
+	h.Unlock()
+}
+
+// stats returns the hub-wide counters, along with how many clients currently have a websocket connected
+func (h *Hub) stats(c *gin.Context) {
+	h.Lock()
+	active := len(h.sessions)
+	var evicted map[uint64]uint64
+	if len(h.evictedBytes) > 0 {
+		evicted = make(map[uint64]uint64, len(h.evictedBytes))
+		for id, size := range h.evictedBytes {
+			evicted[id] = size
+		}
+	}
+	h.Unlock()
+
+	c.JSON(http.StatusOK, types.StatsResponse{
+		MessagesRelayed: atomic.LoadUint64(&h.counters.messages),
+		BytesRelayed:    atomic.LoadUint64(&h.counters.bytes),
+		Failures:        atomic.LoadUint64(&h.counters.failures),
+		Throttled:       atomic.LoadUint64(&h.counters.throttled),
+		Expired:         atomic.LoadUint64(&h.counters.expired),
+		ActiveClients:   active,
+		EvictedBytes:    evicted,
+	})
+}
+
+// resetStats zeros the counters, for operators measuring over a window. ActiveClients is a live figure so isn't affected.
+func (h *Hub) resetStats(c *gin.Context) {
+	atomic.StoreUint64(&h.counters.messages, 0)
+	atomic.StoreUint64(&h.counters.bytes, 0)
+	atomic.StoreUint64(&h.counters.failures, 0)
+	atomic.StoreUint64(&h.counters.throttled, 0)
+	atomic.StoreUint64(&h.counters.expired, 0)
+	h.Lock()
+	h.evictedBytes = make(map[uint64]uint64)
+	h.Unlock()
+
+	h.stats(c)
+}
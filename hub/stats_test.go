@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getStats fetches /stats from the hub
+func getStats(t *testing.T, h *Hub) types.StatsResponse {
+	req, err := http.NewRequest("GET", "/stats", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var stats types.StatsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	return stats
+}
+
+func TestHub_stats(t *testing.T) {
+	h := New()
+	h.AdminToken = "secret"
+	h.Clients = map[uint64]chan []byte{
+		500: make(chan []byte, 2),
+	}
+
+	for _, ids := range []string{"500", "500", "999"} {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/send?ids=%s", ids), bytes.NewBufferString("Hi"))
+		require.NoError(t, err)
+		h.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, types.StatsResponse{MessagesRelayed: 2, BytesRelayed: 4, Failures: 1}, getStats(t, h))
+
+	req, err := http.NewRequest("POST", "/stats/reset", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	assert.Equal(t, types.StatsResponse{}, getStats(t, h))
+}
+
+func TestHub_resetStatsAdmin(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		expectedCode  int
+		expectedError gin.H
+	}{
+		{
+			name:          "Golden Path",
+			adminToken:    "secret",
+			authorization: "Bearer secret",
+			expectedCode:  200,
+		},
+		{
+			name:          "No token",
+			adminToken:    "secret",
+			expectedCode:  401,
+			expectedError: gin.H{"message": "Admin token required", "status": "Unauthorized"},
+		},
+		{
+			name:          "Wrong token",
+			adminToken:    "secret",
+			authorization: "Bearer guess",
+			expectedCode:  403,
+			expectedError: gin.H{"message": "Invalid admin token", "status": "Forbidden"},
+		},
+		{
+			name:          "Admin disabled",
+			authorization: "Bearer secret",
+			expectedCode:  403,
+			expectedError: gin.H{"message": "Admin endpoints are disabled", "status": "Forbidden"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.AdminToken = tt.adminToken
+			h.counters.relayed(10)
+
+			req, err := http.NewRequest("POST", "/stats/reset", nil)
+			require.NoError(t, err)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+
+				// Counters are left alone when the reset is refused
+				assert.Equal(t, uint64(1), getStats(t, h).MessagesRelayed)
+				return
+			}
+
+			assert.Equal(t, uint64(0), getStats(t, h).MessagesRelayed)
+		})
+	}
+}
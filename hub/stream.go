@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+const eventStreamType = "text/event-stream"
+
+var errStreamClosed = errors.New("event stream closed")
+
+// streamConn writes a device's messages as server-sent events
+type streamConn struct {
+	mu     sync.Mutex
+	w      gin.ResponseWriter
+	closed chan struct{}
+}
+
+// WriteMessage sends data as a single event, flushing it straight out to the client
+func (s *streamConn) WriteMessage(_ int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return errStreamClosed
+	default:
+	}
+
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+// Close ends the stream, once it returns nothing more is written
+func (s *streamConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+// stream is an alternative to /ws for receiving, where websocket upgrades are blocked.
+// The connection is a device like any other, but messages are sent as server-sent events and nothing can be sent back.
+func (h *Hub) stream(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Unable to parse ID"})
+		return
+	}
+
+	if _, registered := h.getClient(id); !registered {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "ID not registered"})
+		return
+	}
+
+	if !h.authorized(c, id) {
+		return
+	}
+
+	weight, ok := deviceWeight(c)
+	if !ok {
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": err.Error()})
+		return
+	}
+
+	if !h.reserveConnection(c, id) {
+		return
+	}
+
+	c.Header("Content-Type", eventStreamType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header(types.SessionTokenHeader, token)
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	conn := &streamConn{w: c.Writer, closed: make(chan struct{})}
+	d := h.connectDevice(id, conn, token, weight)
+
+	select {
+	case <-c.Request.Context().Done():
+	case <-conn.closed:
+	}
+	h.disconnectDevice(id, d, closeClient)
+}
@@ -0,0 +1,106 @@
+package hub
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_stream(t *testing.T) {
+	h := New()
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// Only registered clients can stream
+	resp, err := http.Get(serv.URL + "/stream?id=600")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 400, resp.StatusCode)
+
+	resp, err = http.Get(serv.URL + "/stream?id=500")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get(types.SessionTokenHeader))
+
+	require.Eventually(t, func() bool { return connectedDevices(h, 500) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 200, sendTo(t, h, "500").Code)
+
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan())
+	require.True(t, strings.HasPrefix(lines.Text(), "data: "))
+
+	var msg types.SendingMessage
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines.Text(), "data: ")), &msg))
+	assert.Equal(t, "data", string(msg.Data))
+
+	// Hanging up unregisters the client like closing a websocket does
+	resp.Body.Close()
+	assert.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		_, exists := h.Clients[500]
+		return !exists
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHub_streamAndWebsocket(t *testing.T) {
+	h := New()
+	h.SeedClients(500, 600)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	// 500 connects over the websocket and 600 over the event stream, to the same hub
+	ws := dialAs(t, serv, 500)
+	defer ws.Close()
+
+	resp, err := http.Get(serv.URL + "/stream?id=600")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	events := bufio.NewScanner(resp.Body)
+
+	require.Eventually(t, func() bool { return connectedDevices(h, 500) == 1 && connectedDevices(h, 600) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Both are registered in the one Clients map
+	req, err := http.NewRequest("GET", "/users?id=500", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	var users types.ListResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+	assert.Equal(t, []uint64{600}, users.IDs)
+
+	// From the websocket to the stream
+	writeFrame(t, ws, types.SendingMessage{Recipients: "600", Data: []byte("to the stream")})
+	require.True(t, events.Scan())
+	var msg types.SendingMessage
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events.Text(), "data: ")), &msg))
+	assert.Equal(t, "to the stream", string(msg.Data))
+	assert.Equal(t, uint64(500), msg.Sender)
+
+	// And back, the streaming client sending over HTTP
+	req, err = http.NewRequest("POST", "/send?ids=500&from=600", strings.NewReader("to the websocket"))
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	msg = types.SendingMessage{}
+	require.NoError(t, ws.ReadJSON(&msg))
+	assert.Equal(t, "to the websocket", string(msg.Data))
+	assert.Equal(t, uint64(600), msg.Sender)
+}
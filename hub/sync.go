@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	defaultSyncWait = 5 * time.Second  // How long /send-sync waits for a reply unless told otherwise
+	maxSyncWait     = 30 * time.Second // Longest /send-sync will hold a request open for
+)
+
+// sendSync is sendMessage for callers without a websocket, who then wait up to the "wait" query (e.g. 5s) for a reply.
+// The message is given a CorrelationID, and the first message any client sends with the same CorrelationID is
+// returned as the reply instead of being relayed. Responds with 204 if no reply arrives in time.
+func (h *Hub) sendSync(c *gin.Context) {
+	wait := defaultSyncWait
+	if c.Query("wait") != "" {
+		var err error
+		wait, err = time.ParseDuration(c.Query("wait"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": err.Error()})
+			return
+		}
+		if wait <= 0 || wait > maxSyncWait {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "Bad Request", "message": "Wait must be positive and at most " + maxSyncWait.String()})
+			return
+		}
+	}
+
+	msg, ids, ok := h.readSend(c)
+	if !ok || !h.limitSend(c, msg.Sender) {
+		return
+	}
+
+	correlationID, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "Internal Server Error", "message": err.Error()})
+		return
+	}
+	msg.CorrelationID = correlationID
+
+	// Wait for the reply before relaying, so even the quickest can't be missed
+	reply := make(chan types.SendingMessage, 1)
+	h.Lock()
+	h.replies[correlationID] = reply
+	h.Unlock()
+	defer func() {
+		h.Lock()
+		delete(h.replies, correlationID)
+		h.Unlock()
+	}()
+
+	if !h.relay(c, msg, ids) {
+		return
+	}
+
+	select {
+	case r := <-reply:
+		c.JSON(http.StatusOK, r)
+	case <-h.Clock.After(wait):
+		c.Status(http.StatusNoContent)
+	case <-c.Request.Context().Done():
+	}
+}
+
+// reply hands msg to the /send-sync call waiting on its CorrelationID, returning false if there isn't one
+func (h *Hub) reply(msg types.SendingMessage) bool {
+	h.Lock()
+	ch, waiting := h.replies[msg.CorrelationID]
+	delete(h.replies, msg.CorrelationID)
+	h.Unlock()
+
+	if waiting {
+		ch <- msg
+	}
+	return waiting
+}
@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_sendSync(t *testing.T) {
+	tests := []struct {
+		name          string
+		wait          string
+		expectedCode  int
+		expectedError gin.H
+	}{
+		{
+			name:         "No reply",
+			wait:         "100ms",
+			expectedCode: 204,
+		},
+		{
+			name:          "Invalid wait",
+			wait:          "soon",
+			expectedCode:  400,
+			expectedError: gin.H{"message": "time: invalid duration \"soon\"", "status": "Bad Request"},
+		},
+		{
+			name:          "Wait too long",
+			wait:          "1h",
+			expectedCode:  400,
+			expectedError: gin.H{"message": "Wait must be positive and at most 30s", "status": "Bad Request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			// Connected, but never replies
+			conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			req, err := http.NewRequest("POST", fmt.Sprintf("/send-sync?ids=500&wait=%s", tt.wait), strings.NewReader("ping"))
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			// Nobody is left waiting on the reply
+			h.Lock()
+			assert.Empty(t, h.replies)
+			h.Unlock()
+		})
+	}
+}
+
+func TestHub_sendSyncOversizeReply(t *testing.T) {
+	h := New()
+	h.MaxDataSize = 4
+	h.SeedClients(500)
+
+	serv := httptest.NewServer(h.Router)
+	defer serv.Close()
+
+	responder := dialAs(t, serv, 500)
+	defer responder.Close()
+
+	codes := make(chan int, 1)
+	go func() {
+		req, err := http.NewRequest("POST", "/send-sync?ids=500&wait=500ms", strings.NewReader("ping"))
+		if err != nil {
+			codes <- 0
+			return
+		}
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, req)
+		codes <- w.Code
+	}()
+
+	var request types.SendingMessage
+	require.NoError(t, responder.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, responder.ReadJSON(&request))
+	require.NotEmpty(t, request.CorrelationID)
+
+	// A reply is held to the same limits as any other message, so it's refused rather than handed to the caller
+	writeFrame(t, responder, types.SendingMessage{Recipients: "0", CorrelationID: request.CorrelationID, Data: []byte("too long")})
+
+	var refused types.SendingMessage
+	require.NoError(t, responder.ReadJSON(&refused))
+	assert.True(t, refused.System)
+	assert.Equal(t, "Message dropped, maximum data size is 4 bytes", string(refused.Data))
+	assert.Equal(t, 204, <-codes)
+}
@@ -0,0 +1,170 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// ThrottlePolicy decides what happens to messages arriving for a client faster than RecipientRate
+type ThrottlePolicy int
+
+const (
+	// ThrottleQueue holds messages back until the client's rate allows them, which holds up senders once any queue is full
+	ThrottleQueue ThrottlePolicy = iota
+	// ThrottleDrop discards messages over the client's rate
+	ThrottleDrop
+)
+
+// newRecipientLimiter returns the limiter for a new session, or nil if recipients aren't rate limited
+func (h *Hub) newRecipientLimiter() *ratelimit.Limiter {
+	if h.RecipientRate <= 0 {
+		return nil
+	}
+
+	burst := h.RecipientBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return ratelimit.New(h.RecipientRate, burst)
+}
+
+// throttle applies the ThrottlePolicy to a message about to be delivered to id, returning false if it's dropped
+func (h *Hub) throttle(id uint64, s *session) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	if h.ThrottlePolicy == ThrottleDrop {
+		if !s.limiter.Allow() {
+			h.Log.Infof("Dropping message over its recipient's inbound rate client=%d", id)
+			h.counters.throttle()
+			return false
+		}
+		return true
+	}
+
+	s.limiter.Wait()
+	return true
+}
+
+// senderLimit enforces SenderRate on a client, counting how many of its messages went over it
+type senderLimit struct {
+	limiter    *ratelimit.Limiter
+	violations int
+}
+
+// newSenderLimiter returns a limiter enforcing the SenderRate
+func (h *Hub) newSenderLimiter() *ratelimit.Limiter {
+	return ratelimit.New(h.SenderRate, h.senderBurst())
+}
+
+// senderBurst is the SenderBurst, at least 1
+func (h *Hub) senderBurst() int {
+	if h.SenderBurst < 1 {
+		return 1
+	}
+	return h.SenderBurst
+}
+
+// allowSend reports whether the registered client id can send another message under the SenderRate, counting those it
+// can't as throttled. Once id has gone over MaxSenderViolations times its connections are closed.
+// IDs that aren't registered aren't limited, as they'd never be forgotten, see limitSend.
+func (h *Hub) allowSend(id uint64) bool {
+	if h.SenderRate <= 0 {
+		return true
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if _, registered := h.Clients[id]; !registered {
+		return true
+	}
+	limit, exists := h.senderLimits[id]
+	if !exists {
+		limit = &senderLimit{limiter: h.newSenderLimiter()}
+		h.senderLimits[id] = limit
+	}
+	if limit.limiter.Allow() {
+		return true
+	}
+
+	h.Log.Infof("Dropping message over its sender's rate client=%d", id)
+	h.counters.throttle()
+	limit.violations++
+	if h.MaxSenderViolations > 0 && limit.violations >= h.MaxSenderViolations {
+		h.Log.Infof("Disconnecting client for repeatedly sending over its rate client=%d violations=%d", id, limit.violations)
+		if s, exists := h.sessions[id]; exists {
+			for _, d := range append([]*device(nil), s.devices...) {
+				d.close()
+				h.disconnectDeviceLocked(id, d, closeThrottled)
+			}
+		}
+	}
+	return false
+}
+
+// senderRateMessage tells a client why its message over the SenderRate was dropped
+func (h *Hub) senderRateMessage() string {
+	return fmt.Sprintf("Message dropped, sending is limited to %v messages a second", h.SenderRate)
+}
+
+// allowAddress reports whether the client at ip can send another message under the SenderRate, for senders that aren't
+// registered clients, counting those it can't as throttled
+func (h *Hub) allowAddress(ip string) bool {
+	if h.SenderRate <= 0 {
+		return true
+	}
+
+	now := h.Clock.Now()
+
+	h.Lock()
+	l, exists := h.addressLimiters[ip]
+	if !exists {
+		// A limiter idle for long enough to have refilled is no different to a new one, so can be forgotten
+		refill := time.Duration(float64(h.senderBurst()) / h.SenderRate * float64(time.Second))
+		for other, idle := range h.addressLimiters {
+			if now.Sub(idle.lastSeen) > refill {
+				delete(h.addressLimiters, other)
+			}
+		}
+
+		l = &ipLimiter{limiter: h.newSenderLimiter()}
+		h.addressLimiters[ip] = l
+	}
+	l.lastSeen = now
+	h.Unlock()
+
+	if !l.limiter.Allow() {
+		h.Log.Infof("Dropping message over its sender's rate ip=%s", ip)
+		h.counters.throttle()
+		return false
+	}
+	return true
+}
+
+// limitSend applies the SenderRate to an HTTP send from sender, responding 429 and returning false if it's over it.
+// Sends from a registered client count against that client, while anonymous ones, or those from an ID that isn't
+// registered, count against the caller's IP.
+func (h *Hub) limitSend(c *gin.Context, sender uint64) bool {
+	if h.SenderRate <= 0 {
+		return true
+	}
+
+	_, registered := h.getClient(sender)
+	var allowed bool
+	if sender != 0 && registered {
+		allowed = h.allowSend(sender)
+	} else {
+		allowed = h.allowAddress(c.ClientIP())
+	}
+
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{"status": "Too Many Requests", "message": h.senderRateMessage()})
+	}
+	return allowed
+}
@@ -0,0 +1,270 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_RecipientRate(t *testing.T) {
+	const senders, perSender = 3, 10
+
+	tests := []struct {
+		name   string
+		policy ThrottlePolicy
+	}{
+		{
+			name:   "Queue",
+			policy: ThrottleQueue,
+		},
+		{
+			name:   "Drop",
+			policy: ThrottleDrop,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.RecipientRate = 20
+			h.RecipientBurst = 1
+			h.ThrottlePolicy = tt.policy
+			h.SeedClients(500)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// Several senders flood the recipient at once
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < senders; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < perSender; j++ {
+						if w := sendTo(t, h, "500"); w.Code != 200 {
+							t.Errorf("send failed with %d", w.Code)
+						}
+					}
+				}()
+			}
+
+			received := 0
+			var last time.Time
+			for {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				if _, _, err := conn.ReadMessage(); err != nil {
+					break
+				}
+				received++
+				last = time.Now()
+			}
+			wg.Wait()
+
+			stats := getStats(t, h)
+			if tt.policy == ThrottleDrop {
+				// Only what the rate allowed while the flood lasted gets through, the rest are counted as throttled
+				assert.Less(t, received, senders*perSender/2)
+				assert.Equal(t, uint64(senders*perSender-received), stats.Throttled)
+				return
+			}
+
+			// Everything arrives, but no faster than the rate
+			assert.Equal(t, senders*perSender, received)
+			assert.Zero(t, stats.Throttled)
+			assert.GreaterOrEqual(t, float64(last.Sub(start))/float64(time.Second), float64(received-h.RecipientBurst)/h.RecipientRate*0.9)
+		})
+	}
+}
+
+func TestHub_SenderRate(t *testing.T) {
+	const flood, rate = 100, 10
+
+	tests := []struct {
+		name          string
+		maxViolations int
+		disconnected  bool
+	}{
+		{
+			name: "Dropped",
+		},
+		{
+			name:          "Disconnected",
+			maxViolations: 5,
+			disconnected:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SenderRate = rate
+			h.SenderBurst = rate
+			h.MaxSenderViolations = tt.maxViolations
+			h.SeedClients(500, 600)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			sender := dialAs(t, serv, 500)
+			defer sender.Close()
+			recipient := dialAs(t, serv, 600)
+			defer recipient.Close()
+
+			// The sender pushes its messages as fast as it can, stopping if the hub closes its connection
+			frame, err := json.Marshal(types.SendingMessage{Recipients: "600", Data: []byte("flood")})
+			require.NoError(t, err)
+			for i := 0; i < flood; i++ {
+				if err := sender.WriteMessage(websocket.TextMessage, frame); err != nil {
+					break
+				}
+			}
+
+			received := 0
+			for {
+				recipient.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+				if _, _, err := recipient.ReadMessage(); err != nil {
+					break
+				}
+				received++
+			}
+			// The burst gets through, and little more in the time the flood took
+			assert.True(t, received >= rate && received < 2*rate, "received %d messages", received)
+
+			warnings := 0
+			var readErr error
+			for {
+				sender.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+				_, b, err := sender.ReadMessage()
+				if err != nil {
+					readErr = err
+					break
+				}
+				var msg types.SendingMessage
+				require.NoError(t, json.Unmarshal(b, &msg))
+				assert.True(t, msg.System)
+				assert.Equal(t, "Message dropped, sending is limited to 10 messages a second", string(msg.Data))
+				warnings++
+			}
+
+			netErr, timedOut := readErr.(net.Error)
+			timedOut = timedOut && netErr.Timeout()
+			stats := getStats(t, h)
+			if tt.disconnected {
+				assert.False(t, timedOut, "sender left connected")
+				// Warnings still queued when the connection closes are lost with it
+				assert.True(t, warnings < tt.maxViolations, "warned %d times", warnings)
+				assert.Equal(t, uint64(tt.maxViolations), stats.Throttled)
+				_, exists := h.getClient(500)
+				assert.False(t, exists)
+				return
+			}
+
+			assert.True(t, timedOut, "sender disconnected: %v", readErr)
+			assert.Equal(t, flood-received, warnings)
+			assert.Equal(t, uint64(flood-received), stats.Throttled)
+		})
+	}
+}
+
+func TestHub_SenderRateSend(t *testing.T) {
+	const flood, rate = 100, 10
+
+	tests := []struct {
+		name  string
+		query func(i int) string
+		// registered is whether the sends count against a registered client rather than the caller's IP
+		registered bool
+	}{
+		{
+			name:       "Registered sender",
+			query:      func(int) string { return "/send?ids=600&from=500" },
+			registered: true,
+		},
+		{
+			name:  "Anonymous",
+			query: func(int) string { return "/send?ids=600" },
+		},
+		{
+			name:  "Unregistered senders",
+			query: func(i int) string { return fmt.Sprintf("/send?ids=600&from=%d", 1000+i) },
+		},
+		{
+			name:       "Group",
+			query:      func(int) string { return "/groups/send?name=room&from=500" },
+			registered: true,
+		},
+		{
+			name:  "Broadcast",
+			query: func(int) string { return "/broadcast" },
+		},
+		{
+			name:       "Send sync",
+			query:      func(int) string { return "/send-sync?ids=600&from=500&wait=1ms" },
+			registered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SenderRate = rate
+			h.SenderBurst = rate
+			h.ClientBufferSize = flood
+			h.SeedClients(500, 600)
+			h.Groups["room"] = map[uint64]struct{}{600: {}}
+
+			post := func(query, ip string) int {
+				req, err := http.NewRequest("POST", query, strings.NewReader("data"))
+				require.NoError(t, err)
+				req.RemoteAddr = ip + ":1234"
+
+				w := httptest.NewRecorder()
+				h.Router.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			throttled := 0
+			for i := 0; i < flood; i++ {
+				if post(tt.query(i), "10.0.0.1") == http.StatusTooManyRequests {
+					throttled++
+				}
+			}
+
+			// The burst gets through, and little more in the time the flood took
+			accepted := flood - throttled
+			assert.True(t, accepted >= rate && accepted < 2*rate, "accepted %d sends", accepted)
+			assert.Equal(t, uint64(throttled), getStats(t, h).Throttled)
+
+			h.Lock()
+			limits, addresses := len(h.senderLimits), len(h.addressLimiters)
+			h.Unlock()
+			if tt.registered {
+				assert.Equal(t, 1, limits)
+				assert.Zero(t, addresses)
+			} else {
+				// Nothing is held for IDs that aren't registered, as it would never be forgotten
+				assert.Zero(t, limits)
+				assert.Equal(t, 1, addresses)
+			}
+
+			// Other callers aren't limited by the flood
+			assert.NotEqual(t, http.StatusTooManyRequests, post("/send?ids=600", "10.0.0.2"))
+			if tt.registered {
+				assert.NotEqual(t, http.StatusTooManyRequests, post("/send?ids=600", "10.0.0.1"))
+			}
+		})
+	}
+}
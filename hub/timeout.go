@@ -0,0 +1,51 @@
+package hub
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// requestTimeout is middleware bounding the request's context by RequestTimeout. Handlers give up once it ends,
+// leaving the response to this, which is a 503 if they ran out of time.
+func (h *Hub) requestTimeout(c *gin.Context) {
+	if h.RequestTimeout <= 0 || websocket.IsWebSocketUpgrade(c.Request) || c.GetHeader("Accept") == eventStreamType {
+		c.Next()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.RequestTimeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"status": "Service Unavailable", "message": "Request timed out"})
+	}
+}
+
+// sendTimeout derives a context from ctx that's cancelled once SendTimeout passes, bounding how long a send waits on a
+// single recipient. Cancelling it stops the timer, so sends that finish early don't leave one behind.
+func (h *Hub) sendTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.SendTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.SendTimeout)
+}
+
+// abortSend returns a channel closed once either ctx ends or Shutdown aborts in-flight sends, for enqueue to give up on.
+// release must be called once the send is done with it.
+func (h *Hub) abortSend(ctx context.Context) (abort <-chan struct{}, release func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-h.stopSends:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx.Done(), cancel
+}
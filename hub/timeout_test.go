@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_RequestTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+
+	h := New()
+	h.RequestTimeout = timeout
+	// Registered but never connected, without a buffer, so sends to it wait until they're given up on
+	h.ClientBufferSize = 0
+	h.SeedClients(500)
+
+	// Slow unless it's told to give up
+	h.Router.GET("/slow", func(c *gin.Context) {
+		select {
+		case <-time.After(5 * time.Second):
+			c.Status(http.StatusOK)
+		case <-c.Request.Context().Done():
+		}
+	})
+	h.Router.GET("/fast", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode int
+	}{
+		{
+			name:         "Slow handler",
+			method:       "GET",
+			path:         "/slow",
+			expectedCode: 503,
+		},
+		{
+			name:         "Fast handler",
+			method:       "GET",
+			path:         "/fast",
+			expectedCode: 200,
+		},
+		{
+			name:         "Send to a client that isn't reading",
+			method:       "POST",
+			path:         "/send?ids=500",
+			expectedCode: 503,
+		},
+		{
+			name:         "Sync send outlasting the timeout",
+			method:       "POST",
+			path:         "/send-sync?ids=500&wait=5s",
+			expectedCode: 503,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader("data"))
+			require.NoError(t, err)
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			elapsed := time.Since(start)
+
+			require.Equal(t, tt.expectedCode, w.Code)
+			assert.Less(t, int64(elapsed), int64(timeout+time.Second))
+
+			if tt.expectedCode == 503 {
+				assert.GreaterOrEqual(t, int64(elapsed), int64(timeout))
+
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, "Request timed out", body["message"])
+			}
+		})
+	}
+}
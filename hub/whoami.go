@@ -0,0 +1,49 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newToken generates a random token, e.g. for sessions or correlating replies
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// whoami returns the ID of the client whose connection issued the bearer session token.
+// Unlike /identify the caller doesn't say who they are, the hub works it out from their connection.
+func (h *Hub) whoami(c *gin.Context) {
+	id, ok := h.sessionClient(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, id)
+}
+
+// sessionClient returns the client whose connection issued the bearer session token.
+// It responds with an error and returns false if there's no token or it isn't recognised.
+func (h *Hub) sessionClient(c *gin.Context) (uint64, bool) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "message": "Session token required"})
+		return 0, false
+	}
+
+	h.Lock()
+	id, exists := h.sessionTokens[token]
+	h.Unlock()
+	if !exists {
+		c.JSON(http.StatusForbidden, gin.H{"status": "Forbidden", "message": "Session token not recognised"})
+		return 0, false
+	}
+	return id, true
+}
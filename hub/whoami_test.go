@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenBirch/message-delivery-system/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// whoamiRequest runs a GET against /whoami with the given bearer token, if any
+func whoamiRequest(t *testing.T, h *Hub, token string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/whoami", nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHub_whoami(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         func(connected string) string
+		expectedCode  int
+		expectedError gin.H
+	}{
+		{
+			name:         "Golden Path",
+			token:        func(connected string) string { return connected },
+			expectedCode: 200,
+		},
+		{
+			name:          "No token",
+			token:         func(string) string { return "" },
+			expectedCode:  401,
+			expectedError: gin.H{"message": "Session token required", "status": "Unauthorized"},
+		},
+		{
+			name:          "Unknown token",
+			token:         func(string) string { return "notatoken" },
+			expectedCode:  403,
+			expectedError: gin.H{"message": "Session token not recognised", "status": "Forbidden"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			h.SeedClients(500)
+
+			serv := httptest.NewServer(h.Router)
+			defer serv.Close()
+
+			conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?id=500", strings.TrimPrefix(serv.URL, "http://")), nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			token := resp.Header.Get(types.SessionTokenHeader)
+			require.NotEmpty(t, token)
+
+			w := whoamiRequest(t, h, tt.token(token))
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			if tt.expectedError != nil {
+				var errorBody gin.H
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errorBody))
+				assert.Equal(t, tt.expectedError, errorBody)
+				return
+			}
+
+			var id uint64
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&id))
+			assert.Equal(t, uint64(500), id)
+
+			// The token only identifies the client while its connection is open
+			conn.Close()
+			assert.Eventually(t, func() bool { return whoamiRequest(t, h, token).Code == 403 }, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package metrics provides counters, gauges and histograms served in the Prometheus text exposition format, for
+// scraping by Prometheus without pulling in its client library
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the Prometheus text exposition format written by Registry
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of Histogram buckets suited to latencies in seconds, from 5ms up to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric that can write itself out in the text exposition format
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics, writing them out in the order they were registered
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds c to those written by the Registry
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Write writes every metric in the Registry to w in the text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// ServeHTTP serves the Registry's metrics, so it can be mounted for Prometheus to scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.Write(w)
+}
+
+// writeHeader writes the HELP and TYPE lines introducing a metric
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Counter is a total that only goes up
+type Counter struct {
+	name, help string
+	value      uint64 // Only accessed atomically
+}
+
+// NewCounter creates a Counter and registers it with r
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(c)
+	return c
+}
+
+// Inc adds 1 to the Counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds n to the Counter
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the Counter's total
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.Value())
+}
+
+// CounterVec is a Counter split by the value of a single label, e.g. the reason a connection closed
+type CounterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounterVec creates a CounterVec split by label and registers it with r
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds 1 to the total for the label value
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+// Values returns a copy of the totals, by label value
+func (c *CounterVec) Values() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make(map[string]uint64, len(c.values))
+	for value, total := range c.values {
+		values[value] = total
+	}
+	return values
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	values := c.Values()
+	labels := make([]string, 0, len(values))
+	for value := range values {
+		labels = append(labels, value)
+	}
+	sort.Strings(labels)
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, value := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, value, values[value])
+	}
+}
+
+// Gauge is a value that can go up and down, e.g. how many clients are connected
+type Gauge struct {
+	name, help string
+	value      int64 // Only accessed atomically
+}
+
+// NewGauge creates a Gauge and registers it with r
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	r.register(g)
+	return g
+}
+
+// Inc adds 1 to the Gauge
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+// Dec takes 1 from the Gauge
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+// Value returns the Gauge's current value
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.name, g.Value())
+}
+
+// Histogram counts observations, e.g. latencies, into buckets by their size
+type Histogram struct {
+	name, help string
+	buckets    []float64 // Upper bounds, in increasing order
+
+	mu     sync.Mutex
+	counts []uint64 // Observations in each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a Histogram with buckets of the given upper bounds and registers it with r.
+// Observations over the last bound are only counted in the implicit +Inf bucket.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+
+	h := &Histogram{name: name, help: help, buckets: bounds, counts: make([]uint64, len(bounds))}
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the Histogram
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// Count returns how many observations the Histogram has had
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, count)
+}
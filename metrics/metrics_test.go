@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	connected := r.NewGauge("connected", "Connected clients.")
+	relayed := r.NewCounter("relayed_total", "Messages relayed.")
+	closed := r.NewCounterVec("closed_total", "Connections closed.", "reason")
+	latency := r.NewHistogram("latency_seconds", "Delivery latency.", []float64{1, 0.1})
+
+	connected.Inc()
+	connected.Inc()
+	connected.Dec()
+	relayed.Add(3)
+	relayed.Inc()
+	closed.Inc("write_error")
+	closed.Inc("client_closed")
+	closed.Inc("client_closed")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		latency.Observe(v)
+	}
+
+	var b bytes.Buffer
+	r.Write(&b)
+	assert.Equal(t, `# HELP connected Connected clients.
+# TYPE connected gauge
+connected 1
+# HELP relayed_total Messages relayed.
+# TYPE relayed_total counter
+relayed_total 4
+# HELP closed_total Connections closed.
+# TYPE closed_total counter
+closed_total{reason="client_closed"} 2
+closed_total{reason="write_error"} 1
+# HELP latency_seconds Delivery latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 2.65
+latency_seconds_count 4
+`, b.String())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, b.String(), w.Body.String())
+}
@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := New(10, 3)
+
+	// The full burst is available straight away, but no more
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow())
+	}
+	assert.False(t, l.Allow())
+
+	// A token is earned back every 100ms
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, l.Allow())
+	assert.False(t, l.Allow())
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	l := New(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Wait()
+	}
+
+	// The first is free from the burst, the other four wait 50ms each
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(190*time.Millisecond))
+}
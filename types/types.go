@@ -1,12 +1,327 @@
 package types
 
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SessionTokenHeader is the /ws upgrade response header carrying the connection's session token.
+// The token identifies the client on /whoami for as long as that connection stays open.
+const SessionTokenHeader = "X-Session-Token"
+
+// ProtocolTokenHeader is the /ws request header carrying the hub's protocol token, for hubs that require one
+const ProtocolTokenHeader = "X-Protocol-Token"
+
+// ProtocolVersion is the version of the hub/client protocol, reported by the hub in RegisterResponse
+const ProtocolVersion = "1"
+
+// GzipEncoding is the compression a client can ask for with "compress" on /register, see SendingMessage.Encoding
+const GzipEncoding = "gzip"
+
+// EnvelopeVersion is the newest version of Envelope this package reads and writes
+const EnvelopeVersion = 1
+
+// RegisterResponseType is the media type to Accept on /register for a RegisterResponse instead of the bare ID
+const RegisterResponseType = "application/vnd.message-delivery-system.register+json"
+
+// RegisterResponse is the detailed response to /register, letting clients sync clocks and check compatibility
+type RegisterResponse struct {
+	ID              uint64
+	ServerTime      time.Time
+	ProtocolVersion string
+	// Token is issued by POST /register, for the client to present as a bearer token whenever it acts as itself
+	Token string `json:",omitempty"`
+}
+
+// TokenInfo describes a token issued to a client by POST /register, as listed by /admin/tokens
+type TokenInfo struct {
+	ID       uint64
+	IssuedAt time.Time
+	// LastUsed is when the client last presented the token, or when it was issued if it hasn't yet
+	LastUsed time.Time
+}
+
+// TokensResponse lists the tokens issued to registered clients, by ID
+type TokensResponse struct {
+	Tokens []TokenInfo
+}
+
 // ListResponse is used to wrap IDs for json (un)Marshalling
 type ListResponse struct {
 	IDs []uint64
 }
 
+// StatsResponse is used to wrap the hub-wide counters served on /stats
+type StatsResponse struct {
+	MessagesRelayed uint64
+	BytesRelayed    uint64
+	Failures        uint64
+	Throttled       uint64 // Messages dropped for exceeding their recipient's inbound rate or their sender's outbound rate
+	Expired         uint64 // Messages dropped for reaching their ExpiresAt before they were delivered
+	ActiveClients   int
+	// EvictedBytes is the total size of the messages evicted from each client's full queue, by ID
+	EvictedBytes map[uint64]uint64 `json:",omitempty"`
+}
+
+// SelfTestResponse is the result of a successful /selftest
+type SelfTestResponse struct {
+	Success bool
+	// Latency is how long the test message took to round trip through the relay
+	Latency time.Duration
+}
+
 // SendingMessage is used to combine a recipients and the data to deliver
 type SendingMessage struct {
 	Recipients string
 	Data       []byte
+	// Sender is the ID of the client the message came from, when the hub knows it
+	Sender uint64 `json:",omitempty"`
+	// DeliverAt asks the hub to hold the message until the given time, the zero value delivers immediately
+	DeliverAt time.Time
+	// ExpiresAt asks the hub to drop the message if it isn't delivered by then, e.g. while the recipient is offline.
+	// The zero value never expires.
+	ExpiresAt time.Time
+	// Encrypted marks Data as encrypted to the recipient's public key, which the hub relays without being able to read
+	Encrypted bool `json:",omitempty"`
+	// Sequence is stamped by the hub with the message's position in everything delivered to the recipient, starting at 1
+	Sequence uint64 `json:",omitempty"`
+	// Lamport is the hub's Lamport timestamp of the message, stamped as the hub accepts it when it has CausalOrdering set.
+	// The hub stamps every message from every sender in the order it accepts them, so a message sent in reply to another
+	// always has the larger timestamp, and comparing them orders messages from different senders as the hub saw them.
+	Lamport uint64 `json:",omitempty"`
+	// Priority decides which messages are evicted first when a recipient's queue overflows, lowest first
+	Priority int `json:",omitempty"`
+	// MessageID identifies the message so the hub can acknowledge it, messages without one aren't acknowledged
+	MessageID string `json:",omitempty"`
+	// CorrelationID links a reply to the message it answers, see Client.Reply
+	CorrelationID string `json:",omitempty"`
+	// Ack marks a frame from the hub acknowledging that the recipient named by Sender accepted message MessageID
+	Ack bool `json:",omitempty"`
+	// AckStatus is how far the recipient got with the message an Ack is for, AckDelivered if it's empty
+	AckStatus string `json:",omitempty"`
+	// Multipart marks Data as holding several named parts, read them with Parts
+	Multipart bool `json:",omitempty"`
+	// System is set by the hub on messages it originates itself, e.g. shutdown notices
+	System bool `json:",omitempty"`
+	// Encoding is set by the hub when it compressed Data for the recipient, e.g. GzipEncoding
+	Encoding string `json:",omitempty"`
+	// Metadata holds arbitrary key/values from the sender, e.g. a trace-id, relayed untouched alongside Data
+	Metadata map[string]string `json:",omitempty"`
+	// Enveloped marks Data as an Envelope, which the hub relays like any other Data whatever its Version
+	Enveloped bool `json:",omitempty"`
+	// FileID identifies the message a chunk was split from, for its chunks to be reassembled by, see Client.SplitOversize
+	FileID string `json:",omitempty"`
+	// ChunkIndex is the chunk's position in the message it was split from, starting at 0, of ChunkCount chunks
+	ChunkIndex int `json:",omitempty"`
+	ChunkCount int `json:",omitempty"`
+}
+
+// Envelope wraps a message in the schema of its Version, so the message schema can change without the hub needing to.
+// The hub routes an envelope by the SendingMessage carrying it, clients agree the Version between themselves, see
+// Client.SendEnveloped. A Version 1 Message is a SendingMessage.
+type Envelope struct {
+	Version int
+	Message json.RawMessage
+}
+
+// Preferences are the settings the hub holds for a client, see Client.UpdatePreferences
+type Preferences struct {
+	// Name is shown for the client in /users/export
+	Name string `json:",omitempty"`
+	// Compress is the encoding the client can decompress, the hub compresses messages for it when that makes them smaller
+	Compress string `json:",omitempty"`
+	// Filter is the filter messages must match to be delivered to the client
+	Filter Filter
+	// Muted stops the client being delivered messages from peers, messages from the hub still are
+	Muted bool `json:",omitempty"`
+	// MaxDataSize is the largest Data the client wants delivered, larger messages are dropped. 0 means no limit beyond the hub's
+	MaxDataSize int `json:",omitempty"`
+}
+
+// DeadLetter is a message the hub gave up delivering to Recipient
+type DeadLetter struct {
+	Recipient uint64
+	Message   SendingMessage
+	// Attempts is how many times the message was delivered without being acknowledged
+	Attempts int
+	Reason   string
+	At       time.Time
+}
+
+// DeadLettersResponse lists the messages the hub recently couldn't deliver, oldest first, from /deadletter
+type DeadLettersResponse struct {
+	DeadLetters []DeadLetter
+}
+
+// Filter is set by a client to have the hub only deliver it the messages that match
+type Filter struct {
+	// Metadata must all be present, with the same values, in a message's Metadata for it to match
+	Metadata map[string]string `json:",omitempty"`
+}
+
+// Matches reports whether msg passes the filter
+func (f Filter) Matches(msg SendingMessage) bool {
+	for key, value := range f.Metadata {
+		if v, exists := msg.Metadata[key]; !exists || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Parts returns the named parts of a Multipart message
+func (m SendingMessage) Parts() (map[string][]byte, error) {
+	if !m.Multipart {
+		return nil, errors.New("message isn't multipart")
+	}
+
+	var parts map[string][]byte
+	if err := json.Unmarshal(m.Data, &parts); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// Part returns the named part of a Multipart message, and whether it has one by that name
+func (m SendingMessage) Part(name string) ([]byte, bool) {
+	parts, err := m.Parts()
+	if err != nil {
+		return nil, false
+	}
+
+	part, exists := parts[name]
+	return part, exists
+}
+
+// Ack statuses, see SendingMessage.AckStatus
+const (
+	// AckDelivered acknowledges the message was delivered to the recipient's connection
+	AckDelivered = "delivered"
+	// AckProcessed acknowledges the recipient's application processed the message, see Client.AckProcessed
+	AckProcessed = "processed"
+	// AckFailed tells the sender the hub gave up on the message for the recipient, with the reason as the Data. A
+	// Sender of 0 means the hub refused the message outright, so it failed for all of its recipients.
+	AckFailed = "failed"
+)
+
+// Presence event types, see PresenceEvent
+const (
+	PresenceSnapshot = "snapshot"
+	PresenceJoin     = "join"
+	PresenceLeave    = "leave"
+)
+
+// CapabilitiesResponse describes the protocol a hub speaks, served on /capabilities for clients not written in Go
+type CapabilitiesResponse struct {
+	ProtocolVersion string
+	Endpoints       []Endpoint
+	// Message describes the fields of SendingMessage as it's framed over the websocket
+	Message []MessageField
+	// ContentTypes are the media types the hub reads or writes, besides plain bodies of data to send
+	ContentTypes []string
+	// Compression lists the encodings a client can ask for with "compress" on /register
+	Compression []string
+	Limits      Limits
+}
+
+// Endpoint is a route served by the hub
+type Endpoint struct {
+	Method string
+	Path   string
+}
+
+// MessageField is a single field of SendingMessage, as named in JSON
+type MessageField struct {
+	Name string
+	// Type is the JSON type, with Format refining it where needed, e.g. base64 for a string of bytes
+	Type     string
+	Format   string `json:",omitempty"`
+	Optional bool
+}
+
+// Limits are the hub's configured limits, 0 meaning there isn't one
+type Limits struct {
+	MaxRecipients          int
+	MaxDataSize            int
+	MaxMessageSize         int
+	MaxQueryLength         int
+	SendReadTimeout        time.Duration
+	SendTimeout            time.Duration
+	RequestTimeout         time.Duration
+	ClientBufferSize       int
+	QueueSize              int
+	QueueBytes             int
+	RecipientRate          float64
+	RecipientBurst         int
+	SenderRate             float64
+	SenderBurst            int
+	MaxGroups              int
+	RegistrationsPerMinute int
+}
+
+// PendingMessage is a message the hub is yet to deliver to some of its recipients, as listed by /pending
+type PendingMessage struct {
+	MessageID string
+	// Recipients are those still waiting on the message
+	Recipients []uint64
+}
+
+// PresenceEvent is streamed by the hub's /presence endpoint, a snapshot of who's online followed by who joins and leaves
+type PresenceEvent struct {
+	// Type is PresenceSnapshot, PresenceJoin or PresenceLeave
+	Type string
+	// ID is the client that joined or left
+	ID uint64 `json:",omitempty"`
+	// Online is every client connected at the time of a snapshot
+	Online []uint64 `json:",omitempty"`
+}
+
+// RPCRequest is the Data of a message calling a method on a client, see Client.Call
+type RPCRequest struct {
+	Method string
+	Args   json.RawMessage `json:",omitempty"`
+}
+
+// RPCResponse is the Data of the reply to an RPCRequest, holding either the method's result or the error it failed with
+type RPCResponse struct {
+	Result json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+}
+
+// ResendResponse holds the messages /resend found in the range asked for, oldest first, framed as they were delivered.
+// Those the hub no longer keeps are missing from it.
+type ResendResponse struct {
+	Messages []json.RawMessage
+}
+
+// PollResponse holds the messages a /poll took for the client, oldest first, framed as they'd be delivered on /ws.
+// It's empty if none arrived before the poll's wait was over.
+type PollResponse struct {
+	Messages []json.RawMessage
+}
+
+// BroadcastResponse summarises a /broadcast, which is delivered to every registered client bar the one excluded
+type BroadcastResponse struct {
+	// Delivered is how many clients were handed the message
+	Delivered int
+	// Failed holds the clients that couldn't be handed the message in time
+	Failed []uint64 `json:",omitempty"`
+}
+
+// ConnectionChurn counts the websocket and event stream connections the hub has seen opened and closed
+type ConnectionChurn struct {
+	Opened uint64
+	// Closed counts the connections closed by reason, e.g. client_closed or write_error
+	Closed map[string]uint64
+}
+
+// DebugState is a summary of the hub's internal state, served to admins on /debug/state
+type DebugState struct {
+	// Clients is how many clients are registered, and Connected how many of them have a connection open
+	Clients   int
+	Connected int
+	// Connections is how many connections are open across every client
+	Connections int
+	Churn       ConnectionChurn
 }